@@ -153,6 +153,7 @@ func main() {
 			fmt.Println(err)
 			return
 		}
+		defer rm.Close()
 		recovery.Prime(strings.TrimSuffix(db.GetBasePath(), "/"))
 		repls = append(repls, recovery.RecoveryREPL(db, tm, rm))
 		// Recover in this case!
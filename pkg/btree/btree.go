@@ -76,6 +76,38 @@ func (index *BTreeIndex) Find(key int64) (entry.Entry, error) {
 	return entry.Entry{}, fmt.Errorf("no entry with key %d was found", key)
 }
 
+// [RECOVERY] PageLSN returns the LSN of the leaf node that holds the given key, or would hold it
+// if it were inserted, which recovery uses to tell whether an edit of the key was applied.
+func (index *BTreeIndex) PageLSN(key int64) (uint64, error) {
+	rootPage, err := index.pager.GetPage(index.rootPN)
+	if err != nil {
+		return 0, err
+	}
+	// [CONCURRENCY] lock-crabbing down to the leaf, as in CursorAt
+	rootPage.RLock()
+	curNode := pageToNode(rootPage)
+	for {
+		iNode, ok := curNode.(*InternalNode)
+		if !ok {
+			break
+		}
+		child, err := iNode.getChildAt(iNode.search(key))
+		if err != nil {
+			index.pager.PutPage(iNode.page)
+			iNode.page.RUnlock()
+			return 0, err
+		}
+		child.getPage().RLock()
+		index.pager.PutPage(iNode.page)
+		iNode.page.RUnlock()
+		curNode = child
+	}
+	leafPage := curNode.getPage()
+	defer leafPage.RUnlock()
+	defer index.pager.PutPage(leafPage)
+	return leafPage.GetLSN(), nil
+}
+
 // Insert inserts a key-value entry into the B+Tree,
 // returning an error if there is a problem with the insertion or splitting process.
 func (index *BTreeIndex) Insert(key int64, value int64) error {
@@ -189,7 +221,9 @@ func (index *BTreeIndex) Select() ([]entry.Entry, error) {
 	// Get a cursor pointing to the first entry
 	// Cursor returns locked
 	cursor, err := index.CursorAtStart()
-	if err != nil {
+	if errors.Is(err, errEmptyTree) {
+		return entries, nil
+	} else if err != nil {
 		return nil, err
 	}
 	defer cursor.Close()
@@ -7,6 +7,9 @@ import (
 	"dinodb/pkg/entry"
 )
 
+// errEmptyTree is returned when positioning a cursor in a B+Tree without any entries.
+var errEmptyTree = errors.New("all leaf nodes are empty")
+
 // BTreeCursor is a data structure that allows for easy iteration through
 // the entries in a B+Tree's leaf nodes in order.
 type BTreeCursor struct {
@@ -58,7 +61,8 @@ func (index *BTreeIndex) CursorAtStart() (cursor.Cursor, error) {
 		noEntries := cursor.Next()
 		//if noEntries is true, then all our leaf nodes are empty
 		if noEntries {
-			return nil, errors.New("all leaf nodes are empty")
+			cursor.Close()
+			return nil, errEmptyTree
 		}
 	}
 	return cursor, nil
@@ -0,0 +1,40 @@
+package concurrency
+
+import "github.com/google/uuid"
+
+/*
+   Transactions are ordered by the timestamps they get from the transaction manager's clock as
+   they begin, which a new transaction manager starts over at zero. After a restart, that would
+   hand out timestamps already given to transactions before it. So that it doesn't, the recovery
+   manager assigns each transaction the LSN of its Start log as its timestamp, which the log
+   keeps, and recovery advances the clock past the last LSN in the log: every transaction begun
+   after a restart is then younger than any whose Start log survived it.
+
+   A transaction begun without an assigned timestamp takes the next one from the clock, which is
+   kept past every timestamp assigned so far.
+*/
+
+// Clock returns the highest timestamp given or assigned to a transaction so far.
+func (tm *TransactionManager) Clock() uint64 {
+	tm.mtx.RLock()
+	defer tm.mtx.RUnlock()
+	return tm.clock
+}
+
+// AdvanceClock makes every transaction begun from now on get a timestamp greater than the given
+// one, unless the clock is already past it. Wounded transactions restarting keep their timestamps.
+func (tm *TransactionManager) AdvanceClock(timestamp uint64) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	tm.clock = max(tm.clock, timestamp)
+}
+
+// AssignTimestamp gives the transaction the client begins next the given timestamp, rather than
+// the next one from the clock, and advances the clock past it. A client restarting a wounded
+// transaction keeps the timestamp it had instead.
+func (tm *TransactionManager) AssignTimestamp(clientId uuid.UUID, timestamp uint64) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	tm.assigned[clientId] = timestamp
+	tm.clock = max(tm.clock, timestamp)
+}
@@ -0,0 +1,66 @@
+package concurrency
+
+import (
+	"context"
+	"encoding/binary"
+
+	"dinodb/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+/*
+   Entries are usually locked by their int64 key, but a table whose primary key spans several
+   columns can be locked precisely with a CompositeKey instead, which serializes the columns
+   with a length prefix each so that different column values never serialize the same. Two
+   composite keys conflict only if every column is equal, so keys sharing some of their columns
+   can be locked by different transactions at once. A composite key never conflicts with an
+   int64 key, but both conflict with locks on their whole table. The serialization starts with
+   the number of columns, so it's never empty, even for a key of no columns.
+
+   Tables only store int64 keys, so edits are still logged and recovered by their int64 key;
+   composite keys only make locking as fine-grained as the caller's data model.
+*/
+
+// A CompositeKey is the byte serialization of a multi-column key, made by NewCompositeKey.
+type CompositeKey string
+
+// NewCompositeKey serializes the columns of a key in order.
+func NewCompositeKey(columns ...[]byte) CompositeKey {
+	b := binary.AppendUvarint(nil, uint64(len(columns)))
+	for _, column := range columns {
+		b = binary.AppendUvarint(b, uint64(len(column)))
+		b = append(b, column...)
+	}
+	return CompositeKey(b)
+}
+
+// Columns returns the columns the key was made from.
+func (k CompositeKey) Columns() [][]byte {
+	b := []byte(k)
+	count, size := binary.Uvarint(b)
+	b = b[size:]
+	columns := make([][]byte, 0, count)
+	for len(b) > 0 {
+		n, size := binary.Uvarint(b)
+		b = b[size:]
+		columns = append(columns, b[:n])
+		b = b[n:]
+	}
+	return columns
+}
+
+// LockComposite locks the entry of the table with the given composite key, like Lock.
+func (tm *TransactionManager) LockComposite(clientId uuid.UUID, table database.Index, key CompositeKey, lType LockType) error {
+	return tm.LockCompositeContext(context.Background(), clientId, table, key, lType)
+}
+
+// LockCompositeContext locks the entry of the table with the given composite key, like LockContext.
+func (tm *TransactionManager) LockCompositeContext(ctx context.Context, clientId uuid.UUID, table database.Index, key CompositeKey, lType LockType) error {
+	return tm.lock(ctx, clientId, Resource{tableName: table.GetName(), composite: key}, lType)
+}
+
+// UnlockComposite unlocks the entry of the table with the given composite key, like Unlock.
+func (tm *TransactionManager) UnlockComposite(clientId uuid.UUID, table database.Index, key CompositeKey, lType LockType) error {
+	return tm.unlock(clientId, Resource{tableName: table.GetName(), composite: key}, lType)
+}
@@ -2,9 +2,58 @@ package concurrency
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
+
+	"github.com/google/uuid"
 )
 
+// DeadlockError is returned when locking would deadlock, naming the transaction chosen
+// to be aborted to break the deadlock, and the cycle of transactions that deadlocked.
+// Any other transaction in the deadlock keeps waiting.
+type DeadlockError struct {
+	Victim    uuid.UUID   // The client whose transaction must be aborted
+	Cycle     []uuid.UUID // The clients in the cycle, starting with the one whose request closed it
+	Resources []Resource  // The resource each client in Cycle waits for, held by the next one
+}
+
+func (e *DeadlockError) Error() string {
+	var cycle strings.Builder
+	for i, clientId := range e.Cycle {
+		fmt.Fprintf(&cycle, "%s -[%s]-> ", clientId, e.Resources[i])
+	}
+	if len(e.Cycle) > 0 {
+		cycle.WriteString(e.Cycle[0].String())
+	}
+	return fmt.Sprintf("deadlock detected: transaction %s must be aborted, cycle: %s", e.Victim, cycle.String())
+}
+
+// newDeadlockError returns the DeadlockError for the given cycle, found by FindCycle, naming
+// the given victim.
+func (g *WaitsForGraph) newDeadlockError(cycle []*Transaction, victim *Transaction) *DeadlockError {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	err := &DeadlockError{Victim: victim.GetClientID()}
+	for i, t := range cycle {
+		next := cycle[(i+1)%len(cycle)]
+		var resource Resource
+		for _, e := range g.edges {
+			if e.from == t && e.to == next && e.resource != nil {
+				resource = *e.resource
+				break
+			}
+		}
+		err.Cycle = append(err.Cycle, t.GetClientID())
+		err.Resources = append(err.Resources, resource)
+	}
+	return err
+}
+
+// ErrSelfWait is returned when a transaction would wait for itself. Unlike a DeadlockError,
+// which is a genuine deadlock between transactions, this is a bug in the lock manager or its caller.
+var ErrSelfWait = errors.New("transaction cannot wait for itself")
+
 // WaitsForGraph is a precedence graph used to keep track of whether
 // there are deadlocks in transactions
 type WaitsForGraph struct {
@@ -16,8 +65,9 @@ type WaitsForGraph struct {
 // if Txn1 is waiting for a resource held by Txn2,
 // then there is an Edge from Txn1 to Txn2
 type Edge struct {
-	from *Transaction
-	to   *Transaction
+	from     *Transaction
+	to       *Transaction
+	resource *Resource // The resource Txn1 is waiting for, if known
 }
 
 func NewGraph() *WaitsForGraph {
@@ -25,19 +75,28 @@ func NewGraph() *WaitsForGraph {
 }
 
 // Add an edge from `from` to `to`. Logically, `from` waits for `to`.
-func (g *WaitsForGraph) AddEdge(from *Transaction, to *Transaction) {
+// Errors with ErrSelfWait rather than add an edge from a transaction to itself.
+func (g *WaitsForGraph) AddEdge(from *Transaction, to *Transaction) error {
+	return g.addEdge(from, to, nil)
+}
+
+// Add an edge from `from` to `to` like AddEdge, labelled with the resource `from` waits for.
+func (g *WaitsForGraph) addEdge(from *Transaction, to *Transaction, r *Resource) error {
+	if from == to {
+		return ErrSelfWait
+	}
 	g.mtx.Lock()
 	defer g.mtx.Unlock()
-	g.edges = append(g.edges, Edge{from: from, to: to})
+	g.edges = append(g.edges, Edge{from: from, to: to, resource: r})
+	return nil
 }
 
 // Remove an edge. Only removes one of these edges if multiple copies exist.
 func (g *WaitsForGraph) RemoveEdge(from *Transaction, to *Transaction) error {
 	g.mtx.Lock()
 	defer g.mtx.Unlock()
-	toRemove := Edge{from: from, to: to}
 	for i, e := range g.edges {
-		if e == toRemove {
+		if e.from == from && e.to == to {
 			g.edges = removeHelper(g.edges, i)
 			return nil
 		}
@@ -45,6 +104,26 @@ func (g *WaitsForGraph) RemoveEdge(from *Transaction, to *Transaction) error {
 	return errors.New("edge not found")
 }
 
+// Remove every edge to or from the given transaction, such as once it finishes.
+func (g *WaitsForGraph) RemoveTransaction(t *Transaction) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	edges := g.edges[:0]
+	for _, e := range g.edges {
+		if e.from != t && e.to != t {
+			edges = append(edges, e)
+		}
+	}
+	g.edges = edges
+}
+
+// Return a copy of all the edges in the graph.
+func (g *WaitsForGraph) GetEdges() []Edge {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	return append([]Edge(nil), g.edges...)
+}
+
 // Remove the element at index `i` from `list`.
 func removeHelper(list []Edge, i int) []Edge {
 	list[i] = list[len(list)-1]
@@ -73,6 +152,39 @@ func (g *WaitsForGraph) DetectCycle() (hasCycle bool) {
 	/* SOLUTION }}} */
 }
 
+// Return the transactions on a cycle through the given transaction, starting with it,
+// or nil if there is none.
+func (g *WaitsForGraph) FindCycle(t *Transaction) []*Transaction {
+	g.mtx.RLock()
+	defer g.mtx.RUnlock()
+	seen := make(map[*Transaction]bool)
+	path := make([]*Transaction, 0)
+	var visit func(from *Transaction) bool
+	visit = func(from *Transaction) bool {
+		path = append(path, from)
+		for _, e := range g.edges {
+			if e.from != from {
+				continue
+			}
+			if e.to == t {
+				return true
+			}
+			if !seen[e.to] {
+				seen[e.to] = true
+				if visit(e.to) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if visit(t) {
+		return path
+	}
+	return nil
+}
+
 // depth-first search function to help detect cycles in a graph
 func dfs(g *WaitsForGraph, from *Transaction, seen map[*Transaction]bool) bool {
 	// Go through each edge.
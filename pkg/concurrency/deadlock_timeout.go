@@ -0,0 +1,80 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+/*
+   Under cycle detection, every lock request that has to wait searches the waits-for graph for
+   a cycle through its transaction before waiting, which gets expensive once many transactions
+   wait at once. With a deadlock timeout, a request instead only searches once it has waited for
+   the timeout, and again each time the timeout passes while it still waits. Most waits end
+   before then, so most requests never search at all. Edges are still added to the graph as each
+   request starts waiting, so the search finds the whole cycle once it runs.
+
+   A deadlock is then only broken once one of its transactions has waited for the timeout, rather
+   than right away. The victim is picked as with eager detection: if it's the transaction that
+   timed out, its request fails with the DeadlockError, and otherwise the victim is wounded.
+*/
+
+// WithDeadlockTimeout makes a lock request under cycle detection only look for a deadlock once
+// it has waited for the timeout, and again every timeout while it keeps waiting, rather than
+// before it starts waiting. A non-positive timeout looks for deadlocks eagerly, which is the default.
+func WithDeadlockTimeout(timeout time.Duration) Option {
+	return func(tm *TransactionManager) {
+		tm.deadlockTimeout = timeout
+	}
+}
+
+// detectDeadlock looks for a cycle in the waits-for graph through the transaction. If there is
+// one, the cheapest transaction in it must be aborted: the DeadlockError is returned if it's
+// the given transaction, and otherwise the victim is wounded so that it stops waiting. A victim
+// that was already wounded is on its way out, so the deadlock isn't counted again.
+func (tm *TransactionManager) detectDeadlock(t *Transaction) *DeadlockError {
+	cycle := tm.waitsForGraph.FindCycle(t)
+	if cycle == nil {
+		return nil
+	}
+	victim := cheapestTransaction(cycle)
+	if victim != t && victim.IsWounded() {
+		return nil
+	}
+	tm.counters.deadlocks.Add(1)
+	deadlock := tm.waitsForGraph.newDeadlockError(cycle, victim)
+	if victim == t {
+		return deadlock
+	}
+	victim.wound(deadlock)
+	return nil
+}
+
+// detectAfterTimeout looks for a deadlock through the waiting transaction every deadlockTimeout
+// until the returned function is called, cancelling the wait with the DeadlockError as the cause
+// should the transaction be the victim.
+func (tm *TransactionManager) detectAfterTimeout(t *Transaction, cancel context.CancelCauseFunc) (stop func()) {
+	var mtx sync.Mutex
+	stopped := false
+	mtx.Lock()
+	defer mtx.Unlock()
+	var timer *time.Timer
+	timer = time.AfterFunc(tm.deadlockTimeout, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if stopped {
+			return
+		}
+		if deadlock := tm.detectDeadlock(t); deadlock != nil {
+			cancel(deadlock)
+			return
+		}
+		timer.Reset(tm.deadlockTimeout)
+	})
+	return func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		stopped = true
+		timer.Stop()
+	}
+}
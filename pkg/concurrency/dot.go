@@ -0,0 +1,51 @@
+package concurrency
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+/*
+   ExportWaitsFor writes the waits-for graph in Graphviz DOT format for debugging deadlocks,
+   for example by piping it to `dot -Tsvg`. Every running transaction is a node named by its
+   client id, and every edge points from a waiting transaction to one it waits for, labelled
+   with the resource it waits for: "table" for a whole table, or "table/key" for an entry.
+
+   The edges are copied at once under the graph's read lock, while tm.mtx is read locked so
+   that no transaction ends, and with it every edge to or from it, before its node is listed.
+   Nodes and edges are sorted, so the same graph is always written the same way.
+*/
+
+// ExportWaitsFor writes the current waits-for graph to w in Graphviz DOT format.
+func (tm *TransactionManager) ExportWaitsFor(w io.Writer) error {
+	tm.mtx.RLock()
+	nodes := make([]string, 0, len(tm.transactions))
+	for clientId := range tm.transactions {
+		nodes = append(nodes, clientId.String())
+	}
+	edges := tm.waitsForGraph.GetEdges()
+	tm.mtx.RUnlock()
+
+	lines := make([]string, 0, len(edges))
+	for _, e := range edges {
+		line := fmt.Sprintf("\t%q -> %q", e.from.GetClientID().String(), e.to.GetClientID().String())
+		if e.resource != nil {
+			line += fmt.Sprintf(" [label=%q]", e.resource.String())
+		}
+		lines = append(lines, line+";\n")
+	}
+	slices.Sort(nodes)
+	slices.Sort(lines)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph waitsfor {")
+	for _, node := range nodes {
+		fmt.Fprintf(bw, "\t%q;\n", node)
+	}
+	fmt.Fprint(bw, strings.Join(lines, ""))
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
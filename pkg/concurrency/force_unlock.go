@@ -0,0 +1,43 @@
+package concurrency
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+   ForceUnlockAll lets an operator take a wedged transaction's locks away, as when its client's
+   connection hangs, without the client's cooperation. The transaction is wounded first, so that
+   its lock requests in flight give up with ErrForceUnlocked, and it is only ended once none are
+   left, so that no lock is granted to it after its locks were released. Its edits are not undone;
+   roll it back with the RecoveryManager first if it has any.
+*/
+
+// ErrForceUnlocked is returned to the lock requests of a transaction whose locks were forcibly released.
+var ErrForceUnlocked = errors.New("transaction's locks were forcibly released")
+
+// ForceUnlockAll releases every resource the client's transaction holds, removes it from the
+// waits-for graph, and ends it, logging a warning. Returns an error if no transaction is
+// running for the client.
+func (tm *TransactionManager) ForceUnlockAll(clientId uuid.UUID) error {
+	t, found := tm.GetTransaction(clientId)
+	if !found {
+		return errors.New("no transaction running for specified client")
+	}
+	t.wound(ErrForceUnlocked)
+	// Wounded lock requests stop waiting, so this doesn't wait long.
+	for t.locking.Load() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	t.RLock()
+	held := len(t.lockedResources)
+	t.RUnlock()
+	if err := tm.end(clientId, false); err != nil {
+		return err
+	}
+	log.Printf("warning: forcibly released %d locks held by client %s", held, clientId)
+	return nil
+}
@@ -0,0 +1,70 @@
+package concurrency
+
+import (
+	"cmp"
+	"slices"
+)
+
+/*
+   The ResourceLockManager counts, for every resource, how many lock requests had to wait for
+   it, so that operators can find the hotspots that serialize transactions, such as a single
+   counter row every transaction updates. A whole table counts the requests that waited to lock
+   it, including requests for its keys held up by a lock on the whole table. The counts are kept
+   until the TransactionManager's stats are reset.
+*/
+
+// ResourceContention is how contended the lock on a resource has been.
+type ResourceContention struct {
+	Resource Resource
+	Waits    int64 // The number of lock requests that had to wait for the resource
+	Queued   int   // The number of lock requests waiting for the resource now
+}
+
+// Hotspots returns the n most contended resources, most contended first, ordered by the number of
+// lock requests that had to wait for them and then by the number waiting now. Resources no lock
+// request has waited for are left out. A non-positive n returns every contended resource.
+func (lm *ResourceLockManager) Hotspots(n int) []ResourceContention {
+	var hotspots []ResourceContention
+	lm.mtx.Lock()
+	for r, lock := range lm.locks {
+		lock.mtx.Lock()
+		if lock.waits > 0 {
+			hotspots = append(hotspots, ResourceContention{Resource: r, Waits: lock.waits, Queued: len(lock.queue)})
+		}
+		lock.mtx.Unlock()
+	}
+	lm.mtx.Unlock()
+	tl := lm.tables
+	tl.mtx.Lock()
+	for table, waits := range tl.waits {
+		r := Resource{tableName: table, wholeTable: true}
+		hotspots = append(hotspots, ResourceContention{Resource: r, Waits: waits, Queued: tl.waiting[table]})
+	}
+	tl.mtx.Unlock()
+
+	slices.SortFunc(hotspots, func(a, b ResourceContention) int {
+		return cmp.Or(
+			cmp.Compare(b.Waits, a.Waits),
+			cmp.Compare(b.Queued, a.Queued),
+			cmp.Compare(a.Resource.String(), b.Resource.String()),
+		)
+	})
+	if n > 0 && len(hotspots) > n {
+		hotspots = hotspots[:n]
+	}
+	return hotspots
+}
+
+// resetContention zeroes the number of lock requests that waited for each resource.
+func (lm *ResourceLockManager) resetContention() {
+	lm.mtx.Lock()
+	for _, lock := range lm.locks {
+		lock.mtx.Lock()
+		lock.waits = 0
+		lock.mtx.Unlock()
+	}
+	lm.mtx.Unlock()
+	lm.tables.mtx.Lock()
+	clear(lm.tables.waits)
+	lm.tables.mtx.Unlock()
+}
@@ -0,0 +1,121 @@
+package concurrency
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A client that begins a transaction, takes locks, and then disappears would hold its locks
+   forever. With a transaction timeout set, a background reaper aborts every transaction that
+   has been idle for longer than the timeout, releasing its locks.
+
+   A transaction is active whenever it locks or unlocks a resource, or when Touch is called for
+   it, as the RecoveryManager does for every edit. A transaction waiting for a lock is never
+   idle, however long it waits. Idle transactions are aborted with the abort handler, which
+   defaults to Abort; the RecoveryManager sets it to Rollback, so that a reaped transaction's
+   edits are undone and an abort is logged.
+*/
+
+// WithTransactionTimeout reaps transactions that have been idle for longer than the timeout,
+// checking for them a few times per timeout. A non-positive timeout disables the reaper,
+// which is the default. See config.TransactionTimeout for a sensible default timeout.
+func WithTransactionTimeout(timeout time.Duration) Option {
+	return func(tm *TransactionManager) {
+		tm.idleTimeout = timeout
+	}
+}
+
+// SetAbortHandler sets how the reaper aborts an idle transaction. A nil handler restores the
+// default, Abort. The handler is expected to end the transaction, releasing its locks.
+func (tm *TransactionManager) SetAbortHandler(abort func(clientId uuid.UUID) error) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	tm.abortHandler = abort
+}
+
+// Touch marks the client's transaction as active now, so that it isn't reaped for being idle.
+// Touching a client with no running transaction is a no-op.
+func (tm *TransactionManager) Touch(clientId uuid.UUID) {
+	if t, found := tm.GetTransaction(clientId); found {
+		t.touch()
+	}
+}
+
+// Close stops the reaper, if running. Transactions are left running.
+func (tm *TransactionManager) Close() {
+	tm.closeOnce.Do(func() {
+		if tm.stop != nil {
+			close(tm.stop)
+			tm.reaper.Wait()
+		}
+	})
+}
+
+// startReaper starts the reaper if a transaction timeout is set.
+func (tm *TransactionManager) startReaper() {
+	if tm.idleTimeout <= 0 {
+		return
+	}
+	tm.stop = make(chan struct{})
+	tm.reaper.Add(1)
+	go tm.runReaper()
+}
+
+// runReaper aborts idle transactions, checking for them once every quarter of the
+// transaction timeout, until tm.stop is closed.
+func (tm *TransactionManager) runReaper() {
+	defer tm.reaper.Done()
+	ticker := time.NewTicker(max(tm.idleTimeout/4, time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-tm.stop:
+			return
+		case <-ticker.C:
+		}
+		tm.mtx.RLock()
+		abort := tm.abortHandler
+		if abort == nil {
+			abort = tm.Abort
+		}
+		var idle []uuid.UUID
+		for clientId, t := range tm.transactions {
+			if t.idleFor() > tm.idleTimeout {
+				idle = append(idle, clientId)
+			}
+		}
+		tm.mtx.RUnlock()
+		for _, clientId := range idle {
+			// The transaction may have ended or been aborted since; either way it's no longer idle.
+			if abort(clientId) == nil {
+				tm.counters.reaped.Add(1)
+			}
+		}
+	}
+}
+
+// touch marks the transaction as active now.
+func (t *Transaction) touch() {
+	t.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the transaction has been idle, or 0 if it is waiting for a lock.
+func (t *Transaction) idleFor() time.Duration {
+	if t.locking.Load() > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, t.lastActivity.Load()))
+}
+
+// startLocking marks the transaction as active until the returned function is called,
+// which marks it active again.
+func (t *Transaction) startLocking() (done func()) {
+	t.locking.Add(1)
+	t.touch()
+	return func() {
+		t.touch()
+		t.locking.Add(-1)
+	}
+}
@@ -0,0 +1,25 @@
+package concurrency
+
+import "errors"
+
+// ErrTooManyTransactions is returned by Begin when the maximum number of active transactions
+// are already running. The client may retry once another transaction ends.
+var ErrTooManyTransactions = errors.New("too many active transactions")
+
+// WithMaxActiveTransactions limits how many transactions may run at once, so that Begin
+// returns ErrTooManyTransactions rather than accept unlimited transactions.
+// A non-positive limit means no limit, which is the default.
+func WithMaxActiveTransactions(limit int) Option {
+	return func(tm *TransactionManager) {
+		tm.maxActive = limit
+	}
+}
+
+// SetMaxActiveTransactions changes the limit on how many transactions may run at once, as set
+// by WithMaxActiveTransactions. Lowering the limit below the number of running transactions
+// doesn't end any of them, but no more may begin until enough of them have ended.
+func (tm *TransactionManager) SetMaxActiveTransactions(limit int) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	tm.maxActive = limit
+}
@@ -0,0 +1,116 @@
+package concurrency
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+
+	"dinodb/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+/*
+   Two transactions that lock the same entries in different orders can deadlock, each holding
+   an entry the other wants next. LockAll avoids this by sorting a set of lock requests into a
+   canonical order, by table name, then key, then lock type, before locking them one at a time,
+   so any two transactions locking overlapping sets with LockAll lock their common entries in
+   the same order, and one simply waits for the other.
+
+   Requests for the same entry are merged into one for the strongest lock type requested, so a
+   transaction never upgrades its own lock partway through the set. The requests are locked as
+   a unit: if any of them fails, the locks taken by the call are released again, leaving only
+   the locks the transaction held before it. A read lock held before the call that the call
+   upgraded is left as a write lock.
+*/
+
+// LockRequest requests a lock on one entry of a table, for LockAll.
+type LockRequest struct {
+	Table database.Index
+	Key   int64
+	Type  LockType
+}
+
+// LockAll locks every requested entry for the client's transaction in a canonical order, so
+// that transactions locking overlapping sets with LockAll never deadlock against each other.
+// If any lock fails, the locks taken by the call are released and the error is returned.
+func (tm *TransactionManager) LockAll(clientId uuid.UUID, requests []LockRequest) error {
+	return tm.LockAllContext(context.Background(), clientId, requests)
+}
+
+// LockAllContext locks every requested entry like LockAll, but gives up waiting for them like
+// LockContext, releasing the locks taken by the call.
+func (tm *TransactionManager) LockAllContext(ctx context.Context, clientId uuid.UUID, requests []LockRequest) error {
+	t, found := tm.GetTransaction(clientId)
+	if !found {
+		return errors.New("transaction not found")
+	}
+	resources := canonicalOrder(requests)
+	t.RLock()
+	held := make(map[Resource]LockType, len(resources))
+	for _, r := range resources {
+		if lType, ok := t.lockedResources[r.resource]; ok {
+			held[r.resource] = lType
+		}
+	}
+	t.RUnlock()
+
+	for i, r := range resources {
+		if err := tm.lock(ctx, clientId, r.resource, r.lType); err != nil {
+			// Release what this call took, in reverse order. A failed lock holds nothing.
+			for j := i - 1; j >= 0; j-- {
+				if lType, ok := held[resources[j].resource]; ok {
+					// Read locking a read locked entry took another hold of it.
+					if lType == R_LOCK && resources[j].lType == R_LOCK {
+						tm.unlock(clientId, resources[j].resource, R_LOCK)
+					}
+					continue
+				}
+				t.RLock()
+				lType, locked := t.lockedResources[resources[j].resource]
+				t.RUnlock()
+				if locked {
+					tm.unlock(clientId, resources[j].resource, lType)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// requestedLock is a resource to lock with the given type.
+type requestedLock struct {
+	resource Resource
+	lType    LockType
+}
+
+// Returns the resources of the requests sorted by table name, key, and lock type, with
+// requests for the same resource merged into the strongest lock type requested.
+func canonicalOrder(requests []LockRequest) []requestedLock {
+	locks := make([]requestedLock, 0, len(requests))
+	for _, req := range requests {
+		locks = append(locks, requestedLock{
+			resource: Resource{tableName: req.Table.GetName(), key: req.Key},
+			lType:    req.Type,
+		})
+	}
+	slices.SortFunc(locks, func(a, b requestedLock) int {
+		return cmp.Or(
+			cmp.Compare(a.resource.tableName, b.resource.tableName),
+			cmp.Compare(a.resource.key, b.resource.key),
+			cmp.Compare(a.lType, b.lType),
+		)
+	})
+	// Sorting puts a resource's write lock after its read lock, so keep the last of each.
+	merged := locks[:0]
+	for _, l := range locks {
+		if n := len(merged); n > 0 && merged[n-1].resource == l.resource {
+			merged[n-1] = l
+			continue
+		}
+		merged = append(merged, l)
+	}
+	return merged
+}
@@ -0,0 +1,215 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"dinodb/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+/*
+   Locking the entries a range scan reads doesn't stop another transaction from inserting a new
+   entry into the scanned range, a phantom the scan would see if it ran again. A transaction can
+   lock a range of a table's keys instead, from lo to hi inclusive, which conflicts with any lock
+   on a key in the range and with any overlapping range lock, so a transaction that read locks
+   a range keeps every other transaction from writing, and so inserting, any key in it until it
+   ends. Like a key, a range first locks its table with the matching intention.
+
+   A range is checked against the keys locked by other transactions, so the lock manager tracks
+   the holders of every lock on an int64 key alongside the ranges, under the same mutex: a key
+   lock waits for the conflicting ranges before it is taken, and a range lock waits for the
+   conflicting keys and ranges. A transaction's own locks never conflict with each other, so it
+   can lock keys within its own ranges. Composite keys aren't ordered, so ranges never cover them.
+*/
+
+// rangeLocks tracks the key ranges and int64 keys each transaction holds locks on.
+type rangeLocks struct {
+	ranges  map[string][]heldRange                        // The ranges locked in each table
+	keys    map[string]map[int64]map[*Transaction]*[2]int // The number of times each transaction holds each key in each lock type
+	changed chan struct{}                                 // Closed and replaced when a lock is released while a request is waiting
+	waiting int                                           // The number of requests waiting now
+	mtx     sync.Mutex
+}
+
+// heldRange is a lock held on a range of keys.
+type heldRange struct {
+	t      *Transaction
+	lo, hi int64
+	lType  LockType
+}
+
+func newRangeLocks() *rangeLocks {
+	return &rangeLocks{
+		ranges:  make(map[string][]heldRange),
+		keys:    make(map[string]map[int64]map[*Transaction]*[2]int),
+		changed: make(chan struct{}),
+	}
+}
+
+// LockRange locks the range of the table's keys from lo to hi inclusive, like Lock locks a single
+// entry. A range read lock keeps other transactions from writing any key in the range, including
+// inserting new ones, and a range write lock keeps them from locking any key in it at all.
+// Returns an error if lo is greater than hi.
+func (tm *TransactionManager) LockRange(clientId uuid.UUID, table database.Index, lo int64, hi int64, lType LockType) error {
+	return tm.LockRangeContext(context.Background(), clientId, table, lo, hi, lType)
+}
+
+// LockRangeContext locks a range of the table's keys like LockRange, but gives up waiting for it
+// like LockContext. A range's read lock being upgraded is kept if the upgrade gives up.
+func (tm *TransactionManager) LockRangeContext(ctx context.Context, clientId uuid.UUID, table database.Index, lo int64, hi int64, lType LockType) error {
+	if lo > hi {
+		return errors.New("range starts after it ends")
+	}
+	return tm.lock(ctx, clientId, Resource{tableName: table.GetName(), key: lo, hi: hi, keyRange: true}, lType)
+}
+
+// UnlockRange unlocks a range of the table's keys locked by LockRange.
+func (tm *TransactionManager) UnlockRange(clientId uuid.UUID, table database.Index, lo int64, hi int64, lType LockType) error {
+	return tm.unlock(clientId, Resource{tableName: table.GetName(), key: lo, hi: hi, keyRange: true}, lType)
+}
+
+// lockRange locks the range or key resource for the transaction in the range locks, waiting until
+// no other transaction holds a conflicting range or key, or returning ctx.Err() if ctx is done first.
+// Does nothing for other resources.
+func (lm *ResourceLockManager) lockRange(ctx context.Context, t *Transaction, r Resource, lType LockType) error {
+	if !r.keyRange && !r.isKey() {
+		return nil
+	}
+	rl := lm.ranges
+	waited := false
+	for {
+		rl.mtx.Lock()
+		if rl.compatible(t, r, lType) {
+			rl.add(t, r, lType)
+			if waited {
+				rl.waiting--
+			}
+			rl.mtx.Unlock()
+			return nil
+		}
+		if !waited {
+			waited = true
+			rl.waiting++
+		}
+		changed := rl.changed
+		rl.mtx.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			rl.mtx.Lock()
+			rl.waiting--
+			rl.mtx.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// tryLockRange locks the resource like lockRange if it can be locked without waiting, and
+// otherwise returns false.
+func (lm *ResourceLockManager) tryLockRange(t *Transaction, r Resource, lType LockType) bool {
+	if !r.keyRange && !r.isKey() {
+		return true
+	}
+	rl := lm.ranges
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	if !rl.compatible(t, r, lType) {
+		return false
+	}
+	rl.add(t, r, lType)
+	return true
+}
+
+// unlockRange releases one hold of the range or key resource by the transaction.
+func (lm *ResourceLockManager) unlockRange(t *Transaction, r Resource, lType LockType) {
+	if !r.keyRange && !r.isKey() {
+		return
+	}
+	rl := lm.ranges
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	if r.keyRange {
+		held := rl.ranges[r.tableName]
+		for i, h := range held {
+			if h.t == t && h.lo == r.key && h.hi == r.hi && h.lType == lType {
+				held = append(held[:i], held[i+1:]...)
+				break
+			}
+		}
+		if len(held) == 0 {
+			delete(rl.ranges, r.tableName)
+		} else {
+			rl.ranges[r.tableName] = held
+		}
+	} else {
+		counts, ok := rl.keys[r.tableName][r.key][t]
+		if !ok || counts[lType] == 0 {
+			return
+		}
+		counts[lType]--
+		if *counts == [2]int{} {
+			delete(rl.keys[r.tableName][r.key], t)
+			if len(rl.keys[r.tableName][r.key]) == 0 {
+				delete(rl.keys[r.tableName], r.key)
+			}
+			if len(rl.keys[r.tableName]) == 0 {
+				delete(rl.keys, r.tableName)
+			}
+		}
+	}
+	// Wake everyone waiting for a range or key to check again, if anyone is.
+	if rl.waiting > 0 {
+		close(rl.changed)
+		rl.changed = make(chan struct{})
+	}
+}
+
+// compatible returns whether the transaction can lock the range or key resource with the given
+// lock type. Expects rl.mtx to be held.
+func (rl *rangeLocks) compatible(t *Transaction, r Resource, lType LockType) bool {
+	for _, h := range rl.ranges[r.tableName] {
+		held := Resource{tableName: r.tableName, key: h.lo, hi: h.hi, keyRange: true}
+		if h.t != t && conflicts(held, h.lType, r, lType) {
+			return false
+		}
+	}
+	if !r.keyRange {
+		return true
+	}
+	for key, holders := range rl.keys[r.tableName] {
+		if key < r.key || key > r.hi {
+			continue
+		}
+		for holder, counts := range holders {
+			if holder != t && (lType == W_LOCK || counts[W_LOCK] > 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// add records a hold of the range or key resource by the transaction. Expects rl.mtx to be held.
+func (rl *rangeLocks) add(t *Transaction, r Resource, lType LockType) {
+	if r.keyRange {
+		rl.ranges[r.tableName] = append(rl.ranges[r.tableName], heldRange{t: t, lo: r.key, hi: r.hi, lType: lType})
+		return
+	}
+	if rl.keys[r.tableName] == nil {
+		rl.keys[r.tableName] = make(map[int64]map[*Transaction]*[2]int)
+	}
+	holders := rl.keys[r.tableName][r.key]
+	if holders == nil {
+		holders = make(map[*Transaction]*[2]int)
+		rl.keys[r.tableName][r.key] = holders
+	}
+	counts, ok := holders[t]
+	if !ok {
+		counts = &[2]int{}
+		holders[t] = counts
+	}
+	counts[lType]++
+}
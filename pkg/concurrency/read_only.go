@@ -0,0 +1,53 @@
+package concurrency
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A read-only transaction only takes read locks, which never conflict with each other, so it
+   needs no edges in the waits-for graph unless a writer is in its way. When a read-only
+   transaction locks an entry or table that no writer holds or is waiting for, it is granted
+   the lock at once, without looking for conflicting transactions, touching the waits-for graph,
+   or being recorded as waiting under wound-wait. This keeps the graph small for read-heavy
+   workloads. Otherwise it locks like any other transaction, waiting for the writers and adding
+   edges to them, since a reader can still deadlock with writers.
+*/
+
+// ErrReadOnly is returned when a read-only transaction requests a write lock.
+var ErrReadOnly = errors.New("read-only transaction cannot take a write lock")
+
+// BeginReadOnly begins a read-only transaction for the given client, like Begin. Its requests
+// for write locks fail with ErrReadOnly.
+func (tm *TransactionManager) BeginReadOnly(clientId uuid.UUID) error {
+	return tm.begin(clientId, true)
+}
+
+// Returns whether the transaction is read-only.
+func (t *Transaction) IsReadOnly() bool {
+	return t.readOnly
+}
+
+// Read locks the resource for the transaction if it can be without waiting, along with the
+// matching intention on its table if it's an entry or a range, returning whether it did.
+func (tm *TransactionManager) tryReadLock(t *Transaction, r Resource) bool {
+	lm := tm.resourceLockManager
+	if r.wholeTable {
+		return lm.tryLockTable(t, r.tableName, shared)
+	}
+	if !lm.tryLockTable(t, r.tableName, intentionShared) {
+		return false
+	}
+	if !lm.tryLockRange(t, r, R_LOCK) {
+		lm.unlockTable(t, r.tableName, intentionShared)
+		return false
+	}
+	if !r.keyRange && !lm.TryLock(r, R_LOCK) {
+		lm.unlockRange(t, r, R_LOCK)
+		lm.unlockTable(t, r.tableName, intentionShared)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package concurrency
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A client whose connection drops and reconnects under a new client id can resume its running
+   transaction rather than orphaning it. Locks are held and waited for by the transaction itself,
+   not by its client id, so handing it over only rekeys it: its locks, its place in the waits-for
+   graph, and its age under wound-wait all carry over. Lock requests made under the old id
+   afterwards fail as for any client without a transaction.
+*/
+
+// ReclaimTransaction hands the transaction running for oldClientId, with every lock it holds,
+// over to newClientId. Returns an error if no transaction is running for oldClientId, or if
+// one is already running for newClientId.
+func (tm *TransactionManager) ReclaimTransaction(oldClientId uuid.UUID, newClientId uuid.UUID) error {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+	t, found := tm.transactions[oldClientId]
+	if !found {
+		return errors.New("no transaction running for specified client")
+	}
+	if _, found = tm.transactions[newClientId]; found {
+		return errors.New("transaction already began")
+	}
+	t.clientId.Store(&newClientId)
+	delete(tm.transactions, oldClientId)
+	tm.transactions[newClientId] = t
+	t.touch()
+	return nil
+}
@@ -0,0 +1,41 @@
+package concurrency
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+/*
+   Reset brings a TransactionManager back to the state of a new one, as after restarting the
+   database: every transaction is aborted, releasing its locks, and no wounded transaction or
+   assigned timestamp is remembered for when its client begins again. Recovery resets the
+   transaction manager before rolling back the transactions that didn't commit, so that clients
+   that were running before a crash are cleanly gone and the lock tables start out empty. The
+   clock is kept, so that timestamps aren't given out twice; see clock.go.
+*/
+
+// ErrReset is returned to the lock requests of transactions that were waiting when the
+// transaction manager was reset.
+var ErrReset = errors.New("transaction manager was reset")
+
+// Reset aborts every running transaction, releasing all of their locks, and forgets the
+// timestamps of wounded transactions and those assigned with AssignTimestamp. Transactions
+// waiting for a lock stop waiting with ErrReset.
+// Undoing the transactions' edits is left to the caller, as in RecoveryManager.Recover.
+func (tm *TransactionManager) Reset() {
+	tm.mtx.Lock()
+	for _, t := range tm.transactions {
+		t.wound(ErrReset)
+	}
+	ids := slices.Collect(maps.Keys(tm.transactions))
+	tm.mtx.Unlock()
+	for _, id := range ids {
+		// The transaction could have ended on its own since.
+		_ = tm.end(id, false)
+	}
+	tm.mtx.Lock()
+	clear(tm.restarting)
+	clear(tm.assigned)
+	tm.mtx.Unlock()
+}
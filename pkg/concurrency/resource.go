@@ -1,5 +1,7 @@
 package concurrency
 
+import "fmt"
+
 // Indicates whether a lock is a reader or a writer lock.
 type LockType int
 
@@ -9,10 +11,15 @@ const (
 )
 
 // A Resource refers to an entry in our database,
-// uniquely identified by tableName and key
+// uniquely identified by tableName and key, or by tableName and a composite key, or to a whole table,
+// or to a range of a table's keys, from key to hi inclusive
 type Resource struct {
-	tableName string
-	key       int64
+	tableName  string
+	key        int64
+	composite  CompositeKey // The entry's composite key, if it's identified by one rather than key
+	wholeTable bool
+	keyRange   bool  // Whether the resource is the range of keys from key to hi; see range_locks.go
+	hi         int64 // The last key of the range, if the resource is a range
 }
 
 func (r *Resource) GetTableName() string {
@@ -22,3 +29,74 @@ func (r *Resource) GetTableName() string {
 func (r *Resource) GetResourceKey() int64 {
 	return r.key
 }
+
+// Returns the entry's composite key, and whether the entry is identified by one rather than an int64 key.
+func (r *Resource) GetCompositeKey() (CompositeKey, bool) {
+	return r.composite, r.composite != ""
+}
+
+// Returns whether the resource is a whole table rather than one of its entries.
+func (r *Resource) IsTable() bool {
+	return r.wholeTable
+}
+
+// Returns the first and last keys of the range the resource covers, and whether the resource is a
+// range of keys rather than a single entry or a whole table.
+func (r *Resource) GetRange() (lo int64, hi int64, isRange bool) {
+	if !r.keyRange {
+		return 0, 0, false
+	}
+	return r.key, r.hi, true
+}
+
+// Returns the resource as "table" for a whole table, "table/key" for an entry, "table/[lo,hi]"
+// for a range of keys, or "table/0x..." for an entry with a composite key, in hex.
+func (r Resource) String() string {
+	switch {
+	case r.wholeTable:
+		return r.tableName
+	case r.keyRange:
+		return fmt.Sprintf("%s/[%d,%d]", r.tableName, r.key, r.hi)
+	case r.composite != "":
+		return fmt.Sprintf("%s/%#x", r.tableName, string(r.composite))
+	default:
+		return fmt.Sprintf("%s/%d", r.tableName, r.key)
+	}
+}
+
+// Returns whether locking the two resources with the given lock types conflicts.
+// Locking a whole table conflicts with locking any of its entries, and locking a range of keys
+// conflicts with locking any key in the range or any overlapping range.
+func conflicts(r1 Resource, lType1 LockType, r2 Resource, lType2 LockType) bool {
+	if r1.tableName != r2.tableName {
+		return false
+	}
+	if !r1.wholeTable && !r2.wholeTable && !overlap(r1, r2) {
+		return false
+	}
+	return lType1 == W_LOCK || lType2 == W_LOCK
+}
+
+// Returns whether two resources of the same table, neither of them the whole table, cover a common
+// entry. Ranges only cover int64 keys, so they never overlap an entry with a composite key.
+func overlap(r1 Resource, r2 Resource) bool {
+	if !r1.keyRange && !r2.keyRange {
+		return r1.key == r2.key && r1.composite == r2.composite
+	}
+	if r1.composite != "" || r2.composite != "" {
+		return false
+	}
+	hi1, hi2 := r1.key, r2.key
+	if r1.keyRange {
+		hi1 = r1.hi
+	}
+	if r2.keyRange {
+		hi2 = r2.hi
+	}
+	return r1.key <= hi2 && r2.key <= hi1
+}
+
+// Returns whether the resource is an entry identified by an int64 key, which ranges can cover.
+func (r *Resource) isKey() bool {
+	return !r.wholeTable && !r.keyRange && r.composite == ""
+}
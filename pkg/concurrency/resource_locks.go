@@ -1,57 +1,91 @@
 package concurrency
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
 
 // ResourceLockManager handles the locking of database resources.
 type ResourceLockManager struct {
-	locks map[Resource]*sync.RWMutex
-	mtx   sync.Mutex
+	locks  map[Resource]*queueLock // Each resource's lock, which grants its waiters in FIFO order
+	tables *tableLocks             // Locks on whole tables, and the intentions of locks on their keys
+	ranges *rangeLocks             // Locks on ranges of keys, and the holders of locks on keys; see range_locks.go
+	mtx    sync.Mutex
 }
 
 func NewResourceLockManager() *ResourceLockManager {
 	return &ResourceLockManager{
-		locks: make(map[Resource]*sync.RWMutex),
+		locks:  make(map[Resource]*queueLock),
+		tables: newTableLocks(),
+		ranges: newRangeLocks(),
 	}
 }
 
 // Lock the resource in the database (read lock or write lock depending on `lType`)
 func (lm *ResourceLockManager) Lock(r Resource, lType LockType) error {
-	// Safely acquire the mutex guarding the Resource, initializing the mutex if needed
+	return lm.LockContext(context.Background(), r, lType)
+}
+
+// LockContext locks the resource like Lock, but gives up and returns ctx.Err() if ctx is done
+// before the lock is acquired. Locks on a resource are granted in the order they were requested.
+func (lm *ResourceLockManager) LockContext(ctx context.Context, r Resource, lType LockType) error {
+	return lm.getLock(r).lock(ctx, lType)
+}
+
+// TryLock locks the resource like Lock if it can be locked without waiting, and otherwise returns false.
+func (lm *ResourceLockManager) TryLock(r Resource, lType LockType) bool {
+	return lm.getLock(r).tryLock(lType)
+}
+
+// Safely acquire the lock guarding the Resource, initializing the lock if needed
+func (lm *ResourceLockManager) getLock(r Resource) *queueLock {
 	lm.mtx.Lock()
+	defer lm.mtx.Unlock()
 	lock, found := lm.locks[r]
 	if !found {
-		lm.locks[r] = &sync.RWMutex{}
-		lock = lm.locks[r]
-	}
-	lm.mtx.Unlock()
-	// Lock accordingly
-	switch lType {
-	case R_LOCK:
-		lock.RLock()
-	case W_LOCK:
-		lock.Lock()
+		lock = &queueLock{}
+		lm.locks[r] = lock
 	}
-	return nil
+	return lock
 }
 
 // Unlock the resource in the database (read unlock or write unlock depending on `lType`)
 func (lm *ResourceLockManager) Unlock(r Resource, lType LockType) error {
-	// Safely acquire the mutex guarding the Resource
+	// Safely acquire the lock guarding the Resource
 	lm.mtx.Lock()
 	lock, found := lm.locks[r]
+	lm.mtx.Unlock()
 	if !found {
 		return errors.New("tried to unlock nonexistent resource")
 	}
-	lm.mtx.Unlock()
-	// Unlock accordingly
-	switch lType {
-	case R_LOCK:
-		lock.RUnlock()
-	case W_LOCK:
-		lock.Unlock()
+	return lock.unlock(lType)
+}
+
+// Upgrade a held read lock on the resource to a write lock. The read lock is released before
+// the write lock is acquired, so another writer may take the resource in between.
+func (lm *ResourceLockManager) Upgrade(r Resource) error {
+	return lm.UpgradeContext(context.Background(), r)
+}
+
+// UpgradeContext upgrades the lock like Upgrade, but gives up and returns ctx.Err() if ctx is
+// done before the write lock is acquired. The read lock isn't held again when it gives up,
+// since waiting for it could block as long as the write lock.
+func (lm *ResourceLockManager) UpgradeContext(ctx context.Context, r Resource) error {
+	if err := lm.Unlock(r, R_LOCK); err != nil {
+		return err
+	}
+	return lm.LockContext(ctx, r, W_LOCK)
+}
+
+// tryUpgrade upgrades a held read lock on the resource to a write lock if it can be done without
+// waiting. Otherwise, the read lock is taken back if that can be done without waiting.
+// Returns whether the lock was upgraded, and whether the read lock is still held if not.
+func (lm *ResourceLockManager) tryUpgrade(r Resource) (upgraded bool, readHeld bool) {
+	lock := lm.getLock(r)
+	lock.unlock(R_LOCK)
+	if lock.tryLock(W_LOCK) {
+		return true, false
 	}
-	return nil
+	return false, lock.tryLock(R_LOCK)
 }
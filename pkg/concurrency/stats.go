@@ -0,0 +1,63 @@
+package concurrency
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// LockStats summarizes lock contention in a TransactionManager since it was created or its
+// stats were last reset.
+type LockStats struct {
+	LocksGranted       int64         // The number of locks acquired, including by TryLock
+	LockWaits          int64         // The number of locks acquired after waiting for another transaction
+	DeadlocksDetected  int64         // The number of lock requests that found a deadlock
+	AverageWait        time.Duration // The average time spent waiting by the locks that waited
+	ActiveTransactions int           // The number of transactions running now
+	TransactionsReaped int64         // The number of transactions aborted for being idle too long
+}
+
+// lockCounters accumulates the counts behind LockStats.
+type lockCounters struct {
+	granted   atomic.Int64
+	waits     atomic.Int64
+	deadlocks atomic.Int64
+	waitNanos atomic.Int64 // The total time spent waiting by the locks that waited
+	reaped    atomic.Int64
+}
+
+// Stats returns the lock contention counts accumulated so far.
+func (tm *TransactionManager) Stats() LockStats {
+	stats := LockStats{
+		LocksGranted:       tm.counters.granted.Load(),
+		LockWaits:          tm.counters.waits.Load(),
+		DeadlocksDetected:  tm.counters.deadlocks.Load(),
+		TransactionsReaped: tm.counters.reaped.Load(),
+	}
+	if stats.LockWaits > 0 {
+		stats.AverageWait = time.Duration(tm.counters.waitNanos.Load() / stats.LockWaits)
+	}
+	tm.mtx.RLock()
+	stats.ActiveTransactions = len(tm.transactions)
+	tm.mtx.RUnlock()
+	return stats
+}
+
+// ResetStats zeroes the lock contention counts, including the resource lock manager's
+// per-resource counts; see Hotspots.
+func (tm *TransactionManager) ResetStats() {
+	tm.resourceLockManager.resetContention()
+	tm.counters.granted.Store(0)
+	tm.counters.waits.Store(0)
+	tm.counters.deadlocks.Store(0)
+	tm.counters.waitNanos.Store(0)
+	tm.counters.reaped.Store(0)
+}
+
+// recordGranted counts a granted lock, and how long it waited if it had to wait.
+func (c *lockCounters) recordGranted(waited bool, start time.Time) {
+	c.granted.Add(1)
+	if waited {
+		c.waits.Add(1)
+		c.waitNanos.Add(int64(time.Since(start)))
+	}
+}
@@ -0,0 +1,164 @@
+package concurrency
+
+import (
+	"context"
+	"sync"
+)
+
+// tableMode is a mode a table can be locked in. Locking a whole table locks it in a shared or
+// exclusive mode, while locking one of its keys first locks the table in the matching intention
+// mode, so that key locks and whole-table locks can be checked against each other at the table.
+type tableMode int
+
+const (
+	intentionShared    tableMode = iota // IS: a key of the table is read locked
+	intentionExclusive                  // IX: a key of the table is write locked
+	shared                              // S: the whole table is read locked
+	exclusive                           // X: the whole table is write locked
+)
+
+// tableModesCompatible[a][b] is whether a table can be locked in mode a while another
+// transaction holds it in mode b.
+var tableModesCompatible = [4][4]bool{
+	intentionShared:    {true, true, true, false},
+	intentionExclusive: {true, true, false, false},
+	shared:             {true, false, true, false},
+	exclusive:          {false, false, false, false},
+}
+
+// Returns the mode that locking a whole table with the given lock type locks it in.
+func tableModeOf(lType LockType) tableMode {
+	if lType == W_LOCK {
+		return exclusive
+	}
+	return shared
+}
+
+// Returns the intention mode that locking a key with the given lock type locks its table in.
+func intentionOf(lType LockType) tableMode {
+	if lType == W_LOCK {
+		return intentionExclusive
+	}
+	return intentionShared
+}
+
+// tableLocks tracks the modes each transaction holds each table in. Unlike a key's mutex, a table
+// lock knows its holders, so a transaction's own locks on a table never conflict with each other.
+type tableLocks struct {
+	held    map[string]map[*Transaction]*[4]int // The number of times each transaction holds each table in each mode
+	changed chan struct{}                       // Closed and replaced whenever a table lock is released
+	waits   map[string]int64                    // The number of table lock requests that had to wait for each table
+	waiting map[string]int                      // The number of table lock requests waiting for each table now
+	mtx     sync.Mutex
+}
+
+func newTableLocks() *tableLocks {
+	return &tableLocks{
+		held:    make(map[string]map[*Transaction]*[4]int),
+		changed: make(chan struct{}),
+		waits:   make(map[string]int64),
+		waiting: make(map[string]int),
+	}
+}
+
+// lockTable locks the table in the given mode for the transaction, waiting until no other transaction
+// holds it in a conflicting mode, or returning ctx.Err() if ctx is done first.
+func (lm *ResourceLockManager) lockTable(ctx context.Context, t *Transaction, table string, mode tableMode) error {
+	tl := lm.tables
+	waited := false
+	for {
+		tl.mtx.Lock()
+		if tl.compatible(t, table, mode) {
+			tl.add(t, table, mode)
+			if waited {
+				tl.stopWaiting(table)
+			}
+			tl.mtx.Unlock()
+			return nil
+		}
+		if !waited {
+			waited = true
+			tl.waits[table]++
+			tl.waiting[table]++
+		}
+		changed := tl.changed
+		tl.mtx.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			tl.mtx.Lock()
+			tl.stopWaiting(table)
+			tl.mtx.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// stopWaiting records that a request waiting for the table is no longer waiting. Expects tl.mtx to be held.
+func (tl *tableLocks) stopWaiting(table string) {
+	tl.waiting[table]--
+	if tl.waiting[table] == 0 {
+		delete(tl.waiting, table)
+	}
+}
+
+// tryLockTable locks the table like lockTable if it can be locked without waiting, and otherwise returns false.
+func (lm *ResourceLockManager) tryLockTable(t *Transaction, table string, mode tableMode) bool {
+	tl := lm.tables
+	tl.mtx.Lock()
+	defer tl.mtx.Unlock()
+	if !tl.compatible(t, table, mode) {
+		return false
+	}
+	tl.add(t, table, mode)
+	return true
+}
+
+// unlockTable releases one hold of the table in the given mode by the transaction.
+func (lm *ResourceLockManager) unlockTable(t *Transaction, table string, mode tableMode) {
+	tl := lm.tables
+	tl.mtx.Lock()
+	defer tl.mtx.Unlock()
+	counts, ok := tl.held[table][t]
+	if !ok || counts[mode] == 0 {
+		return
+	}
+	counts[mode]--
+	if *counts == [4]int{} {
+		delete(tl.held[table], t)
+		if len(tl.held[table]) == 0 {
+			delete(tl.held, table)
+		}
+	}
+	// Wake everyone waiting for a table lock to check again.
+	close(tl.changed)
+	tl.changed = make(chan struct{})
+}
+
+// compatible returns whether the transaction can lock the table in the given mode. Expects tl.mtx to be held.
+func (tl *tableLocks) compatible(t *Transaction, table string, mode tableMode) bool {
+	for holder, counts := range tl.held[table] {
+		if holder == t {
+			continue
+		}
+		for heldMode, count := range counts {
+			if count > 0 && !tableModesCompatible[mode][heldMode] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// add records a hold of the table in the given mode by the transaction. Expects tl.mtx to be held.
+func (tl *tableLocks) add(t *Transaction, table string, mode tableMode) {
+	if tl.held[table] == nil {
+		tl.held[table] = make(map[*Transaction]*[4]int)
+	}
+	counts, ok := tl.held[table][t]
+	if !ok {
+		counts = &[4]int{}
+		tl.held[table][t] = counts
+	}
+	counts[mode]++
+}
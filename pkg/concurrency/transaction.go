@@ -1,7 +1,9 @@
 package concurrency
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 )
@@ -9,9 +11,18 @@ import (
 // Each client will have at most one transaction running at a given time.
 // Therefore, the clientID is a unique identifier for both the Transaction and its Client
 type Transaction struct {
-	clientId        uuid.UUID
-	lockedResources map[Resource]LockType 	// tracks currently locked resources and LockType. Useful for error handling when Locking
+	clientId        atomic.Pointer[uuid.UUID] // changes when the transaction is reclaimed; see reclaim.go
+	lockedResources map[Resource]LockType     // tracks currently locked resources and LockType. Useful for error handling when Locking
+	holds           map[Resource]int          // the number of times each read locked resource was locked again, each needing its own unlock
 	mtx             sync.RWMutex
+	timestamp       uint64                  // orders transactions by age under wound-wait; lower is older
+	woundCtx        context.Context         // done once this transaction is wounded
+	wound           context.CancelCauseFunc // wounds this transaction
+	waitingFor      *Resource               // the resource this transaction is waiting to lock, if any
+	waitingType     LockType                // the type of lock this transaction is waiting for
+	lastActivity    atomic.Int64            // when this transaction was last active, in Unix nanoseconds
+	locking         atomic.Int32            // the number of lock requests this transaction is making now
+	readOnly        bool                    // whether this transaction may only take read locks
 }
 
 func (t *Transaction) WLock() {
@@ -31,9 +42,53 @@ func (t *Transaction) RUnlock() {
 }
 
 func (t *Transaction) GetClientID() (clientId uuid.UUID) {
-	return t.clientId
+	return *t.clientId.Load()
 }
 
 func (t *Transaction) GetResources() (resources map[Resource]LockType) {
 	return t.lockedResources
 }
+
+// Returns the transaction's timestamp. Under wound-wait, older transactions have lower timestamps.
+func (t *Transaction) GetTimestamp() uint64 {
+	return t.timestamp
+}
+
+// Returns whether the transaction has been wounded, by an older transaction under wound-wait
+// or as the victim of a deadlock, and must then abort.
+func (t *Transaction) IsWounded() bool {
+	return t.woundCtx != nil && t.woundCtx.Err() != nil
+}
+
+// Checks the transaction's rights to the resource before locking it with the given lock type,
+// returning whether the resource itself is locked and whether the transaction's locks already
+// cover locking it. Locking a read locked resource for reading again counts as another hold of
+// it, released by its own unlock, so that nested lock and unlock pairs match up.
+func (t *Transaction) relock(r Resource, lType LockType) (held bool, covered bool) {
+	t.WLock()
+	defer t.WUnlock()
+	heldType, held := t.lockedResources[r]
+	if held && heldType == R_LOCK && lType == R_LOCK {
+		t.holds[r]++
+	}
+	return held, t.covers(r, lType)
+}
+
+// Forgets the resource, along with any extra holds of it. Expects t to be write locked.
+func (t *Transaction) forget(r Resource) {
+	delete(t.lockedResources, r)
+	delete(t.holds, r)
+}
+
+// Returns whether the transaction's locks already cover locking the resource with the given
+// lock type, either on the resource itself or on its whole table. Expects t to be read locked.
+func (t *Transaction) covers(r Resource, lType LockType) bool {
+	if held, ok := t.lockedResources[r]; ok && (held == W_LOCK || lType == R_LOCK) {
+		return true
+	}
+	table := Resource{tableName: r.tableName, wholeTable: true}
+	if held, ok := t.lockedResources[table]; ok && (held == W_LOCK || lType == R_LOCK) {
+		return true
+	}
+	return false
+}
@@ -1,8 +1,11 @@
 package concurrency
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"dinodb/pkg/database"
 
@@ -17,20 +20,46 @@ type TransactionManager struct {
 	waitsForGraph       *WaitsForGraph             // Identifies deadlocks through cycle detection
 	transactions        map[uuid.UUID]*Transaction // Identifies the Transaction for a particular client
 	mtx                 sync.RWMutex
+
+	policy     DeadlockPolicy       // How deadlocks are handled
+	clock      uint64               // The timestamp of the most recently begun transaction
+	restarting map[uuid.UUID]uint64 // The timestamps of wounded transactions that aborted, kept for when they begin again
+	assigned   map[uuid.UUID]uint64 // The timestamps assigned to the transactions clients begin next; see clock.go
+	counters   lockCounters         // Counts lock contention for Stats
+	maxActive  int                  // The most transactions that may run at once; 0 for no limit
+
+	deadlockTimeout time.Duration // How long a lock request waits before looking for a deadlock; 0 to look before waiting
+
+	idleTimeout  time.Duration                  // How long a transaction may be idle before it's reaped; 0 disables the reaper
+	abortHandler func(clientId uuid.UUID) error // How the reaper aborts idle transactions; nil for Abort
+	stop         chan struct{}                  // Closed to stop the reaper
+	reaper       sync.WaitGroup                 // Waits for the reaper to stop
+	closeOnce    sync.Once
 }
 
-func NewTransactionManager(lm *ResourceLockManager) *TransactionManager {
-	return &TransactionManager{
+func NewTransactionManager(lm *ResourceLockManager, opts ...Option) *TransactionManager {
+	tm := &TransactionManager{
 		resourceLockManager: lm,
 		waitsForGraph:       NewGraph(),
 		transactions:        make(map[uuid.UUID]*Transaction),
+		restarting:          make(map[uuid.UUID]uint64),
+		assigned:            make(map[uuid.UUID]uint64),
 	}
+	for _, opt := range opts {
+		opt(tm)
+	}
+	tm.startReaper()
+	return tm
 }
 
 func (tm *TransactionManager) GetResourceLockManager() (lm *ResourceLockManager) {
 	return tm.resourceLockManager
 }
 
+func (tm *TransactionManager) GetWaitsForGraph() (g *WaitsForGraph) {
+	return tm.waitsForGraph
+}
+
 func (tm *TransactionManager) GetTransactions() (txs map[uuid.UUID]*Transaction) {
 	return tm.transactions
 }
@@ -43,80 +72,355 @@ func (tm *TransactionManager) GetTransaction(clientId uuid.UUID) (tx *Transactio
 	return tx, found
 }
 
-// Begin a transaction for the given client; error if already began.
+// Get a copy of the resources a client's transaction has locked, and the types of their locks.
+func (tm *TransactionManager) GetLockedResources(clientId uuid.UUID) (resources map[Resource]LockType, found bool) {
+	t, found := tm.GetTransaction(clientId)
+	if !found {
+		return nil, false
+	}
+	t.RLock()
+	defer t.RUnlock()
+	resources = make(map[Resource]LockType, len(t.lockedResources))
+	for r, lType := range t.lockedResources {
+		resources[r] = lType
+	}
+	return resources, true
+}
+
+// Begin a transaction for the given client; error if already began, or ErrTooManyTransactions
+// if the maximum number of active transactions are running.
 func (tm *TransactionManager) Begin(clientId uuid.UUID) error {
+	return tm.begin(clientId, false)
+}
+
+// Begins a transaction for the given client, read-only if readOnly is set.
+func (tm *TransactionManager) begin(clientId uuid.UUID, readOnly bool) error {
 	tm.mtx.Lock()
 	defer tm.mtx.Unlock()
 	_, found := tm.transactions[clientId]
 	if found {
 		return errors.New("transaction already began")
 	}
-	tm.transactions[clientId] = &Transaction{clientId: clientId, lockedResources: make(map[Resource]LockType)}
+	if tm.maxActive > 0 && len(tm.transactions) >= tm.maxActive {
+		return ErrTooManyTransactions
+	}
+	// A wounded transaction begins again as old as it was, so it can't be wounded forever.
+	timestamp, restarting := tm.restarting[clientId]
+	assigned, isAssigned := tm.assigned[clientId]
+	delete(tm.assigned, clientId)
+	switch {
+	case restarting:
+		delete(tm.restarting, clientId)
+	case isAssigned:
+		timestamp = assigned
+	default:
+		tm.clock++
+		timestamp = tm.clock
+	}
+	t := &Transaction{lockedResources: make(map[Resource]LockType), holds: make(map[Resource]int), timestamp: timestamp, readOnly: readOnly}
+	t.clientId.Store(&clientId)
+	t.woundCtx, t.wound = context.WithCancelCause(context.Background())
+	t.touch()
+	tm.transactions[clientId] = t
 	return nil
 }
 
-// Locks the requested resource. Will return an error if deadlock is created by locking.
+// Locks the requested resource. Will return a DeadlockError if deadlock is created by locking
+// and this transaction is the cheapest in the cycle to abort.
 // 1) Get the transaction we want, and construct the resource.
 // 2) Check if we already have rights to the resource
-//   - Error if upgrading from read to write locks within this transaction.
-//   - Ignore requests for a duplicate lock
+//   - Upgrade a held read lock to a write lock, waiting for the other readers like any other conflict.
+//   - Ignore requests for a duplicate or weaker lock
 //
-// 4) Check for deadlocks using waitsForGraph
+// 4) Check for deadlocks using waitsForGraph, or wound younger transactions under wound-wait
 // 5) Lock resource's mutex
 // 6) Add resource to the transaction's resources
 // Hint: conflictingTransactions(), GetTransaction()
 func (tm *TransactionManager) Lock(clientId uuid.UUID, table database.Index, resourceKey int64, lType LockType) error {
+	return tm.LockContext(context.Background(), clientId, table, resourceKey, lType)
+}
+
+// LockContext locks the requested resource like Lock, but gives up waiting for it and returns
+// ctx.Err() if ctx is cancelled or its deadline passes, leaving the transaction's locks as they were,
+// except that a read lock being upgraded is given up.
+func (tm *TransactionManager) LockContext(ctx context.Context, clientId uuid.UUID, table database.Index, resourceKey int64, lType LockType) error {
+	return tm.lock(ctx, clientId, Resource{tableName: table.GetName(), key: resourceKey}, lType)
+}
+
+// LockTable locks a whole table like Lock locks one of its entries. A table write lock conflicts
+// with every lock on the table's entries, and a table read lock with every write lock on them.
+// The transaction can then access any entry of the table without locking it.
+func (tm *TransactionManager) LockTable(clientId uuid.UUID, table database.Index, lType LockType) error {
+	return tm.LockTableContext(context.Background(), clientId, table, lType)
+}
+
+// LockTableContext locks a whole table like LockTable, but gives up waiting for it like LockContext.
+// A table's read lock being upgraded is kept if the upgrade gives up.
+func (tm *TransactionManager) LockTableContext(ctx context.Context, clientId uuid.UUID, table database.Index, lType LockType) error {
+	return tm.lock(ctx, clientId, Resource{tableName: table.GetName(), wholeTable: true}, lType)
+}
+
+// Locks the resource for the client's transaction, waiting until ctx is done.
+func (tm *TransactionManager) lock(ctx context.Context, clientId uuid.UUID, resource Resource, lType LockType) error {
 	/* SOLUTION {{{ */
-	// Get the transaction we want, and construct the resource.
+	// Get the transaction we want.
+	// GetTransaction would read lock tm.mtx again, which deadlocks if a writer is waiting.
 	tm.mtx.RLock()
-	t, found := tm.GetTransaction(clientId)
+	t, found := tm.transactions[clientId]
 	if !found {
 		tm.mtx.RUnlock()
 		return errors.New("transaction not found")
 	}
+	defer t.startLocking()()
+	if t.IsWounded() {
+		tm.mtx.RUnlock()
+		return context.Cause(t.woundCtx)
+	}
 
-	resource := Resource{tableName: table.GetName(), key: resourceKey}
 	// Check if we already have rights to the resource
-	t.RLock()
-	if curLockType, ok := t.lockedResources[resource]; ok {
+	held, covered := t.relock(resource, lType)
+	if covered {
 		tm.mtx.RUnlock()
-		defer t.RUnlock()
-
-		if curLockType == R_LOCK && lType != curLockType {
-			return errors.New("cannot upgrade from read lock to write lock in the middle of transaction")
-		} else {
-			return nil
+		return nil
+	}
+	if t.readOnly {
+		if lType == W_LOCK {
+			tm.mtx.RUnlock()
+			return ErrReadOnly
+		}
+		// Skip the waits-for graph unless a writer is in the way.
+		if tm.tryReadLock(t, resource) {
+			tm.mtx.RUnlock()
+			tm.counters.recordGranted(false, time.Time{})
+			return tm.acquired(t, resource, lType)
 		}
 	}
-	t.RUnlock()
+	upgrade := held
+	if tm.policy == WoundWait {
+		// Let younger transactions that lock the resource before us see that we're waiting.
+		t.WLock()
+		t.waitingFor, t.waitingType = &resource, lType
+		t.WUnlock()
+		defer func() {
+			t.WLock()
+			t.waitingFor = nil
+			t.WUnlock()
+		}()
+	}
 
 	// Create a waits for graph, see if we create a cycle by locking this resource.
-	for _, conflictingTxn := range tm.conflictingTransactions(resource, lType) {
-		if t == conflictingTxn {
-			continue
+	start, waits := time.Now(), false
+	for _, conflictingTxn := range tm.conflictingTransactions(t, resource, lType) {
+		if err := tm.waitsForGraph.addEdge(t, conflictingTxn, &resource); err != nil {
+			tm.mtx.RUnlock()
+			return fmt.Errorf("locking %s: %w", resource, err)
 		}
-		tm.waitsForGraph.AddEdge(t, conflictingTxn)
+		waits = true
 		defer tm.waitsForGraph.RemoveEdge(t, conflictingTxn)
+		if tm.policy == WoundWait && t.timestamp < conflictingTxn.timestamp {
+			conflictingTxn.wound(ErrWounded)
+		}
 	}
 
-	// If a deadlock, unlock and error.
-	if tm.waitsForGraph.DetectCycle() {
-		tm.mtx.RUnlock()
-		return errors.New("deadlock detected")
+	// If a deadlock, abort the cheapest transaction in the cycle: error if it's us, or else
+	// wound it so that it stops waiting and we can wait. Under wound-wait, only younger
+	// transactions wait for older ones, so no deadlock can form. With a deadlock timeout,
+	// we only look once we've waited for it; see deadlock_timeout.go.
+	lazy := tm.policy == CycleDetection && tm.deadlockTimeout > 0
+	if tm.policy == CycleDetection && !lazy {
+		if deadlock := tm.detectDeadlock(t); deadlock != nil {
+			tm.mtx.RUnlock()
+			return deadlock
+		}
 	}
 
-	// Else, lock the resource.
+	// Else, lock the resource, trading our read lock for the write lock if upgrading.
 	tm.mtx.RUnlock()
-	err := tm.resourceLockManager.Lock(resource, lType)
+	// Stop waiting if we're wounded while we wait.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(t.woundCtx, func() { cancel(nil) })
+	defer stop()
+	stopDetecting := func() {}
+	if lazy && waits {
+		stopDetecting = tm.detectAfterTimeout(t, cancel)
+	}
+	err := tm.acquire(ctx, t, resource, lType, upgrade)
+	stopDetecting()
 	if err != nil {
+		if upgrade && !resource.wholeTable && !resource.keyRange {
+			// A failed upgrade of an entry gives up the read lock too.
+			t.WLock()
+			t.forget(resource)
+			t.WUnlock()
+		}
+		if t.IsWounded() {
+			return context.Cause(t.woundCtx)
+		}
+		var deadlock *DeadlockError
+		if errors.As(context.Cause(ctx), &deadlock) {
+			return deadlock
+		}
 		return err
 	}
+	tm.counters.recordGranted(waits, start)
+	return tm.acquired(t, resource, lType)
+	/* SOLUTION }}} */
+}
+
+// Locks the resource for the transaction, waiting until ctx is done. Locking an entry or a range
+// first locks its table with the matching intention, which is released again if locking the entry
+// fails. An entry with an int64 key also waits for the ranges covering it; see range_locks.go.
+func (tm *TransactionManager) acquire(ctx context.Context, t *Transaction, r Resource, lType LockType, upgrade bool) error {
+	lm := tm.resourceLockManager
+	if r.wholeTable {
+		// Our own read lock on the table doesn't conflict with the write lock, so it's kept until we have it.
+		if err := lm.lockTable(ctx, t, r.tableName, tableModeOf(lType)); err != nil {
+			return err
+		}
+		if upgrade {
+			lm.unlockTable(t, r.tableName, shared)
+		}
+		return nil
+	}
+	intention := intentionOf(lType)
+	if err := lm.lockTable(ctx, t, r.tableName, intention); err != nil {
+		if upgrade && !r.keyRange {
+			// A failed upgrade of an entry gives up the read lock too.
+			tm.release(t, r, R_LOCK)
+		}
+		return err
+	}
+	if err := lm.lockRange(ctx, t, r, lType); err != nil {
+		lm.unlockTable(t, r.tableName, intention)
+		if upgrade && !r.keyRange {
+			// A failed upgrade of an entry gives up the read lock too.
+			tm.release(t, r, R_LOCK)
+		}
+		return err
+	}
+	if r.keyRange {
+		// Like a whole table, our own read lock on the range is kept until we have the write lock.
+		if upgrade {
+			lm.unlockRange(t, r, R_LOCK)
+			lm.unlockTable(t, r.tableName, intentionShared)
+		}
+		return nil
+	}
+	var err error
+	if upgrade {
+		err = lm.UpgradeContext(ctx, r)
+	} else {
+		err = lm.LockContext(ctx, r, lType)
+	}
+	if err != nil {
+		lm.unlockRange(t, r, lType)
+		lm.unlockTable(t, r.tableName, intention)
+	}
+	if upgrade {
+		// Either way, the read lock's intention is no longer needed.
+		lm.unlockRange(t, r, R_LOCK)
+		lm.unlockTable(t, r.tableName, intentionShared)
+	}
+	return err
+}
+
+// Unlocks the resource for the transaction, along with the intention on its table if it's an entry
+// or a range. The intention is released even if unlocking the entry fails.
+func (tm *TransactionManager) release(t *Transaction, r Resource, lType LockType) error {
+	lm := tm.resourceLockManager
+	if r.wholeTable {
+		lm.unlockTable(t, r.tableName, tableModeOf(lType))
+		return nil
+	}
+	var err error
+	if !r.keyRange {
+		err = lm.Unlock(r, lType)
+	}
+	lm.unlockRange(t, r, lType)
+	lm.unlockTable(t, r.tableName, intentionOf(lType))
+	return err
+}
+
+// TryLock locks the requested resource like Lock if it can be locked without waiting, and otherwise
+// returns false without waiting or adding to the waits-for graph. A read lock that can't be upgraded
+// at once is kept if it can be taken back at once, and is given up otherwise.
+func (tm *TransactionManager) TryLock(clientId uuid.UUID, table database.Index, resourceKey int64, lType LockType) (bool, error) {
+	// Get the transaction we want, and construct the resource.
+	tm.mtx.RLock()
+	t, found := tm.transactions[clientId]
+	tm.mtx.RUnlock()
+	if !found {
+		return false, errors.New("transaction not found")
+	}
+	defer t.startLocking()()
+	if t.IsWounded() {
+		return false, context.Cause(t.woundCtx)
+	}
+	if t.readOnly && lType == W_LOCK {
+		return false, ErrReadOnly
+	}
 
+	resource := Resource{tableName: table.GetName(), key: resourceKey}
+	// Check if we already have rights to the resource
+	held, covered := t.relock(resource, lType)
+	if covered {
+		return true, nil
+	}
+
+	// Try to lock the resource's table with the matching intention, then the resource,
+	// trading our read lock for the write lock if upgrading.
+	lm := tm.resourceLockManager
+	intention := intentionOf(lType)
+	if !lm.tryLockTable(t, resource.tableName, intention) {
+		return false, nil
+	}
+	if !lm.tryLockRange(t, resource, lType) {
+		lm.unlockTable(t, resource.tableName, intention)
+		return false, nil
+	}
+	if held {
+		upgraded, readHeld := lm.tryUpgrade(resource)
+		if !upgraded {
+			lm.unlockRange(t, resource, lType)
+			lm.unlockTable(t, resource.tableName, intention)
+			if !readHeld {
+				lm.unlockRange(t, resource, R_LOCK)
+				lm.unlockTable(t, resource.tableName, intentionShared)
+				t.WLock()
+				t.forget(resource)
+				t.WUnlock()
+			}
+			return false, nil
+		}
+		lm.unlockRange(t, resource, R_LOCK)
+		lm.unlockTable(t, resource.tableName, intentionShared)
+	} else if !lm.TryLock(resource, lType) {
+		lm.unlockRange(t, resource, lType)
+		lm.unlockTable(t, resource.tableName, intention)
+		return false, nil
+	}
+	tm.counters.recordGranted(false, time.Time{})
+	return true, tm.acquired(t, resource, lType)
+}
+
+// Records that the transaction has locked the resource. Upgrading a read lock held several times
+// leaves a single hold of the write lock. Under wound-wait, wounds the transaction if an older one
+// is waiting for the resource.
+func (tm *TransactionManager) acquired(t *Transaction, resource Resource, lType LockType) error {
 	t.WLock()
-	defer t.WUnlock()
 	t.lockedResources[resource] = lType
+	if lType == W_LOCK {
+		delete(t.holds, resource)
+	}
+	t.WUnlock()
+	if tm.policy == WoundWait && tm.olderWaiting(t, resource, lType) {
+		// An older transaction started waiting for the resource before we got it, so we must
+		// yield it to the older one like any younger holder. It's released when we abort.
+		t.wound(ErrWounded)
+		return context.Cause(t.woundCtx)
+	}
 	return nil
-	/* SOLUTION }}} */
 }
 
 // Unlocks the requested resource.
@@ -124,16 +428,41 @@ func (tm *TransactionManager) Lock(clientId uuid.UUID, table database.Index, res
 // 2) Remove resource from the transaction's currently locked resources if it is valid.
 // 3) Unlock resource's mutex
 func (tm *TransactionManager) Unlock(clientId uuid.UUID, table database.Index, resourceKey int64, lType LockType) error {
+	return tm.unlock(clientId, Resource{tableName: table.GetName(), key: resourceKey}, lType)
+}
+
+// Unlocks a whole table locked by LockTable.
+func (tm *TransactionManager) UnlockTable(clientId uuid.UUID, table database.Index, lType LockType) error {
+	return tm.unlock(clientId, Resource{tableName: table.GetName(), wholeTable: true}, lType)
+}
+
+// UnlockResource unlocks the requested resource like Unlock, but releases whichever type of lock
+// the client's transaction holds on it, which after an upgrade is the write lock.
+func (tm *TransactionManager) UnlockResource(clientId uuid.UUID, table database.Index, resourceKey int64) error {
+	resource := Resource{tableName: table.GetName(), key: resourceKey}
+	t, found := tm.GetTransaction(clientId)
+	if !found {
+		return errors.New("transaction not found")
+	}
+	t.RLock()
+	lType, held := t.lockedResources[resource]
+	t.RUnlock()
+	if !held {
+		return errors.New("trying to unlock a resource that was not locked")
+	}
+	return tm.unlock(clientId, resource, lType)
+}
+
+// Unlocks the resource for the client's transaction.
+func (tm *TransactionManager) unlock(clientId uuid.UUID, resource Resource, lType LockType) error {
 	/* SOLUTION {{{ */
-	// Get the transaction we want, and construct the resource.
-	tm.mtx.RLock()
+	// Get the transaction we want.
 	t, found := tm.GetTransaction(clientId)
-	tm.mtx.RUnlock()
 	if !found {
 		return errors.New("transaction not found")
 	}
+	t.touch()
 
-	resource := Resource{tableName: table.GetName(), key: resourceKey}
 	// Iterate through our locks to find the right one and remove it.
 	t.WLock()
 	defer t.WUnlock()
@@ -143,8 +472,16 @@ func (tm *TransactionManager) Unlock(clientId uuid.UUID, table database.Index, r
 			if storedType != lType {
 				return errors.New("incorrect unlock type")
 			}
+			// A resource locked again only gives up the extra hold.
+			if t.holds[r] > 0 {
+				t.holds[r]--
+				if t.holds[r] == 0 {
+					delete(t.holds, r)
+				}
+				return nil
+			}
 			removed = true
-			delete(t.lockedResources, r)
+			t.forget(r)
 			break
 		}
 	}
@@ -155,7 +492,7 @@ func (tm *TransactionManager) Unlock(clientId uuid.UUID, table database.Index, r
 	}
 
 	// Unlock the resource.
-	err := tm.resourceLockManager.Unlock(resource, lType)
+	err := tm.release(t, resource, lType)
 	if err != nil {
 		return err
 	}
@@ -164,7 +501,23 @@ func (tm *TransactionManager) Unlock(clientId uuid.UUID, table database.Index, r
 }
 
 // Commits the given transaction and removes it from the running transactions list.
+// Every resource is unlocked even if unlocking some of them fails, and the transaction is
+// removed regardless; the errors are returned joined.
 func (tm *TransactionManager) Commit(clientId uuid.UUID) error {
+	return tm.end(clientId, false)
+}
+
+// Aborts the given transaction, releasing its locks and removing it from the running transactions list.
+// Undoing the transaction's edits is left to the caller, as in RecoveryManager.Rollback.
+// A wounded transaction keeps its timestamp for when its client begins again.
+func (tm *TransactionManager) Abort(clientId uuid.UUID) error {
+	return tm.end(clientId, true)
+}
+
+// Ends the given transaction, unlocking all of its resources and removing it from the
+// running transactions list and the waits-for graph. A resource that fails to unlock doesn't
+// keep the others locked or the transaction running; its error is joined with any others.
+func (tm *TransactionManager) end(clientId uuid.UUID, aborted bool) error {
 	tm.mtx.Lock()
 	defer tm.mtx.Unlock()
 	// Get the transaction we want.
@@ -175,24 +528,67 @@ func (tm *TransactionManager) Commit(clientId uuid.UUID) error {
 	// Unlock all resources.
 	t.RLock()
 	defer t.RUnlock()
+	var errs []error
 	for r, lType := range t.lockedResources {
-		err := tm.resourceLockManager.Unlock(r, lType)
-		if err != nil {
-			return err
+		if err := tm.release(t, r, lType); err != nil {
+			errs = append(errs, fmt.Errorf("unlocking %v: %w", r, err))
 		}
 	}
-	// Remove the transaction from our transactions list.
+	// Remove the transaction from our transactions list and the waits-for graph.
 	delete(tm.transactions, clientId)
-	return nil
+	tm.waitsForGraph.RemoveTransaction(t)
+	if aborted && t.IsWounded() {
+		tm.restarting[clientId] = t.timestamp
+	}
+	return errors.Join(errs...)
+}
+
+// Returns whether a transaction older than the given one is waiting for a lock on the
+// resource that conflicts with the given lock type.
+func (tm *TransactionManager) olderWaiting(t *Transaction, r Resource, lType LockType) bool {
+	tm.mtx.RLock()
+	defer tm.mtx.RUnlock()
+	for _, waiter := range tm.transactions {
+		if waiter == t || waiter.timestamp > t.timestamp {
+			continue
+		}
+		waiter.RLock()
+		waiting := waiter.waitingFor != nil && conflicts(*waiter.waitingFor, waiter.waitingType, r, lType)
+		waiter.RUnlock()
+		if waiting {
+			return true
+		}
+	}
+	return false
 }
 
-// Returns a slice of all transactions that conflict w/ the given resource and locktype.
-func (tm *TransactionManager) conflictingTransactions(r Resource, lType LockType) []*Transaction {
+// Returns the transaction that is cheapest to abort, the one holding the fewest locks.
+// Ties go to the earliest transaction in the slice.
+func cheapestTransaction(txs []*Transaction) *Transaction {
+	var cheapest *Transaction
+	cheapestCost := 0
+	for _, t := range txs {
+		t.RLock()
+		cost := len(t.lockedResources)
+		t.RUnlock()
+		if cheapest == nil || cost < cheapestCost {
+			cheapest, cheapestCost = t, cost
+		}
+	}
+	return cheapest
+}
+
+// Returns a slice of all transactions other than the requester that conflict w/ the given resource and locktype.
+// A transaction's own locks never conflict with its requests, which upgrade them instead.
+func (tm *TransactionManager) conflictingTransactions(requester *Transaction, r Resource, lType LockType) []*Transaction {
 	txs := make([]*Transaction, 0)
 	for _, t := range tm.transactions {
+		if t == requester {
+			continue
+		}
 		t.RLock()
 		for storedResource, storedType := range t.lockedResources {
-			if storedResource == r && (storedType == W_LOCK || lType == W_LOCK) {
+			if conflicts(storedResource, storedType, r, lType) {
 				txs = append(txs, t)
 				break
 			}
@@ -0,0 +1,139 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+/*
+   Each resource is locked with a queueLock, a readers-writer lock that grants its lock requests
+   in the order they were made. A request that can't be granted at once joins the end of the
+   resource's wait queue, and whenever the lock is released, waiters are granted from the front
+   of the queue for as long as they are compatible with the lock's holders: a writer once there
+   are no holders, and a run of readers while there is no writer.
+
+   A new request is never granted ahead of the queue, even if it is compatible with the lock's
+   holders, so a stream of readers can't starve a waiting writer, and every waiter is granted
+   the lock once everyone ahead of it in the queue has had it.
+*/
+
+// queueLock is a readers-writer lock that grants the lock in FIFO order.
+type queueLock struct {
+	readers int           // The number of read locks held
+	writer  bool          // Whether the write lock is held
+	queue   []*lockWaiter // The lock requests waiting to be granted, oldest first
+	waits   int64         // The number of lock requests that had to wait; see hotspots.go
+	mtx     sync.Mutex
+}
+
+// lockWaiter is a lock request waiting in a queueLock's wait queue.
+type lockWaiter struct {
+	lType   LockType
+	granted chan struct{} // Closed once the lock is granted
+}
+
+// lock locks the lock with the given type once every earlier request has been granted,
+// or returns ctx.Err() if ctx is done first.
+func (l *queueLock) lock(ctx context.Context, lType LockType) error {
+	l.mtx.Lock()
+	if len(l.queue) == 0 && l.compatible(lType) {
+		l.grant(lType)
+		l.mtx.Unlock()
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		l.mtx.Unlock()
+		return err
+	}
+	w := &lockWaiter{lType: lType, granted: make(chan struct{})}
+	l.queue = append(l.queue, w)
+	l.waits++
+	l.mtx.Unlock()
+
+	select {
+	case <-w.granted:
+		return nil
+	case <-ctx.Done():
+	}
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	select {
+	case <-w.granted:
+		// Granted as we stopped waiting, so give it back.
+		l.release(lType)
+	default:
+		for i, waiter := range l.queue {
+			if waiter == w {
+				l.queue = append(l.queue[:i], l.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	// Waiters behind us may be grantable now.
+	l.grantWaiters()
+	return ctx.Err()
+}
+
+// tryLock locks the lock with the given type if it can be granted without waiting,
+// which is only if no one is waiting for it.
+func (l *queueLock) tryLock(lType LockType) bool {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if len(l.queue) > 0 || !l.compatible(lType) {
+		return false
+	}
+	l.grant(lType)
+	return true
+}
+
+// unlock releases a held lock of the given type, granting the lock to waiters that can now have it.
+func (l *queueLock) unlock(lType LockType) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if (lType == W_LOCK && !l.writer) || (lType == R_LOCK && l.readers == 0) {
+		return errors.New("tried to unlock a resource that isn't locked")
+	}
+	l.release(lType)
+	l.grantWaiters()
+	return nil
+}
+
+// Returns whether a lock of the given type is compatible with the lock's holders.
+// Expects l.mtx to be locked.
+func (l *queueLock) compatible(lType LockType) bool {
+	if lType == W_LOCK {
+		return !l.writer && l.readers == 0
+	}
+	return !l.writer
+}
+
+// Records that a lock of the given type is held. Expects l.mtx to be locked.
+func (l *queueLock) grant(lType LockType) {
+	if lType == W_LOCK {
+		l.writer = true
+	} else {
+		l.readers++
+	}
+}
+
+// Records that a lock of the given type is no longer held. Expects l.mtx to be locked.
+func (l *queueLock) release(lType LockType) {
+	if lType == W_LOCK {
+		l.writer = false
+	} else {
+		l.readers--
+	}
+}
+
+// Grants the lock to waiters from the front of the queue for as long as they are compatible
+// with the lock's holders. Expects l.mtx to be locked.
+func (l *queueLock) grantWaiters() {
+	for len(l.queue) > 0 && l.compatible(l.queue[0].lType) {
+		w := l.queue[0]
+		l.queue[0] = nil
+		l.queue = l.queue[1:]
+		l.grant(w.lType)
+		close(w.granted)
+	}
+}
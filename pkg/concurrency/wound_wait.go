@@ -0,0 +1,30 @@
+package concurrency
+
+import "errors"
+
+// DeadlockPolicy selects how a TransactionManager handles transactions that would deadlock.
+type DeadlockPolicy int
+
+const (
+	// CycleDetection lets any transaction wait for any other, and errors a lock request
+	// that closes a cycle in the waits-for graph.
+	CycleDetection DeadlockPolicy = iota
+	// WoundWait avoids deadlocks by ordering transactions by age: a younger transaction
+	// waits for an older one, while an older transaction wounds a younger one that holds
+	// a lock it wants. A wounded transaction's lock requests fail with ErrWounded, and its
+	// client must then abort it.
+	WoundWait
+)
+
+// ErrWounded is returned when locking for a transaction that an older transaction has wounded.
+var ErrWounded = errors.New("transaction wounded by an older transaction")
+
+// Option configures optional settings of a TransactionManager when constructing it.
+type Option func(*TransactionManager)
+
+// WithDeadlockPolicy sets how the TransactionManager handles deadlocks. Defaults to CycleDetection.
+func WithDeadlockPolicy(policy DeadlockPolicy) Option {
+	return func(tm *TransactionManager) {
+		tm.policy = policy
+	}
+}
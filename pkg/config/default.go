@@ -1,6 +1,8 @@
 // Global database config.
 package config
 
+import "time"
+
 // Name of the database.
 const DBName = "dinodb"
 
@@ -13,6 +15,15 @@ const MaxPagesInBuffer = 32
 // Name of log file.
 const LogFileName = "db.log"
 
+// How long to coalesce log syncs for when group commit is enabled.
+const GroupCommitInterval = 2 * time.Millisecond
+
+// How often to check whether the log grew enough to checkpoint when automatic checkpointing is enabled.
+const AutoCheckpointInterval = 100 * time.Millisecond
+
+// How long a transaction may be idle before it's aborted when the transaction reaper is enabled.
+const TransactionTimeout = 5 * time.Minute
+
 // Return prompt if requested, else "".
 func GetPrompt(flag bool) string {
 	if flag {
@@ -89,6 +89,34 @@ func (db *Database) CreateTable(name string, indexType IndexType) (index Index,
 	return index, nil
 }
 
+// Drop a table, closing it and deleting its files.
+func (db *Database) DropTable(name string) error {
+	// Ensure the db name is alphanumeric, so that only files in the database are deleted.
+	alphanumeric, _ := regexp.Compile(`\W`)
+	if alphanumeric.MatchString(name) {
+		return errors.New("table name must be alphanumeric")
+	}
+	path := filepath.Join(db.basepath, name)
+	if _, err := os.Stat(path); err != nil {
+		return errors.New("table not found")
+	}
+	// Close the table if it is open.
+	if index, ok := db.tables[name]; ok {
+		delete(db.tables, name)
+		if err := index.Close(); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	// Hash tables also have a .meta file.
+	if err := os.Remove(path + ".meta"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 // Get a table by its name, either from existing tables, or by creating a new one.
 func (db *Database) GetTable(name string) (index Index, err error) {
 	// Check existing set of tables.
@@ -18,6 +18,10 @@ func DatabaseRepl(db *Database) *repl.REPL {
 		return HandleCreateTable(db, payload)
 	}, "Create a table. usage: create <btree|hash> table <table>")
 
+	r.AddCommand("drop", func(payload string, replConfig *repl.REPLConfig) (string, error) {
+		return HandleDropTable(db, payload)
+	}, "Drop a table. usage: drop table <table>")
+
 	r.AddCommand("find", func(payload string, replConfig *repl.REPLConfig) (string, error) {
 		return HandleFind(db, payload)
 	}, "Find an element. usage: find <key> from <table>")
@@ -71,6 +75,23 @@ func HandleCreateTable(d *Database, payload string) (output string, err error) {
 	return fmt.Sprintf("%s table %s created.\n", fields[1], tableName), nil
 }
 
+// Handle drop table.
+func HandleDropTable(d *Database, payload string) (output string, err error) {
+	fields := strings.Fields(payload)
+	numFields := len(fields)
+	// Usage: drop table <table>
+	if numFields != 3 || fields[1] != "table" {
+		return "", fmt.Errorf("usage: drop table <table>")
+	}
+	tableName := fields[2]
+	err = d.DropTable(tableName)
+	if err != nil {
+		return "", fmt.Errorf("drop error: %v", err)
+	}
+
+	return fmt.Sprintf("table %s dropped.\n", tableName), nil
+}
+
 // Handle find.
 func HandleFind(d *Database, payload string) (output string, err error) {
 	fields := strings.Fields(payload)
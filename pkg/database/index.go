@@ -1,9 +1,12 @@
 package database
 
 import (
+	"dinodb/pkg/btree"
 	"dinodb/pkg/cursor"
 	"dinodb/pkg/entry"
+	"dinodb/pkg/hash"
 	"dinodb/pkg/pager"
+	"errors"
 	"io"
 )
 
@@ -28,4 +31,17 @@ type Index interface {
 	Print(io.Writer)
 	PrintPN(int, io.Writer)
 	CursorAtStart() (cursor.Cursor, error)
+	PageLSN(int64) (uint64, error)
+}
+
+// Get the type of an index.
+func TypeOf(index Index) (IndexType, error) {
+	switch index.(type) {
+	case *btree.BTreeIndex:
+		return BTreeIndexType, nil
+	case *hash.HashIndex:
+		return HashIndexType, nil
+	default:
+		return "", errors.New("unknown index type")
+	}
 }
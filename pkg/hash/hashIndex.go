@@ -74,6 +74,11 @@ func (index *HashIndex) Delete(key int64) error {
 	return index.table.Delete(key)
 }
 
+// [RECOVERY] Get the LSN of the bucket that holds the given key.
+func (index *HashIndex) PageLSN(key int64) (uint64, error) {
+	return index.table.PageLSN(key)
+}
+
 // Select all elements.
 func (index *HashIndex) Select() ([]entry.Entry, error) {
 	return index.table.Select()
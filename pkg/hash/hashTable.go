@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"os"
 	"sync"
 
 	"dinodb/pkg/entry"
@@ -78,6 +79,25 @@ func (table *HashTable) Find(key int64) (entry.Entry, error) {
 	return foundEntry, nil
 }
 
+// [RECOVERY] PageLSN returns the LSN of the bucket that holds the given key, or would hold it
+// if it were inserted.
+func (table *HashTable) PageLSN(key int64) (uint64, error) {
+	table.RLock()
+	hash := Hasher(key, table.globalDepth)
+	if hash < 0 || int(hash) >= len(table.buckets) {
+		table.RUnlock()
+		return 0, errors.New("not found")
+	}
+	bucket, err := table.GetAndLockBucket(hash, READ_LOCK)
+	table.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	defer table.pager.PutPage(bucket.page)
+	defer bucket.RUnlock()
+	return bucket.page.GetLSN(), nil
+}
+
 // ExtendTable increases the global depth of the table by 1.
 func (table *HashTable) ExtendTable() {
 	table.globalDepth = table.globalDepth + 1
@@ -367,8 +387,22 @@ func ReadHashTable(bucketPager *pager.Pager) (*HashTable, error) {
 
 // Write hash table out to memory.
 func WriteHashTable(bucketPager *pager.Pager, table *HashTable) error {
+	if err := WriteHashMeta(bucketPager, table); err != nil {
+		return err
+	}
+	return bucketPager.Close()
+}
+
+// WriteHashMeta writes the hash table's global depth and bucket page numbers out to its
+// .meta file without closing the table. The file is written from scratch under a temporary
+// name and then renamed, so an existing .meta file is replaced whole.
+func WriteHashMeta(bucketPager *pager.Pager, table *HashTable) error {
 	backingFilename := bucketPager.GetFileName() + ".meta"
-	indexPager, err := pager.New(backingFilename)
+	tmpFilename := backingFilename + ".tmp"
+	if err := os.Remove(tmpFilename); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	indexPager, err := pager.New(tmpFilename)
 	if err != nil {
 		return err
 	}
@@ -400,8 +434,10 @@ func WriteHashTable(bucketPager *pager.Pager, table *HashTable) error {
 		bytesWritten += pnSize
 	}
 	indexPager.PutPage(metaPage)
-	indexPager.Close()
-	return bucketPager.Close()
+	if err = indexPager.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFilename, backingFilename)
 }
 
 // x^y
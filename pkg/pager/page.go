@@ -1,6 +1,7 @@
 package pager
 
 import (
+	"encoding/binary"
 	"sync"
 	"sync/atomic"
 )
@@ -34,9 +35,19 @@ func (page *Page) IsDirty() bool {
 	return page.dirty
 }
 
-// SetDirty changes the dirty status of a page.
+// SetDirty changes the dirty status of a page, tracking it in its pager's dirty pages.
 func (page *Page) SetDirty(dirty bool) {
 	page.dirty = dirty
+	if page.pager == nil {
+		return
+	}
+	page.pager.dirtyMtx.Lock()
+	if dirty {
+		page.pager.dirtyPages[page] = struct{}{}
+	} else {
+		delete(page.pager.dirtyPages, page)
+	}
+	page.pager.dirtyMtx.Unlock()
 }
 
 // GetData returns the byte data held by the page.
@@ -54,12 +65,38 @@ func (page *Page) Put() int64 {
 	return page.pinCount.Add(-1)
 }
 
-// Update updates this page with `size` bytes of the the given data slice at the specified offset.
+// Update updates this page with `size` bytes of the the given data slice at the specified offset,
+// raising the page's LSN to the pager's write LSN if it's lower; see SetWriteLSN.
+// The page's LSN never decreases, even if the data covers it.
 func (page *Page) Update(data []byte, offset int64, size int64) {
 	page.updateLock.Lock()
 	defer page.updateLock.Unlock()
-	page.dirty = true
+	if !page.dirty {
+		page.SetDirty(true)
+	}
+	// Copying a whole page over this one mustn't lower its LSN.
+	lsn := page.lsn()
+	if page.pager != nil {
+		lsn = max(lsn, page.pager.writeLSN.Load())
+	}
 	copy(page.data[offset:offset+size], data)
+	// Pages of a pager that's never given a write LSN, like a hash table's .meta file, may use
+	// the bytes for data, so they're only written when the LSN has to change.
+	if lsn > page.lsn() {
+		binary.LittleEndian.PutUint64(page.data[PageLSNOffset:], lsn)
+	}
+}
+
+// [RECOVERY] GetLSN returns the LSN of the last logged edit that changed the page, or 0 if none has.
+func (page *Page) GetLSN() uint64 {
+	page.updateLock.Lock()
+	defer page.updateLock.Unlock()
+	return page.lsn()
+}
+
+// lsn returns the page's LSN. The update lock should be held on entry.
+func (page *Page) lsn() uint64 {
+	return binary.LittleEndian.Uint64(page.data[PageLSNOffset:])
 }
 
 // [CONCURRENCY] Grab a writers lock on the page.
@@ -82,6 +119,11 @@ func (page *Page) RUnlock() {
 	page.rwlock.RUnlock()
 }
 
+// [RECOVERY] Try to grab a readers lock on the page, reporting whether it was grabbed.
+func (page *Page) TryRLock() bool {
+	return page.rwlock.TryRLock()
+}
+
 // [RECOVERY] Grab the update lock.
 func (page *Page) LockUpdates() {
 	page.updateLock.Lock()
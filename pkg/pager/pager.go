@@ -2,11 +2,16 @@
 package pager
 
 import (
+	"cmp"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"dinodb/pkg/config"
 	"dinodb/pkg/list"
@@ -17,6 +22,15 @@ import (
 // Pagesize is the size of an individual page (ie the maximum number of bytes that the page can hold) - defaults to 4kb.
 const Pagesize int64 = directio.BlockSize
 
+// The last PageLSNSize bytes of a table's pages hold the page's LSN: the log sequence number of
+// the last logged edit that changed the page, which recovery compares against a log's LSN to
+// tell whether the page already reflects it. The B+Tree node and hash bucket page layouts leave
+// these bytes unused, so pages written before page LSNs existed read as having an LSN of 0.
+const (
+	PageLSNSize   int64 = 8
+	PageLSNOffset int64 = Pagesize - PageLSNSize
+)
+
 // Error for when there are no free/unpinned pages to be used
 var ErrRanOutOfPages = errors.New("no available pages")
 
@@ -29,7 +43,14 @@ type Pager struct {
 	pinnedList   *list.List // The list of in-memory pages currently being used by the database.
 	// The page table, which maps pagenums to their corresponding pages (stored in a link belonging to the list the page is in).
 	pageTable map[int64]*list.Link
-	ptMtx     sync.Mutex // Mutex for protecting the Page table for concurrent use.
+	ptMtx     sync.Mutex    // Mutex for protecting the Page table for concurrent use.
+	writeLSN  atomic.Uint64 // The LSN that pages updated now are stamped with, or 0 for none; see SetWriteLSN.
+
+	// The pages modified since they were last written to disk, so that flushing the pager
+	// visits only those rather than every page in the buffer.
+	dirtyPages   map[*Page]struct{}
+	dirtyMtx     sync.Mutex   // Mutex for protecting dirtyPages, taken after a page's update lock.
+	pagesWritten atomic.Int64 // The number of pages written to disk since the pager was created.
 }
 
 // New constructs a new Pager, backing it with a database file at the specified filePath.
@@ -37,6 +58,7 @@ type Pager struct {
 func New(filePath string) (pager *Pager, err error) {
 	pager = &Pager{}
 	pager.pageTable = make(map[int64]*list.Link)
+	pager.dirtyPages = make(map[*Page]struct{})
 	pager.freeList = list.NewList()
 	pager.unpinnedList = list.NewList()
 	pager.pinnedList = list.NewList()
@@ -120,7 +142,10 @@ func (pager *Pager) Close() error {
 		return errors.New("pages are still pinned on close")
 	}
 	// Cleanup.
-	pager.FlushAllPages()
+	if err := pager.FlushAllPages(); err != nil {
+		pager.file.Close()
+		return err
+	}
 	return pager.file.Close()
 }
 
@@ -148,16 +173,19 @@ func (pager *Pager) newPage(pagenum int64) (newPage *Page, err error) {
 	} else if unpinLink := pager.unpinnedList.PeekHead(); unpinLink != nil {
 		// If no page was found, evict a page from the unpinned list.
 		// But skip this if our pager isn't backed by disk.
-		unpinLink.PopSelf()
 		newPage = unpinLink.GetValue().(*Page)
-		pager.FlushPage(newPage)
+		// A page that can't be written out can't be evicted without losing its changes.
+		if err = pager.FlushPage(newPage); err != nil {
+			return nil, err
+		}
+		unpinLink.PopSelf()
 		delete(pager.pageTable, newPage.pagenum)
 	} else {
 		// If still no page is found, error.
 		return nil, ErrRanOutOfPages
 	}
 	newPage.pagenum = pagenum
-	newPage.dirty = false
+	newPage.SetDirty(false)
 	newPage.pinCount.Store(1)
 	return newPage, nil
 	/* SOLUTION }}} */
@@ -175,7 +203,9 @@ func (pager *Pager) GetNewPage() (page *Page, err error) {
 	}
 
 	// Mark dirty so new page is eventually flushed to disk.
-	page.dirty = true
+	page.SetDirty(true)
+	// The page's buffer may hold another page's LSN.
+	clear(page.data[PageLSNOffset:])
 	// Insert new page into the pinned list and page table.
 	newLink := pager.pinnedList.PushTail(page)
 	pager.pageTable[pager.numPages] = newLink
@@ -216,7 +246,7 @@ func (pager *Pager) GetPage(pagenum int64) (page *Page, err error) {
 	}
 
 	// Read the page in from disk.
-	page.dirty = false
+	page.SetDirty(false)
 	err = pager.FillPageFromDisk(page)
 	if err != nil {
 		pager.freeList.PushTail(page)
@@ -250,30 +280,77 @@ func (pager *Pager) PutPage(page *Page) (err error) {
 }
 
 // FlushPage flushes a particular page's data to disk if it is dirty.
-func (pager *Pager) FlushPage(page *Page) {
+// If the write fails, the page stays dirty and the error is returned.
+func (pager *Pager) FlushPage(page *Page) error {
 	/* SOLUTION {{{ */
 	if page.IsDirty() {
-		pager.file.WriteAt(
+		_, err := pager.file.WriteAt(
 			page.data,
 			page.pagenum*Pagesize,
 		)
+		if err != nil {
+			return fmt.Errorf("error writing page %d: %w", page.pagenum, err)
+		}
+		pager.pagesWritten.Add(1)
 		page.SetDirty(false)
 	}
+	return nil
 	/* SOLUTION }}} */
 }
 
-// FlushAllPages flushes all dirty pages to disk.
-func (pager *Pager) FlushAllPages() {
+// FlushAllPages flushes all dirty pages to disk, returning the errors of any writes that failed.
+// Every dirty page is attempted even if an earlier one fails.
+func (pager *Pager) FlushAllPages() error {
 	/* SOLUTION {{{ */
-	writer := func(link *list.Link) {
-		page := link.GetValue().(*Page)
-		pager.FlushPage(page)
-	}
-	pager.pinnedList.Map(writer)
-	pager.unpinnedList.Map(writer)
+	return pager.FlushAllPagesContext(context.Background())
 	/* SOLUTION }}} */
 }
 
+// FlushAllPagesContext flushes all dirty pages to disk like FlushAllPages, but stops before the
+// next page and returns ctx.Err() once ctx is cancelled. Pages not yet written stay dirty.
+func (pager *Pager) FlushAllPagesContext(ctx context.Context) error {
+	// Only dirty pages are written, so skip the clean ones, writing the rest in file order.
+	pager.dirtyMtx.Lock()
+	dirty := make([]*Page, 0, len(pager.dirtyPages))
+	for page := range pager.dirtyPages {
+		dirty = append(dirty, page)
+	}
+	pager.dirtyMtx.Unlock()
+	slices.SortFunc(dirty, func(a, b *Page) int {
+		return cmp.Compare(a.pagenum, b.pagenum)
+	})
+	var errs []error
+	for _, page := range dirty {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := pager.FlushPage(page); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// GetNumDirtyPages returns the number of pages modified since they were last written to disk.
+func (pager *Pager) GetNumDirtyPages() int {
+	pager.dirtyMtx.Lock()
+	defer pager.dirtyMtx.Unlock()
+	return len(pager.dirtyPages)
+}
+
+// GetNumPagesWritten returns the number of pages written to disk since the pager was created.
+func (pager *Pager) GetNumPagesWritten() int64 {
+	return pager.pagesWritten.Load()
+}
+
+// [RECOVERY] SetWriteLSN sets the LSN that every page updated from now on is stamped with,
+// as the LSN of the logged edit being made, until it's set back to 0. Pages keep the highest
+// LSN they're stamped with. The caller must make sure that no other edit of the pager's pages
+// is being made meanwhile, or they would be stamped too.
+func (pager *Pager) SetWriteLSN(lsn uint64) {
+	pager.writeLSN.Store(lsn)
+}
+
 // [RECOVERY] Read locks the pager and all of the pager's pages.
 func (pager *Pager) LockAllPages() {
 	pager.ptMtx.Lock()
@@ -282,6 +359,26 @@ func (pager *Pager) LockAllPages() {
 	}
 }
 
+// [RECOVERY] Tries to read lock the pager and all of the pager's pages without waiting on
+// any page, which may be held by someone waiting on the pager. Reports whether it succeeded;
+// if not, nothing is left locked.
+func (pager *Pager) TryLockAllPages() bool {
+	pager.ptMtx.Lock()
+	locked := make([]*Page, 0, len(pager.pageTable))
+	for _, pageLink := range pager.pageTable {
+		page := pageLink.GetValue().(*Page)
+		if !page.TryRLock() {
+			for _, p := range locked {
+				p.RUnlock()
+			}
+			pager.ptMtx.Unlock()
+			return false
+		}
+		locked = append(locked, page)
+	}
+	return true
+}
+
 // [RECOVERY] Read unlocks the pager and all of the pager's pages.
 func (pager *Pager) UnlockAllPages() {
 	for _, pageLink := range pager.pageTable {
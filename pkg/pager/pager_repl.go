@@ -255,8 +255,7 @@ func HandlePagerFlush(p *Pager, payload string) (err error) {
 	}
 	// Flush.
 	page := link.GetValue().(*Page)
-	p.FlushPage(page)
-	return nil
+	return p.FlushPage(page)
 }
 
 // Function to flush all pages.
@@ -268,6 +267,5 @@ func HandlePagerFlushAll(p *Pager, payload string) (err error) {
 		return fmt.Errorf("usage: pager_flushall")
 	}
 	// Flush all.
-	p.FlushAllPages()
-	return nil
+	return p.FlushAllPages()
 }
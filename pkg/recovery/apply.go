@@ -0,0 +1,59 @@
+package recovery
+
+import (
+	"context"
+
+	"dinodb/pkg/database"
+)
+
+/*
+   ApplyLog replays a log file onto a database of its own, so that a replica can be built, or
+   data migrated, by shipping only the log. It redoes the log like the redo pass of recovery,
+   from the first log rather than from a checkpoint, but leaves out the transactions that never
+   finished instead of undoing them, so nothing is written back to the log.
+
+   Aborted transactions are redone along with their compensation logs, which cancel them out.
+*/
+
+// ApplyLog redoes every committed edit in the specified log file and its segments onto db,
+// which may be empty, creating and dropping tables as the log did. Edits of transactions that
+// never committed or aborted are ignored. The log file is only read. An encrypted log needs
+// the WithEncryptionKey option; options that only affect writing logs are ignored.
+func ApplyLog(db *database.Database, logFilename string, opts ...Option) error {
+	rm := &RecoveryManager{db: db, logFilename: logFilename, redoWorkers: 1}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	if rm.encryptionKey != nil {
+		aead, err := newAEAD(rm.encryptionKey)
+		if err != nil {
+			return err
+		}
+		rm.aead = aead
+	}
+	logs, err := readLogFile(logFilename, rm.aead)
+	if err != nil {
+		return err
+	}
+
+	if err := rm.redoSchema(logs, -1); err != nil {
+		return err
+	}
+	unfinished, _ := analyze(logs)
+	redo := make([]log, 0, len(logs))
+	for _, l := range logs {
+		switch log := l.(type) {
+		case editLog:
+			if unfinished[log.id] {
+				continue
+			}
+		case clrLog:
+			if unfinished[log.edit.id] {
+				continue
+			}
+		}
+		redo = append(redo, l)
+	}
+	_, err = rm.redoLogs(context.Background(), redo, nil, nil)
+	return err
+}
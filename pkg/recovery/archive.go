@@ -0,0 +1,142 @@
+package recovery
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dinodb/pkg/database"
+)
+
+/*
+   Backups can also be streamed as a tar archive, e.g. to ship them to another host, without
+   going through the backup folder. The archive holds the database folder right after a
+   checkpoint, including the log file up to the checkpoint log, so like Prime it expects the
+   log file to be kept in the database folder.
+*/
+
+// BackupTo creates a checkpoint and writes a tar archive of the database folder as of
+// that checkpoint to w. Logging is blocked until the archive has been written.
+// The archive can be restored with RestoreFromArchive.
+func (rm *RecoveryManager) BackupTo(w io.Writer) error {
+	if len(rm.dbs) > 0 {
+		return ErrSharedLog
+	}
+	rm.checkpointMtx.Lock()
+	defer rm.checkpointMtx.Unlock()
+	unlock := rm.lockAllTables()
+	defer unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if err := rm.checkpoint(context.Background()); err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	if err := writeArchive(tw, strings.TrimSuffix(rm.db.GetBasePath(), "/")); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeArchive adds every folder and regular file in the specified folder to the archive,
+// named relative to the folder.
+func writeArchive(tw *tar.Writer, folder string) error {
+	return filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(folder, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.CopyN(tw, file, info.Size())
+		return err
+	})
+}
+
+// RestoreFromArchive restores the database in the specified folder from a tar archive written
+// by BackupTo, in place of Prime. Like RestoreFrom, the archive also becomes the database's backup.
+// Should be followed by recovering with a new RecoveryManager, like Prime.
+func RestoreFromArchive(r io.Reader, dbFolder string, opts ...Option) (*database.Database, error) {
+	base := filepath.Clean(dbFolder)
+	snapshot, err := os.MkdirTemp(filepath.Dir(base), filepath.Base(base)+"-archive")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(snapshot)
+	if err = readArchive(tar.NewReader(r), snapshot); err != nil {
+		return nil, err
+	}
+	return RestoreFrom(base, snapshot, opts...)
+}
+
+// readArchive extracts the folders and regular files of the archive into the specified folder.
+func readArchive(tr *tar.Reader, folder string) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !filepath.IsLocal(filepath.FromSlash(header.Name)) {
+			return fmt.Errorf("archive entry %q escapes the restored folder", header.Name)
+		}
+		target := filepath.Join(folder, filepath.FromSlash(header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0775); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = extractFile(tr, target, header.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// extractFile writes the contents of the current archive entry to the specified file.
+func extractFile(tr *tar.Reader, name string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0775); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(file, tr); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
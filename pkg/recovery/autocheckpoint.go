@@ -0,0 +1,91 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+/*
+   With automatic checkpointing enabled, a background goroutine wakes up once every interval
+   and takes a fuzzy checkpoint if the log has grown by more than a threshold number of bytes
+   or logs since the last checkpoint completed, keeping the amount of log redone on recovery
+   bounded. Fuzzy checkpoints are taken since they hold up other clients only briefly, unless
+   other databases share the log.
+
+   Should an automatic checkpoint fail, no further ones are taken, and Close returns the error.
+*/
+
+// WithAutoCheckpoint enables automatic checkpointing, checking once every interval whether
+// more than maxBytes bytes or maxLogs logs were logged since the last checkpoint, and taking
+// a fuzzy checkpoint if so. A non-positive threshold is ignored, and a non-positive interval
+// or both thresholds being non-positive disables automatic checkpointing.
+// See config.AutoCheckpointInterval for a sensible default interval.
+func WithAutoCheckpoint(interval time.Duration, maxBytes int64, maxLogs int64) Option {
+	return func(rm *RecoveryManager) {
+		rm.autoCheckpointInterval = interval
+		rm.autoCheckpointBytes = maxBytes
+		rm.autoCheckpointLogs = maxLogs
+	}
+}
+
+// autoCheckpointEnabled returns whether automatic checkpointing is configured.
+func (rm *RecoveryManager) autoCheckpointEnabled() bool {
+	return rm.autoCheckpointInterval > 0 && (rm.autoCheckpointBytes > 0 || rm.autoCheckpointLogs > 0)
+}
+
+// countLog records that the log with the specified LSN and size in bytes was flushed, restarting
+// the count of what was logged since the last checkpoint if the log completes a checkpoint.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) countLog(l log, lsn uint64, size int) {
+	switch l.(type) {
+	case checkpointLog, endCheckpointLog:
+		rm.checkpointLSN = lsn
+		rm.bytesSinceCheckpoint = 0
+	default:
+		rm.bytesSinceCheckpoint += int64(size)
+	}
+}
+
+// needsCheckpoint returns whether the log grew past a threshold since the last checkpoint.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) needsCheckpoint() bool {
+	if rm.autoCheckpointBytes > 0 && rm.bytesSinceCheckpoint > rm.autoCheckpointBytes {
+		return true
+	}
+	return rm.autoCheckpointLogs > 0 && int64(rm.lsn-rm.checkpointLSN) > rm.autoCheckpointLogs
+}
+
+// runCheckpointer takes a fuzzy checkpoint whenever the log grew past a threshold, checking
+// once per automatic checkpoint interval, until rm.stop is closed or a checkpoint fails.
+func (rm *RecoveryManager) runCheckpointer() {
+	defer rm.background.Done()
+	ticker := time.NewTicker(rm.autoCheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rm.stop:
+			return
+		case <-ticker.C:
+		}
+		rm.mtx.Lock()
+		needed := !rm.closed && rm.needsCheckpoint()
+		rm.mtx.Unlock()
+		if !needed {
+			continue
+		}
+		checkpoint := rm.FuzzyCheckpoint
+		if len(rm.dbs) > 0 {
+			// Fuzzy checkpoints only back up the primary database; see databases.go.
+			checkpoint = rm.Checkpoint
+		}
+		if err := checkpoint(); err != nil {
+			if !errors.Is(err, ErrClosed) {
+				rm.mtx.Lock()
+				rm.autoCheckpointErr = fmt.Errorf("error taking an automatic checkpoint: %w", err)
+				rm.mtx.Unlock()
+			}
+			return
+		}
+	}
+}
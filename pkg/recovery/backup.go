@@ -0,0 +1,396 @@
+package recovery
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dinodb/pkg/config"
+	"dinodb/pkg/database"
+	"dinodb/pkg/pager"
+
+	"github.com/otiai10/copy"
+)
+
+/*
+   Backups are taken incrementally: a file whose size and modification time match its copy
+   in the backup folder is skipped, and any other file is compared against its copy one page
+   at a time, only rewriting the pages that differ. Files and folders that no longer exist in
+   the database folder are removed from the backup folder, so the backup always ends up
+   byte-identical to the database folder.
+
+   To never be left without a complete backup, a backup is first taken in a temporary folder
+   (db-recovery.tmp) and then swapped in: the current backup is renamed aside (db-recovery.old),
+   the temporary folder takes its place, and the old backup becomes the temporary folder for the
+   next backup. Since the old backup is only a couple of checkpoints behind, the next backup can
+   still be taken incrementally. Once a backup is complete, and before it is swapped in, a marker
+   file (.committed) is written to it, and Prime only restores from a backup folder holding the
+   marker, so a partial backup is never restored over the database. The marker isn't copied into
+   the database folder on restore.
+
+   The backup folder defaults to the database folder's sibling (db-recovery), but can be put
+   anywhere with WithBackupFolder, such as on a larger volume; its temporary folders are always
+   its siblings. Prime and the Restore functions must then be given the same option.
+
+   Optionally, older backups are kept as generations in timestamped folders (db-recovery-<ts>), so
+   that the database can be restored to an earlier checkpoint. Each generation is a snapshot of the
+   backup folder right after a checkpoint, log included. Once the retention limit is reached, the
+   oldest generation is recycled into the newest one so that it can also be taken incrementally.
+*/
+
+// The layout of the timestamp naming a backup generation, which sorts chronologically.
+const generationLayout = "20060102T150405.000000000Z"
+
+// The name of the file marking a backup folder as complete.
+const completeMarker = ".committed"
+
+// WithBackupRetention keeps the backups of the specified number of most recent checkpoints
+// as generations, which can be listed with ListBackups and restored with RestoreBackup.
+// Defaults to 0, only keeping the backup folder of the latest checkpoint.
+func WithBackupRetention(n int) Option {
+	return func(rm *RecoveryManager) {
+		rm.backupRetention = n
+	}
+}
+
+// WithBackupFolder keeps the database's backup in the specified folder, absolute or relative to
+// the working directory, instead of next to the database folder. The folder must not be inside
+// the database folder. Prime, RestoreBackup, RestoreFrom, and RestoreFromArchive must be given
+// the same option to find the backup.
+func WithBackupFolder(folder string) Option {
+	return func(rm *RecoveryManager) {
+		rm.backupFolder = folder
+	}
+}
+
+// backupFolderOf returns the backup folder of the database in the specified folder, as
+// configured by the given options.
+func backupFolderOf(base string, opts []Option) string {
+	rm := &RecoveryManager{}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	return rm.backupFolderFor(base)
+}
+
+// backupFolderFor returns the backup folder of the database in the specified folder:
+// the configured backup folder if set, or else the database folder's -recovery sibling.
+func (rm *RecoveryManager) backupFolderFor(base string) string {
+	if rm.backupFolder != "" {
+		return filepath.Clean(rm.backupFolder)
+	}
+	return base + "-recovery"
+}
+
+// backupFolders returns the names of the specified backup folder, the temporary folder backups
+// are taken in, and the folder an old backup is moved to while swapping.
+func backupFolders(backup string) (current string, tmp string, old string) {
+	return backup, backup + ".tmp", backup + ".old"
+}
+
+// swapInBackup takes a backup of the database in the specified folder
+// and swaps it in as the specified backup folder.
+func swapInBackup(base string, backup string) error {
+	_, tmp, _ := backupFolders(backup)
+	if _, err := syncFolder(base, tmp, nil); err != nil {
+		return err
+	}
+	return swapBackup(backup)
+}
+
+// swapBackup marks the backup taken in the temporary folder as complete, and then swaps it in
+// as the specified backup folder.
+func swapBackup(backup string) error {
+	current, tmp, old := backupFolders(backup)
+	if err := markComplete(tmp); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(current, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// If we crash now, Prime restores the old backup.
+	if err := os.Rename(tmp, current); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(backup)); err != nil {
+		return err
+	}
+	// Keep the old backup around to take the next backup incrementally.
+	if err := os.Rename(old, tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// generationFolder returns the name of the generation of the specified backup folder
+// that was taken at the given time.
+func generationFolder(backup string, ts time.Time) string {
+	return backup + "-" + ts.UTC().Format(generationLayout)
+}
+
+// listGenerations returns the times of the generations of the specified backup folder,
+// from oldest to newest.
+func listGenerations(backup string) ([]time.Time, error) {
+	entries, err := os.ReadDir(filepath.Dir(backup))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(backup) + "-"
+	generations := make([]time.Time, 0)
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		// Skips generations that are still being taken.
+		ts, err := time.Parse(generationLayout, name)
+		if err != nil {
+			continue
+		}
+		generations = append(generations, ts)
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i].Before(generations[j]) })
+	return generations, nil
+}
+
+// snapshotGeneration keeps the specified backup folder as a new generation taken at the given
+// time, pruning the oldest generations beyond the retention limit.
+func snapshotGeneration(backup string, retention int, now time.Time) error {
+	generations, err := listGenerations(backup)
+	if err != nil {
+		return err
+	}
+	current, _, _ := backupFolders(backup)
+	target := generationFolder(backup, now)
+	tmp := target + ".tmp"
+	if excess := len(generations) + 1 - retention; excess > 0 {
+		// Recycle the oldest generation so the snapshot can be taken incrementally.
+		if err := os.Rename(generationFolder(backup, generations[0]), tmp); err != nil {
+			return err
+		}
+		for _, ts := range generations[1:excess] {
+			if err := os.RemoveAll(generationFolder(backup, ts)); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := syncFolder(current, tmp, nil); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(backup))
+}
+
+// restoreSwappedBackup moves the old backup back into place as the specified backup folder
+// if a crash in swapInBackup left the database without a backup folder.
+func restoreSwappedBackup(backup string) error {
+	current, _, old := backupFolders(backup)
+	if _, err := os.Stat(current); !os.IsNotExist(err) {
+		return err
+	}
+	if _, err := os.Stat(old); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Rename(old, current)
+}
+
+// markComplete writes the marker of a complete backup to the specified backup folder.
+func markComplete(backup string) error {
+	marker, err := os.Create(filepath.Join(backup, completeMarker))
+	if err != nil {
+		return err
+	}
+	err = marker.Sync()
+	if closeErr := marker.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// isComplete returns whether the specified backup folder holds a complete backup.
+func isComplete(backup string) bool {
+	_, err := os.Stat(filepath.Join(backup, completeMarker))
+	return err == nil
+}
+
+// restoreFolder replaces the database folder with a copy of the specified backup folder,
+// leaving out its marker, and opens the restored database.
+func restoreFolder(backup string, dbFolder string) (*database.Database, error) {
+	if err := restoreFiles(backup, dbFolder); err != nil {
+		return nil, err
+	}
+	return database.Open(dbFolder)
+}
+
+// restoreFiles replaces the database folder with a copy of the specified backup folder,
+// leaving out its marker.
+func restoreFiles(backup string, dbFolder string) error {
+	if err := os.RemoveAll(dbFolder); err != nil {
+		return err
+	}
+	skipMarker := func(src string) (bool, error) {
+		return filepath.Base(src) == completeMarker, nil
+	}
+	return copy.Copy(backup, dbFolder, copy.Options{Skip: skipMarker})
+}
+
+// RestoreBackup restores the database in the specified folder to the backup generation taken
+// at the given time, as listed by ListBackups, in place of Prime. The generation also becomes
+// the database's backup, and any logs written after its checkpoint are discarded.
+// Should be followed by recovering with a new RecoveryManager, like Prime.
+func RestoreBackup(folder string, ts time.Time, opts ...Option) (*database.Database, error) {
+	base := filepath.Clean(folder)
+	generation := generationFolder(backupFolderOf(base, opts), ts)
+	if _, err := os.Stat(generation); err != nil {
+		return nil, fmt.Errorf("no backup taken at %v: %w", ts, err)
+	}
+	return RestoreFrom(base, generation, opts...)
+}
+
+// RestoreFrom restores the database in the specified folder from an explicit snapshot folder,
+// such as a backup shipped from another host, in place of Prime. The snapshot, which must hold
+// a log file, also becomes the database's backup, and the database's own log is discarded.
+// Should be followed by recovering with a new RecoveryManager, like Prime.
+func RestoreFrom(dbFolder string, snapshotFolder string, opts ...Option) (*database.Database, error) {
+	base := filepath.Clean(dbFolder)
+	snapshot := filepath.Clean(snapshotFolder)
+	// Validate the snapshot before clobbering the database folder.
+	if _, err := os.Stat(filepath.Join(snapshot, config.LogFileName)); err != nil {
+		return nil, fmt.Errorf("snapshot %s has no log file: %w", snapshot, err)
+	}
+	current := backupFolderOf(base, opts)
+	if snapshot != current {
+		if _, err := syncFolder(snapshot, current, nil); err != nil {
+			return nil, err
+		}
+		if err := markComplete(current); err != nil {
+			return nil, err
+		}
+	}
+	return restoreFolder(current, base+"/")
+}
+
+// syncDir syncs the specified directory, making renames within it durable.
+func syncDir(name string) error {
+	dir, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// syncFolder incrementally updates dst to be an exact copy of src,
+// returning the number of bytes written to dst. Files for which skip, if set, returns true
+// given their name relative to src are left as they are in dst.
+func syncFolder(src string, dst string, skip func(rel string) bool) (copied int64, err error) {
+	err = filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if entry.IsDir() {
+			return os.MkdirAll(target, 0775)
+		}
+		if skip != nil && skip(rel) {
+			return nil
+		}
+		n, err := syncFile(path, target)
+		copied += n
+		return err
+	})
+	if err != nil {
+		return copied, err
+	}
+	// Remove anything from the backup that is no longer in the database folder.
+	err = filepath.WalkDir(dst, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); os.IsNotExist(err) {
+			if err := os.RemoveAll(path); err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// syncFile incrementally updates dst to be an exact copy of src, rewriting only the pages
+// that differ, and returns the number of bytes written. Files whose size and modification
+// time already match are assumed to be identical.
+func syncFile(src string, dst string) (copied int64, err error) {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if dstInfo, err := os.Stat(dst); err == nil &&
+		dstInfo.Size() == srcInfo.Size() && dstInfo.ModTime().Equal(srcInfo.ModTime()) {
+		return 0, nil
+	}
+
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer srcFile.Close()
+	dstFile, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, srcInfo.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	defer dstFile.Close()
+
+	srcPage := make([]byte, pager.Pagesize)
+	dstPage := make([]byte, pager.Pagesize)
+	for offset := int64(0); offset < srcInfo.Size(); offset += pager.Pagesize {
+		n, err := srcFile.ReadAt(srcPage, offset)
+		if err != nil && err != io.EOF {
+			return copied, err
+		}
+		m, err := dstFile.ReadAt(dstPage, offset)
+		if err != nil && err != io.EOF {
+			return copied, err
+		}
+		if n == m && bytes.Equal(srcPage[:n], dstPage[:m]) {
+			continue
+		}
+		if _, err = dstFile.WriteAt(srcPage[:n], offset); err != nil {
+			return copied, err
+		}
+		copied += int64(n)
+	}
+	if err = dstFile.Truncate(srcInfo.Size()); err != nil {
+		return copied, err
+	}
+	if err = dstFile.Sync(); err != nil {
+		return copied, err
+	}
+	// Match the modification time so that an unchanged file is skipped next time.
+	return copied, os.Chtimes(dst, srcInfo.ModTime(), srcInfo.ModTime())
+}
@@ -0,0 +1,138 @@
+package recovery
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dinodb/pkg/config"
+)
+
+/*
+   Backups are taken and restored through a Backuper, so that a database can be backed up
+   somewhere other than the local filesystem, such as object storage, with WithBackuper. A
+   checkpoint hands the database folder to Snapshot once every table is flushed, and a fuzzy
+   checkpoint hands it the local folder it copied the tables into one at a time. Prime then
+   restores the latest snapshot with Restore before recovery.
+
+   The default Backuper keeps the backup in a local folder as described in backup.go, and is
+   configured with WithBackupFolder and WithBackupRetention. RestoreBackup, RestoreFrom,
+   RestoreFromArchive, and VerifyBackup only work with it.
+*/
+
+// ErrNoBackup is returned by a Backuper's Restore when no backup was ever completed.
+var ErrNoBackup = errors.New("no complete backup")
+
+// Backuper takes and restores the backups of a database folder.
+type Backuper interface {
+	// Snapshot backs up the files in the specified folder, log included, as the latest backup.
+	// The previous backup must stay restorable until the new one is complete.
+	Snapshot(srcDir string) error
+	// Restore replaces the files in the specified database folder with the latest complete
+	// backup, but keeps the log file and its segments already in the folder, which are newer
+	// than the backup's, and must not lose them should it crash midway. Returns ErrNoBackup,
+	// leaving the folder as it is, if no backup was ever completed.
+	Restore(dstDir string) error
+	// List returns the times of the backups kept, from oldest to newest.
+	List() ([]time.Time, error)
+}
+
+// WithBackuper takes and restores backups with the specified Backuper instead of the local
+// backup folder. Prime must be given the same option.
+func WithBackuper(backuper Backuper) Option {
+	return func(rm *RecoveryManager) {
+		rm.backuper = backuper
+	}
+}
+
+// backuperOf returns the Backuper of the database in the specified folder, as configured by
+// the given options.
+func backuperOf(base string, opts []Option) Backuper {
+	rm := &RecoveryManager{clock: systemClock{}}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	return rm.backuperFor(base)
+}
+
+// backuperFor returns the configured Backuper if set, or else one keeping the backup of the
+// database in the specified folder in its local backup folder.
+func (rm *RecoveryManager) backuperFor(base string) Backuper {
+	if rm.backuper != nil {
+		return rm.backuper
+	}
+	return &localBackuper{backup: rm.backupFolderFor(base), retention: rm.backupRetention, clock: rm.clock}
+}
+
+// localBackuper is the default Backuper, keeping the backup in a local folder; see backup.go.
+type localBackuper struct {
+	backup    string // The backup folder.
+	retention int    // The number of generations to keep, if any.
+	clock     Clock  // Where the time generations are named after is read from.
+}
+
+// Snapshot takes a backup of the specified folder incrementally and swaps it in. A backup
+// already taken in the temporary backup folder, as by a fuzzy checkpoint, is swapped in as is.
+func (b *localBackuper) Snapshot(srcDir string) error {
+	_, tmp, _ := backupFolders(b.backup)
+	var err error
+	if filepath.Clean(srcDir) == tmp {
+		err = swapBackup(b.backup)
+	} else {
+		err = swapInBackup(filepath.Clean(srcDir), b.backup)
+	}
+	if err != nil {
+		return err
+	}
+	if b.retention > 0 {
+		return snapshotGeneration(b.backup, b.retention, b.clock.Now())
+	}
+	return nil
+}
+
+// Restore replaces the database folder with the backup folder, after copying the log file and
+// its segments into the backup folder, so that they survive a crash while restoring.
+func (b *localBackuper) Restore(dstDir string) error {
+	recoveryFolder := b.backup + "/"
+	// Finish swapping in a backup if we crashed in the middle of it.
+	if err := restoreSwappedBackup(b.backup); err != nil {
+		return err
+	}
+
+	// If recovery folder doesn't exist, create it and leave the db folder as it is.
+	// It starts out as a complete backup of the empty database.
+	if _, err := os.Stat(recoveryFolder); err != nil {
+		if os.IsNotExist(err) {
+			err := os.MkdirAll(recoveryFolder, 0775)
+			if err == nil {
+				err = markComplete(b.backup)
+			}
+			if err != nil {
+				return err
+			}
+			return ErrNoBackup
+		}
+		return err
+	}
+
+	// A backup that was never completed can't be trusted over the db folder.
+	if !isComplete(b.backup) {
+		return ErrNoBackup
+	}
+
+	// Copies over log file and its segments if they are in the db folder
+	logSrcPath := filepath.Join(dstDir, config.LogFileName)
+	if _, err := os.Stat(logSrcPath); err == nil {
+		logDstPath := filepath.Join(recoveryFolder, config.LogFileName)
+		if err := copyLogFiles(logSrcPath, logDstPath); err != nil {
+			return err
+		}
+	}
+	return restoreFiles(recoveryFolder, dstDir)
+}
+
+// List returns the times of the backup generations kept.
+func (b *localBackuper) List() ([]time.Time, error) {
+	return listGenerations(b.backup)
+}
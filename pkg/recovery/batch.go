@@ -0,0 +1,143 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+
+	"dinodb/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+/*
+   EditBatch logs many edits of a transaction at once, for bulk loads. Logging each edit with
+   Edit takes rm.mtx and appends to the log once per edit; a batch is serialized under a single
+   acquisition of rm.mtx, so its edits get consecutive LSNs, and is then pushed onto the client's
+   stack in one go. Like with Edit, the edits only become durable once the transaction commits
+   or the log is flushed.
+
+   The whole batch is serialized into one buffer with a single timestamp and appended to the log
+   at once, each edit sealed on its own if the log is encrypted. Should the batch not fit in the
+   log file, the log file is rotated before any of it is written, so a batch never spans two log
+   files and a failed rotation leaves none of it in the log. Subscribers are shipped each edit.
+
+   If logging the batch fails, none of its edits is pushed, so the client's stack never holds
+   part of a batch. Should a failed write have left part of the batch in the log, it doesn't
+   matter: a failed write fails every later log, commit included, so the transaction can only
+   be rolled back.
+*/
+
+// EditRecord is an individual entry change to log as part of a batch; see EditBatch.
+type EditRecord struct {
+	Table  database.Index // The table where the edit takes place
+	Action action         // The type of edit action taken
+	Key    int64          // The key of the tuple edited
+	OldVal int64          // The old value before the edit
+	NewVal int64          // The new value after the edit
+}
+
+// EditBatch records several entry changes of a client's transaction to the write-ahead log,
+// in order, like calling Edit for each but holding rm.mtx only once. Returns an error
+// without pushing any of the edits onto the client's stack if logging any of them fails.
+func (rm *RecoveryManager) EditBatch(clientId uuid.UUID, edits []EditRecord) error {
+	batch := make([]editLog, len(edits))
+	for i, edit := range edits {
+		if edit.Table == nil {
+			return errors.New("error writing an Edit log: edit has no table")
+		}
+		batch[i] = editLog{
+			id:        clientId,
+			db:        rm.dbIDOf(edit.Table),
+			tablename: edit.Table.GetName(),
+			action:    edit.Action,
+			key:       edit.Key,
+			oldval:    edit.OldVal,
+			newval:    edit.NewVal,
+		}
+	}
+	if _, started := rm.stacks.get(clientId); !started {
+		return fmt.Errorf("error writing an Edit log: %w", ErrNoTransaction)
+	}
+	rm.quiesceMtx.RLock()
+	defer rm.quiesceMtx.RUnlock()
+	if len(batch) > 0 {
+		batch[0].prevLSN = rm.stacks.prevLSN(clientId)
+	}
+	rm.tm.Touch(clientId)
+	rm.mtx.Lock()
+	if rm.closed {
+		rm.mtx.Unlock()
+		return ErrClosed
+	}
+	err := rm.flushEdits(batch)
+	rm.mtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("error writing an Edit log: %w", err)
+	}
+	rm.stacks.push(clientId, batch...)
+	return nil
+}
+
+// flushEdits assigns the edit logs consecutive LSNs and appends them to the log file's buffer
+// at once, like calling flushLog for each, setting the LSN of each and chaining each edit after
+// the first to the one before it. Expects rm.mtx to be locked.
+func (rm *RecoveryManager) flushEdits(batch []editLog) error {
+	if rm.syncErr != nil {
+		return rm.syncErr
+	}
+	buf := recordBuffers.Get().(*[]byte)
+	records := (*buf)[:0]
+	defer func() {
+		*buf = records[:0]
+		recordBuffers.Put(buf)
+	}()
+	if rm.logSize == 0 {
+		if err := rm.writeVersion(); err != nil {
+			return err
+		}
+	}
+	// The end of each edit's record in records, to ship them one by one.
+	var ends []int
+	if len(rm.subscribers) > 0 {
+		ends = make([]int, len(batch))
+	}
+	serialize := func() {
+		records = records[:0]
+		timestamp := rm.nextTimestamp()
+		for i := range batch {
+			batch[i].logHeader = logHeader{lsn: rm.lsn + uint64(i) + 1, timestamp: timestamp}
+			if i > 0 {
+				batch[i].prevLSN = batch[i-1].lsn
+			}
+			if rm.aead != nil {
+				records = rm.serialize(records, batch[i].logHeader, batch[i])
+			} else {
+				records = appendRecord(records, batch[i].logHeader, batch[i], rm.format, rm.checksums)
+			}
+			if ends != nil {
+				ends[i] = len(records)
+			}
+		}
+	}
+	serialize()
+	if rm.maxLogSize > 0 && rm.logSize > 0 && rm.logSize+int64(len(records)) > rm.maxLogSize {
+		if err := rm.rotate(); err != nil {
+			return fmt.Errorf("error rotating the log file: %w", err)
+		}
+		// The segment log heading the new log file took the next LSN.
+		serialize()
+	}
+	if _, err := rm.writer.Write(records); err != nil {
+		return err
+	}
+	rm.lsn += uint64(len(batch))
+	rm.logSize += int64(len(records))
+	// Like countLog does for each edit.
+	rm.bytesSinceCheckpoint += int64(len(records))
+	start := 0
+	for i, end := range ends {
+		rm.ship(batch[i], batch[i].lsn, records[start:end])
+		start = end
+	}
+	return nil
+}
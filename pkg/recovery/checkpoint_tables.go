@@ -0,0 +1,204 @@
+package recovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"dinodb/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A table checkpoint flushes and backs up only some of the tables, so that a few busy tables can
+   be checkpointed often without copying every other table each time. The new backup holds the
+   named tables as they are now and every other table as it was in the backup in place, along
+   with everything else in the database folder, such as the log. Once it has been swapped in, a
+   table checkpoint log naming the tables is written.
+
+   Recovery doesn't start at a table checkpoint, since the other tables in the backup are older:
+   the logs since the last full or fuzzy checkpoint are redone as always, but the edits of each
+   named table logged before its latest table checkpoint are skipped, since its backup already
+   holds them. Skipping them only saves time, as the pages they changed carry their LSNs anyway;
+   see page_lsn.go. Should we crash after the backup is swapped in but before the log is written,
+   those edits are redone and skipped by their page LSNs instead.
+
+   The edits of transactions still running at a table checkpoint are kept, though: an edit
+   logged through Edit is applied by its caller after it was logged, without the table's apply
+   mutex, so it may not have reached the backup even if a later edit stamped its page. Those are
+   redone whatever their pages' LSNs, and then undone from the log like any other edits of a
+   transaction recovery rolls back, should it never have finished.
+*/
+
+// ErrNotLocalBackup is returned by operations that build on the backup in the local backup
+// folder when the recovery manager was configured with another Backuper.
+var ErrNotLocalBackup = errors.New("only supported with the local backup folder")
+
+// CheckpointTables flushes the named tables and takes a backup holding them as they are now,
+// leaving every other table in the backup as it was, then writes a checkpoint log naming them.
+// Clients editing other tables keep running in the meantime. Returns an error if a table
+// doesn't exist. If the backup can't be taken, nothing is logged.
+func (rm *RecoveryManager) CheckpointTables(names ...string) error {
+	if len(rm.dbs) > 0 {
+		return ErrSharedLog
+	}
+	if _, local := rm.backuper.(*localBackuper); !local {
+		return ErrNotLocalBackup
+	}
+	tables := make(map[string]database.Index, len(names))
+	for _, name := range names {
+		table, err := rm.db.GetTable(name)
+		if err != nil {
+			return err
+		}
+		tables[name] = table
+	}
+	sorted := make([]string, 0, len(tables))
+	for name := range tables {
+		sorted = append(sorted, name)
+	}
+	slices.Sort(sorted)
+
+	rm.checkpointMtx.Lock()
+	defer rm.checkpointMtx.Unlock()
+	// Each edit made through the recovery REPL is logged and applied under its table's apply mutex,
+	// so while they are held, every such edit of the named tables has been applied and no other
+	// edit can be logged of them. Edits logged through Edit are kept by recovery instead.
+	for _, name := range sorted {
+		mtx := rm.tableMutex("", name)
+		mtx.Lock()
+		defer mtx.Unlock()
+	}
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	for _, name := range sorted {
+		if err := flushTable(context.Background(), tables[name], nil); err != nil {
+			return fmt.Errorf("error flushing table %s: %w", name, err)
+		}
+	}
+	if err := rm.backupTables(sorted); err != nil {
+		return err
+	}
+	lsn, err := rm.flushLog(tableCheckpointLog{tables: sorted})
+	if err == nil {
+		err = rm.waitDurable(lsn)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing a TableCheckpoint log: %w", err)
+	}
+	return nil
+}
+
+// backupTables takes a backup holding the named tables, and everything in the database folder
+// besides tables, as they are now, and every other table as it is in the backup in place, and
+// swaps it in. Expects the named tables to be flushed and rm.mtx to be held.
+func (rm *RecoveryManager) backupTables(names []string) error {
+	base := strings.TrimSuffix(rm.db.GetBasePath(), "/")
+	current, tmp, _ := backupFolders(rm.backupFolderFor(base))
+	if _, err := os.Stat(current); err == nil {
+		if _, err = syncFolder(current, tmp, nil); err != nil {
+			return err
+		}
+	} else if os.IsNotExist(err) {
+		// Without a backup in place, the other tables are left out and rebuilt from the log.
+		if err = os.RemoveAll(tmp); err != nil {
+			return err
+		}
+		if err = os.MkdirAll(tmp, 0775); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+	kept := make(map[string]bool)
+	for name := range rm.db.GetTables() {
+		if !slices.Contains(names, name) {
+			kept[name] = true
+			kept[name+".meta"] = true
+		}
+	}
+	// The removal pass of syncFolder still drops tables that are gone from the database folder.
+	if _, err := syncFolder(base, tmp, func(rel string) bool { return kept[rel] }); err != nil {
+		return err
+	}
+	return rm.backuper.Snapshot(tmp)
+}
+
+// skipCheckpointedTables returns the logs to redo, leaving out the edit and compensation logs
+// of each table that precede its latest table checkpoint among them, unless their transaction
+// was still running at it. Those are marked in force, to be redone whatever their pages' LSNs.
+func skipCheckpointedTables(logs []log, force map[uint64]bool) []log {
+	checkpointed := make(map[string]uint64)
+	for _, l := range logs {
+		if log, ok := l.(tableCheckpointLog); ok {
+			for _, name := range log.tables {
+				checkpointed[name] = log.lsn
+			}
+		}
+	}
+	if len(checkpointed) == 0 {
+		return logs
+	}
+	finished := finishedAt(logs)
+	redo := make([]log, 0, len(logs))
+	for _, l := range logs {
+		var edit editLog
+		switch log := l.(type) {
+		case editLog:
+			edit = log
+		case clrLog:
+			edit = log.edit
+		}
+		if lsn, ok := checkpointed[edit.tablename]; ok && edit.db == "" && l.getLSN() < lsn {
+			if end := finished(edit.id, l.getLSN()); end != 0 && end < lsn {
+				continue
+			}
+			force[l.getLSN()] = true
+		}
+		redo = append(redo, l)
+	}
+	return redo
+}
+
+// finishedAt returns a function giving the LSN of the commit or abort log among the given logs
+// that finished the transaction running under the specified id at the given LSN, following it
+// across reclaims, or 0 if it never finished.
+func finishedAt(logs []log) func(id uuid.UUID, lsn uint64) uint64 {
+	type event struct {
+		lsn uint64
+		to  uuid.UUID // The id the transaction was reclaimed by, unless it finished
+		end bool
+	}
+	events := make(map[uuid.UUID][]event)
+	for _, l := range logs {
+		switch log := l.(type) {
+		case commitLog:
+			events[log.id] = append(events[log.id], event{lsn: log.lsn, end: true})
+		case abortLog:
+			events[log.id] = append(events[log.id], event{lsn: log.lsn, end: true})
+		case reclaimLog:
+			events[log.id] = append(events[log.id], event{lsn: log.lsn, to: log.to})
+		}
+	}
+	var finished func(id uuid.UUID, lsn uint64) uint64
+	finished = func(id uuid.UUID, lsn uint64) uint64 {
+		for _, e := range events[id] {
+			if e.lsn <= lsn {
+				continue
+			}
+			if e.end {
+				return e.lsn
+			}
+			return finished(e.to, e.lsn)
+		}
+		return 0
+	}
+	return finished
+}
@@ -0,0 +1,505 @@
+package recovery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/icza/backscanner"
+)
+
+// LogFormat is the encoding used to write logs to the log file.
+type LogFormat int
+
+const (
+	StringLogFormat LogFormat = iota // Human-readable text, one log per line
+	BinaryLogFormat                  // Length-prefixed binary frames
+)
+
+/*
+   Binary logs are written as frames that can be scanned in either direction:
+
+   magic (1 byte) | length (4 bytes) | body (length bytes) | crc32 of body (4 bytes) | length (4 bytes)
+
+   The body holds the log's LSN (8 bytes), a tag for the log's type (1 byte), the log's timestamp
+   (8 bytes, only if the tag has timestampFlag set), the id of the log's database (only if the tag
+   has dbFlag set, for a log of a database other than the primary one), and then the log's fields,
+   followed by the edit's previous LSN (8 bytes) for an edit log whose tag has prevFlag set.
+   Strings are prefixed by their 2 byte length, UUIDs take 16 bytes, and all integers are
+   fixed-width and big-endian. Binary frames are always checksummed.
+*/
+
+// The first byte of every binary frame. Never the first byte of a string log.
+const binaryMagic byte = 0xDB
+
+// The number of bytes in a binary frame besides the body.
+const frameOverhead = 1 + 4 + 4 + 4
+
+// Tags identifying the type of a log in the binary format.
+const (
+	tableTag byte = iota + 1
+	editTag
+	clrTag
+	startTag
+	commitTag
+	checkpointTag
+	segmentTag
+	abortTag
+	dropTableTag
+	beginCheckpointTag
+	endCheckpointTag
+	sealedTag
+	versionTag
+	reclaimTag
+	tableCheckpointTag
+)
+
+// Set on the tag of a log that is followed by a timestamp. Logs written before
+// timestamps were introduced don't have it.
+const timestampFlag byte = 0x80
+
+// Set on the tag of a table, drop, edit, or compensation log of a database other than the
+// primary one, which is followed by the database's id.
+const dbFlag byte = 0x40
+
+// Set on the tag of an edit log that is chained to its transaction's previous log, whose LSN
+// follows the edit's fields. Edits logged before edits were chained don't have it.
+const prevFlag byte = 0x20
+
+var errShortLog = errors.New("log is too short")
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+	return append(b, s...)
+}
+
+func appendUUID(b []byte, id uuid.UUID) []byte {
+	return append(b, id[:]...)
+}
+
+func appendInt64(b []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(v))
+}
+
+// appendTag appends the tag of a log of the specified database to b, followed by the
+// database's id unless it is the primary database.
+func appendTag(b []byte, tag byte, db string) []byte {
+	if db == "" {
+		return append(b, tag)
+	}
+	return appendString(append(b, tag|dbFlag), db)
+}
+
+func (tl tableLog) encode(b []byte) []byte {
+	b = appendTag(b, tableTag, tl.db)
+	b = appendString(b, tl.tblType)
+	return appendString(b, tl.tblName)
+}
+
+func (dl dropTableLog) encode(b []byte) []byte {
+	b = appendTag(b, dropTableTag, dl.db)
+	return appendString(b, dl.tblName)
+}
+
+// encodeFields appends the fields of the edit, without its tag and database, to b.
+func (el editLog) encodeFields(b []byte) []byte {
+	b = appendUUID(b, el.id)
+	b = appendString(b, el.tablename)
+	b = appendString(b, string(el.action))
+	b = appendInt64(b, el.key)
+	b = appendInt64(b, el.oldval)
+	return appendInt64(b, el.newval)
+}
+
+func (el editLog) encode(b []byte) []byte {
+	if el.prevLSN == 0 {
+		return el.encodeFields(appendTag(b, editTag, el.db))
+	}
+	b = el.encodeFields(appendTag(b, editTag|prevFlag, el.db))
+	return binary.BigEndian.AppendUint64(b, el.prevLSN)
+}
+
+func (cl clrLog) encode(b []byte) []byte {
+	b = appendTag(b, clrTag, cl.edit.db)
+	b = cl.edit.encodeFields(b)
+	return binary.BigEndian.AppendUint64(b, cl.undoNext)
+}
+
+func (sl startLog) encode(b []byte) []byte {
+	b = append(b, startTag)
+	return appendUUID(b, sl.id)
+}
+
+func (cl commitLog) encode(b []byte) []byte {
+	b = append(b, commitTag)
+	return appendUUID(b, cl.id)
+}
+
+func (al abortLog) encode(b []byte) []byte {
+	b = append(b, abortTag)
+	return appendUUID(b, al.id)
+}
+
+func (rl reclaimLog) encode(b []byte) []byte {
+	b = append(b, reclaimTag)
+	return appendUUID(appendUUID(b, rl.id), rl.to)
+}
+
+func (cl checkpointLog) encode(b []byte) []byte {
+	b = append(b, checkpointTag)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(cl.ids)))
+	for _, id := range cl.ids {
+		b = appendUUID(b, id)
+	}
+	return b
+}
+
+func (bl beginCheckpointLog) encode(b []byte) []byte {
+	b = append(b, beginCheckpointTag)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(bl.ids)))
+	for _, id := range bl.ids {
+		b = appendUUID(b, id)
+	}
+	return b
+}
+
+func (el endCheckpointLog) encode(b []byte) []byte {
+	b = append(b, endCheckpointTag)
+	return binary.BigEndian.AppendUint64(b, el.begin)
+}
+
+func (tl tableCheckpointLog) encode(b []byte) []byte {
+	b = append(b, tableCheckpointTag)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(tl.tables)))
+	for _, name := range tl.tables {
+		b = appendString(b, name)
+	}
+	return b
+}
+
+func (sl segmentLog) encode(b []byte) []byte {
+	b = append(b, segmentTag)
+	return binary.BigEndian.AppendUint64(b, sl.prev)
+}
+
+func (vl versionLog) encode(b []byte) []byte {
+	b = append(b, versionTag)
+	b = binary.BigEndian.AppendUint32(b, vl.version)
+	return binary.BigEndian.AppendUint32(b, vl.features)
+}
+
+// decoder reads fixed-width fields off the front of a binary log,
+// remembering the first error encountered.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.buf) < n {
+		d.err = errShortLog
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	return int64(d.uint64())
+}
+
+func (d *decoder) string() string {
+	n := 0
+	if b := d.next(2); b != nil {
+		n = int(binary.BigEndian.Uint16(b))
+	}
+	return string(d.next(n))
+}
+
+func (d *decoder) uuid() uuid.UUID {
+	var id uuid.UUID
+	copy(id[:], d.next(16))
+	return id
+}
+
+func (d *decoder) editFields() editLog {
+	return editLog{
+		id:        d.uuid(),
+		tablename: d.string(),
+		action:    action(d.string()),
+		key:       d.int64(),
+		oldval:    d.int64(),
+		newval:    d.int64(),
+	}
+}
+
+// encodeFrame serializes the specified log with the given header into a binary frame.
+func encodeFrame(header logHeader, log log) []byte {
+	encoded := log.encode(make([]byte, 0, 64))
+	body := binary.BigEndian.AppendUint64(make([]byte, 0, len(encoded)+16), header.lsn)
+	// The timestamp goes between the tag and the log's fields.
+	body = append(body, encoded[0]|timestampFlag)
+	body = appendInt64(body, header.timestamp)
+	body = append(body, encoded[1:]...)
+	frame := make([]byte, 0, len(body)+frameOverhead)
+	frame = append(frame, binaryMagic)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+	frame = append(frame, body...)
+	frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(body))
+	return binary.BigEndian.AppendUint32(frame, uint32(len(body)))
+}
+
+// logFromBytes converts a binary frame to its respective log struct.
+// Returns an error if the frame is malformed or failed its checksum.
+func logFromBytes(frame []byte) (log, error) {
+	if len(frame) < frameOverhead || frame[0] != binaryMagic {
+		return nil, errors.New("could not parse log")
+	}
+	n := int(binary.BigEndian.Uint32(frame[1:5]))
+	if len(frame) != n+frameOverhead || int(binary.BigEndian.Uint32(frame[len(frame)-4:])) != n {
+		return nil, errors.New("log has mismatched lengths")
+	}
+	body := frame[5 : 5+n]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(frame[5+n:]) {
+		return nil, errors.New("log failed its checksum")
+	}
+	d := &decoder{buf: body}
+	header := logHeader{lsn: d.uint64()}
+	tag := d.byte()
+	if tag&timestampFlag != 0 {
+		tag &^= timestampFlag
+		header.timestamp = d.int64()
+	}
+	return decodeLog(header, tag, d)
+}
+
+// decodeLog decodes the fields of a log of the type with the given tag, which must make up
+// the rest of the decoder's buffer.
+func decodeLog(header logHeader, tag byte, d *decoder) (log, error) {
+	chained := tag&prevFlag != 0
+	if chained {
+		tag &^= prevFlag
+		if tag&^dbFlag != editTag {
+			return nil, errors.New("log of unknown type has a previous LSN")
+		}
+	}
+	var db string
+	if tag&dbFlag != 0 {
+		tag &^= dbFlag
+		db = d.string()
+		if tag != tableTag && tag != dropTableTag && tag != editTag && tag != clrTag {
+			return nil, errors.New("log of unknown type has a database")
+		}
+	}
+	var l log
+	switch tag {
+	case tableTag:
+		l = tableLog{logHeader: header, db: db, tblType: d.string(), tblName: d.string()}
+	case dropTableTag:
+		l = dropTableLog{logHeader: header, db: db, tblName: d.string()}
+	case editTag:
+		edit := d.editFields()
+		edit.logHeader = header
+		edit.db = db
+		if chained {
+			edit.prevLSN = d.uint64()
+		}
+		l = edit
+	case clrTag:
+		edit := d.editFields()
+		edit.db = db
+		l = clrLog{logHeader: header, edit: edit, undoNext: d.uint64()}
+	case startTag:
+		l = startLog{logHeader: header, id: d.uuid()}
+	case commitTag:
+		l = commitLog{logHeader: header, id: d.uuid()}
+	case abortTag:
+		l = abortLog{logHeader: header, id: d.uuid()}
+	case reclaimTag:
+		l = reclaimLog{logHeader: header, id: d.uuid(), to: d.uuid()}
+	case checkpointTag:
+		ids := make([]uuid.UUID, d.uint32())
+		for i := range ids {
+			ids[i] = d.uuid()
+		}
+		l = checkpointLog{logHeader: header, ids: ids}
+	case beginCheckpointTag:
+		ids := make([]uuid.UUID, d.uint32())
+		for i := range ids {
+			ids[i] = d.uuid()
+		}
+		l = beginCheckpointLog{logHeader: header, ids: ids}
+	case endCheckpointTag:
+		l = endCheckpointLog{logHeader: header, begin: d.uint64()}
+	case tableCheckpointTag:
+		tables := make([]string, d.uint32())
+		for i := range tables {
+			tables[i] = d.string()
+		}
+		l = tableCheckpointLog{logHeader: header, tables: tables}
+	case segmentTag:
+		l = segmentLog{logHeader: header, prev: d.uint64()}
+	case versionTag:
+		l = versionLog{logHeader: header, version: d.uint32(), features: d.uint32()}
+		// Later versions may add fields to the version log.
+		d.next(len(d.buf))
+	case sealedTag:
+		l = sealedLog{logHeader: header, payload: d.next(len(d.buf))}
+	default:
+		return nil, errors.New("unknown log type")
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.buf) != 0 {
+		return nil, errors.New("log has trailing bytes")
+	}
+	return l, nil
+}
+
+// detectLogFormat returns the format of the log file based on its first byte,
+// or def if the log file is empty.
+func detectLogFormat(logFile io.ReaderAt, def LogFormat) (LogFormat, error) {
+	first := make([]byte, 1)
+	_, err := logFile.ReadAt(first, 0)
+	if err == io.EOF {
+		return def, nil
+	} else if err != nil {
+		return def, err
+	}
+	if first[0] == binaryMagic {
+		return BinaryLogFormat, nil
+	}
+	return StringLogFormat, nil
+}
+
+// recordScanner reads the raw records of a log file backward, starting from the end.
+type recordScanner interface {
+	// prev returns the previous record and its byte offset in the log file,
+	// or io.EOF once the start of the log file is reached.
+	prev() (record []byte, offset int64, err error)
+}
+
+// textScanner reads newline-delimited string logs backward, skipping empty lines.
+type textScanner struct {
+	scanner *backscanner.Scanner
+}
+
+func (s *textScanner) prev() ([]byte, int64, error) {
+	for {
+		line, pos, err := s.scanner.LineBytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(bytes.TrimSpace(line)) > 0 {
+			return bytes.Clone(line), int64(pos), nil
+		}
+	}
+}
+
+// binaryScanner reads binary frames backward using the length at the end of each frame.
+type binaryScanner struct {
+	file io.ReaderAt
+	pos  int64 // The offset just past the next frame to read
+}
+
+func (s *binaryScanner) prev() ([]byte, int64, error) {
+	if s.pos == 0 {
+		return nil, 0, io.EOF
+	}
+	if s.pos < frameOverhead {
+		return nil, 0, errShortLog
+	}
+	var lenBuf [4]byte
+	if _, err := s.file.ReadAt(lenBuf[:], s.pos-4); err != nil {
+		return nil, 0, err
+	}
+	start := s.pos - frameOverhead - int64(binary.BigEndian.Uint32(lenBuf[:]))
+	if start < 0 {
+		return nil, 0, errShortLog
+	}
+	frame := make([]byte, s.pos-start)
+	if _, err := s.file.ReadAt(frame, start); err != nil {
+		return nil, 0, err
+	}
+	s.pos = start
+	return frame, start, nil
+}
+
+// newRecordScanner returns a scanner over the records of the log file in the given format.
+func newRecordScanner(logFile LogStorage, format LogFormat) (recordScanner, error) {
+	fstats, err := logFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if format == BinaryLogFormat {
+		return &binaryScanner{file: logFile, pos: fstats.Size()}, nil
+	}
+	return &textScanner{scanner: backscanner.New(logFile, int(fstats.Size()))}, nil
+}
+
+// parseRecord converts a raw record in the given format to its respective log struct.
+func parseRecord(record []byte, format LogFormat) (log, error) {
+	if format == BinaryLogFormat {
+		return logFromBytes(record)
+	}
+	return logFromString(string(record))
+}
+
+// lastCompleteFrame scans the binary frames of the log file forward, returning the offset
+// just past the last frame that could be read in full and parsed.
+func lastCompleteFrame(logFile LogStorage) (int64, error) {
+	fstats, err := logFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := fstats.Size()
+	var pos int64
+	header := make([]byte, 5)
+	for pos+frameOverhead <= size {
+		if _, err := logFile.ReadAt(header, pos); err != nil {
+			return 0, err
+		}
+		end := pos + frameOverhead + int64(binary.BigEndian.Uint32(header[1:]))
+		if header[0] != binaryMagic || end > size {
+			break
+		}
+		frame := make([]byte, end-pos)
+		if _, err := logFile.ReadAt(frame, pos); err != nil {
+			return 0, err
+		}
+		if _, err := logFromBytes(frame); err != nil {
+			break
+		}
+		pos = end
+	}
+	return pos, nil
+}
@@ -0,0 +1,271 @@
+package recovery
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+/*
+   Compacting the log rewrites it to hold as little as recovery and rollback still need. A
+   checkpoint is taken first, so that the backup reflects every log, and then the log file and
+   its segments are replaced by a single log file holding, in order:
+
+   - the create log of every table that still exists, and every log of each transaction that
+     hasn't finished, with their original LSNs so that undoNext LSNs and undo stacks stay valid
+   - a committed transaction with a single edit per key that committed transactions edited,
+     setting the key to its latest committed value, or deleting it if it was last deleted
+   - a checkpoint log listing the running transactions, as recovery starts from it
+
+   Edits of dropped tables are discarded along with the drop logs. The new log file is written
+   next to the log file and renamed over it, and only then are the segments removed.
+*/
+
+// keyState is the latest committed state of a key, as seen while compacting the log.
+type keyState struct {
+	present bool  // Whether the key was last inserted or updated, rather than deleted
+	val     int64 // The key's value if present, otherwise its value before it was deleted
+}
+
+// CompactLog takes a checkpoint and then rewrites the log so that it holds a single edit per
+// key with the key's latest committed value, along with every log of any transaction that
+// hasn't finished; see compact.go. The log file's segments are merged into the log file.
+// History before the compaction can no longer be recovered to with RecoverTo.
+func (rm *RecoveryManager) CompactLog() error {
+	if rm.storage != nil {
+		return ErrNotLogFile
+	}
+	rm.checkpointMtx.Lock()
+	defer rm.checkpointMtx.Unlock()
+	unlock := rm.lockAllTables()
+	defer unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if err := rm.checkpoint(context.Background()); err != nil {
+		return err
+	}
+	rm.fileMtx.Lock()
+	defer rm.fileMtx.Unlock()
+	if err := rm.syncAll(); err != nil {
+		return err
+	}
+
+	kept, values, order, err := rm.compactLogs()
+	if err != nil {
+		return err
+	}
+
+	// Write the compacted log file, assigning new LSNs to the logs made up for it.
+	tmpName := rm.logFilename + ".compact"
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, rm.logFileMode)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	lsn, size := rm.lsn, int64(0)
+	write := func(header logHeader, l log) {
+		record := rm.serialize(nil, header, l)
+		size += int64(len(record))
+		writer.Write(record)
+	}
+	version := rm.versionRecord()
+	size += int64(len(version))
+	writer.Write(version)
+	for _, l := range kept {
+		write(logHeader{lsn: l.getLSN(), timestamp: timestampOf(l)}, l)
+	}
+	next := func() logHeader {
+		lsn++
+		return logHeader{lsn: lsn, timestamp: rm.nextTimestamp()}
+	}
+	id := uuid.New()
+	write(next(), startLog{id: id})
+	for _, k := range order {
+		edit := editLog{id: id, db: k.db, tablename: k.table, action: INSERT_ACTION, key: k.key, newval: values[k].val}
+		if !values[k].present {
+			edit.action, edit.oldval, edit.newval = DELETE_ACTION, values[k].val, 0
+		}
+		write(next(), edit)
+	}
+	write(next(), commitLog{id: id})
+	write(next(), checkpointLog{ids: rm.runningIds()})
+	err = writer.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, rm.logFilename)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("error writing the compacted log: %w", err)
+	}
+
+	// The compacted log file is in place, so the segments are redundant.
+	segments, err := listSegments(rm.logFilename)
+	if err != nil {
+		return err
+	}
+	for _, n := range segments {
+		if err := removeSegment(rm.logFilename, n); err != nil {
+			return err
+		}
+	}
+	logFile, err := os.OpenFile(rm.logFilename, os.O_APPEND|os.O_RDWR|os.O_CREATE, rm.logFileMode)
+	if err != nil {
+		rm.syncErr = fmt.Errorf("error reopening the log file: %w", err)
+		return rm.syncErr
+	}
+	rm.logFile.Close()
+	rm.logFile = logFile
+	rm.writer.Reset(logFile)
+	rm.logSize = size
+	rm.lsn = lsn
+	rm.syncedLSN = lsn
+	rm.checkpointLSN = lsn
+	rm.bytesSinceCheckpoint = 0
+	return nil
+}
+
+// compactKey names a key of a table.
+type compactKey struct {
+	db    string
+	table string
+	key   int64
+}
+
+// compactLogs reads every log and returns the logs kept as is by CompactLog, in order, along
+// with the latest committed state of every key edited by a committed transaction and the order
+// in which the keys were first edited. Expects rm.mtx and rm.fileMtx to be locked, and every
+// log to have been written to the log file.
+func (rm *RecoveryManager) compactLogs() (kept []log, values map[compactKey]keyState, order []compactKey, err error) {
+	cursor, err := rm.openLogCursor(rm.logSize, 0, 0, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer cursor.Close()
+	var logs []log
+	for {
+		l, err := cursor.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, nil, err
+		}
+		logs = append(logs, l)
+	}
+
+	// The index of the last table or drop log of each table, and whether each transaction finished.
+	lastSchema := make(map[string]int)
+	finished := make(map[uuid.UUID]bool)
+	var reclaims []reclaimLog
+	for i, l := range logs {
+		switch log := l.(type) {
+		case tableLog:
+			lastSchema[qualify(log.db, log.tblName)] = i
+		case dropTableLog:
+			lastSchema[qualify(log.db, log.tblName)] = i
+		case commitLog:
+			finished[log.id] = true
+		case abortLog:
+			finished[log.id] = true
+		case reclaimLog:
+			reclaims = append(reclaims, log)
+		}
+	}
+	// A reclaimed transaction finishes under the id it was reclaimed by.
+	for i := len(reclaims) - 1; i >= 0; i-- {
+		if finished[reclaims[i].to] {
+			finished[reclaims[i].id] = true
+		}
+	}
+
+	// Apply the edits of each transaction once it commits, discarding edits of dropped tables.
+	values = make(map[compactKey]keyState)
+	pending := make(map[uuid.UUID][]editLog)
+	apply := func(el editLog) {
+		k := compactKey{el.db, el.tablename, el.key}
+		state, seen := values[k]
+		if !seen {
+			order = append(order, k)
+		}
+		if el.action == DELETE_ACTION {
+			state.present = false
+		} else {
+			state = keyState{present: true, val: el.newval}
+		}
+		values[k] = state
+	}
+	for i, l := range logs {
+		switch log := l.(type) {
+		case tableLog:
+			if lastSchema[qualify(log.db, log.tblName)] == i {
+				kept = append(kept, l)
+			}
+			for k := range values {
+				if k.db == log.db && k.table == log.tblName {
+					delete(values, k)
+				}
+			}
+		case dropTableLog:
+			for k := range values {
+				if k.db == log.db && k.table == log.tblName {
+					delete(values, k)
+				}
+			}
+		case startLog:
+			if !finished[log.id] {
+				kept = append(kept, l)
+			}
+		case editLog:
+			if !finished[log.id] {
+				kept = append(kept, l)
+			}
+			pending[log.id] = append(pending[log.id], log)
+		case clrLog:
+			if !finished[log.edit.id] {
+				kept = append(kept, l)
+			}
+			pending[log.edit.id] = append(pending[log.edit.id], log.edit.inverse())
+		case commitLog:
+			for _, el := range pending[log.id] {
+				apply(el)
+			}
+			delete(pending, log.id)
+		case abortLog:
+			delete(pending, log.id)
+		case reclaimLog:
+			if !finished[log.to] {
+				kept = append(kept, l)
+			}
+			pending[log.to] = pending[log.id]
+			delete(pending, log.id)
+		}
+	}
+	// Skip the keys of tables dropped or recreated since, and keys listed again after a recreate.
+	live := make([]compactKey, 0, len(values))
+	listed := make(map[compactKey]bool, len(values))
+	for _, k := range order {
+		if _, ok := values[k]; ok && !listed[k] {
+			listed[k] = true
+			live = append(live, k)
+		}
+	}
+	return kept, values, live, nil
+}
+
+// timestampOf returns the timestamp of the log, or 0 if it has none.
+func timestampOf(l log) int64 {
+	if l.getTime().IsZero() {
+		return 0
+	}
+	return l.getTime().UnixNano()
+}
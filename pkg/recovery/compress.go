@@ -0,0 +1,165 @@
+package recovery
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+   With segment compression enabled, each segment is gzip-compressed once the log file is rotated
+   into it, since it will never be appended to again. A compressed segment is named after the
+   segment with a .gz suffix (db.log.000001.gz), and the uncompressed segment is removed once
+   the compressed one is in place. The log file itself is never compressed.
+
+   A compressed segment is read by decompressing it in full into an unlinked temporary file,
+   which is then read like any other segment, so byte offsets within a segment always refer to
+   its uncompressed contents. Compressed and uncompressed segments can be mixed.
+*/
+
+// The suffix of a compressed segment.
+const compressedSuffix = ".gz"
+
+// WithCompressSealedSegments sets whether segments are gzip-compressed once the log file is
+// rotated into them. Segments are read the same either way.
+func WithCompressSealedSegments(enabled bool) Option {
+	return func(rm *RecoveryManager) {
+		rm.compressSegments = enabled
+	}
+}
+
+// isCompressed returns whether the named file is a compressed segment.
+func isCompressed(name string) bool {
+	return strings.HasSuffix(name, compressedSuffix)
+}
+
+// segmentFile returns the name of the file holding the specified log file's segment with the
+// given number: the uncompressed segment if it exists, and otherwise the compressed one. Both only
+// exist after a crash while compressing, in which case either holds the whole segment.
+func segmentFile(logFilename string, n uint64) string {
+	name := segmentName(logFilename, n)
+	if _, err := os.Stat(name); err == nil {
+		return name
+	}
+	if _, err := os.Stat(name + compressedSuffix); err == nil {
+		return name + compressedSuffix
+	}
+	return name
+}
+
+// openSegment opens the named log file or segment for reading, decompressing it into
+// a temporary file first if it is compressed.
+func openSegment(name string) (*os.File, error) {
+	if !isCompressed(name) {
+		return os.Open(name)
+	}
+	src, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	reader, err := gzip.NewReader(src)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "segment-*")
+	if err != nil {
+		return nil, err
+	}
+	// The file stays readable until it is closed.
+	os.Remove(tmp.Name())
+	if _, err = io.Copy(tmp, reader); err == nil {
+		err = reader.Close()
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	return tmp, nil
+}
+
+// compressSegment replaces the named uncompressed segment with a compressed one.
+func compressSegment(name string, mode os.FileMode) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	return writeSegment(name+compressedSuffix, mode, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	}, func() error {
+		return os.Remove(name)
+	})
+}
+
+// writeSegment atomically writes the named file with the contents written by write, through
+// gzip if the file is a compressed segment, by writing a temporary file and renaming it over the
+// named one. Then done is called, if set, once the file is in place.
+func writeSegment(name string, mode os.FileMode, write func(w io.Writer) error, done func() error) error {
+	tmpName := name + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if isCompressed(name) {
+		gz := gzip.NewWriter(tmp)
+		err = write(gz)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	} else {
+		err = write(tmp)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, name)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	if done != nil {
+		return done()
+	}
+	return nil
+}
+
+// decompressPrefix replaces the named compressed segment with an uncompressed segment holding
+// only its first n uncompressed bytes, returning the name of the uncompressed segment.
+func decompressPrefix(name string, n int64, mode os.FileMode) (string, error) {
+	src, err := openSegment(name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	uncompressed := strings.TrimSuffix(name, compressedSuffix)
+	err = writeSegment(uncompressed, mode, func(w io.Writer) error {
+		_, err := io.CopyN(w, src, n)
+		return err
+	}, func() error {
+		return os.Remove(name)
+	})
+	return uncompressed, err
+}
+
+// removeSegment removes the specified log file's segment with the given number,
+// whether it is compressed or not.
+func removeSegment(logFilename string, n uint64) error {
+	name := segmentName(logFilename, n)
+	for _, file := range []string{name, name + compressedSuffix} {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
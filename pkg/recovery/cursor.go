@@ -0,0 +1,263 @@
+package recovery
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// LogCursor streams the logs of the log file and its segments forward, oldest first,
+// holding only the log being read in memory. A cursor reads the logs written before it was
+// created; logs written since are not seen.
+type LogCursor struct {
+	files    []string      // The names of the files to read, oldest first
+	size     int64         // The size of the log file itself, the last file, when the cursor was created
+	format   LogFormat     // The default format of the files
+	aead     cipher.AEAD   // Decrypts sealed logs, or nil if there is no encryption key
+	fromLSN  uint64        // Logs with a lower LSN are skipped
+	idx      int           // The index of the file being read
+	storage  LogStorage    // Read in place of the log file if the log isn't kept in one, or nil
+	file     LogStorage    // The file being read
+	reader   *bufio.Reader // Buffers reads of the file being read
+	fileFmt  LogFormat     // The format of the file being read
+	offset   int64         // The byte offset of the next record in the file being read
+	end      int64         // The byte offset of the end of the file being read
+	prefetch *logPrefetch  // Reads the files, if set; see prefetch.go
+}
+
+// NewLogCursor returns a cursor over the logs starting from the first log with an LSN
+// of at least fromLSN. A fromLSN of 0 starts from the first log.
+func (rm *RecoveryManager) NewLogCursor(fromLSN uint64) (*LogCursor, error) {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return nil, ErrClosed
+	}
+	if err := rm.writeBuffer(); err != nil {
+		return nil, err
+	}
+	cursor, err := rm.openLogCursor(rm.logSize, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	cursor.fromLSN = fromLSN
+	return cursor, nil
+}
+
+// NewCheckpointCursor returns a cursor over the logs starting from the most recent
+// checkpoint log, or from the first log if there is no checkpoint.
+func (rm *RecoveryManager) NewCheckpointCursor() (*LogCursor, error) {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return nil, ErrClosed
+	}
+	if err := rm.writeBuffer(); err != nil {
+		return nil, err
+	}
+	scanner, err := rm.newLogScanner()
+	if err != nil {
+		return nil, err
+	}
+	defer scanner.close()
+	var finder checkpointFinder
+	for {
+		record, offset, err := scanner.prev()
+		if err == io.EOF {
+			return rm.openLogCursor(rm.logSize, 0, 0, nil)
+		} else if err != nil {
+			return nil, err
+		}
+		l, err := scanner.parse(record, offset)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := finder.checkpoint(l); ok {
+			// The scanner's files are ordered newest first.
+			return rm.openLogCursor(rm.logSize, len(scanner.files)-1-scanner.idx, offset, nil)
+		}
+	}
+}
+
+// openLogCursor returns a cursor over the log of the given size and its segments, starting
+// from the byte offset of the file with the given index, oldest first. The files are read
+// through the prefetch, if one is given.
+func (rm *RecoveryManager) openLogCursor(size int64, idx int, offset int64, prefetch *logPrefetch) (*LogCursor, error) {
+	if rm.storage == nil {
+		return openLogCursor(rm.logFilename, rm.format, rm.aead, size, idx, offset, prefetch)
+	}
+	// A log kept elsewhere has no segments.
+	c := &LogCursor{files: []string{rm.logFilename}, size: size, format: rm.format, aead: rm.aead, storage: rm.storage, idx: idx, prefetch: prefetch}
+	if err := c.open(offset); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// openLogCursor returns a cursor over the specified log file of the given size and its
+// segments, starting from the byte offset of the file with the given index, oldest first.
+// The files are read through the prefetch, if one is given.
+func openLogCursor(logFilename string, format LogFormat, aead cipher.AEAD, size int64, idx int, offset int64, prefetch *logPrefetch) (*LogCursor, error) {
+	segments, err := listSegments(logFilename)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(segments)+1)
+	for _, segment := range segments {
+		files = append(files, segmentFile(logFilename, segment))
+	}
+	files = append(files, logFilename)
+	c := &LogCursor{files: files, size: size, format: format, aead: aead, idx: idx, prefetch: prefetch}
+	if err = c.open(offset); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// open starts reading the file at c.idx from the specified byte offset.
+func (c *LogCursor) open(offset int64) (err error) {
+	switch {
+	case c.prefetch != nil && c.storage != nil:
+		if c.file, err = c.prefetch.wrap(c.files[c.idx], c.storage, false); err != nil {
+			return err
+		}
+	case c.prefetch != nil:
+		if c.file, err = c.prefetch.open(c.files[c.idx]); err != nil {
+			return err
+		}
+	case c.storage != nil:
+		c.file = c.storage
+	default:
+		file, err := openSegment(c.files[c.idx])
+		if err != nil {
+			return err
+		}
+		c.file = file
+	}
+	c.fileFmt, err = detectLogFormat(c.file, c.format)
+	if err == nil {
+		err = checkFileVersion(c.file, c.fileFmt)
+	}
+	if err != nil {
+		c.closeFile()
+		return err
+	}
+	end := c.size
+	if c.idx < len(c.files)-1 {
+		info, err := c.file.Stat()
+		if err != nil {
+			c.closeFile()
+			return err
+		}
+		end = info.Size()
+	}
+	c.reader = bufio.NewReader(io.NewSectionReader(c.file, offset, end-offset))
+	c.offset, c.end = offset, end
+	return nil
+}
+
+// closeFile closes the file being read.
+func (c *LogCursor) closeFile() {
+	if c.file != nil && c.file != c.storage {
+		c.file.Close()
+	}
+	c.file = nil
+	c.reader = nil
+}
+
+// Next returns the next log, or io.EOF once every log has been read. A log that can't be
+// parsed is returned as a CorruptLogError, after which Next continues with the following log,
+// or with the next file if the log's length couldn't be read. The version log heading a file
+// is skipped; see version.go.
+func (c *LogCursor) Next() (log, error) {
+	for c.idx < len(c.files) {
+		if c.reader == nil {
+			if err := c.open(0); err != nil {
+				return nil, err
+			}
+		}
+		record, n, err := readRecord(c.reader, c.fileFmt, c.end-c.offset)
+		offset := c.offset
+		c.offset += int64(n)
+		if err == io.EOF {
+			c.closeFile()
+			c.idx++
+			continue
+		} else if err != nil {
+			// The rest of the file can't be told apart, so skip to the next one.
+			name := c.files[c.idx]
+			c.closeFile()
+			c.idx++
+			return nil, &CorruptLogError{File: name, Offset: offset, Line: string(record), Err: err}
+		}
+		l, err := parseRecord(record, c.fileFmt)
+		if err == nil {
+			l, err = unseal(c.aead, l, c.fileFmt)
+		}
+		if err != nil {
+			return nil, &CorruptLogError{File: c.files[c.idx], Offset: offset, Line: string(record), Err: err}
+		}
+		// The version log was checked when the file was opened.
+		if _, ok := l.(versionLog); ok {
+			continue
+		}
+		if c.fromLSN > 0 && l.getLSN() < c.fromLSN {
+			continue
+		}
+		return l, nil
+	}
+	return nil, io.EOF
+}
+
+// Close releases the file being read.
+func (c *LogCursor) Close() error {
+	c.closeFile()
+	c.idx = len(c.files)
+	return nil
+}
+
+// readRecord reads the next record forward in the given format out of the remaining bytes,
+// returning it along with the number of bytes consumed, or io.EOF if there are no more records.
+// Empty lines between string logs are skipped.
+func readRecord(r *bufio.Reader, format LogFormat, remaining int64) (record []byte, n int, err error) {
+	if format == BinaryLogFormat {
+		return readFrame(r, remaining)
+	}
+	for {
+		line, err := r.ReadBytes('\n')
+		n += len(line)
+		if trimmed := bytes.TrimRight(line, "\n"); len(bytes.TrimSpace(trimmed)) > 0 {
+			return trimmed, n, nil
+		}
+		if err != nil {
+			return nil, n, err
+		}
+	}
+}
+
+// readFrame reads the next binary frame forward out of the remaining bytes, returning it
+// along with the number of bytes consumed, or io.EOF if there are no more frames.
+func readFrame(r *bufio.Reader, remaining int64) ([]byte, int, error) {
+	header, err := r.Peek(5)
+	if err == io.EOF && len(header) == 0 {
+		return nil, 0, io.EOF
+	} else if err != nil {
+		return header, 0, errShortLog
+	}
+	if header[0] != binaryMagic {
+		return header, 0, errors.New("missing frame magic")
+	}
+	size := frameOverhead + int64(binary.BigEndian.Uint32(header[1:]))
+	if size > remaining {
+		return header, 0, errShortLog
+	}
+	frame := make([]byte, size)
+	n, err := io.ReadFull(r, frame)
+	if err != nil {
+		return frame[:n], n, errShortLog
+	}
+	return frame, n, nil
+}
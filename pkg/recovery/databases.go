@@ -0,0 +1,140 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"dinodb/pkg/database"
+)
+
+/*
+   Several databases can share one log, so that a small multi-tenant store has a single durable
+   log. The database the recovery manager is constructed with is the primary one, and the log
+   file lives in its folder. Every other database is registered under an id with WithDatabase,
+   and the table, drop, edit, and compensation logs of its tables carry its id. Recovery routes
+   each of them to the database it names, so tables of the same name in different databases
+   stay apart. A transaction may edit tables in any number of the databases.
+
+   Every database must be primed with Prime before the recovery manager is constructed. A
+   checkpoint flushes the tables of every database and backs up each of them, the primary
+   database, and so the log, last. Edits of a registered database's tables are logged for it
+   on their own; creating or dropping one of its tables is logged with TableIn and DropTableIn.
+   FuzzyCheckpoint, BackupTo, and VerifyBackup return ErrSharedLog once other databases are
+   registered, and snapshot reads only cover the primary database.
+*/
+
+// ErrUnknownDatabase is returned when a log names a database that isn't registered.
+var ErrUnknownDatabase = errors.New("database is not registered")
+
+// ErrSharedLog is returned by operations that only back up or check the primary database
+// when other databases share its log.
+var ErrSharedLog = errors.New("not supported with other databases sharing the log")
+
+// Database ids must be able to qualify table names in the string log format.
+var dbIDExp = regexp.MustCompile(`^\w+$`)
+
+// WithDatabase registers another database whose logs are written to the same log, under the
+// specified id, which must be alphanumeric. The id must stay the same across restarts.
+func WithDatabase(dbID string, db *database.Database) Option {
+	return func(rm *RecoveryManager) {
+		if rm.dbs == nil {
+			rm.dbs = make(map[string]*database.Database)
+		}
+		rm.dbs[dbID] = db
+	}
+}
+
+// checkDatabases returns an error if a registered database's id is invalid.
+func (rm *RecoveryManager) checkDatabases() error {
+	for id := range rm.dbs {
+		if !dbIDExp.MatchString(id) {
+			return fmt.Errorf("database id %q must be alphanumeric", id)
+		}
+	}
+	return nil
+}
+
+// database returns the database with the specified id, or the primary database for "".
+func (rm *RecoveryManager) database(dbID string) (*database.Database, error) {
+	if dbID == "" {
+		return rm.db, nil
+	}
+	if db, ok := rm.dbs[dbID]; ok {
+		return db, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownDatabase, dbID)
+}
+
+// dbIDOf returns the id of the database holding the table, or "" for the primary database.
+func (rm *RecoveryManager) dbIDOf(table database.Index) string {
+	if len(rm.dbs) == 0 {
+		return ""
+	}
+	name := table.GetName()
+	if t, ok := rm.db.GetTables()[name]; ok && t == table {
+		return ""
+	}
+	for id, db := range rm.dbs {
+		if t, ok := db.GetTables()[name]; ok && t == table {
+			return id
+		}
+	}
+	return ""
+}
+
+// databaseIDs returns the ids of the registered databases in order, followed by "" for the
+// primary database.
+func (rm *RecoveryManager) databaseIDs() []string {
+	return append(slices.Sorted(maps.Keys(rm.dbs)), "")
+}
+
+// qualify returns the name of a table qualified by its database's id, as it appears in the
+// string log format, which tells apart tables of the same name in different databases.
+func qualify(dbID string, tblName string) string {
+	if dbID == "" {
+		return tblName
+	}
+	return dbID + "." + tblName
+}
+
+// TableIn records the creation of a table in the database registered under the specified id
+// to the write-ahead log, like Table does for the primary database.
+func (rm *RecoveryManager) TableIn(dbID string, tblType string, tblName string) error {
+	if _, err := rm.database(dbID); err != nil {
+		return err
+	}
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	tl := tableLog{
+		db:      dbID,
+		tblType: tblType,
+		tblName: tblName,
+	}
+	_, err := rm.flushLog(tl)
+	if err != nil {
+		return fmt.Errorf("error writing a Table log: %w", err)
+	}
+	return nil
+}
+
+// backupDatabases backs up every registered database, after its tables were flushed by a
+// checkpoint. The primary database is backed up by delta. Unless a Backuper is configured,
+// a registered database is backed up next to its folder even if WithBackupFolder is set,
+// since the backup folder only holds one database.
+func (rm *RecoveryManager) backupDatabases() error {
+	for id, db := range rm.dbs {
+		base := strings.TrimSuffix(db.GetBasePath(), "/")
+		backuper := rm.backuper
+		if _, local := backuper.(*localBackuper); local {
+			backuper = &localBackuper{backup: base + "-recovery", retention: rm.backupRetention, clock: rm.clock}
+		}
+		if err := backuper.Snapshot(base); err != nil {
+			return fmt.Errorf("error backing up database %s: %w", id, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// DumpLog writes every log in the log file and its segments to w, oldest first, in the
+// human-readable form of DumpLogFile. Buffered logs are written to the log file first,
+// but nothing else is changed.
+func (rm *RecoveryManager) DumpLog(w io.Writer) error {
+	cursor, err := rm.NewLogCursor(0)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	return dumpLogs(w, cursor)
+}
+
+// DumpLogFile writes every log in the specified log file and its segments to w, oldest first,
+// with one row per log and a column for each of its LSN, time, type, client id, table, action,
+// key, and change in value. A log that can't be parsed is dumped as a CORRUPT row.
+// The log file is only read, so logs can be dumped even if the database can't be opened.
+func DumpLogFile(w io.Writer, logFilename string) error {
+	info, err := os.Stat(logFilename)
+	if err != nil {
+		return err
+	}
+	cursor, err := openLogCursor(logFilename, StringLogFormat, nil, info.Size(), 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close()
+	return dumpLogs(w, cursor)
+}
+
+// dumpLogs writes a row for every log read by the cursor to w.
+func dumpLogs(w io.Writer, cursor *LogCursor) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "LSN\tTIME\tTYPE\tCLIENT\tTABLE\tACTION\tKEY\tCHANGE")
+	for {
+		l, err := cursor.Next()
+		var corrupt *CorruptLogError
+		if err == io.EOF {
+			break
+		} else if errors.As(err, &corrupt) {
+			fmt.Fprintln(tw, strings.Join([]string{"-", "-", "CORRUPT", "-", "-", "-", "-", corrupt.Error()}, "\t"))
+			continue
+		} else if err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, strings.Join(dumpRow(l), "\t"))
+	}
+	return tw.Flush()
+}
+
+// dumpRow returns the columns of the dumped form of a log, using "-" for columns that don't apply.
+func dumpRow(l log) []string {
+	lsn, ts := "-", "-"
+	if l.getLSN() != 0 {
+		lsn = fmt.Sprint(l.getLSN())
+	}
+	if !l.getTime().IsZero() {
+		ts = l.getTime().UTC().Format(time.RFC3339Nano)
+	}
+	row := func(typ string, client string, table string, action string, key string, change string) []string {
+		return []string{lsn, ts, typ, client, table, action, key, change}
+	}
+	switch log := l.(type) {
+	case tableLog:
+		return row("TABLE", "-", qualify(log.db, log.tblName), "CREATE "+strings.ToUpper(log.tblType), "-", "-")
+	case dropTableLog:
+		return row("TABLE", "-", qualify(log.db, log.tblName), "DROP", "-", "-")
+	case editLog:
+		return row("EDIT", log.id.String(), qualify(log.db, log.tablename), string(log.action), fmt.Sprint(log.key),
+			fmt.Sprintf("%d→%d", log.oldval, log.newval))
+	case clrLog:
+		el := log.edit
+		return row("CLR", el.id.String(), qualify(el.db, el.tablename), string(el.action), fmt.Sprint(el.key),
+			fmt.Sprintf("%d→%d (undone, undoNext %d)", el.oldval, el.newval, log.undoNext))
+	case startLog:
+		return row("START", log.id.String(), "-", "-", "-", "-")
+	case commitLog:
+		return row("COMMIT", log.id.String(), "-", "-", "-", "-")
+	case abortLog:
+		return row("ABORT", log.id.String(), "-", "-", "-", "-")
+	case reclaimLog:
+		return row("RECLAIM", log.id.String(), "-", "-", "-", "reclaimed by "+log.to.String())
+	case checkpointLog:
+		ids := make([]string, 0, len(log.ids))
+		for _, id := range log.ids {
+			ids = append(ids, id.String())
+		}
+		if len(ids) == 0 {
+			ids = append(ids, "-")
+		}
+		return row("CHECKPOINT", strings.Join(ids, ","), "-", "-", "-", "-")
+	case beginCheckpointLog:
+		ids := make([]string, 0, len(log.ids))
+		for _, id := range log.ids {
+			ids = append(ids, id.String())
+		}
+		if len(ids) == 0 {
+			ids = append(ids, "-")
+		}
+		return row("CHECKPOINT", strings.Join(ids, ","), "-", "BEGIN", "-", "-")
+	case endCheckpointLog:
+		return row("CHECKPOINT", "-", "-", "END", "-", fmt.Sprintf("begun at %d", log.begin))
+	case tableCheckpointLog:
+		return row("CHECKPOINT", "-", strings.Join(log.tables, ","), "TABLES", "-", "-")
+	case segmentLog:
+		return row("SEGMENT", "-", "-", "-", "-", fmt.Sprintf("follows segment %d", log.prev))
+	default:
+		return row("UNKNOWN", "-", "-", "-", "-", "-")
+	}
+}
@@ -0,0 +1,230 @@
+package recovery
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+   Logs are written to the log file through a buffer, which is only written out and synced
+   to disk at transaction boundaries: Commit and Checkpoint wait until their log and every log
+   before it is durable. A full buffer is written out early, but not synced.
+
+   With group commit enabled, logs are instead synced by a background goroutine that wakes up
+   once every interval and issues a single Sync() for every log written since it last woke up.
+   Commit and Checkpoint still wait until their log is durable, but concurrent transactions
+   share the cost of each sync rather than paying for one each.
+
+   With asynchronous writes enabled, a background goroutine also writes out and syncs the buffer
+   once every interval, bounding how many logs can be lost on a crash. Flush makes every log
+   durable on demand.
+
+   With NoSyncDurability, logs are written out at the same points, in the same order, but the
+   log file is never synced, so a Commit returns once its log is handed to the OS rather than
+   once it is on disk. This is UNSAFE: a crash of the OS or the machine, though not of the
+   process alone, can lose committed transactions, or leave a log the OS wrote out of order.
+   It is only meant for tests and throwaway databases, where syncing dominates the runtime.
+
+   Other logs don't need to be durable right away: the database is restored from the last
+   checkpoint's backup on a crash, so an edit whose log was lost never reaches the recovered database.
+*/
+
+// Durability is how far the log goes to make committed transactions survive a crash.
+type Durability int
+
+const (
+	// FullDurability syncs the log file to disk before a Commit or Checkpoint returns.
+	FullDurability Durability = iota
+	// NoSyncDurability writes logs to the log file but never syncs it. UNSAFE: committed
+	// transactions can be lost if the OS or machine crashes. Only for tests and dev databases.
+	NoSyncDurability
+)
+
+// WithDurability sets how durable the log is. Defaults to FullDurability; see durability.go
+// for what NoSyncDurability gives up.
+func WithDurability(d Durability) Option {
+	return func(rm *RecoveryManager) {
+		rm.durability = d
+	}
+}
+
+// WithGroupCommit enables group commit, coalescing the syncs of all logs written within
+// each interval into a single sync. A non-positive interval disables group commit.
+// See config.GroupCommitInterval for a sensible default.
+func WithGroupCommit(interval time.Duration) Option {
+	return func(rm *RecoveryManager) {
+		rm.groupCommitInterval = interval
+	}
+}
+
+// WithAsyncWrites enables asynchronous writes, writing buffered logs to the log file
+// once every interval. Logs written since the last interval may be lost on a crash
+// unless a Commit, Checkpoint, or Flush made them durable.
+// A non-positive interval disables asynchronous writes.
+func WithAsyncWrites(interval time.Duration) Option {
+	return func(rm *RecoveryManager) {
+		rm.asyncInterval = interval
+	}
+}
+
+// startBackground starts the background goroutines needed by the configured options.
+func (rm *RecoveryManager) startBackground() {
+	rm.stop = make(chan struct{})
+	if rm.groupCommitInterval > 0 {
+		rm.synced = sync.NewCond(&rm.mtx)
+		rm.background.Add(1)
+		go rm.runSyncer()
+	}
+	if rm.asyncInterval > 0 {
+		rm.background.Add(1)
+		go rm.runWriter()
+	}
+	if rm.autoCheckpointEnabled() {
+		rm.background.Add(1)
+		go rm.runCheckpointer()
+	}
+}
+
+// stopBackground stops the background goroutines, waiting for them to return.
+// Expects rm.mtx to be unlocked.
+func (rm *RecoveryManager) stopBackground() {
+	close(rm.stop)
+	rm.background.Wait()
+}
+
+// runSyncer syncs every log written since the last sync once per group commit interval,
+// waking up everyone waiting on those logs, until rm.stop is closed.
+func (rm *RecoveryManager) runSyncer() {
+	defer rm.background.Done()
+	ticker := time.NewTicker(rm.groupCommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rm.stop:
+			return
+		case <-ticker.C:
+		}
+		rm.mtx.Lock()
+		rm.writeBuffer()
+		target := rm.lsn
+		if target <= rm.syncedLSN || rm.syncErr != nil {
+			rm.mtx.Unlock()
+			continue
+		}
+		rm.mtx.Unlock()
+		// Every log up to target was written while holding rm.mtx, so this sync covers them.
+		rm.fileMtx.Lock()
+		err := rm.syncLog()
+		rm.fileMtx.Unlock()
+		rm.mtx.Lock()
+		if err != nil {
+			rm.syncErr = err
+		} else if target > rm.syncedLSN {
+			rm.syncedLSN = target
+		}
+		rm.synced.Broadcast()
+		rm.mtx.Unlock()
+	}
+}
+
+// runWriter writes out the buffered logs once per asynchronous write interval,
+// until rm.stop is closed.
+func (rm *RecoveryManager) runWriter() {
+	defer rm.background.Done()
+	ticker := time.NewTicker(rm.asyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rm.stop:
+			return
+		case <-ticker.C:
+		}
+		rm.mtx.Lock()
+		if rm.synced == nil {
+			rm.waitDurable(rm.lsn)
+		} else {
+			// The syncer takes care of syncing.
+			rm.writeBuffer()
+		}
+		rm.mtx.Unlock()
+	}
+}
+
+// writeBuffer writes any buffered logs to the log file.
+// A failed write is remembered in rm.syncErr, since the logs it held are lost.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) writeBuffer() error {
+	if rm.syncErr != nil {
+		return rm.syncErr
+	}
+	if err := rm.writer.Flush(); err != nil {
+		rm.syncErr = err
+	}
+	return rm.syncErr
+}
+
+// syncAll writes out the buffer and syncs the log file, making every log flushed so far durable.
+// Expects rm.mtx and rm.fileMtx to be locked.
+func (rm *RecoveryManager) syncAll() error {
+	if err := rm.writeBuffer(); err != nil {
+		return err
+	}
+	if err := rm.syncLog(); err != nil {
+		rm.syncErr = err
+		return err
+	}
+	rm.syncedLSN = rm.lsn
+	if rm.synced != nil {
+		rm.synced.Broadcast()
+	}
+	return nil
+}
+
+// syncLog syncs the log file to disk, unless the durability is NoSyncDurability, in which
+// case the logs written to it are taken as durable without syncing.
+func (rm *RecoveryManager) syncLog() error {
+	if rm.durability == NoSyncDurability {
+		return nil
+	}
+	return rm.logFile.Sync()
+}
+
+// waitDurable blocks until the log with the specified LSN has been synced to disk,
+// returning the error of the write or sync if it failed. Buffered logs are written out first.
+// Without group commit, the log file is synced right away; otherwise rm.mtx is released while
+// waiting for the syncer.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) waitDurable(lsn uint64) error {
+	if err := rm.writeBuffer(); err != nil {
+		return err
+	}
+	if rm.synced == nil {
+		if rm.syncedLSN < lsn {
+			if err := rm.syncLog(); err != nil {
+				rm.syncErr = err
+				return err
+			}
+			rm.syncedLSN = rm.lsn
+		}
+		return nil
+	}
+	for rm.syncedLSN < lsn && rm.syncErr == nil {
+		rm.synced.Wait()
+	}
+	return rm.syncErr
+}
+
+// Flush makes every log flushed so far durable, writing out any buffered logs
+// and waiting for them to be synced to disk.
+func (rm *RecoveryManager) Flush() error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if err := rm.waitDurable(rm.lsn); err != nil {
+		return err
+	}
+	rm.publish()
+	return nil
+}
@@ -0,0 +1,125 @@
+package recovery
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+   With an encryption key set, every log is sealed with AES-GCM before it is written. The log's
+   LSN and timestamp are left in the clear, so that the log file can still be scanned, truncated,
+   and rotated without the key, while everything else about the log is encrypted:
+
+   lsn @timestamp { base64 of nonce | ciphertext } #checksum
+
+   In the binary format, the sealed log is a frame with the sealed tag whose fields are the nonce
+   and ciphertext. The ciphertext holds the log as it would otherwise have been written, minus its
+   LSN and timestamp, which are authenticated along with it so that they can't be tampered with
+   either. Every log gets a random nonce. Logs written without a key can still be read with one.
+*/
+
+var (
+	// ErrLogEncrypted is returned for a sealed log read without an encryption key.
+	ErrLogEncrypted = errors.New("log is encrypted, but no encryption key is set")
+	// ErrLogAuthentication is returned for a sealed log that failed authentication, either
+	// because the encryption key is wrong or because the log was tampered with.
+	ErrLogAuthentication = errors.New("log failed authentication: wrong encryption key or tampered log")
+)
+
+// WithEncryptionKey encrypts every log written with AES-GCM under the specified key, which must
+// be 16, 24, or 32 bytes long to select AES-128, AES-192, or AES-256. The same key is needed to
+// read the logs back, including to recover. NewRecoveryManager returns an error for a bad key.
+func WithEncryptionKey(key []byte) Option {
+	return func(rm *RecoveryManager) {
+		rm.encryptionKey = key
+	}
+}
+
+// newAEAD returns the AES-GCM cipher for the specified key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// A log sealed with AES-GCM. Its payload is the nonce followed by the ciphertext.
+type sealedLog struct {
+	logHeader
+	payload []byte
+}
+
+func (sl sealedLog) toString() string {
+	return string(sl.appendTo(nil)) + "\n"
+}
+
+func (sl sealedLog) appendTo(b []byte) []byte {
+	return append(base64.StdEncoding.AppendEncode(append(b, "{ "...), sl.payload), " }"...)
+}
+
+func (sl sealedLog) encode(b []byte) []byte {
+	return append(append(b, sealedTag), sl.payload...)
+}
+
+// sealedData returns the data authenticated along with a log's ciphertext, its LSN and timestamp.
+func sealedData(header logHeader) []byte {
+	data := binary.BigEndian.AppendUint64(make([]byte, 0, 16), header.lsn)
+	return binary.BigEndian.AppendUint64(data, uint64(header.timestamp))
+}
+
+// seal encrypts the specified log, to be written with the given header in the given format.
+func seal(aead cipher.AEAD, header logHeader, l log, format LogFormat) sealedLog {
+	var plaintext []byte
+	if format == BinaryLogFormat {
+		plaintext = l.encode(nil)
+	} else {
+		plaintext = l.appendTo(nil)
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("error generating a nonce: %v", err))
+	}
+	return sealedLog{logHeader: header, payload: aead.Seal(nonce, nonce, plaintext, sealedData(header))}
+}
+
+// unseal decrypts the log if it is sealed, returning it as is otherwise. Returns ErrLogEncrypted
+// if the log is sealed but there is no cipher, and ErrLogAuthentication if it fails to decrypt.
+func unseal(aead cipher.AEAD, l log, format LogFormat) (log, error) {
+	sealed, ok := l.(sealedLog)
+	if !ok {
+		return l, nil
+	}
+	if aead == nil {
+		return nil, ErrLogEncrypted
+	}
+	if len(sealed.payload) < aead.NonceSize() {
+		return nil, ErrLogAuthentication
+	}
+	nonce, ciphertext := sealed.payload[:aead.NonceSize()], sealed.payload[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, sealedData(sealed.logHeader))
+	if err != nil {
+		return nil, ErrLogAuthentication
+	}
+	var opened log
+	if format == BinaryLogFormat {
+		if len(plaintext) == 0 {
+			return nil, errShortLog
+		}
+		opened, err = decodeLog(sealed.logHeader, plaintext[0], &decoder{buf: plaintext[1:]})
+	} else {
+		opened, err = logFromString(fmt.Sprintf("%d @%d %s", sealed.lsn, sealed.timestamp, plaintext))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := opened.(sealedLog); ok {
+		return nil, errors.New("log is sealed twice")
+	}
+	return opened, nil
+}
@@ -0,0 +1,166 @@
+package recovery
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A fuzzy checkpoint takes a backup without stalling the database for its whole duration.
+   It writes a begin checkpoint log listing the running transactions, then flushes and copies
+   each table into the backup while locking only that table, and finally writes an end
+   checkpoint log naming the begin log once the backup has been swapped in.
+
+   Each table in the backup is a consistent copy of the table as of some point between the
+   begin and end logs, so recovery treats the begin log as the checkpoint and redoes every
+   edit after it. An edit is logged before it is made, so the edits of the transactions
+   running at the begin log may not have reached their table yet when it was copied; those
+   are redone first. Edits copied into the backup are skipped, since the pages they changed
+   carry their LSNs; see page_lsn.go. A begin log
+   without an end log belongs to a checkpoint that never finished and is ignored: the logs
+   since the previous checkpoint are redone instead, which holds up whichever backup is in place.
+*/
+
+// FuzzyCheckpoint creates a checkpoint like Checkpoint, but only holds up logging while
+// writing its begin and end checkpoint logs, and only locks one table at a time while
+// flushing it and copying it into the backup. Other clients keep running in the meantime.
+func (rm *RecoveryManager) FuzzyCheckpoint() error {
+	if len(rm.dbs) > 0 {
+		return ErrSharedLog
+	}
+	rm.checkpointMtx.Lock()
+	defer rm.checkpointMtx.Unlock()
+	rm.mtx.Lock()
+	if rm.closed {
+		rm.mtx.Unlock()
+		return ErrClosed
+	}
+	begin, err := rm.flushLog(beginCheckpointLog{ids: rm.runningIds()})
+	if err == nil {
+		err = rm.waitDurable(begin)
+	}
+	rm.mtx.Unlock()
+	if err != nil {
+		return fmt.Errorf("error writing a BeginCheckpoint log: %w", err)
+	}
+
+	base := strings.TrimSuffix(rm.db.GetBasePath(), "/")
+	backup := rm.backupFolderFor(base)
+	_, tmp, _ := backupFolders(backup)
+	if err = os.MkdirAll(tmp, 0775); err != nil {
+		return err
+	}
+	copied := make(map[string]bool)
+	backupTables := func() error {
+		for name, table := range rm.db.GetTables() {
+			if copied[name] {
+				continue
+			}
+			copied[name] = true
+			err := flushTable(context.Background(), table, func() error {
+				for _, file := range []string{name, name + ".meta"} {
+					if _, err := os.Stat(filepath.Join(base, file)); os.IsNotExist(err) {
+						continue
+					}
+					if _, err := syncFile(filepath.Join(base, file), filepath.Join(tmp, file)); err != nil {
+						return err
+					}
+					copied[file] = true
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("error backing up table %s: %w", name, err)
+			}
+		}
+		return nil
+	}
+	if err = backupTables(); err != nil {
+		return err
+	}
+
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	// Back up any table opened since, then everything else, such as the log, as is.
+	if err = backupTables(); err != nil {
+		return err
+	}
+	if _, err = syncFolder(base, tmp, func(rel string) bool { return copied[rel] }); err != nil {
+		return err
+	}
+	// The backup is taken before the checkpoint is complete. Should we crash in between,
+	// recovery redoes the logs since the previous checkpoint on top of the new backup instead.
+	if err = rm.backuper.Snapshot(tmp); err != nil {
+		return err
+	}
+	end, err := rm.flushLog(endCheckpointLog{begin: begin})
+	if err == nil {
+		err = rm.waitDurable(end)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing an EndCheckpoint log: %w", err)
+	}
+	return nil
+}
+
+// checkpointFinder picks out the most recent complete checkpoint from logs read newest first.
+// A fuzzy checkpoint is complete once its end log was written, and is taken at its begin log.
+type checkpointFinder struct {
+	begin uint64 // The LSN of the begin log of the most recent complete fuzzy checkpoint, if any
+}
+
+// checkpoint returns whether the log, the next one read backward, is the most recent complete
+// checkpoint, along with the ids of the transactions that were running at it.
+func (f *checkpointFinder) checkpoint(l log) (ids []uuid.UUID, ok bool) {
+	switch log := l.(type) {
+	case checkpointLog:
+		return log.ids, true
+	case endCheckpointLog:
+		if f.begin == 0 {
+			f.begin = log.begin
+		}
+	case beginCheckpointLog:
+		if f.begin != 0 && log.lsn == f.begin {
+			return log.ids, true
+		}
+	}
+	return nil, false
+}
+
+// inFlightLogs returns the edit and compensation logs of the specified transactions among
+// the given logs, which precede a checkpoint log or a fuzzy checkpoint's begin log. The transactions
+// hold the locks on the keys they edited, so their logs are the latest of those keys before it.
+func inFlightLogs(logs []log, ids []uuid.UUID) []log {
+	running := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		running[id] = true
+	}
+	// Logs of a reclaimed transaction from before it was reclaimed are under its old id.
+	for i := len(logs) - 1; i >= 0; i-- {
+		if log, ok := logs[i].(reclaimLog); ok && running[log.to] {
+			running[log.id] = true
+		}
+	}
+	inFlight := make([]log, 0)
+	for _, l := range logs {
+		switch log := l.(type) {
+		case editLog:
+			if running[log.id] {
+				inFlight = append(inFlight, l)
+			}
+		case clrLog:
+			if running[log.edit.id] {
+				inFlight = append(inFlight, l)
+			}
+		}
+	}
+	return inFlight
+}
@@ -1,23 +1,40 @@
 package recovery
 
 import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 /*
+   Every log is written on its own line, prefixed by its log sequence number (LSN) and the
+   time it was written in nanoseconds since the Unix epoch, and optionally followed by a
+   CRC32 checksum of everything before it:
+   lsn @timestamp < ... > #checksum
+
    Logs come in the following forms:
 
 	 TABLE log -- create a table;
 	 < create tblType table tblName >
 
-   EDIT log -- actions that modify database state;
-   < Tx, table, INSERT|DELETE|UPDATE, key, oldval, newval >
+   DROP log -- drop a table:
+   < drop table tblName >
+
+   EDIT log -- actions that modify database state, chained to the transaction's previous edit
+   left to undo, or to its START log if there is none:
+   < Tx, table, INSERT|DELETE|UPDATE, key, oldval, newval, prev lsn >
+
+   CLR log -- compensation for an undone edit, never undone itself:
+   < clr Tx, table, INSERT|DELETE|UPDATE, key, oldval, newval, undoNext lsn >
 
    START log -- start of a transaction:
    < Tx start >
@@ -25,23 +42,100 @@ import (
    COMMIT log -- end of a transaction:
    < Tx commit >
 
+   ABORT log -- end of a transaction that was rolled back, once all its edits were compensated:
+   < Tx abort >
+
+   RECLAIM log -- a running transaction handed over to a new id, which its later logs are under;
+   see reclaim.go:
+   < Tx reclaimed by Tx2 >
+
    CHECKPOINT log -- lists the currently running transactions:
    < Tx1, Tx2... checkpoint >
+
+   BEGIN CHECKPOINT log -- start of a fuzzy checkpoint, listing the currently running transactions:
+   < Tx1, Tx2... begin checkpoint >
+
+   END CHECKPOINT log -- end of the fuzzy checkpoint begun by the log with LSN begin:
+   < end checkpoint begin >
+
+   TABLE CHECKPOINT log -- the named tables were flushed and backed up on their own; see
+   checkpoint_tables.go:
+   < checkpoint tables tblName1, tblName2... >
+
+   SEGMENT log -- header of a log file started by rotation, naming the segment it follows:
+   < segment N >
+
+   VERSION log -- header of a new log file, with the log's format version and feature flags;
+   see version.go:
+   < version V features F >
+
+   SEALED log -- any of the above, encrypted; see encryption.go:
+   { base64 }
+
+   The table of a TABLE, DROP, EDIT, or CLR log of a database other than the recovery manager's
+   own is qualified by the database's id, as in dbID.tblName; see databases.go.
+
+   Logs can instead be written in a binary format; see codec.go.
 */
 
 // Interface that all log structs share.
 type log interface {
-	toString() string // Serializes the log to a string
+	toString() string         // Serializes the log to a string
+	appendTo(b []byte) []byte // Appends the log's string, without its trailing newline, to b
+	encode(b []byte) []byte   // Appends the binary encoding of the log, without its LSN, to b
+	getLSN() uint64           // Returns the log sequence number of the log
+	getTime() time.Time       // Returns when the log was written
+}
+
+// Fields shared by every log struct, assigned when the log is flushed.
+// Logs written before LSNs or timestamps were introduced have an LSN or timestamp of 0.
+type logHeader struct {
+	lsn       uint64 // The log sequence number of the log
+	timestamp int64  // When the log was written, in nanoseconds since the Unix epoch
+}
+
+func (lh logHeader) getLSN() uint64 {
+	return lh.lsn
+}
+
+// getTime returns the time the log was written, or the zero time if it has no timestamp.
+func (lh logHeader) getTime() time.Time {
+	if lh.timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, lh.timestamp)
 }
 
 // Log for creating a table.
 type tableLog struct {
+	logHeader
+	db      string // The id of the database the table was created in, or "" for the primary database
 	tblType string // The type of table created, either "btree" or "hash"
 	tblName string // The name of the table created
 }
 
 func (tl tableLog) toString() string {
-	return fmt.Sprintf("< create %s table %s >\n", tl.tblType, tl.tblName)
+	return string(tl.appendTo(nil)) + "\n"
+}
+
+func (tl tableLog) appendTo(b []byte) []byte {
+	b = append(append(append(b, "< create "...), tl.tblType...), " table "...)
+	return append(appendTableName(b, tl.db, tl.tblName), " >"...)
+}
+
+// Log for dropping a table.
+type dropTableLog struct {
+	logHeader
+	db      string // The id of the database the table was dropped from, or "" for the primary database
+	tblName string // The name of the table dropped
+}
+
+func (dl dropTableLog) toString() string {
+	return string(dl.appendTo(nil)) + "\n"
+}
+
+func (dl dropTableLog) appendTo(b []byte) []byte {
+	return append(appendTableName(append(b, "< drop table "...), dl.db, dl.tblName), " >"...)
 }
 
 // The type of edit action. Either insert, delete, or update.
@@ -55,102 +149,453 @@ const (
 
 // Log for making a change to a database entry within a transaction.
 type editLog struct {
+	logHeader
 	id        uuid.UUID // The id of the transaction this edit was done in
+	db        string    // The id of the database holding the table, or "" for the primary database
 	tablename string    // The name of the table where the edit took place
 	action    action    // The type of edit action taken
 	key       int64     // The key of the tuple that was edited
 	oldval    int64     // The old value before the edit
 	newval    int64     // The new value after the edit
+	prevLSN   uint64    // The LSN of the transaction's previous edit left to undo (or its Start log), or 0 if not logged
 }
 
 func (el editLog) toString() string {
-	return fmt.Sprintf("< %s, %s, %s, %v, %v, %v >\n", el.id.String(), el.tablename, el.action, el.key, el.oldval, el.newval)
+	return string(el.appendTo(nil)) + "\n"
+}
+
+func (el editLog) appendTo(b []byte) []byte {
+	b = el.appendFields(append(b, "< "...))
+	// Edits logged before edits were chained have no previous LSN.
+	if el.prevLSN != 0 {
+		b = strconv.AppendUint(append(b, ", prev "...), el.prevLSN, 10)
+	}
+	return append(b, " >"...)
+}
+
+// appendFields appends the edit's comma-separated fields to b.
+func (el editLog) appendFields(b []byte) []byte {
+	b = appendTableName(append(appendUUIDText(b, el.id), ", "...), el.db, el.tablename)
+	b = append(append(append(b, ", "...), el.action...), ", "...)
+	b = append(strconv.AppendInt(b, el.key, 10), ", "...)
+	b = append(strconv.AppendInt(b, el.oldval, 10), ", "...)
+	return strconv.AppendInt(b, el.newval, 10)
+}
+
+// inverse returns the edit that reverses the effect of this edit.
+func (el editLog) inverse() editLog {
+	inv := editLog{logHeader: el.logHeader, id: el.id, db: el.db, tablename: el.tablename, action: el.action, key: el.key, oldval: el.newval, newval: el.oldval}
+	switch el.action {
+	case INSERT_ACTION:
+		inv.action = DELETE_ACTION
+	case DELETE_ACTION:
+		inv.action = INSERT_ACTION
+	}
+	return inv
+}
+
+// Compensation log record (CLR) for undoing an edit during a rollback.
+// Redoing a CLR reapplies the inverse of the undone edit, but a CLR is never undone.
+type clrLog struct {
+	logHeader
+	edit     editLog // The original edit that was undone
+	undoNext uint64  // The LSN of the transaction's next edit left to undo, or 0 if none
+}
+
+func (cl clrLog) toString() string {
+	return string(cl.appendTo(nil)) + "\n"
+}
+
+func (cl clrLog) appendTo(b []byte) []byte {
+	b = append(cl.edit.appendFields(append(b, "< clr "...)), ", undoNext "...)
+	return append(strconv.AppendUint(b, cl.undoNext, 10), " >"...)
 }
 
 // Log for starting a transaction.
 type startLog struct {
+	logHeader
 	id uuid.UUID // The id of the transaction
 }
 
 func (sl startLog) toString() string {
-	return fmt.Sprintf("< %s start >\n", sl.id.String())
+	return string(sl.appendTo(nil)) + "\n"
+}
+
+func (sl startLog) appendTo(b []byte) []byte {
+	return append(appendUUIDText(append(b, "< "...), sl.id), " start >"...)
 }
 
 // Log for committing a transaction.
 type commitLog struct {
+	logHeader
 	id uuid.UUID // The id of the transaction
 }
 
 func (cl commitLog) toString() string {
-	return fmt.Sprintf("< %s commit >\n", cl.id.String())
+	return string(cl.appendTo(nil)) + "\n"
+}
+
+func (cl commitLog) appendTo(b []byte) []byte {
+	return append(appendUUIDText(append(b, "< "...), cl.id), " commit >"...)
+}
+
+// Log for aborting a transaction, written once all of its edits have been undone.
+type abortLog struct {
+	logHeader
+	id uuid.UUID // The id of the transaction
+}
+
+func (al abortLog) toString() string {
+	return string(al.appendTo(nil)) + "\n"
+}
+
+func (al abortLog) appendTo(b []byte) []byte {
+	return append(appendUUIDText(append(b, "< "...), al.id), " abort >"...)
+}
+
+// Log for handing a running transaction over to a new client id.
+type reclaimLog struct {
+	logHeader
+	id uuid.UUID // The id the transaction ran under
+	to uuid.UUID // The id the transaction runs under from now on
+}
+
+func (rl reclaimLog) toString() string {
+	return string(rl.appendTo(nil)) + "\n"
+}
+
+func (rl reclaimLog) appendTo(b []byte) []byte {
+	b = appendUUIDText(append(b, "< "...), rl.id)
+	return append(appendUUIDText(append(b, " reclaimed by "...), rl.to), " >"...)
 }
 
 // Log for making a checkpoint.
 type checkpointLog struct {
+	logHeader
 	ids []uuid.UUID // The currently running transactions.
 }
 
 func (cl checkpointLog) toString() string {
-	idStrings := make([]string, 0)
-	for _, id := range cl.ids {
-		idStrings = append(idStrings, id.String())
+	return string(cl.appendTo(nil)) + "\n"
+}
+
+func (cl checkpointLog) appendTo(b []byte) []byte {
+	return append(appendUUIDs(append(b, "< "...), cl.ids), "checkpoint >"...)
+}
+
+// Log for beginning a fuzzy checkpoint, which is only complete once its end log is written.
+type beginCheckpointLog struct {
+	logHeader
+	ids []uuid.UUID // The transactions running when the checkpoint began.
+}
+
+func (bl beginCheckpointLog) toString() string {
+	return string(bl.appendTo(nil)) + "\n"
+}
+
+func (bl beginCheckpointLog) appendTo(b []byte) []byte {
+	return append(appendUUIDs(append(b, "< "...), bl.ids), "begin checkpoint >"...)
+}
+
+// Log for ending a fuzzy checkpoint, once its backup has been taken.
+type endCheckpointLog struct {
+	logHeader
+	begin uint64 // The LSN of the log that began the checkpoint
+}
+
+func (el endCheckpointLog) toString() string {
+	return string(el.appendTo(nil)) + "\n"
+}
+
+func (el endCheckpointLog) appendTo(b []byte) []byte {
+	return append(strconv.AppendUint(append(b, "< end checkpoint "...), el.begin, 10), " >"...)
+}
+
+// Log for a checkpoint of only some of the tables, once their backup has been taken.
+type tableCheckpointLog struct {
+	logHeader
+	tables []string // The names of the tables checkpointed
+}
+
+func (tl tableCheckpointLog) toString() string {
+	return string(tl.appendTo(nil)) + "\n"
+}
+
+func (tl tableCheckpointLog) appendTo(b []byte) []byte {
+	return append(append(append(b, "< checkpoint tables "...), strings.Join(tl.tables, ", ")...), " >"...)
+}
+
+// Log heading a fresh log file after the previous one was rotated into a segment.
+type segmentLog struct {
+	logHeader
+	prev uint64 // The number of the segment holding the logs before this one
+}
+
+func (sl segmentLog) toString() string {
+	return string(sl.appendTo(nil)) + "\n"
+}
+
+func (sl segmentLog) appendTo(b []byte) []byte {
+	return append(strconv.AppendUint(append(b, "< segment "...), sl.prev, 10), " >"...)
+}
+
+// Log heading a new log file, recording the version of the log format it was written in.
+type versionLog struct {
+	logHeader
+	version  uint32 // The version of the log format
+	features uint32 // The optional features the log uses, which a reader must support
+}
+
+func (vl versionLog) toString() string {
+	return string(vl.appendTo(nil)) + "\n"
+}
+
+func (vl versionLog) appendTo(b []byte) []byte {
+	b = strconv.AppendUint(append(b, "< version "...), uint64(vl.version), 10)
+	return append(strconv.AppendUint(append(b, " features "...), uint64(vl.features), 10), " >"...)
+}
+
+// appendTableName appends the name of a table to b, qualified by the id of its database
+// unless it is the primary database.
+func appendTableName(b []byte, db string, name string) []byte {
+	if db != "" {
+		b = append(append(b, db...), '.')
 	}
-	if len(idStrings) == 0 {
-		return "< checkpoint >\n"
+	return append(b, name...)
+}
+
+// appendUUIDText appends the canonical string of a uuid to b, like id.String().
+func appendUUIDText(b []byte, id uuid.UUID) []byte {
+	b = append(hex.AppendEncode(b, id[:4]), '-')
+	b = append(hex.AppendEncode(b, id[4:6]), '-')
+	b = append(hex.AppendEncode(b, id[6:8]), '-')
+	b = append(hex.AppendEncode(b, id[8:10]), '-')
+	return hex.AppendEncode(b, id[10:])
+}
+
+// appendUUIDs appends the uuids to b, separated by commas and followed by a space if any.
+func appendUUIDs(b []byte, ids []uuid.UUID) []byte {
+	for i, id := range ids {
+		if i > 0 {
+			b = append(b, ", "...)
+		}
+		b = appendUUIDText(b, id)
 	}
-	return fmt.Sprintf("< %s checkpoint >\n", strings.Join(idStrings, ", "))
+	if len(ids) > 0 {
+		b = append(b, ' ')
+	}
+	return b
 }
 
 // Regex pattern for a uuid
 const uuidPattern = "[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}"
 
-var tableExp = regexp.MustCompile("< create (?P<tblType>\\w+) table (?P<tblName>\\w+) >")
+// Regex pattern for a table name, qualified by its database's id unless in the primary database
+const tableNamePattern = "(?:(?P<db>\\w+)\\.)?(?P<table>\\w+)"
+
+var tableExp = regexp.MustCompile(fmt.Sprintf("< create (?P<tblType>\\w+) table %s >", tableNamePattern))
 
-var editExp = regexp.MustCompile(fmt.Sprintf("< (?P<uuid>%s), (?P<table>\\w+), (?P<action>UPDATE|INSERT|DELETE), (?P<key>\\d+), (?P<oldval>\\d+), (?P<newval>\\d+) >", uuidPattern))
+var dropTableExp = regexp.MustCompile(fmt.Sprintf("< drop table %s >", tableNamePattern))
+var editExp = regexp.MustCompile(fmt.Sprintf("< (?P<uuid>%s), %s, (?P<action>UPDATE|INSERT|DELETE), (?P<key>\\d+), (?P<oldval>\\d+), (?P<newval>\\d+)(?:, prev (?P<prev>\\d+))? >", uuidPattern, tableNamePattern))
+var clrExp = regexp.MustCompile(fmt.Sprintf("< clr (?P<uuid>%s), %s, (?P<action>UPDATE|INSERT|DELETE), (?P<key>\\d+), (?P<oldval>\\d+), (?P<newval>\\d+), undoNext (?P<undoNext>\\d+) >", uuidPattern, tableNamePattern))
 var startExp = regexp.MustCompile(fmt.Sprintf("< (%s) start >", uuidPattern))
 var commitExp = regexp.MustCompile(fmt.Sprintf("< (%s) commit >", uuidPattern))
+var abortExp = regexp.MustCompile(fmt.Sprintf("< (%s) abort >", uuidPattern))
+var reclaimExp = regexp.MustCompile(fmt.Sprintf("< (?P<uuid>%s) reclaimed by (?P<to>%s) >", uuidPattern, uuidPattern))
 var checkpointExp = regexp.MustCompile(fmt.Sprintf("< (%s,?\\s)*checkpoint >", uuidPattern))
+var beginCheckpointExp = regexp.MustCompile(fmt.Sprintf("< (%s,?\\s)*begin checkpoint >", uuidPattern))
+var endCheckpointExp = regexp.MustCompile("< end checkpoint (?P<begin>\\d+) >")
+var tableCheckpointExp = regexp.MustCompile("< checkpoint tables (?P<tables>\\w+(?:, \\w+)*) >")
+var segmentExp = regexp.MustCompile("< segment (?P<prev>\\d+) >")
+
+// Later versions may add fields to the version log, so only its leading fields are matched.
+var versionExp = regexp.MustCompile("< version (?P<version>\\d+) features (?P<features>\\d+)[^>]*>")
 var uuidExp = regexp.MustCompile(uuidPattern)
+var sealedExp = regexp.MustCompile("\\{ (?P<payload>[A-Za-z0-9+/=]+) \\}$")
+var headerExp = regexp.MustCompile("^(?P<lsn>\\d+) (?:@(?P<timestamp>\\d+) )?[<{]")
+var checksumExp = regexp.MustCompile(" #(?P<checksum>\\S*)$")
+
+// ErrCorruptLog matches every error for a log file holding a log that can't be read, whether
+// the log could not be parsed, failed its checksum, or is out of order.
+var ErrCorruptLog = errors.New("corrupt log")
+
+// CorruptLogError is returned when reading a log from the log file that
+// could not be parsed or failed its checksum. It matches ErrCorruptLog.
+type CorruptLogError struct {
+	File   string // The name of the log file or segment holding the corrupt log
+	Offset int64  // The byte offset of the corrupt log in the log file
+	Line   string // The (possibly partial) contents of the corrupt log
+	Err    error  // The reason the log is corrupt
+}
+
+func (e *CorruptLogError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("corrupt log in %s at byte offset %d (%v): %q", e.File, e.Offset, e.Err, e.Line)
+	}
+	return fmt.Sprintf("corrupt log at byte offset %d (%v): %q", e.Offset, e.Err, e.Line)
+}
+
+func (e *CorruptLogError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CorruptLogError) Is(target error) bool {
+	return target == ErrCorruptLog
+}
+
+// checksumSuffix returns the checksum suffix to append to the textual representation of a log.
+func checksumSuffix(s string) string {
+	return fmt.Sprintf(" #%08x", crc32.ChecksumIEEE([]byte(s)))
+}
+
+// appendChecksum appends the checksum suffix, like checksumSuffix, of the record at the end of
+// b, starting at the given index.
+func appendChecksum(b []byte, start int) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(b[start:]))
+	return hex.AppendEncode(append(b, " #"...), sum[:])
+}
+
+// verifyChecksum strips the checksum suffix from the textual representation of a log,
+// returning an error if the checksum doesn't match. Logs without a checksum are returned as is.
+func verifyChecksum(s string) (string, error) {
+	loc := checksumExp.FindStringIndex(s)
+	if loc == nil {
+		return s, nil
+	}
+	payload := s[:loc[0]]
+	if checksumSuffix(payload) != s[loc[0]:] {
+		return "", errors.New("log failed its checksum")
+	}
+	return payload, nil
+}
+
+// headerFromString returns the LSN and timestamp prefixing the textual representation
+// of a log, leaving either 0 if the log doesn't have it.
+func headerFromString(s string) logHeader {
+	expStrs := headerExp.FindStringSubmatch(s)
+	if expStrs == nil {
+		return logHeader{}
+	}
+	lsn, _ := strconv.ParseUint(expStrs[1], 10, 64)
+	timestamp, _ := strconv.ParseInt(expStrs[2], 10, 64)
+	return logHeader{lsn: lsn, timestamp: timestamp}
+}
 
 // Convert the textual representation of a log to its respective struct.
-// Returns an error if the string could not be parsed into a log.
+// Returns an error if the string could not be parsed into a log or failed its checksum.
 func logFromString(s string) (log, error) {
+	s, err := verifyChecksum(s)
+	if err != nil {
+		return nil, err
+	}
+	if expStrs := sealedExp.FindStringSubmatch(strings.TrimSpace(s)); expStrs != nil {
+		payload, err := base64.StdEncoding.DecodeString(expStrs[1])
+		if err != nil {
+			return nil, err
+		}
+		return sealedLog{logHeader: headerFromString(s), payload: payload}, nil
+	}
+	if !strings.HasSuffix(strings.TrimSpace(s), ">") {
+		return nil, errors.New("could not parse log")
+	}
+	header := headerFromString(s)
 	switch {
 	case tableExp.MatchString(s):
 		expStrs := tableExp.FindStringSubmatch(s)
 		tblType := expStrs[1]
-		tblName := expStrs[2]
+		tblName := expStrs[3]
 		return tableLog{
-			tblType: tblType,
-			tblName: tblName,
+			logHeader: header,
+			db:        expStrs[2],
+			tblType:   tblType,
+			tblName:   tblName,
+		}, nil
+	case dropTableExp.MatchString(s):
+		expStrs := dropTableExp.FindStringSubmatch(s)
+		return dropTableLog{
+			logHeader: header,
+			db:        expStrs[1],
+			tblName:   expStrs[2],
 		}, nil
 	case editExp.MatchString(s):
 		expStrs := editExp.FindStringSubmatch(s)
 		uuid := uuid.MustParse(expStrs[1])
-		key, _ := strconv.Atoi(expStrs[4])
-		oldval, _ := strconv.Atoi(expStrs[5])
-		newval, _ := strconv.Atoi(expStrs[6])
+		key, _ := strconv.Atoi(expStrs[5])
+		oldval, _ := strconv.Atoi(expStrs[6])
+		newval, _ := strconv.Atoi(expStrs[7])
+		prevLSN, _ := strconv.ParseUint(expStrs[8], 10, 64)
 		return editLog{
+			logHeader: header,
 			id:        uuid,
-			tablename: expStrs[2],
-			action:    action(expStrs[3]),
+			db:        expStrs[2],
+			tablename: expStrs[3],
+			action:    action(expStrs[4]),
 			key:       int64(key),
 			oldval:    int64(oldval),
 			newval:    int64(newval),
+			prevLSN:   prevLSN,
+		}, nil
+	case clrExp.MatchString(s):
+		expStrs := clrExp.FindStringSubmatch(s)
+		uuid := uuid.MustParse(expStrs[1])
+		key, _ := strconv.Atoi(expStrs[5])
+		oldval, _ := strconv.Atoi(expStrs[6])
+		newval, _ := strconv.Atoi(expStrs[7])
+		undoNext, _ := strconv.ParseUint(expStrs[8], 10, 64)
+		return clrLog{
+			logHeader: header,
+			edit: editLog{
+				id:        uuid,
+				db:        expStrs[2],
+				tablename: expStrs[3],
+				action:    action(expStrs[4]),
+				key:       int64(key),
+				oldval:    int64(oldval),
+				newval:    int64(newval),
+			},
+			undoNext: undoNext,
 		}, nil
 	case startExp.MatchString(s):
 		uuid := uuid.MustParse(uuidExp.FindString(s))
-		return startLog{id: uuid}, nil
+		return startLog{logHeader: header, id: uuid}, nil
 	case commitExp.MatchString(s):
 		uuid := uuid.MustParse(uuidExp.FindString(s))
-		return commitLog{id: uuid}, nil
+		return commitLog{logHeader: header, id: uuid}, nil
+	case abortExp.MatchString(s):
+		uuid := uuid.MustParse(uuidExp.FindString(s))
+		return abortLog{logHeader: header, id: uuid}, nil
+	case reclaimExp.MatchString(s):
+		expStrs := reclaimExp.FindStringSubmatch(s)
+		return reclaimLog{logHeader: header, id: uuid.MustParse(expStrs[1]), to: uuid.MustParse(expStrs[2])}, nil
+	case beginCheckpointExp.MatchString(s):
+		uuidStrs := uuidExp.FindAllString(s, -1)
+		uuids := make([]uuid.UUID, 0)
+		for _, uuidStr := range uuidStrs {
+			uuids = append(uuids, uuid.MustParse(uuidStr))
+		}
+		return beginCheckpointLog{logHeader: header, ids: uuids}, nil
+	case endCheckpointExp.MatchString(s):
+		begin, _ := strconv.ParseUint(endCheckpointExp.FindStringSubmatch(s)[1], 10, 64)
+		return endCheckpointLog{logHeader: header, begin: begin}, nil
+	case tableCheckpointExp.MatchString(s):
+		tables := strings.Split(tableCheckpointExp.FindStringSubmatch(s)[1], ", ")
+		return tableCheckpointLog{logHeader: header, tables: tables}, nil
 	case checkpointExp.MatchString(s):
 		uuidStrs := uuidExp.FindAllString(s, -1)
 		uuids := make([]uuid.UUID, 0)
 		for _, uuidStr := range uuidStrs {
 			uuids = append(uuids, uuid.MustParse(uuidStr))
 		}
-		return checkpointLog{ids: uuids}, nil
+		return checkpointLog{logHeader: header, ids: uuids}, nil
+	case segmentExp.MatchString(s):
+		prev, _ := strconv.ParseUint(segmentExp.FindStringSubmatch(s)[1], 10, 64)
+		return segmentLog{logHeader: header, prev: prev}, nil
+	case versionExp.MatchString(s):
+		expStrs := versionExp.FindStringSubmatch(s)
+		version, _ := strconv.ParseUint(expStrs[1], 10, 32)
+		features, _ := strconv.ParseUint(expStrs[2], 10, 32)
+		return versionLog{logHeader: header, version: uint32(version), features: uint32(features)}, nil
 	default:
 		return nil, errors.New("could not parse log")
 	}
@@ -0,0 +1,154 @@
+package recovery
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+/*
+   MigrateLog upgrades a log written by an older version to the current version of the log format,
+   which is how a log rejected with ErrUnsupportedLogVersion is brought forward. Every log is read
+   in the old version and written back in the current one, in order, with its LSN, timestamp, and
+   content unchanged, under a fresh version log. The log can be switched between the string and
+   binary formats along the way.
+
+   The migrated log is written to a temporary file next to the new log file, read back and compared
+   log by log against the old log, and only then renamed into place, so a failed migration never
+   leaves a partial log behind. The old log is only read.
+*/
+
+// MigrateLog rewrites the log in the specified old log file and its segments into a single new
+// log file in the current version of the given format, replacing any file at newPath.
+// Returns an error without writing the new log file if the old log is corrupt or the migrated
+// log doesn't read back the same. An encrypted log needs the WithEncryptionKey option, and stays
+// encrypted with the same key; options that don't affect reading logs are ignored.
+func MigrateLog(oldPath string, newPath string, format LogFormat, opts ...Option) error {
+	if filepath.Clean(oldPath) == filepath.Clean(newPath) {
+		return errors.New("cannot migrate a log file in place")
+	}
+	rm := &RecoveryManager{logFilename: oldPath, logFileMode: 0666, format: format, checksums: true}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	if rm.encryptionKey != nil {
+		aead, err := newAEAD(rm.encryptionKey)
+		if err != nil {
+			return err
+		}
+		rm.aead = aead
+	}
+	logs, err := readLogFile(oldPath, rm.aead)
+	if err != nil {
+		return fmt.Errorf("error reading the old log: %w", err)
+	}
+
+	tmpName := newPath + ".migrate"
+	err = rm.writeMigrated(tmpName, logs)
+	if err == nil {
+		err = checkMigrated(tmpName, logs, rm.aead)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, newPath)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("error migrating the log: %w", err)
+	}
+	return nil
+}
+
+// readLogFile returns every log in the specified log file and its segments, decrypting sealed
+// logs with the given cipher.
+func readLogFile(logFilename string, aead cipher.AEAD) ([]log, error) {
+	info, err := os.Stat(logFilename)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := openLogCursor(logFilename, StringLogFormat, aead, info.Size(), 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+	var logs []log
+	for {
+		l, err := cursor.Next()
+		if err == io.EOF {
+			return logs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		logs = append(logs, l)
+	}
+}
+
+// writeMigrated writes the logs to the named file in the recovery manager's format, headed by a
+// version log, and syncs it.
+func (rm *RecoveryManager) writeMigrated(name string, logs []log) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, rm.logFileMode)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	features := rm.features()
+	for _, l := range logs {
+		if isQualified(l) {
+			features |= featureDatabases
+		}
+	}
+	vl := versionLog{version: logVersion, features: features}
+	// The version log takes the timestamp of the first log, so timestamps never decrease.
+	if len(logs) > 0 {
+		vl.timestamp = timestampOf(logs[0])
+	}
+	writer.Write(appendRecord(nil, vl.logHeader, vl, rm.format, rm.checksums))
+	for _, l := range logs {
+		writer.Write(rm.serialize(nil, logHeader{lsn: l.getLSN(), timestamp: timestampOf(l)}, l))
+	}
+	err = writer.Flush()
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// checkMigrated returns an error unless the named file holds exactly the specified logs.
+func checkMigrated(name string, logs []log, aead cipher.AEAD) error {
+	migrated, err := readLogFile(name, aead)
+	if err != nil {
+		return err
+	}
+	if len(migrated) != len(logs) {
+		return fmt.Errorf("migrated log has %d logs, expected %d", len(migrated), len(logs))
+	}
+	for i, l := range logs {
+		m := migrated[i]
+		if m.getLSN() != l.getLSN() || timestampOf(m) != timestampOf(l) || !bytes.Equal(m.appendTo(nil), l.appendTo(nil)) {
+			return fmt.Errorf("migrated log %q differs from %q", m.toString(), l.toString())
+		}
+	}
+	return nil
+}
+
+// isQualified returns whether the log names a table of a database other than the primary one.
+func isQualified(l log) bool {
+	switch log := l.(type) {
+	case tableLog:
+		return log.db != ""
+	case dropTableLog:
+		return log.db != ""
+	case editLog:
+		return log.db != ""
+	case clrLog:
+		return log.edit.db != ""
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package recovery
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"dinodb/pkg/database"
+
+	"github.com/google/uuid"
+)
+
+/*
+   Every page of a table carries the LSN of the last logged edit that changed it; see the pager.
+   Redo compares an edit's LSN against the LSN of the page that holds its key: if the page's
+   LSN is at least as high, the page, and so the key, already reflects the edit, which is
+   skipped. Otherwise the edit is applied as logged, so redoing a log twice, or redoing edits
+   a fuzzy checkpoint's backup already reflects, leaves the database as it was.
+
+   For that to hold, a page's LSN must never cover an edit that the page doesn't reflect. The
+   pages an edit changes are stamped with its LSN through the table's pager, so the edits of a
+   table are logged and applied one at a time, in LSN order, under the table's apply mutex.
+   The write lock on the edited key is taken before the mutex, so that an edit never holds up
+   the table's other edits while waiting for a lock. Edits of different tables run in parallel.
+
+   Edit only logs an edit, which its caller applies afterwards without the mutex, so an edit
+   logged through Edit may reach its page after a later edit of another key stamped the page.
+   Should a checkpoint flush the page in between, its LSN covers an edit it doesn't reflect. The
+   edit's transaction is then still running at the checkpoint, so recovery redoes the edits of
+   the transactions running at a full or table checkpoint whatever the LSNs of their pages; see
+   redoEdit and checkpoint_tables.go.
+
+   Checkpoints, compaction and backups flush tables that are being edited, so they take every
+   table's apply mutex, in order, before rm.mtx: while they are held, every edit logged so far
+   through the recovery REPL has been applied, and no edit is logged until the tables have been
+   flushed.
+*/
+
+// tableMutex returns the mutex under which the edits of the named table of the specified
+// database are logged and applied.
+func (rm *RecoveryManager) tableMutex(dbID string, tableName string) *sync.Mutex {
+	mtx, _ := rm.applyMtxs.LoadOrStore(qualify(dbID, tableName), &sync.Mutex{})
+	return mtx.(*sync.Mutex)
+}
+
+// lockAllTables locks every table's mutex, in order, and then rm.mtx, so that every edit logged
+// so far under a table's mutex has been applied and no other edit can be logged until the
+// returned function is called. Should a table be created in between, its mutex is locked by
+// trying again.
+func (rm *RecoveryManager) lockAllTables() (unlock func()) {
+	for {
+		names := rm.qualifiedTableNames()
+		mtxs := make([]*sync.Mutex, 0, len(names))
+		for _, name := range names {
+			mtx := rm.tableMutex("", name)
+			mtx.Lock()
+			mtxs = append(mtxs, mtx)
+		}
+		unlockTables := func() {
+			for _, mtx := range mtxs {
+				mtx.Unlock()
+			}
+		}
+		rm.mtx.Lock()
+		if slices.Equal(names, rm.qualifiedTableNames()) {
+			return func() {
+				rm.mtx.Unlock()
+				unlockTables()
+			}
+		}
+		rm.mtx.Unlock()
+		unlockTables()
+	}
+}
+
+// qualifiedTableNames returns the names of every table of every database, qualified by their
+// database's id, in order.
+func (rm *RecoveryManager) qualifiedTableNames() []string {
+	names := make([]string, 0)
+	for _, dbID := range rm.databaseIDs() {
+		db, _ := rm.database(dbID)
+		for name := range db.GetTables() {
+			names = append(names, qualify(dbID, name))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// applyAt applies an edit of the table with apply, stamping every page it changes with the
+// given LSN. The table's mutex should be held on entry.
+func applyAt(table database.Index, lsn uint64, apply func() error) error {
+	table.GetPager().SetWriteLSN(lsn)
+	defer table.GetPager().SetWriteLSN(0)
+	return apply()
+}
+
+// editAndApply logs an edit of a table for a client like Edit, and then applies it with apply,
+// stamping the pages it changes with the edit's LSN. The client should already hold a write
+// lock on the key. If applying the edit fails, the edit is compensated to mark it as a no-op
+// and the client's transaction is rolled back.
+func (rm *RecoveryManager) editAndApply(clientId uuid.UUID, table database.Index, action action,
+	key int64, oldval int64, newval int64, apply func() error) error {
+	rm.quiesceMtx.RLock()
+	mtx := rm.tableMutex(rm.dbIDOf(table), table.GetName())
+	mtx.Lock()
+	lsn, err := rm.edit(clientId, table, action, key, oldval, newval)
+	if err != nil {
+		mtx.Unlock()
+		rm.quiesceMtx.RUnlock()
+		return err
+	}
+	err = applyAt(table, lsn, apply)
+	mtx.Unlock()
+	rm.quiesceMtx.RUnlock()
+	if err != nil {
+		// Pop the edit off of the transaction stack, so that rolling back doesn't undo it.
+		if cerr := rm.compensateLast(clientId); cerr != nil {
+			return fmt.Errorf("error marking %s as no-op: %w", strings.ToLower(string(action)), cerr)
+		}
+		if rberr := rm.Rollback(clientId); rberr != nil {
+			return rberr
+		}
+	}
+	return err
+}
@@ -0,0 +1,181 @@
+package recovery
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+   Recovery reads the relevant logs twice: backward from the end of the log to find the
+   checkpoint and the start of every transaction running at it, and then forward from there to
+   parse them in order. The log is read through a prefetch in between, which keeps every block
+   of each file that is read in memory, so that the forward pass is served from memory and the
+   relevant bytes are only read from disk once. While a block is being scanned, the block before
+   it is read in the background, since the backward scan needs it next. A compressed segment is
+   likewise only decompressed once. WithLogPrefetch turns the prefetch off, reading the log
+   directly both times.
+*/
+
+// The number of bytes of a log file read at once by a prefetch.
+const prefetchBlockSize = 1 << 20
+
+// WithLogPrefetch sets whether recovery reads the relevant logs from disk once, keeping them in
+// memory between its backward and forward passes; see prefetch.go. Defaults to true.
+func WithLogPrefetch(enabled bool) Option {
+	return func(rm *RecoveryManager) {
+		rm.prefetch = enabled
+	}
+}
+
+// logPrefetch holds the blocks read so far of every file read through it, by name.
+// It isn't safe for concurrent use, though its files are.
+type logPrefetch struct {
+	files map[string]*prefetchedFile
+}
+
+func newLogPrefetch() *logPrefetch {
+	return &logPrefetch{files: make(map[string]*prefetchedFile)}
+}
+
+// wrap returns the named file, which is already open, read through the prefetch. The prefetch
+// closes the file once it is closed if owned is set.
+func (p *logPrefetch) wrap(name string, file LogStorage, owned bool) (LogStorage, error) {
+	if f, ok := p.files[name]; ok {
+		return f, nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	f := &prefetchedFile{file: file, info: info, owned: owned, blocks: make(map[int64]*prefetchBlock)}
+	p.files[name] = f
+	return f, nil
+}
+
+// open returns the named log file or segment read through the prefetch, opening it unless it
+// already was.
+func (p *logPrefetch) open(name string) (LogStorage, error) {
+	if f, ok := p.files[name]; ok {
+		return f, nil
+	}
+	file, err := openSegment(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := p.wrap(name, file, true)
+	if err != nil {
+		file.Close()
+	}
+	return f, err
+}
+
+// close waits for the reads in the background to finish, and closes the files the prefetch opened.
+func (p *logPrefetch) close() {
+	for _, f := range p.files {
+		f.reads.Wait()
+		if f.owned {
+			f.file.Close()
+		}
+	}
+}
+
+// prefetchedFile is a read-only LogStorage serving reads of a file from the blocks read of it
+// so far, reading any other block first. Its size is fixed when it is wrapped.
+type prefetchedFile struct {
+	file   LogStorage  // The file read
+	info   os.FileInfo // The file's information, as of when it was wrapped
+	owned  bool        // Whether the prefetch closes the file
+	blocks map[int64]*prefetchBlock
+	reads  sync.WaitGroup // Tracks the blocks being read
+	mtx    sync.Mutex
+}
+
+// prefetchBlock is a block of a file, which is only available once done is closed.
+type prefetchBlock struct {
+	data []byte
+	err  error
+	done chan struct{}
+}
+
+// block returns the block with the given index, starting to read it unless it already was.
+func (f *prefetchedFile) block(i int64) *prefetchBlock {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	b, ok := f.blocks[i]
+	if !ok {
+		b = &prefetchBlock{done: make(chan struct{})}
+		f.blocks[i] = b
+		f.reads.Add(1)
+		go f.load(i, b)
+	}
+	return b
+}
+
+// load reads the block with the given index.
+func (f *prefetchedFile) load(i int64, b *prefetchBlock) {
+	defer f.reads.Done()
+	defer close(b.done)
+	off := i * prefetchBlockSize
+	data := make([]byte, min(prefetchBlockSize, f.info.Size()-off))
+	n, err := f.file.ReadAt(data, off)
+	if err == io.EOF && n == len(data) {
+		err = nil
+	}
+	b.data, b.err = data[:n], err
+}
+
+// ReadAt reads len(p) bytes of the file starting at the specified offset, returning io.EOF
+// if fewer bytes are left.
+func (f *prefetchedFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	n := 0
+	for n < len(p) && off+int64(n) < f.info.Size() {
+		i := (off + int64(n)) / prefetchBlockSize
+		b := f.block(i)
+		// The backward scan reads the block before this one next.
+		if i > 0 {
+			f.block(i - 1)
+		}
+		<-b.done
+		if b.err != nil {
+			return n, b.err
+		}
+		start := off + int64(n) - i*prefetchBlockSize
+		if start >= int64(len(b.data)) {
+			break
+		}
+		n += copy(p[n:], b.data[start:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Stat returns the file's information as of when it was wrapped.
+func (f *prefetchedFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// Close does nothing, since the prefetch closes the file.
+func (f *prefetchedFile) Close() error {
+	return nil
+}
+
+var errPrefetchReadOnly = errors.New("prefetched log is read-only")
+
+func (f *prefetchedFile) Write(p []byte) (int, error) {
+	return 0, errPrefetchReadOnly
+}
+
+func (f *prefetchedFile) Sync() error {
+	return errPrefetchReadOnly
+}
+
+func (f *prefetchedFile) Truncate(size int64) error {
+	return errPrefetchReadOnly
+}
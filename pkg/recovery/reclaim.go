@@ -0,0 +1,42 @@
+package recovery
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A client whose connection drops can reconnect under a new client id and resume its running
+   transaction with ReclaimTransaction, which hands the transaction's locks and undo stack over to
+   the new id. The transaction's logs are written under the id it ran under at the time, so the
+   handover is logged with a RECLAIM log, after which its logs are under the new id. Recovery and
+   everything else that follows transactions through the log carries a transaction's logs under
+   the old id over to the new one at the RECLAIM log: the new id's COMMIT or ABORT log ends them
+   all, and edits logged under the old id are undone under the new one.
+*/
+
+// ReclaimTransaction hands the transaction running for oldClientId, with its locks and the
+// edits it has yet to undo, over to newClientId, which then commits or rolls it back. The old
+// client must not be logging anything meanwhile. Returns an error if no transaction is running
+// for oldClientId, or if one is already running for newClientId.
+func (rm *RecoveryManager) ReclaimTransaction(oldClientId uuid.UUID, newClientId uuid.UUID) error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if _, started := rm.stacks.get(oldClientId); !started {
+		return fmt.Errorf("error writing a Reclaim log: %w", ErrNoTransaction)
+	}
+	if err := rm.tm.ReclaimTransaction(oldClientId, newClientId); err != nil {
+		return err
+	}
+	if _, err := rm.flushLog(reclaimLog{id: oldClientId, to: newClientId}); err != nil {
+		// The transaction is still the old client's as far as the log is concerned.
+		rm.tm.ReclaimTransaction(newClientId, oldClientId)
+		return fmt.Errorf("error writing a Reclaim log: %w", err)
+	}
+	rm.stacks.rename(oldClientId, newClientId)
+	return nil
+}
@@ -1,101 +1,572 @@
 package recovery
 
 import (
-	"bytes"
+	"bufio"
+	"context"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"dinodb/pkg/concurrency"
-	"dinodb/pkg/config"
 	"dinodb/pkg/database"
+	"dinodb/pkg/hash"
 
 	"github.com/icza/backscanner"
-	"github.com/otiai10/copy"
 
 	"github.com/google/uuid"
 )
 
+// ErrClosed is returned when logging to or recovering with a closed recovery manager.
+var ErrClosed = errors.New("recovery manager is closed")
+
+// ErrNoCheckpoint is returned when recovering to a time that no checkpoint in the log precedes.
+var ErrNoCheckpoint = errors.New("no checkpoint")
+
+// RecoveryError is returned when a pass of recovery fails to process a log, wrapping
+// the reason it failed.
+type RecoveryError struct {
+	Pass string // The recovery pass that failed, "redo" or "undo"
+	LSN  uint64 // The LSN of the log being processed, or 0 if it has none
+	Log  string // The contents of the log being processed
+	Err  error  // The reason the pass failed
+}
+
+func (e *RecoveryError) Error() string {
+	return fmt.Sprintf("error during %s of log %q: %v", e.Pass, e.Log, e.Err)
+}
+
+func (e *RecoveryError) Unwrap() error {
+	return e.Err
+}
+
+// newRecoveryError returns a RecoveryError for the given pass failing to process the log.
+func newRecoveryError(pass string, l log, err error) *RecoveryError {
+	return &RecoveryError{Pass: pass, LSN: l.getLSN(), Log: strings.TrimSpace(l.toString()), Err: err}
+}
+
+// ErrStaleBackup is returned when a checkpoint was logged but its backup couldn't be taken,
+// leaving the previous backup in place until a later checkpoint succeeds.
+var ErrStaleBackup = errors.New("checkpoint was logged, but the backup is stale")
+
+// ErrNoTransaction is returned when a client logs an edit without having started a transaction,
+// so that every edit in the log is framed by its transaction's Start log.
+var ErrNoTransaction = errors.New("client has not started a transaction")
+
 // RecoveryManager is the construct that manages the write-ahead log for a database.
 // It is therefore responsible for recovery from crashes and rolling back uncommitted transactions.
 type RecoveryManager struct {
-	db *database.Database              // The underlying database that this recovery manager is for.
-	tm *concurrency.TransactionManager // The transaction manager used for this database.
+	db  *database.Database              // The underlying database that this recovery manager is for.
+	tm  *concurrency.TransactionManager // The transaction manager used for this database.
+	dbs map[string]*database.Database   // Other databases sharing the log, by id; see databases.go.
 
 	// Keeps track of the operations of all uncommitted transactions.
-	// Maps each client/transaction id to a stack of logs.
-	txStack map[uuid.UUID][]editLog
+	// Maps each client/transaction id to a stack of logs; see stacks.go.
+	stacks *txStacks
+
+	logFilename      string        // The name of the log file, which names its segments too.
+	logFile          LogStorage    // Where the write-ahead log is stored, the log file unless storage is set.
+	storage          LogStorage    // The storage set with WithLogStorage, or nil to use the log file.
+	writer           *bufio.Writer // Buffers logs until they are written to the log file.
+	logFileMode      os.FileMode   // The permission bits used when creating the log file.
+	logSize          int64         // The size of the log file, including buffered logs.
+	maxLogSize       int64         // The size past which the log file is rotated, or 0 to never rotate.
+	compressSegments bool          // Whether segments are compressed once rotated; see compress.go.
+	fileMtx          sync.Mutex    // Held while syncing or replacing the log file outside of rm.mtx.
+	lsn              uint64        // The LSN of the most recently flushed log.
+	timestamp        int64         // The timestamp of the most recently flushed log.
+	checksums        bool          // Whether to append a checksum to every flushed log.
+	format           LogFormat     // The encoding used to write logs to the log file.
+	aead             cipher.AEAD   // Seals every log written, or nil to write logs in the clear; see encryption.go.
+	closed           bool          // Whether the log file has been closed.
+	mtx              sync.Mutex    // A mutex used for allowing safe concurrent use of this struct.
+
+	checkpointMtx sync.Mutex // Held throughout a checkpoint, before rm.mtx, so that only one is taken at a time.
+	applyMtxs     sync.Map   // Maps each table's name to the mutex its edits are applied under; see page_lsn.go.
+
+	quiesceMtx sync.RWMutex // Read locked by every edit and undo, and write locked while quiesced; see quiesce.go.
 
-	logFile *os.File   // The log file where the write-ahead log is stored.
-	mtx     sync.Mutex // A mutex used for allowing safe concurrent use of this struct.
+	// Group commit and asynchronous write state; see durability.go.
+	groupCommitInterval time.Duration  // How often logs are synced, or 0 to sync every log.
+	asyncInterval       time.Duration  // How often buffered logs are written, or 0 to not buffer logs.
+	durability          Durability     // Whether the log file is synced at all.
+	syncedLSN           uint64         // The LSN of the most recent log known to be on disk.
+	syncErr             error          // The error of a failed write or sync, after which no log is durable.
+	synced              *sync.Cond     // Signalled on rm.mtx whenever syncedLSN or syncErr changes.
+	stop                chan struct{}  // Closed to stop the background goroutines.
+	background          sync.WaitGroup // Tracks the running background goroutines.
+
+	// Automatic checkpointing state; see autocheckpoint.go.
+	autoCheckpointInterval time.Duration // How often the log's growth is checked, or 0 to never checkpoint automatically.
+	autoCheckpointBytes    int64         // The bytes logged since the last checkpoint past which one is taken, or 0.
+	autoCheckpointLogs     int64         // The logs logged since the last checkpoint past which one is taken, or 0.
+	bytesSinceCheckpoint   int64         // The bytes logged since the last checkpoint was completed.
+	checkpointLSN          uint64        // The LSN of the log completing the last checkpoint, or where logging resumed.
+	autoCheckpointErr      error         // The error of a failed automatic checkpoint, returned by Close.
+
+	backupRetention int          // The number of backup generations to keep at checkpoints; see backup.go.
+	progress        ProgressFunc // Called with the progress of Recover, if set.
+	redoWorkers     int          // The number of tables whose logs are redone at once during recovery.
+	maxReplay       int          // The most logs recovery may redo and undo, or 0 for no limit.
+	redoHook        RedoHook     // Called with each edit recovery applies, if set; see redo_hook.go.
+	prefetch        bool         // Whether recovery reads the log through a prefetch; see prefetch.go.
+	encryptionKey   []byte       // The key rm.aead is made from, if set.
+	backupFolder    string       // Where the database is backed up to, if not next to it; see backup.go.
+	backuper        Backuper     // Takes and restores the database's backups; see backuper.go.
+	clock           Clock        // Where the time is read from; see clock.go.
+
+	// Log shipping state; see subscribe.go.
+	subscribers []io.Writer                   // Where committed records are streamed to.
+	shipPending map[uuid.UUID][]shippedRecord // The records of each running transaction, held back until it commits.
+	shipReady   []shippedRecord               // The records of committed transactions, waiting to become durable.
+}
+
+// Option configures optional settings of a RecoveryManager when constructing it.
+type Option func(*RecoveryManager)
+
+// WithLogFileMode sets the permission bits used if the log file has to be created.
+// Defaults to 0666 (before the umask).
+func WithLogFileMode(mode os.FileMode) Option {
+	return func(rm *RecoveryManager) {
+		rm.logFileMode = mode
+	}
+}
+
+// ProgressFunc reports the progress of a pass of recovery, "redo" or "undo", as the number of
+// logs processed so far out of the total the pass has to process.
+type ProgressFunc func(pass string, processed int, total int)
+
+// RecoveryResult reports what a recovery did, for logging on startup.
+type RecoveryResult struct {
+	RecordsScanned int           // The number of logs read from the log
+	RecordsRedone  int           // The number of edit and compensation logs redone
+	RecordsUndone  int           // The number of edits undone while rolling back unfinished transactions
+	CheckpointLSN  uint64        // The LSN of the checkpoint recovery started from, or 0 if there was none
+	RolledBack     []uuid.UUID   // The transactions that were rolled back, since they never finished
+	Duration       time.Duration // How long recovery took
+}
+
+// progressInterval is how many logs a recovery pass processes between progress reports.
+const progressInterval = 1000
+
+// WithRecoveryProgress sets a function to call periodically during the redo and undo passes
+// of recovery, and once at the end of each. It's called without rm.mtx held.
+func WithRecoveryProgress(fn ProgressFunc) Option {
+	return func(rm *RecoveryManager) {
+		rm.progress = fn
+	}
+}
+
+// WithRedoWorkers sets how many tables' logs the redo pass of recovery redoes at once.
+// Logs of different tables are independent, so each table's logs are redone in order on
+// one of n goroutines. Defaults to GOMAXPROCS; 1 redoes every log serially, as does setting
+// a redo hook; see redo_hook.go.
+func WithRedoWorkers(n int) Option {
+	return func(rm *RecoveryManager) {
+		rm.redoWorkers = n
+	}
+}
+
+// ErrReplayLimit is returned when recovery stops after redoing and undoing the most logs
+// allowed by WithMaxReplayRecords.
+var ErrReplayLimit = errors.New("recovery replayed too many logs")
+
+// WithMaxReplayRecords caps how many logs recovery may redo and undo altogether, as a guard
+// against a log that is unexpectedly huge or loops back on itself. Once the cap is reached,
+// recovery stops and returns ErrReplayLimit, naming the LSN of the last log it replayed.
+// Defaults to 0, which never stops recovery.
+func WithMaxReplayRecords(n int) Option {
+	return func(rm *RecoveryManager) {
+		rm.maxReplay = n
+	}
+}
+
+// replayBudget counts the logs a recovery replays against the limit set by WithMaxReplayRecords.
+// A nil budget never runs out.
+type replayBudget struct {
+	limit    int    // The most logs that may be replayed, or 0 for no limit
+	replayed int    // The number of logs replayed so far
+	lastLSN  uint64 // The LSN of the log replayed last
+	mtx      sync.Mutex
+}
+
+// take counts the log as replayed, or returns ErrReplayLimit, naming the last log replayed,
+// if the limit has been reached.
+func (b *replayBudget) take(l log) error {
+	if b == nil {
+		return nil
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.limit > 0 && b.replayed >= b.limit {
+		return fmt.Errorf("%w: stopped after %d logs, the last with LSN %d", ErrReplayLimit, b.replayed, b.lastLSN)
+	}
+	b.replayed++
+	b.lastLSN = l.getLSN()
+	return nil
+}
+
+// reportProgress reports the progress of a recovery pass, if a ProgressFunc is set.
+func (rm *RecoveryManager) reportProgress(pass string, processed int, total int) {
+	if rm.progress != nil {
+		rm.progress(pass, processed, total)
+	}
 }
 
 // NewRecoveryManager returns a new recovery manager for the specified database,
-// transaction manager, and using the specified log file.
+// transaction manager, and using the specified log file, which is created if it doesn't exist.
+// The log is kept in the log file unless WithLogStorage is used.
+// The log format is detected from the log file's contents, defaulting to the string format
+// for an empty log file.
 // Returns an error instead if the log file couldn't be opened.
 func NewRecoveryManager(
 	db *database.Database,
 	tm *concurrency.TransactionManager,
 	logFilename string,
+	opts ...Option,
 ) (*RecoveryManager, error) {
-	logFile, err := os.OpenFile(logFilename, os.O_APPEND|os.O_RDWR, 0666)
+	rm := &RecoveryManager{
+		logFilename: logFilename,
+		db:          db,
+		tm:          tm,
+		stacks:      newTxStacks(),
+		logFileMode: 0666,
+		redoWorkers: runtime.GOMAXPROCS(0),
+		prefetch:    true,
+		clock:       systemClock{},
+	}
+	for _, opt := range opts {
+		opt(rm)
+	}
+	if err := rm.checkDatabases(); err != nil {
+		return nil, err
+	}
+	rm.backuper = rm.backuperFor(strings.TrimSuffix(db.GetBasePath(), "/"))
+	if rm.encryptionKey != nil {
+		aead, err := newAEAD(rm.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		rm.aead = aead
+	}
+	logFile, err := rm.openLog()
+	if err != nil {
+		return nil, err
+	}
+	fstats, err := logFile.Stat()
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	// An empty log file may have just been rotated, so fall back on the newest segment.
+	format, segmentLSN := StringLogFormat, uint64(0)
+	if rm.storage == nil {
+		format, segmentLSN, err = lastSegmentState(logFilename)
+		if err != nil {
+			logFile.Close()
+			return nil, err
+		}
+	}
+	format, err = detectLogFormat(logFile, format)
+	if err == nil {
+		err = checkLogVersion(logFile, fstats.Size(), format)
+	}
 	if err != nil {
+		logFile.Close()
 		return nil, err
 	}
-	return &RecoveryManager{
-		db:      db,
-		tm:      tm,
-		txStack: make(map[uuid.UUID][]editLog),
-		logFile: logFile,
-	}, nil
+	// Continue numbering logs from the last LSN in the log file.
+	lsn, err := lastLSN(logFile, format)
+	if err != nil {
+		logFile.Close()
+		return nil, err
+	}
+	if lsn == 0 {
+		lsn = segmentLSN
+	}
+	rm.logFile = logFile
+	rm.logSize = fstats.Size()
+	rm.writer = bufio.NewWriter(logFile)
+	rm.lsn = lsn
+	rm.syncedLSN = lsn
+	rm.checkpointLSN = lsn
+	rm.format = format
+	// Transactions reaped for being idle are rolled back like any other abort.
+	tm.SetAbortHandler(rm.Rollback)
+	rm.startBackground()
+	return rm, nil
+}
+
+// GetLSN returns the LSN of the most recently flushed log, or 0 if no logs have LSNs yet.
+// The log may still be buffered; see Flush.
+func (rm *RecoveryManager) GetLSN() uint64 {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	return rm.lsn
+}
+
+// SetChecksums sets whether a CRC32 checksum is appended to every log flushed from now on.
+// Logs are verified against their checksum when read regardless of this setting,
+// and logs written without a checksum can always be read.
+// Logs written in the binary format are always checksummed.
+func (rm *RecoveryManager) SetChecksums(enabled bool) {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	rm.checksums = enabled
 }
 
-// flushLog serializes the specified log and immediately appends it
-// to the end of log file on disk. Expects rm.mtx to be locked.
-func (rm *RecoveryManager) flushLog(log log) error {
-	_, err := rm.logFile.WriteString(log.toString())
+// SetLogFormat sets the format used to write logs to the log file. Since a log file
+// can only hold logs of one format, returns an error if the log file already has logs
+// written in a different format. The string format is intended for debugging.
+func (rm *RecoveryManager) SetLogFormat(format LogFormat) error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if format == rm.format {
+		return nil
+	}
+	fstats, err := rm.logFile.Stat()
 	if err != nil {
 		return err
 	}
-	err = rm.logFile.Sync()
-	return err
+	if fstats.Size() > 0 || rm.writer.Buffered() > 0 {
+		return errors.New("cannot change the format of a non-empty log file")
+	}
+	rm.format = format
+	return nil
+}
+
+// Close syncs and closes the log file, returning the error of a failed automatic checkpoint
+// if there was one. Any logging after the recovery manager is closed returns ErrClosed,
+// as does closing it again.
+func (rm *RecoveryManager) Close() error {
+	rm.mtx.Lock()
+	if rm.closed {
+		rm.mtx.Unlock()
+		return ErrClosed
+	}
+	rm.closed = true
+	rm.mtx.Unlock()
+	rm.stopBackground()
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.synced != nil {
+		// Wake up anyone still waiting on group commit once everything is synced.
+		defer rm.synced.Broadcast()
+		rm.synced = nil
+	}
+	syncErr := rm.waitDurable(rm.lsn)
+	if syncErr == nil {
+		rm.publish()
+	}
+	rm.subscribers, rm.shipPending, rm.shipReady = nil, nil, nil
+	closeErr := rm.logFile.Close()
+	if syncErr != nil {
+		return syncErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	return rm.autoCheckpointErr
+}
+
+// flushLog assigns the specified log the next LSN, serializes it, and appends it to the
+// log file's buffer. Returns the LSN assigned to the log. The log only becomes durable
+// once waitDurable is called with its LSN (or a later one).
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) flushLog(log log) (lsn uint64, err error) {
+	if rm.closed {
+		return 0, ErrClosed
+	}
+	// Logs written after a failed write would follow a gap in the log.
+	if rm.syncErr != nil {
+		return 0, rm.syncErr
+	}
+	if rm.logSize == 0 {
+		if err = rm.writeVersion(); err != nil {
+			return 0, err
+		}
+	}
+	header := logHeader{lsn: rm.lsn + 1, timestamp: rm.nextTimestamp()}
+	buf := recordBuffers.Get().(*[]byte)
+	record := rm.serialize((*buf)[:0], header, log)
+	defer func() {
+		*buf = record[:0]
+		recordBuffers.Put(buf)
+	}()
+	if rm.maxLogSize > 0 && rm.logSize > 0 && rm.logSize+int64(len(record)) > rm.maxLogSize {
+		if err = rm.rotate(); err != nil {
+			return 0, fmt.Errorf("error rotating the log file: %w", err)
+		}
+		header.lsn = rm.lsn + 1
+		record = rm.serialize(record[:0], header, log)
+	}
+	lsn = header.lsn
+	_, err = rm.writer.Write(record)
+	if err != nil {
+		return 0, err
+	}
+	rm.lsn = lsn
+	rm.logSize += int64(len(record))
+	rm.countLog(log, lsn, len(record))
+	rm.ship(log, lsn, record)
+	return lsn, nil
+}
+
+// nextTimestamp returns the timestamp of the next log, which never precedes the timestamp
+// of the previous log even if the wall clock goes backward. Expects rm.mtx to be locked.
+func (rm *RecoveryManager) nextTimestamp() int64 {
+	rm.timestamp = max(rm.timestamp, rm.clock.Now().UnixNano())
+	return rm.timestamp
+}
+
+// recordBuffers holds the buffers flushLog serializes logs into, so that a buffer is reused
+// rather than allocated for every log. The bufio writer copies each record it is given.
+var recordBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+// serialize appends the specified log, encoded with the given header in the log file's
+// format, to b. Expects rm.mtx to be locked.
+func (rm *RecoveryManager) serialize(b []byte, header logHeader, log log) []byte {
+	if rm.aead != nil {
+		log = seal(rm.aead, header, log, rm.format)
+	}
+	return appendRecord(b, header, log, rm.format, rm.checksums)
+}
+
+// appendRecord appends the specified log, encoded with the given header in the given format,
+// to b. It is generic so that logs of a known type are serialized without boxing them.
+func appendRecord[L log](b []byte, header logHeader, l L, format LogFormat, checksums bool) []byte {
+	if format == BinaryLogFormat {
+		return append(b, encodeFrame(header, l)...)
+	}
+	start := len(b)
+	b = strconv.AppendUint(b, header.lsn, 10)
+	b = strconv.AppendInt(append(b, " @"...), header.timestamp, 10)
+	b = l.appendTo(append(b, ' '))
+	if checksums {
+		b = appendChecksum(b, start)
+	}
+	return append(b, '\n')
 }
 
 // Table records the creation of a table to the write-ahead log.
 func (rm *RecoveryManager) Table(tblType string, tblName string) error {
+	return rm.TableIn("", tblType, tblName)
+}
+
+// DropTable records the dropping of a table to the write-ahead log, waiting until the log
+// is durable so that the table is never resurrected by recovery once its files are deleted.
+// Returns an error without logging anything if a running transaction has edits of the table
+// left to undo, since rolling that transaction back would need the table.
+func (rm *RecoveryManager) DropTable(tblName string) error {
+	return rm.DropTableIn("", tblName)
+}
+
+// DropTableIn records the dropping of a table from the database registered under the specified
+// id to the write-ahead log, like DropTable does for the primary database.
+func (rm *RecoveryManager) DropTableIn(dbID string, tblName string) error {
+	if _, err := rm.database(dbID); err != nil {
+		return err
+	}
 	rm.mtx.Lock()
 	defer rm.mtx.Unlock()
-	tl := tableLog{
-		tblType: tblType,
-		tblName: tblName,
+	var err error
+	rm.stacks.each(func(id uuid.UUID, stack []editLog) bool {
+		for _, el := range stack {
+			if el.db == dbID && el.tablename == tblName {
+				err = fmt.Errorf("cannot drop table %s, which has uncommitted edits by transaction %v", qualify(dbID, tblName), id)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return err
 	}
-	err := rm.flushLog(tl)
+	lsn, err := rm.flushLog(dropTableLog{db: dbID, tblName: tblName})
 	if err != nil {
-		return fmt.Errorf("error writing a Table log: %w", err)
+		return fmt.Errorf("error writing a DropTable log: %w", err)
+	}
+	if err = rm.waitDurable(lsn); err != nil {
+		return err
 	}
+	rm.publish()
 	return nil
 }
 
 // Edit records an individual entry change (insert, update, deletion) to the write-ahead log.
+// Only writing the log holds rm.mtx; the edit is pushed onto the client's stack after.
+// Returns ErrNoTransaction, without logging anything, if the client hasn't called Start.
 func (rm *RecoveryManager) Edit(clientId uuid.UUID, table database.Index, action action, key int64, oldval int64, newval int64) error {
+	rm.quiesceMtx.RLock()
+	defer rm.quiesceMtx.RUnlock()
+	_, err := rm.edit(clientId, table, action, key, oldval, newval)
+	return err
+}
+
+// edit logs an edit like Edit, returning its LSN.
+func (rm *RecoveryManager) edit(clientId uuid.UUID, table database.Index, action action, key int64, oldval int64, newval int64) (uint64, error) {
+	edit := editLog{
+		id:        clientId,
+		db:        rm.dbIDOf(table),
+		tablename: table.GetName(),
+		action:    action,
+		key:       key,
+		oldval:    oldval,
+		newval:    newval,
+	}
+	// The client got a stack when it started, so a checkpoint taken before the edit is
+	// pushed still lists the client as running.
+	if _, started := rm.stacks.get(clientId); !started {
+		return 0, fmt.Errorf("error writing an Edit log: %w", ErrNoTransaction)
+	}
+	// A client's edits are logged one at a time, so the top of its stack stays put until this one is pushed.
+	edit.prevLSN = rm.stacks.prevLSN(clientId)
+	rm.tm.Touch(clientId)
 	rm.mtx.Lock()
-	defer rm.mtx.Unlock()
-	edit := editLog{clientId, table.GetName(), action, key, oldval, newval}
-	rm.flushLog(edit)
-	rm.txStack[clientId] = append(rm.txStack[clientId], edit)
-	return nil
+	if rm.closed {
+		rm.mtx.Unlock()
+		return 0, ErrClosed
+	}
+	lsn, err := rm.flushLog(edit)
+	rm.mtx.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("error writing an Edit log: %w", err)
+	}
+	// Only edits that made it into the log can be undone.
+	edit.lsn = lsn
+	rm.stacks.push(clientId, edit)
+	return lsn, nil
 }
 
-// Start records the start of a transaction to the write-ahead log.
+// Start records the start of a transaction to the write-ahead log, and gives the client
+// an empty stack to push its edits onto. The transaction the client begins next in the
+// transaction manager is given the Start log's LSN as its timestamp.
 func (rm *RecoveryManager) Start(clientId uuid.UUID) error {
 	rm.mtx.Lock()
 	defer rm.mtx.Unlock()
-	start := startLog{clientId}
-	rm.flushLog(start)
+	if rm.closed {
+		return ErrClosed
+	}
+	start := startLog{id: clientId}
+	lsn, err := rm.flushLog(start)
+	if err != nil {
+		return fmt.Errorf("error writing a Start log: %w", err)
+	}
+	rm.stacks.touch(clientId, lsn)
+	// The Start log's LSN orders the transaction across restarts; see concurrency's clock.go.
+	rm.tm.AssignTimestamp(clientId, lsn)
 	return nil
 }
 
@@ -103,9 +574,22 @@ func (rm *RecoveryManager) Start(clientId uuid.UUID) error {
 func (rm *RecoveryManager) Commit(clientId uuid.UUID) error {
 	rm.mtx.Lock()
 	defer rm.mtx.Unlock()
-	delete(rm.txStack, clientId)
-	commit := commitLog{clientId}
-	rm.flushLog(commit)
+	if rm.closed {
+		return ErrClosed
+	}
+	commit := commitLog{id: clientId}
+	lsn, err := rm.flushLog(commit)
+	if err != nil {
+		return fmt.Errorf("error writing a Commit log: %w", err)
+	}
+	// Checkpoints taken while waiting for the commit to be durable follow the Commit log, so
+	// they must not list the transaction as running.
+	rm.stacks.remove(clientId)
+	// The commit must be durable before the transaction counts as committed.
+	if err = rm.waitDurable(lsn); err != nil {
+		return err
+	}
+	rm.publish()
 	return nil
 }
 
@@ -113,196 +597,844 @@ func (rm *RecoveryManager) Commit(clientId uuid.UUID) error {
 // from in case of a crash. Writes a checkpoint log with all the ids of active, uncommitted transactions
 // to the write-ahead log.
 func (rm *RecoveryManager) Checkpoint() error {
-	rm.mtx.Lock()
-	defer rm.mtx.Unlock()
-	for _, table := range(rm.db.GetTables()) {
-		table.GetPager().LockAllPages()
-		table.GetPager().FlushAllPages()
-		table.GetPager().UnlockAllPages()
+	return rm.CheckpointContext(context.Background())
+}
+
+// CheckpointContext creates a checkpoint like Checkpoint, but gives up and returns ctx.Err()
+// once ctx is cancelled while flushing the tables, without writing a checkpoint log. Pages
+// flushed by then stay flushed. Once the checkpoint log is written, the backup is taken
+// regardless of ctx.
+func (rm *RecoveryManager) CheckpointContext(ctx context.Context) error {
+	rm.checkpointMtx.Lock()
+	defer rm.checkpointMtx.Unlock()
+	unlock := rm.lockAllTables()
+	defer unlock()
+	if rm.closed {
+		return ErrClosed
 	}
-	ids := make([]uuid.UUID, 0)
-	for id := range(rm.txStack) {
-		ids = append(ids, id)
+	return rm.checkpoint(ctx)
+}
+
+// checkpoint creates a checkpoint while rm.checkpointMtx is held and every table is locked
+// along with rm.mtx by lockAllTables, so that no edit logged before the checkpoint log is left
+// to be applied after its table was flushed.
+// Nothing is logged unless every table was flushed before ctx was cancelled. If the checkpoint
+// log is durable but the backup can't be taken, the returned error wraps ErrStaleBackup.
+func (rm *RecoveryManager) checkpoint(ctx context.Context) error {
+	for _, dbID := range rm.databaseIDs() {
+		db, _ := rm.database(dbID)
+		for name, table := range db.GetTables() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := flushTable(ctx, table, nil); err != nil {
+				return fmt.Errorf("error flushing table %s: %w", qualify(dbID, name), err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	checkpoint := checkpointLog{ids: rm.runningIds()}
+	lsn, err := rm.flushLog(checkpoint)
+	if err == nil {
+		err = rm.waitDurable(lsn)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing a Checkpoint log: %w", err)
+	}
+	// The primary database is backed up last, since its backup holds the log.
+	if err = rm.backupDatabases(); err != nil {
+		return fmt.Errorf("%w: %w", ErrStaleBackup, err)
+	}
+	// Keep this line at the end that ensures checkpointing works correctly!
+	if err = rm.delta(); err != nil {
+		return fmt.Errorf("%w: %w", ErrStaleBackup, err)
 	}
-	checkpoint := checkpointLog{ids: ids}
-	rm.flushLog(checkpoint)
-	rm.delta() // Keep this line at the end that ensures checkpointing works correctly!
 	return nil
 }
 
-// redo carries out the given table log or edit log's action without
+// flushTable flushes all of the table's pages to disk, unless ctx is cancelled first, and then
+// calls then, if set, before any further changes to the table. A hash table's bucket directory is otherwise only written
+// to its .meta file on close, so it is written along with the pages for the table's files to
+// hold the whole table. The table is locked throughout so that no bucket splits in between.
+func flushTable(ctx context.Context, table database.Index, then func() error) (err error) {
+	index, isHash := table.(*hash.HashIndex)
+	if isHash {
+		index.GetTable().RLock()
+		defer index.GetTable().RUnlock()
+	}
+	// A page may be held by an operation waiting on the pager, so back off rather than wait.
+	for !table.GetPager().TryLockAllPages() {
+		runtime.Gosched()
+	}
+	defer table.GetPager().UnlockAllPages()
+	if err = table.GetPager().FlushAllPagesContext(ctx); err != nil {
+		return err
+	}
+	if isHash {
+		if err = hash.WriteHashMeta(table.GetPager(), index.GetTable()); err != nil {
+			return fmt.Errorf("error writing hash table %s: %w", table.GetName(), err)
+		}
+	}
+	if then != nil {
+		return then()
+	}
+	return nil
+}
+
+// runningIds returns the ids of the transactions that started but haven't been committed or rolled back.
+// A client's stack is only added or removed under rm.mtx as its Start, Commit, Abort, or Reclaim
+// log is flushed, so with rm.mtx locked the ids are exactly those running as of the next log.
+func (rm *RecoveryManager) runningIds() []uuid.UUID {
+	ids := make([]uuid.UUID, 0)
+	rm.stacks.each(func(id uuid.UUID, _ []editLog) bool {
+		ids = append(ids, id)
+		return true
+	})
+	return ids
+}
+
+// redo carries out the given table log, drop log, edit log, or compensation log's action without
 // re-writing the action to the log file. For use when recovering from a crash.
-func (rm *RecoveryManager) redo(log log) error {
+// Edits and compensations that the database already reflects are skipped unless forced; see redoEdit.
+func (rm *RecoveryManager) redo(log log, force bool) error {
 	switch log := log.(type) {
 	case tableLog:
+		db, err := rm.database(log.db)
+		if err != nil {
+			return err
+		}
 		payload := fmt.Sprintf("create %s table %s", log.tblType, log.tblName)
-		_, err := database.HandleCreateTable(rm.db, payload)
+		if _, err = database.HandleCreateTable(db, payload); err != nil {
+			return err
+		}
+		return rm.checkTableType(log)
+	case dropTableLog:
+		db, err := rm.database(log.db)
 		if err != nil {
 			return err
 		}
+		payload := fmt.Sprintf("drop table %s", log.tblName)
+		if _, err = database.HandleDropTable(db, payload); err != nil {
+			return err
+		}
 	case editLog:
-		switch log.action {
+		return rm.redoEdit(log, log.getLSN(), force)
+	case clrLog:
+		return rm.redoEdit(log.edit.inverse(), log.getLSN(), force)
+	default:
+		return errors.New("can only redo edit, compensation, table, or drop logs")
+	}
+	return nil
+}
+
+// redoEdit applies the given edit, logged with the given LSN, unless the page that holds its key
+// already reflects it, having an LSN at least as high; see page_lsn.go. A forced edit is applied
+// whatever the page's LSN, leaving the key as the edit did whether or not it already was.
+func (rm *RecoveryManager) redoEdit(edit editLog, lsn uint64, force bool) error {
+	db, err := rm.database(edit.db)
+	if err != nil {
+		return err
+	}
+	table, err := db.GetTable(edit.tablename)
+	if err != nil {
+		return err
+	}
+	mtx := rm.tableMutex(edit.db, edit.tablename)
+	mtx.Lock()
+	defer mtx.Unlock()
+	action := edit.action
+	if force {
+		_, err = table.Find(edit.key)
+		switch {
+		case action == DELETE_ACTION && err != nil:
+			return nil
+		case action != DELETE_ACTION && err == nil:
+			action = UPDATE_ACTION
+		case action != DELETE_ACTION:
+			action = INSERT_ACTION
+		}
+	} else {
+		pageLSN, err := table.PageLSN(edit.key)
+		if err != nil {
+			return err
+		}
+		if lsn <= pageLSN {
+			return nil
+		}
+	}
+	return applyAt(table, lsn, func() error {
+		switch action {
 		case INSERT_ACTION:
-			payload := fmt.Sprintf("insert %v %v into %s", log.key, log.newval, log.tablename)
-			err := database.HandleInsert(rm.db, payload)
-			if err != nil {
-				// There is already an entry, try updating
-				payload := fmt.Sprintf("update %s %v %v", log.tablename, log.key, log.newval)
-				err = database.HandleUpdate(rm.db, payload)
-				if err != nil {
-					return err
-				}
-			}
+			payload := fmt.Sprintf("insert %v %v into %s", edit.key, edit.newval, edit.tablename)
+			return database.HandleInsert(db, payload)
 		case UPDATE_ACTION:
-			payload := fmt.Sprintf("update %s %v %v", log.tablename, log.key, log.newval)
-			err := database.HandleUpdate(rm.db, payload)
-			if err != nil {
-				// Entry may have been deleted, try inserting
-				payload := fmt.Sprintf("insert %v %v into %s", log.key, log.newval, log.tablename)
-				err := database.HandleInsert(rm.db, payload)
-				if err != nil {
-					return err
-				}
-			}
+			payload := fmt.Sprintf("update %s %v %v", edit.tablename, edit.key, edit.newval)
+			return database.HandleUpdate(db, payload)
 		case DELETE_ACTION:
+			payload := fmt.Sprintf("delete %v from %s", edit.key, edit.tablename)
+			return database.HandleDelete(db, payload)
+		}
+		return nil
+	})
+}
+
+// undo carries out the opposite action of the given edit log's action
+// to undo it, returning an error if the undoing action failed.
+// Note: writes a compensation log of the undoing action to the log file first,
+// where undoNext is the LSN of the transaction's next edit left to undo (or 0 if none),
+// and stamps the pages the undoing action changes with the compensation log's LSN,
+// which is returned.
+func (rm *RecoveryManager) undo(log editLog, undoNext uint64) (uint64, error) {
+	db, err := rm.database(log.db)
+	if err != nil {
+		return 0, err
+	}
+	table, err := db.GetTable(log.tablename)
+	if err != nil {
+		return 0, err
+	}
+	// Lock the key before the table's mutex, as for any edit; see page_lsn.go.
+	if err = rm.tm.Lock(log.id, table, log.key, concurrency.W_LOCK); err != nil {
+		return 0, err
+	}
+	rm.quiesceMtx.RLock()
+	defer rm.quiesceMtx.RUnlock()
+	mtx := rm.tableMutex(log.db, log.tablename)
+	mtx.Lock()
+	defer mtx.Unlock()
+	rm.mtx.Lock()
+	lsn, err := rm.flushLog(clrLog{edit: log, undoNext: undoNext})
+	rm.mtx.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("error writing a compensation log: %w", err)
+	}
+	return lsn, applyAt(table, lsn, func() error {
+		switch log.action {
+		case INSERT_ACTION:
 			payload := fmt.Sprintf("delete %v from %s", log.key, log.tablename)
-			err := database.HandleDelete(rm.db, payload)
-			if err != nil {
+			return concurrency.HandleDelete(db, rm.tm, payload, log.id)
+		case UPDATE_ACTION:
+			payload := fmt.Sprintf("update %s %v %v", log.tablename, log.key, log.oldval)
+			return concurrency.HandleUpdate(db, rm.tm, payload, log.id)
+		case DELETE_ACTION:
+			payload := fmt.Sprintf("insert %v %v into %s", log.key, log.oldval, log.tablename)
+			return concurrency.HandleInsert(db, rm.tm, payload, log.id)
+		}
+		return nil
+	})
+}
+
+// compensateLast pops the client's most recent edit off of its stack and writes a
+// compensation log for it, marking the edit as a no-op. For use when an edit was
+// logged but could not be applied to the database.
+func (rm *RecoveryManager) compensateLast(clientId uuid.UUID) error {
+	stack, _ := rm.stacks.get(clientId)
+	if len(stack) == 0 {
+		return errors.New("no edit to compensate")
+	}
+	last := len(stack) - 1
+	rm.mtx.Lock()
+	_, err := rm.flushLog(clrLog{edit: stack[last], undoNext: undoNextLSN(stack, last)})
+	rm.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	rm.stacks.set(clientId, stack[:last])
+	return nil
+}
+
+// Recover carries out a full recovery to the most recent checkpoint according to
+// the write-ahead log. Intended to be used on startup after a crash.
+// Table and drop logs are replayed first so that every edit log can find its table,
+// then every edit and compensation after the checkpoint is redone. Finally, the
+// undo stacks of any transactions that never committed are rebuilt from the log,
+// by following each transaction's chain of edits back from its last edit or
+// compensation log, and rolled back. Compensation logs skip the edits they undid,
+// so edits that were already undone before the crash are never undone twice. The transaction manager
+// is reset before undoing, so no transaction is left running and no lock held afterwards.
+// A log that fails to be redone or undone is returned as a RecoveryError.
+// Returns what was recovered, as far as recovery got if it failed.
+func (rm *RecoveryManager) Recover() (RecoveryResult, error) {
+	return rm.RecoverContext(context.Background())
+}
+
+// RecoverContext recovers the database like Recover, but gives up and returns ctx.Err() once
+// ctx is cancelled, checking it between logs. The edits redone and undone by then are kept:
+// redoing is idempotent, and each undo logs a compensation log, so recovering again finishes
+// the job. Returns what was recovered before giving up.
+func (rm *RecoveryManager) RecoverContext(ctx context.Context) (RecoveryResult, error) {
+	start := rm.clock.Now()
+	var result RecoveryResult
+	logs, checkpointIndex, err := rm.readLogs()
+	if err == nil {
+		err = rm.recoverLogs(ctx, logs, checkpointIndex, &result)
+	}
+	result.Duration = rm.clock.Now().Sub(start)
+	return result, err
+}
+
+// RecoverToTimestamp recovers the database like Recover, but only up to the given time:
+// every log written after it is discarded from the log file, and transactions still running
+// at that time are rolled back. Returns ErrNoCheckpoint without discarding anything if the
+// time precedes the checkpoint the database was restored from.
+func (rm *RecoveryManager) RecoverToTimestamp(ts time.Time) error {
+	if rm.storage != nil {
+		return ErrNotLogFile
+	}
+	logs, checkpointIndex, err := rm.readLogs()
+	if err != nil {
+		return err
+	}
+	if checkpointIndex >= 0 && logs[checkpointIndex].getTime().After(ts) {
+		return fmt.Errorf("%w at or before %v, the checkpoint was taken at %v",
+			ErrNoCheckpoint, ts, logs[checkpointIndex].getTime())
+	}
+	// Timestamps never decrease, so the logs to discard are all at the end.
+	end := len(logs)
+	for end > checkpointIndex+1 && logs[end-1].getTime().After(ts) {
+		end--
+	}
+	if end < len(logs) {
+		if err = rm.truncateAfter(ts); err != nil {
+			return fmt.Errorf("error discarding logs after %v: %w", ts, err)
+		}
+	}
+	return rm.recoverLogs(context.Background(), logs[:end], checkpointIndex, &RecoveryResult{})
+}
+
+// recoverLogs redoes and undoes the logs read by readLogs, recording what it did in result,
+// until ctx is cancelled.
+func (rm *RecoveryManager) recoverLogs(ctx context.Context, logs []log, checkpointIndex int, result *RecoveryResult) error {
+	result.RecordsScanned = len(logs)
+	if checkpointIndex >= 0 {
+		result.CheckpointLSN = logs[checkpointIndex].getLSN()
+	}
+	budget := &replayBudget{limit: rm.maxReplay}
+	redone, err := rm.redoFromCheckpoint(ctx, logs, checkpointIndex, budget)
+	result.RecordsRedone = redone
+	if err != nil {
+		return err
+	}
+
+	// Analysis pass: rebuild the undo stack of every transaction that didn't commit.
+	activeTxns, stacks := analyze(logs)
+
+	// No client that was running before the crash is still running, nor holds any locks.
+	rm.tm.Reset()
+	// Transactions begun from now on, the losers included, are younger than any before the crash.
+	rm.tm.AdvanceClock(rm.GetLSN())
+
+	// Undo pass.
+	undoTotal, undone := 0, 0
+	losers := make(map[uuid.UUID][]editLog, len(activeTxns))
+	for id := range activeTxns {
+		undoTotal += len(stacks[id])
+		losers[id] = stacks[id]
+		rm.tm.Begin(id)
+		rm.stacks.set(id, stacks[id])
+	}
+	err = rm.undoLosers(ctx, losers, budget, func() {
+		result.RecordsUndone++
+		if undone++; undone%progressInterval == 0 && undone < undoTotal {
+			rm.reportProgress("undo", undone, undoTotal)
+		}
+	}, func(id uuid.UUID) {
+		result.RolledBack = append(result.RolledBack, id)
+	})
+	if err != nil {
+		return err
+	}
+	rm.reportProgress("undo", undoTotal, undoTotal)
+	return nil
+}
+
+// undoLosers rolls back the transactions with the given undo stacks all at once, undoing their
+// edits newest first across every transaction, so that the log is undone in the reverse of the
+// order it was written in. Calls undone after each edit is undone, and aborted once a
+// transaction has no edits left and its abort log is written. Stops before the next undo and
+// returns ctx.Err() once ctx is cancelled, or ErrReplayLimit once the budget runs out, leaving
+// the edits not yet undone on the stacks.
+func (rm *RecoveryManager) undoLosers(ctx context.Context, losers map[uuid.UUID][]editLog,
+	budget *replayBudget, undone func(), aborted func(id uuid.UUID)) error {
+	for len(losers) > 0 {
+		// Finish transactions with nothing left to undo first, then undo the newest edit.
+		var next uuid.UUID
+		found := false
+		for id, stack := range losers {
+			if len(stack) == 0 {
+				next = id
+				break
+			}
+			if newest := losers[next]; !found || stack[len(stack)-1].lsn > newest[len(newest)-1].lsn {
+				next, found = id, true
+			}
+		}
+		stack := losers[next]
+		if len(stack) == 0 {
+			rm.tm.Abort(next)
+			if err := rm.abort(next); err != nil {
 				return err
 			}
+			delete(losers, next)
+			aborted(next)
+			continue
 		}
-	default:
-		return errors.New("can only redo edit or table logs")
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		i := len(stack) - 1
+		if err := budget.take(stack[i]); err != nil {
+			return err
+		}
+		lsn, err := rm.undo(stack[i], undoNextLSN(stack, i))
+		if err == nil && rm.redoHook != nil {
+			err = rm.redoHook(redoneEdit(stack[i].inverse(), lsn, true))
+		}
+		if err != nil {
+			return newRecoveryError("undo", stack[i], err)
+		}
+		losers[next] = stack[:i]
+		rm.stacks.set(next, stack[:i])
+		undone()
 	}
 	return nil
 }
 
-// undo carries out the opposite action of the given edit log's action
-// to undo it, returning an error if the undoing action failed.
-// Note: writes a log of the undoing action to the log file.
-func (rm *RecoveryManager) undo(log editLog) error {
-	switch log.action {
-	case INSERT_ACTION:
-		payload := fmt.Sprintf("delete %v from %s", log.key, log.tablename)
-		err := HandleDelete(rm.db, rm.tm, rm, payload, log.id)
-		if err != nil {
-			return err
+// redoFromCheckpoint redoes the table and drop logs read by readLogs, and then every edit and
+// compensation log since the checkpoint, along with those of the transactions in flight at
+// the checkpoint, until ctx is cancelled or the budget runs out. Edits of tables checkpointed
+// on their own since are skipped, unless their transactions were still running at the table
+// checkpoint; see checkpoint_tables.go. Returns the number of logs redone.
+func (rm *RecoveryManager) redoFromCheckpoint(ctx context.Context, logs []log, checkpointIndex int, budget *replayBudget) (int, error) {
+	if err := rm.redoSchema(logs, checkpointIndex); err != nil {
+		return 0, err
+	}
+	redo := logs[checkpointIndex+1:]
+	force := make(map[uint64]bool)
+	if checkpointIndex >= 0 {
+		switch checkpoint := logs[checkpointIndex].(type) {
+		case checkpointLog:
+			// An edit logged through Edit before a full checkpoint may only have been applied after
+			// it, once a later edit stamped the same page, so these are redone whatever the page LSNs.
+			inFlight := inFlightLogs(logs[:checkpointIndex], checkpoint.ids)
+			for _, l := range inFlight {
+				force[l.getLSN()] = true
+			}
+			redo = append(inFlight, redo...)
+		case beginCheckpointLog:
+			redo = append(inFlightLogs(logs[:checkpointIndex], checkpoint.ids), redo...)
 		}
-	case UPDATE_ACTION:
-		payload := fmt.Sprintf("update %s %v %v", log.tablename, log.key, log.oldval)
-		err := HandleUpdate(rm.db, rm.tm, rm, payload, log.id)
+	}
+	return rm.redoLogs(ctx, skipCheckpointedTables(redo, force), force, budget)
+}
+
+// analyze returns the transactions among the logs that never finished, along with the undo
+// stack of edits each has yet to undo. Each stack is rebuilt by following the transaction's
+// chain of edits back from its next edit left to undo, as named by its last edit or
+// compensation log; see chainStack.
+func analyze(logs []log) (activeTxns map[uuid.UUID]bool, stacks map[uuid.UUID][]editLog) {
+	activeTxns = make(map[uuid.UUID]bool)
+	stacks = make(map[uuid.UUID][]editLog)
+	edits := make(map[uint64]editLog)
+	heads := make(map[uuid.UUID]uint64)
+	for _, l := range logs {
+		switch log := l.(type) {
+		case startLog:
+			activeTxns[log.id] = true
+			stacks[log.id] = nil
+			delete(heads, log.id)
+		case commitLog:
+			delete(activeTxns, log.id)
+			delete(stacks, log.id)
+			delete(heads, log.id)
+		case abortLog:
+			// Every edit of an aborted transaction has already been compensated.
+			delete(activeTxns, log.id)
+			delete(stacks, log.id)
+			delete(heads, log.id)
+		case reclaimLog:
+			if activeTxns[log.id] {
+				activeTxns[log.to] = true
+				stacks[log.to] = stacks[log.id]
+				if head, ok := heads[log.id]; ok {
+					heads[log.to] = head
+				}
+				delete(activeTxns, log.id)
+				delete(stacks, log.id)
+				delete(heads, log.id)
+			}
+		case checkpointLog:
+			for _, id := range log.ids {
+				activeTxns[id] = true
+			}
+		case beginCheckpointLog:
+			for _, id := range log.ids {
+				activeTxns[id] = true
+			}
+		case editLog:
+			if activeTxns[log.id] {
+				stacks[log.id] = append(stacks[log.id], log)
+				edits[log.lsn] = log
+				heads[log.id] = log.lsn
+			}
+		case clrLog:
+			if activeTxns[log.edit.id] {
+				stacks[log.edit.id] = truncateStack(stacks[log.edit.id], log.undoNext)
+				heads[log.edit.id] = log.undoNext
+			}
+		}
+	}
+	for id, head := range heads {
+		stacks[id] = chainStack(edits, head, stacks[id])
+	}
+	// Edits logged before a transaction was reclaimed are undone under the id it was reclaimed by.
+	for id, stack := range stacks {
+		for i := range stack {
+			stack[i].id = id
+		}
+	}
+	return activeTxns, stacks
+}
+
+// chainStack rebuilds a transaction's undo stack by walking the chain of its edits back from
+// head, the LSN of its next edit left to undo, until the chain reaches its Start log. Edits
+// logged before edits were chained end the chain early, so the edits below such an edit are
+// taken from inOrder, the stack rebuilt from the transaction's logs in log order.
+func chainStack(edits map[uint64]editLog, head uint64, inOrder []editLog) []editLog {
+	var chain []editLog // Newest first
+	var base []editLog
+	for lsn := head; lsn != 0; {
+		edit, ok := edits[lsn]
+		if !ok {
+			break
+		}
+		// A previous LSN always points backward, so the walk ends even in a corrupt log.
+		if edit.prevLSN == 0 || edit.prevLSN >= lsn {
+			base = truncateStack(inOrder, lsn)
+			break
+		}
+		chain = append(chain, edit)
+		lsn = edit.prevLSN
+	}
+	stack := make([]editLog, 0, len(base)+len(chain))
+	stack = append(stack, base...)
+	for i := len(chain) - 1; i >= 0; i-- {
+		stack = append(stack, chain[i])
+	}
+	return stack
+}
+
+// redoSchema replays the table and drop logs read by readLogs in LSN order, so that the
+// database ends up with the tables that existed when the last log was written. The restored
+// database already reflects the logs up to the checkpoint, so of the tables that existed
+// then, only those missing from it are recreated; later creates and drops are then replayed
+// on top of it. Returns an error if a table that is never dropped again isn't of its logged type.
+func (rm *RecoveryManager) redoSchema(logs []log, checkpointIndex int) error {
+	tableOf := func(l log) (string, bool) {
+		switch log := l.(type) {
+		case tableLog:
+			return qualify(log.db, log.tblName), true
+		case dropTableLog:
+			return qualify(log.db, log.tblName), true
+		}
+		return "", false
+	}
+	exists := func(dbID string, tblName string) bool {
+		db, err := rm.database(dbID)
 		if err != nil {
+			return false
+		}
+		_, err = db.GetTable(tblName)
+		return err == nil
+	}
+	// The index of the last table or drop log of each table, overall and up to the checkpoint.
+	last := make(map[string]int)
+	lastBefore := make(map[string]int)
+	var names []string
+	for i, l := range logs {
+		name, ok := tableOf(l)
+		if !ok {
+			continue
+		}
+		if i <= checkpointIndex {
+			if _, ok := lastBefore[name]; !ok {
+				names = append(names, name)
+			}
+			lastBefore[name] = i
+		}
+		last[name] = i
+	}
+
+	replay := func(i int) error {
+		switch log := logs[i].(type) {
+		case tableLog:
+			if exists(log.db, log.tblName) {
+				// A table dropped later on may exist as a later table of the same name.
+				if last[qualify(log.db, log.tblName)] != i {
+					return nil
+				}
+				if err := rm.checkTableType(log); err != nil {
+					return newRecoveryError("redo", logs[i], err)
+				}
+				return nil
+			}
+		case dropTableLog:
+			if !exists(log.db, log.tblName) {
+				return nil
+			}
+		default:
+			return nil
+		}
+		if err := rm.redo(logs[i], false); err != nil {
+			return newRecoveryError("redo", logs[i], err)
+		}
+		return nil
+	}
+	for _, name := range names {
+		if _, ok := logs[lastBefore[name]].(tableLog); !ok {
+			continue
+		}
+		if err := replay(lastBefore[name]); err != nil {
 			return err
 		}
-	case DELETE_ACTION:
-		payload := fmt.Sprintf("insert %v %v into %s", log.key, log.oldval, log.tablename)
-		err := HandleInsert(rm.db, rm.tm, rm, payload, log.id)
-		if err != nil {
+	}
+	for i := checkpointIndex + 1; i < len(logs); i++ {
+		if err := replay(i); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-// Recover carries out a full recovery to the most recent checkpoint according to
-// the write-ahead log. Intended to be used on startup after a crash.
-func (rm *RecoveryManager) Recover() error {
-	logs, checkpointIndex, err := rm.readLogs()
-    if err != nil {
-        return err
-    }
+// checkTableType returns an error if the table created by the given table log doesn't
+// exist or isn't of the logged type, in which case edits of it can't be redone faithfully.
+func (rm *RecoveryManager) checkTableType(log tableLog) error {
+	db, err := rm.database(log.db)
+	if err != nil {
+		return err
+	}
+	table, err := db.GetTable(log.tblName)
+	if err != nil {
+		return err
+	}
+	tblType, err := database.TypeOf(table)
+	if err != nil {
+		return err
+	}
+	if string(tblType) != log.tblType {
+		return fmt.Errorf("table %s was logged as a %s table, but is a %s table", qualify(log.db, log.tblName), log.tblType, tblType)
+	}
+	return nil
+}
 
-	for i := 0; i < len(logs); i++ {
-		switch log := logs[i].(type) {
-			case tableLog:
-				rm.redo(log)
-			default:
+// redoLogs redoes every edit and compensation log among the given logs, in order within each
+// table. The tables are split among up to rm.redoWorkers goroutines, so logs of different
+// tables may be redone in parallel. Every table must already exist, and edits of a table
+// made before it was dropped are skipped, since the drop discards them anyway.
+// Stops redoing and returns ctx.Err() once ctx is cancelled, or ErrReplayLimit once the budget,
+// if any, runs out. The logs whose LSNs are in force are redone whatever their pages' LSNs.
+// Returns the number of logs redone.
+func (rm *RecoveryManager) redoLogs(ctx context.Context, logs []log, force map[uint64]bool, budget *replayBudget) (redone int, err error) {
+	// Partition the logs by table, counting the logs with nothing to redo as processed.
+	tables := make(map[string][]log)
+	order := make([]string, 0)
+	skipped := 0
+	var serial []hookedLog // Every log left to redo, in order, if a redo hook needs them so.
+	for _, l := range logs {
+		var table string
+		switch log := l.(type) {
+		case editLog:
+			table = qualify(log.db, log.tablename)
+		case clrLog:
+			table = qualify(log.edit.db, log.edit.tablename)
+		case dropTableLog:
+			name := qualify(log.db, log.tblName)
+			skipped += len(tables[name]) + 1
+			if _, ok := tables[name]; ok {
+				tables[name] = tables[name][:0]
+			}
+			serial = slices.DeleteFunc(serial, func(e hookedLog) bool { return e.table == name })
+			continue
+		default:
+			skipped++
+			continue
+		}
+		if _, ok := tables[table]; !ok {
+			order = append(order, table)
+		}
+		tables[table] = append(tables[table], l)
+		if rm.redoHook != nil {
+			serial = append(serial, hookedLog{table, l})
 		}
 	}
+	if rm.redoHook != nil && len(order) > 0 {
+		// The hook sees every log in order, so they're all redone as one partition.
+		tables = map[string][]log{"": make([]log, 0, len(serial))}
+		for _, e := range serial {
+			tables[""] = append(tables[""], e.l)
+		}
+		order = []string{""}
+	}
 
-    activeTxns := make(map[uuid.UUID]bool)
-    if checkpoint, ok := logs[checkpointIndex].(checkpointLog); ok {
-		for _, id := range checkpoint.ids {
-			activeTxns[id] = true
-			rm.tm.Begin(id)
+	// Progress is reported from every worker, so reports are serialized and only ever increase.
+	var progressMtx sync.Mutex
+	processed, reported := skipped, 0
+	advance := func() {
+		progressMtx.Lock()
+		defer progressMtx.Unlock()
+		processed++
+		if processed-reported >= progressInterval && processed < len(logs) {
+			rm.reportProgress("redo", processed, len(logs))
+			reported = processed
 		}
 	}
-	
-    for i := checkpointIndex + 1; i < len(logs); i++ {
-        switch log := logs[i].(type) {
-			case startLog:
-				rm.tm.Begin(log.id)
-				activeTxns[log.id] = true
-			case commitLog:
-				delete(activeTxns, log.id)
-				rm.tm.Commit(log.id)
-			case editLog:
-				if err := rm.redo(log); err != nil {
-					return err
-				}
-			default:
-        }
-    }
-	
-	for i := len(logs) - 1; i >= 0; i-- {
-		switch log := logs[i].(type) {
-			case editLog:
-				if(activeTxns[log.id]) {
-					if err := rm.undo(log); err != nil {
-						return err
+
+	partitions := make(chan []log)
+	errs := make(chan error, len(order))
+	var failed atomic.Bool
+	var workers sync.WaitGroup
+	for i := 0; i < max(1, min(rm.redoWorkers, len(order))); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for partition := range partitions {
+				for _, l := range partition {
+					// Stop redoing once any table fails or ctx is cancelled.
+					if failed.Load() {
+						break
 					}
-			}
-			case startLog:
-				if(activeTxns[log.id]) {
-					delete(activeTxns, log.id)
-					rm.tm.Commit(log.id)
-					rm.Commit(log.id)
+					if err := ctx.Err(); err != nil {
+						failed.Store(true)
+						errs <- err
+						break
+					}
+					if err := budget.take(l); err != nil {
+						failed.Store(true)
+						errs <- err
+						break
+					}
+					err := rm.redo(l, force[l.getLSN()])
+					if err == nil {
+						err = rm.reportRedone(l)
+					}
+					if err != nil {
+						failed.Store(true)
+						errs <- newRecoveryError("redo", l, err)
+						break
+					}
+					advance()
 				}
-		}
+			}
+		}()
+	}
+	for _, table := range order {
+		partitions <- tables[table]
+	}
+	close(partitions)
+	workers.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return processed - skipped, err
 	}
-    return nil
+	rm.reportProgress("redo", len(logs), len(logs))
+	return processed - skipped, nil
 }
 
 // Rollback rolls back the current uncommitted transaction for a client.
 // This is called when you abort a transaction.
+// Rolling back a client with no running transaction is a no-op. If undoing an edit
+// fails, the edits that have yet to be undone are left on the client's stack.
 func (rm *RecoveryManager) Rollback(clientId uuid.UUID) error {
-	for i := len(rm.txStack[clientId])-1; i >= 0; i-- {
-		rm.undo(rm.txStack[clientId][i])
-	 }
-	 rm.tm.Commit(clientId)
-	 rm.Commit(clientId)
-	 return nil
+	return rm.rollback(context.Background(), clientId, nil)
 }
 
-// Primes the database for recovery
-func Prime(folder string) (*database.Database, error) {
-	// Ensure folder is of the form */
-	base := filepath.Clean(folder)
-	recoveryFolder := base + "-recovery/"
-	dbFolder := base + "/"
+// rollback rolls back a client's transaction like Rollback, calling undone, if set,
+// after each edit is undone. Stops before the next undo and returns ctx.Err() once ctx is
+// cancelled, leaving the edits not yet undone on the client's stack.
+func (rm *RecoveryManager) rollback(ctx context.Context, clientId uuid.UUID, undone func()) error {
+	stack, found := rm.stacks.get(clientId)
+	if _, running := rm.tm.GetTransaction(clientId); !found && !running {
+		return nil
+	}
+	// Undo edits newest first. Each undo logs its own compensation log.
+	for i := len(stack) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			rm.stacks.set(clientId, stack[:i+1])
+			return err
+		}
+		if _, err := rm.undo(stack[i], undoNextLSN(stack, i)); err != nil {
+			rm.stacks.set(clientId, stack[:i+1])
+			return fmt.Errorf("error rolling back transaction: %w", err)
+		}
+		if undone != nil {
+			undone()
+		}
+	}
+	rm.tm.Abort(clientId)
+	return rm.abort(clientId)
+}
 
-	// If recovery folder doesn't exist, create it and open db folder as normal
-	if _, err := os.Stat(recoveryFolder); err != nil {
-		if os.IsNotExist(err) {
-			err := os.MkdirAll(recoveryFolder, 0775)
-			if err != nil {
-				return nil, err
-			}
-			return database.Open(dbFolder)
+// Savepoint returns a handle to the current point of a client's running transaction,
+// which RollbackToSavepoint can later roll the transaction back to.
+// Returns an error if the client has no running transaction.
+func (rm *RecoveryManager) Savepoint(clientId uuid.UUID) (int, error) {
+	if _, running := rm.tm.GetTransaction(clientId); !running {
+		return 0, errors.New("no running transaction to take a savepoint of")
+	}
+	stack, _ := rm.stacks.get(clientId)
+	return len(stack), nil
+}
+
+// RollbackToSavepoint undoes the edits a client's transaction made after the given savepoint,
+// logging a compensation log for each like Rollback, but leaves the transaction running.
+// Returns an error if the savepoint is stale, because the transaction was already rolled
+// back past it. If undoing an edit fails, the edits that have yet to be undone are left
+// on the client's stack.
+func (rm *RecoveryManager) RollbackToSavepoint(clientId uuid.UUID, savepoint int) error {
+	stack, _ := rm.stacks.get(clientId)
+	if savepoint < 0 || savepoint > len(stack) {
+		return fmt.Errorf("savepoint %d is stale, the transaction only has %d edits", savepoint, len(stack))
+	}
+	for i := len(stack) - 1; i >= savepoint; i-- {
+		if _, err := rm.undo(stack[i], undoNextLSN(stack, i)); err != nil {
+			rm.stacks.set(clientId, stack[:i+1])
+			return fmt.Errorf("error rolling back to savepoint: %w", err)
 		}
-		return nil, err
 	}
+	rm.stacks.set(clientId, stack[:savepoint])
+	return nil
+}
 
-	// If recovery folder exists, replace db folder with recovery folder.
-	// Copies over log file if it is in the db folder
-	logSrcPath := filepath.Join(base, config.LogFileName)
-	if _, err := os.Stat(logSrcPath); err == nil {
-		logDstPath := filepath.Join(recoveryFolder, config.LogFileName)
-		copy.Copy(logSrcPath, logDstPath)
+// abort writes an abort log for a client whose transaction was fully rolled back.
+func (rm *RecoveryManager) abort(clientId uuid.UUID) error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
 	}
-	os.RemoveAll(dbFolder)
-	err := copy.Copy(recoveryFolder, dbFolder)
+	abort := abortLog{id: clientId}
+	lsn, err := rm.flushLog(abort)
 	if err != nil {
+		return fmt.Errorf("error writing an Abort log: %w", err)
+	}
+	rm.stacks.remove(clientId)
+	if err = rm.waitDurable(lsn); err != nil {
+		return err
+	}
+	rm.publish()
+	return nil
+}
+
+// Primes the database for recovery by restoring its latest backup. Takes the same
+// WithBackupFolder or WithBackuper option as the RecoveryManager, if any, to find the backup;
+// other options are ignored.
+func Prime(folder string, opts ...Option) (*database.Database, error) {
+	// Ensure folder is of the form */
+	base := filepath.Clean(folder)
+	dbFolder := base + "/"
+	if err := backuperOf(base, opts).Restore(dbFolder); err != nil && !errors.Is(err, ErrNoBackup) {
 		return nil, err
 	}
 	return database.Open(dbFolder)
@@ -312,89 +1444,270 @@ func Prime(folder string) (*database.Database, error) {
 ////////////////////////// Recovery Helper Functions ////////////////////////
 /////////////////////////////////////////////////////////////////////////////
 
-// delta copies the entire database to a backup recovery folder.
+// undoNextLSN returns the LSN of the edit that is left to undo once stack[i] is undone,
+// or 0 if stack[i] is the transaction's first edit.
+func undoNextLSN(stack []editLog, i int) uint64 {
+	if i <= 0 {
+		return 0
+	}
+	return stack[i-1].lsn
+}
+
+// truncateStack pops edits off of the stack until the edit with LSN undoNext is on top,
+// emptying the stack if undoNext is 0.
+func truncateStack(stack []editLog, undoNext uint64) []editLog {
+	for len(stack) > 0 && stack[len(stack)-1].lsn != undoNext {
+		stack = stack[:len(stack)-1]
+	}
+	return stack
+}
+
+// lastLSN returns the LSN of the last readable log in the log file, or 0 if there is none.
+// Trailing logs that can't be parsed (such as a torn write) are skipped.
+func lastLSN(logFile LogStorage, format LogFormat) (uint64, error) {
+	scanner, err := newRecordScanner(logFile, format)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		record, _, err := scanner.prev()
+		if err == io.EOF {
+			return 0, nil
+		} else if err != nil {
+			// A torn binary frame can't be stepped over backward.
+			if format == BinaryLogFormat {
+				return lastCompleteLSN(logFile)
+			}
+			return 0, err
+		}
+		if log, err := parseRecord(record, format); err == nil {
+			return log.getLSN(), nil
+		} else if format == BinaryLogFormat {
+			return lastCompleteLSN(logFile)
+		}
+	}
+}
+
+// lastCompleteLSN returns the LSN of the last complete binary frame in the log file,
+// or 0 if there is none.
+func lastCompleteLSN(logFile LogStorage) (uint64, error) {
+	end, err := lastCompleteFrame(logFile)
+	if err != nil || end == 0 {
+		return 0, err
+	}
+	scanner := &binaryScanner{file: logFile, pos: end}
+	frame, _, err := scanner.prev()
+	if err != nil {
+		return 0, err
+	}
+	log, err := logFromBytes(frame)
+	if err != nil {
+		return 0, err
+	}
+	return log.getLSN(), nil
+}
+
+// truncateTornWrite checks whether the last log in the log file was only partially written,
+// and if so truncates the log file to the end of the last complete log. Such a log can only
+// be left behind by a crash in the middle of flushLog, so it was never acknowledged to anyone.
+func (rm *RecoveryManager) truncateTornWrite() error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if err := rm.writeBuffer(); err != nil {
+		return err
+	}
+	var end int64
+	var err error
+	if rm.format == BinaryLogFormat {
+		end, err = rm.tornFrameStart()
+	} else {
+		end, err = rm.tornLineStart()
+	}
+	if err != nil || end < 0 {
+		return err
+	}
+	err = rm.logFile.Truncate(end)
+	if err != nil {
+		return err
+	}
+	rm.logSize = end
+	return rm.syncLog()
+}
+
+// tornLineStart returns the offset of the last line of the string log file if it is
+// missing its trailing newline or can't be parsed, or -1 if the last line is complete.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) tornLineStart() (int64, error) {
+	fstats, err := rm.logFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	scanner := backscanner.New(rm.logFile, int(fstats.Size()))
+	// Everything after the last newline must be a partially written log.
+	line, pos, err := scanner.Line()
+	if err == io.EOF {
+		return -1, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if line == "" {
+		// The log file ends in a newline, so check that the last complete log parses.
+		line, pos, err = scanner.Line()
+		if err == io.EOF {
+			return -1, nil
+		} else if err != nil {
+			return 0, err
+		}
+		if _, err := logFromString(line); err == nil {
+			return -1, nil
+		}
+	}
+	return int64(pos), nil
+}
+
+// tornFrameStart returns the offset just past the last complete frame of the binary log file
+// if the last frame was only partially written, or -1 if the last frame is complete.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) tornFrameStart() (int64, error) {
+	scanner, err := newRecordScanner(rm.logFile, BinaryLogFormat)
+	if err != nil {
+		return 0, err
+	}
+	frame, _, err := scanner.prev()
+	if err == io.EOF {
+		return -1, nil
+	} else if err == nil {
+		if _, err := logFromBytes(frame); err == nil {
+			return -1, nil
+		}
+	}
+	// The trailing length can't be trusted, so find the last complete frame from the start.
+	return lastCompleteFrame(rm.logFile)
+}
+
+// delta backs up the entire database with the Backuper. By default, the backup is taken
+// in the backup recovery folder, copying only what changed since an earlier backup, in a
+// temporary folder that is then swapped in, so there is always a complete backup on disk.
 // Should be called at end of Checkpoint.
 func (rm *RecoveryManager) delta() error {
-	folder := strings.TrimSuffix(rm.db.GetBasePath(), "/")
-	recoveryFolder := folder + "-recovery/"
-	folder += "/"
-	os.RemoveAll(recoveryFolder)
-	err := copy.Copy(folder, recoveryFolder)
-	return err
+	return rm.backuper.Snapshot(strings.TrimSuffix(rm.db.GetBasePath(), "/"))
 }
 
-// Helper method that gets all log strings and the index of the most recent checkpoint from the log file.
-func (rm *RecoveryManager) getRelevantStrings() (
-	relevantStrings []string, checkpointPos int, err error) {
-	fstats, err := rm.logFile.Stat()
+// ListBackups returns the times of the backup generations kept for the database,
+// from oldest to newest, as listed by the Backuper. See WithBackupRetention.
+func (rm *RecoveryManager) ListBackups() ([]time.Time, error) {
+	return rm.backuper.List()
+}
+
+// Helper method that gets all relevant logs and the index of the most recent checkpoint
+// from the log file (or -1 if there were no checkpoint logs). Logs before the checkpoint are
+// only read back as far as the start of the transactions that were active at the checkpoint.
+// The log is scanned backward to find where the relevant logs start, and then read forward
+// from there, so logs that aren't relevant are never held in memory.
+func (rm *RecoveryManager) getRelevantLogs() (logs []log, checkpointPos int, err error) {
+	// A torn write at the end of the log is discarded, but corruption anywhere else is an error.
+	err = rm.truncateTornWrite()
 	if err != nil {
 		return nil, 0, err
 	}
+	// Both passes read the log through the prefetch, if any, so it is only read from disk once.
+	var prefetch *logPrefetch
+	if rm.prefetch {
+		prefetch = newLogPrefetch()
+		defer prefetch.close()
+	}
+	rm.mtx.Lock()
+	scanner, err := rm.newLogScanner()
+	size := rm.logSize
+	rm.mtx.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer scanner.close()
+	scanner.prefetch = prefetch
 
-	scanner := backscanner.New(rm.logFile, int(fstats.Size()))
-	checkpointTarget := []byte("checkpoint")
-	startTarget := []byte("start")
-	relevantStrings = make([]string, 0)
+	// Without a checkpoint, every log is relevant.
+	startIdx, startOffset := len(scanner.files)-1, int64(0)
 	checkpointHit := false
+	afterCheckpoint := 0
 	txs := make(map[uuid.UUID]bool)
-	for {
-		line, _, err := scanner.LineBytes()
+	var finder checkpointFinder
+	for !checkpointHit || len(txs) > 0 {
+		record, offset, err := scanner.prev()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		l, err := scanner.parse(record, offset)
 		if err != nil {
-			if err == io.EOF {
-				return relevantStrings, 0, nil
-			} else {
-				return nil, 0, err
-			}
+			return nil, 0, err
 		}
-		relevantStrings = append([]string{string(line)}, relevantStrings...)
-		checkpointPos += 1
-		if checkpointHit {
-			if bytes.Contains(line, startTarget) {
-				log, err := logFromString(string(line))
-				if err != nil {
-					return nil, 0, err
+		if log, ok := l.(startLog); ok && checkpointHit {
+			delete(txs, log.id)
+		} else if log, ok := l.(reclaimLog); ok && checkpointHit && txs[log.to] {
+			// The transaction started under the id it was reclaimed from.
+			delete(txs, log.to)
+			txs[log.id] = true
+		} else if !checkpointHit {
+			if ids, ok := finder.checkpoint(l); ok {
+				checkpointHit = true
+				for _, tx := range ids {
+					txs[tx] = true
 				}
-				id := log.(startLog).id
-				delete(txs, id)
 			}
 		}
-		if !checkpointHit && bytes.Contains(line, checkpointTarget) {
-			checkpointHit = true
-			log, err := logFromString(string(line))
-			if err != nil {
-				return nil, 0, err
-			}
-			for _, tx := range log.(checkpointLog).ids {
-				txs[tx] = true
-			}
-			checkpointPos = 0
+		if !checkpointHit {
+			afterCheckpoint++
 		}
-		if checkpointHit && len(txs) <= 0 {
+		startIdx, startOffset = scanner.idx, offset
+	}
+	scanner.close()
+
+	// The scanner's files are ordered newest first, unlike the cursor's.
+	cursor, err := rm.openLogCursor(size, len(scanner.files)-1-startIdx, startOffset, prefetch)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close()
+	logs = make([]log, 0)
+	for {
+		l, err := cursor.Next()
+		if err == io.EOF {
 			break
+		} else if err != nil {
+			return nil, 0, err
 		}
+		logs = append(logs, l)
+	}
+	if !checkpointHit {
+		return logs, -1, nil
 	}
-	return relevantStrings, checkpointPos, err
+	return logs, len(logs) - 1 - afterCheckpoint, nil
 }
 
-// Returns ALL the logs written to disk and the index of the most recent checkpoint log
-// (or len(logs) if there were no checkpoint logs).
-// Alternatively returns an error if there is an IO or deserialization problem.
+// Returns ALL the logs written to disk in LSN order and the index of the most recent checkpoint log
+// (or -1 if there were no checkpoint logs).
+// Alternatively returns an error if there is an IO or deserialization problem,
+// or if the logs are not in LSN order. Logs that can't be parsed or fail their
+// checksum result in a CorruptLogError. Every such error matches ErrCorruptLog.
 func (rm *RecoveryManager) readLogs() (logs []log, checkpointIndex int, err error) {
-	strings, checkpointIndex, err := rm.getRelevantStrings()
+	logs, checkpointIndex, err = rm.getRelevantLogs()
 	if err != nil {
 		return nil, 0, err
 	}
-	if len(strings) > 0 {
-		logs = make([]log, len(strings)-1)
-		for i, s := range strings[:len(strings)-1] {
-			log, err := logFromString(s)
-			if err != nil {
-				return nil, 0, err
+	var prevLSN uint64
+	for _, log := range logs {
+		// Logs without LSNs predate LSNs, so only numbered logs are checked.
+		if lsn := log.getLSN(); lsn != 0 {
+			if lsn <= prevLSN {
+				return nil, 0, fmt.Errorf("%w: log with LSN %d is out of order", ErrCorruptLog, lsn)
 			}
-			logs[i] = log
+			prevLSN = lsn
 		}
-	} else {
-		logs = make([]log, 0)
 	}
 	return logs, checkpointIndex, nil
 }
@@ -20,6 +20,10 @@ func RecoveryREPL(db *database.Database, tm *concurrency.TransactionManager, rm
 		return HandleCreateTable(db, rm, payload)
 	}, "Create a table. usage: create <btree|hash> table <table>")
 
+	r.AddCommand("drop", func(payload string, replConfig *repl.REPLConfig) (string, error) {
+		return HandleDropTable(db, rm, payload)
+	}, "Drop a table. usage: drop table <table>")
+
 	r.AddCommand("find", func(payload string, replConfig *repl.REPLConfig) (string, error) {
 		return HandleFind(db, tm, rm, payload, replConfig.GetAddr())
 	}, "Find an element. usage: find <key> from <table>")
@@ -115,6 +119,24 @@ func HandleCreateTable(db *database.Database, rm *RecoveryManager, payload strin
 	return database.HandleCreateTable(db, payload)
 }
 
+// Handle drop table.
+func HandleDropTable(db *database.Database, rm *RecoveryManager, payload string) (output string, err error) {
+	fields := strings.Fields(payload)
+	numFields := len(fields)
+	// Usage: drop table <table>
+	if numFields != 3 || fields[1] != "table" {
+		return "", fmt.Errorf("usage: drop table <table>")
+	}
+	if _, err = db.GetTable(fields[2]); err != nil {
+		return "", fmt.Errorf("drop error: %v", err)
+	}
+	err = rm.DropTable(fields[2])
+	if err != nil {
+		return "", err
+	}
+	return database.HandleDropTable(db, payload)
+}
+
 // Handle find.
 func HandleFind(db *database.Database, tm *concurrency.TransactionManager, rm *RecoveryManager, payload string, clientId uuid.UUID) (output string, err error) {
 	return concurrency.HandleFind(db, tm, payload, clientId)
@@ -139,34 +161,23 @@ func HandleInsert(db *database.Database, tm *concurrency.TransactionManager, rm
 	if table, err = db.GetTable(fields[4]); err != nil {
 		return fmt.Errorf("insert error: %v", err)
 	}
-	// First, check that the desired value doesn't exist.
-	_, err = table.Find(int64(key))
-	if err == nil {
-		return errors.New("insert error: key already exists")
-	}
-	// Log.
-	err = rm.Edit(clientId, table, INSERT_ACTION, int64(key), 0, int64(newval))
-	if err != nil {
-		return err
-	}
-	// Run transaction insert.
-	err = concurrency.HandleInsert(db, tm, payload, clientId)
-	if err != nil {
-		// Add a log to mark this insert as a no-op.
-		ederr := rm.Edit(clientId, table, DELETE_ACTION, int64(key), int64(newval), int64(0))
-		if ederr != nil {
-			return fmt.Errorf("error marking insert as no-op: %w", ederr)
-		}
-		// Then pop the last two actions from the transaction stack because
-		// these last two actions were no-ops.
-		stack := rm.txStack[clientId]
-		rm.txStack[clientId] = stack[:len(stack)-2]
+	// Lock the key before checking it, so that it can't change until the insert is applied.
+	if err = tm.Lock(clientId, table, int64(key), concurrency.W_LOCK); err != nil {
 		rberr := rm.Rollback(clientId)
 		if rberr != nil {
 			return rberr
 		}
+		return fmt.Errorf("insert error: %v", err)
 	}
-	return err
+	// First, check that the desired value doesn't exist.
+	_, err = table.Find(int64(key))
+	if err == nil {
+		return errors.New("insert error: key already exists")
+	}
+	// Log and run transaction insert, marking it as a no-op if it fails.
+	return rm.editAndApply(clientId, table, INSERT_ACTION, int64(key), 0, int64(newval), func() error {
+		return concurrency.HandleInsert(db, tm, payload, clientId)
+	})
 }
 
 // Handle update.
@@ -188,34 +199,23 @@ func HandleUpdate(db *database.Database, tm *concurrency.TransactionManager, rm
 	if table, err = db.GetTable(fields[1]); err != nil {
 		return fmt.Errorf("update error: %v", err)
 	}
-	// First, check that the desired value exists.
-	oldval, err := table.Find(int64(key))
-	if err != nil {
-		return errors.New("update error: key doesn't exists")
-	}
-	// Log.
-	err = rm.Edit(clientId, table, UPDATE_ACTION, int64(key), oldval.Value, int64(newval))
-	if err != nil {
-		return err
-	}
-	// Run transaction insert.
-	err = concurrency.HandleUpdate(db, tm, payload, clientId)
-	if err != nil {
-		// Add a log to mark this update as a no-op.
-		ederr := rm.Edit(clientId, table, UPDATE_ACTION, int64(key), int64(newval), oldval.Value)
-		if ederr != nil {
-			return fmt.Errorf("error marking update as no-op: %w", ederr)
-		}
-		// Then pop the last two actions from the transaction stack because
-		// these last two actions were no-ops.
-		stack := rm.txStack[clientId]
-		rm.txStack[clientId] = stack[:len(stack)-2]
+	// Lock the key before checking it, so that it can't change until the update is applied.
+	if err = tm.Lock(clientId, table, int64(key), concurrency.W_LOCK); err != nil {
 		rberr := rm.Rollback(clientId)
 		if rberr != nil {
 			return rberr
 		}
+		return fmt.Errorf("update error: %v", err)
 	}
-	return err
+	// First, check that the desired value exists.
+	oldval, err := table.Find(int64(key))
+	if err != nil {
+		return errors.New("update error: key doesn't exists")
+	}
+	// Log and run transaction update, marking it as a no-op if it fails.
+	return rm.editAndApply(clientId, table, UPDATE_ACTION, int64(key), oldval.Value, int64(newval), func() error {
+		return concurrency.HandleUpdate(db, tm, payload, clientId)
+	})
 }
 
 // Handle delete.
@@ -234,34 +234,23 @@ func HandleDelete(db *database.Database, tm *concurrency.TransactionManager, rm
 	if table, err = db.GetTable(fields[3]); err != nil {
 		return fmt.Errorf("delete error: %v", err)
 	}
-	// First, check that the desired value exists.
-	oldval, err := table.Find(int64(key))
-	if err != nil {
-		return errors.New("delete error: key doesn't exists")
-	}
-	// Log.
-	err = rm.Edit(clientId, table, DELETE_ACTION, int64(key), oldval.Value, 0)
-	if err != nil {
-		return err
-	}
-	// Run transaction insert.
-	err = concurrency.HandleDelete(db, tm, payload, clientId)
-	if err != nil {
-		// Add a log to mark this delete as a no-op.
-		ederr := rm.Edit(clientId, table, INSERT_ACTION, int64(key), 0, oldval.Value)
-		if ederr != nil {
-			return fmt.Errorf("error marking delete as no-op: %w", ederr)
-		}
-		// Then pop the last two actions from the transaction stack because
-		// these last two actions were no-ops.
-		stack := rm.txStack[clientId]
-		rm.txStack[clientId] = stack[:len(stack)-2]
+	// Lock the key before checking it, so that it can't change until the delete is applied.
+	if err = tm.Lock(clientId, table, int64(key), concurrency.W_LOCK); err != nil {
 		rberr := rm.Rollback(clientId)
 		if rberr != nil {
 			return rberr
 		}
+		return fmt.Errorf("delete error: %v", err)
 	}
-	return err
+	// First, check that the desired value exists.
+	oldval, err := table.Find(int64(key))
+	if err != nil {
+		return errors.New("delete error: key doesn't exists")
+	}
+	// Log and run transaction delete, marking it as a no-op if it fails.
+	return rm.editAndApply(clientId, table, DELETE_ACTION, int64(key), oldval.Value, 0, func() error {
+		return concurrency.HandleDelete(db, tm, payload, clientId)
+	})
 }
 
 // Handle select.
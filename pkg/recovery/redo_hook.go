@@ -0,0 +1,78 @@
+package recovery
+
+import (
+	"github.com/google/uuid"
+)
+
+/*
+   An application may keep state derived from its tables, like sequences or counters, that
+   the redo pass of recovery knows nothing about. A redo hook lets it rebuild that state as the
+   tables are: it's called for every edit and compensation log the redo pass goes over, in LSN
+   order, right after the log is redone and on the same goroutine. Logs whose edits the tables
+   already reflected are reported too, since state kept in memory is lost in a crash either way.
+   The edits of transactions recovery rolls back are then reported again, as compensations, as
+   the undo pass undoes them.
+
+   To report logs in order, redo doesn't spread tables across goroutines while a hook is set;
+   see WithRedoWorkers. The redo pass starts at the last checkpoint, so state derived from the
+   edits before it must be saved by the application when the checkpoint is taken. The same goes
+   for the edits of a table before its latest table checkpoint, which are skipped.
+*/
+
+// RedoneEdit is an edit applied to a table during recovery, as reported to a RedoHook.
+type RedoneEdit struct {
+	LSN          uint64    // The LSN of the log recording the edit
+	ClientId     uuid.UUID // The client whose transaction made the edit
+	Table        string    // The name of the table edited
+	Action       action    // The type of edit, which for a compensation is the inverse of the edit undone
+	Key          int64     // The key of the tuple edited
+	OldVal       int64     // The value before the edit
+	NewVal       int64     // The value after the edit
+	Compensation bool      // Whether the edit undoes an earlier one, as a rollback does
+}
+
+// RedoHook is called for each edit applied during recovery. An error stops recovery.
+type RedoHook func(edit RedoneEdit) error
+
+// WithRedoHook sets a function to call for each edit and compensation redone during recovery,
+// in LSN order, and for each edit recovery then rolls back; see redo_hook.go.
+func WithRedoHook(hook RedoHook) Option {
+	return func(rm *RecoveryManager) {
+		rm.redoHook = hook
+	}
+}
+
+// reportRedone calls the redo hook, if set, with the edit or compensation log just redone.
+// Other logs aren't reported.
+func (rm *RecoveryManager) reportRedone(l log) error {
+	if rm.redoHook == nil {
+		return nil
+	}
+	switch log := l.(type) {
+	case editLog:
+		return rm.redoHook(redoneEdit(log, log.getLSN(), false))
+	case clrLog:
+		return rm.redoHook(redoneEdit(log.edit.inverse(), log.getLSN(), true))
+	}
+	return nil
+}
+
+// redoneEdit returns the edit, logged with the given LSN, as reported to a redo hook.
+func redoneEdit(edit editLog, lsn uint64, compensation bool) RedoneEdit {
+	return RedoneEdit{
+		LSN:          lsn,
+		ClientId:     edit.id,
+		Table:        edit.tablename,
+		Action:       edit.action,
+		Key:          edit.key,
+		OldVal:       edit.oldval,
+		NewVal:       edit.newval,
+		Compensation: compensation,
+	}
+}
+
+// hookedLog is a log left to redo while a redo hook is set, along with the table it edits.
+type hookedLog struct {
+	table string
+	l     log
+}
@@ -0,0 +1,476 @@
+package recovery
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/otiai10/copy"
+)
+
+/*
+   Once the log file grows past the maximum log size, it is rotated: the log file is renamed to
+   the next numbered segment (db.log.000001, db.log.000002, ...) and a fresh log file is started
+   with a segment log naming the segment it follows. Logs are read across the segments in order,
+   followed by the log file itself.
+*/
+
+// WithMaxLogSize sets the size in bytes past which the log file is rotated into a segment.
+// A non-positive size never rotates the log file.
+func WithMaxLogSize(size int64) Option {
+	return func(rm *RecoveryManager) {
+		rm.maxLogSize = size
+	}
+}
+
+var segmentSuffixExp = regexp.MustCompile(`^\.(\d{6,})(?:\.gz)?$`)
+
+// segmentName returns the name of the specified log file's segment with the given number.
+func segmentName(logFilename string, n uint64) string {
+	return fmt.Sprintf("%s.%06d", logFilename, n)
+}
+
+// listSegments returns the numbers of the specified log file's segments in ascending order.
+func listSegments(logFilename string) ([]uint64, error) {
+	entries, err := os.ReadDir(filepath.Dir(logFilename))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(logFilename)
+	segments := make([]uint64, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || len(entry.Name()) <= len(base) || entry.Name()[:len(base)] != base {
+			continue
+		}
+		expStrs := segmentSuffixExp.FindStringSubmatch(entry.Name()[len(base):])
+		if expStrs == nil {
+			continue
+		}
+		n, err := strconv.ParseUint(expStrs[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, n)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	// A segment may be listed twice if it was left behind both compressed and uncompressed.
+	return slices.Compact(segments), nil
+}
+
+// lastSegmentState returns the format and last LSN of the newest segment of the specified
+// log file, or the string format and 0 if there are no segments.
+func lastSegmentState(logFilename string) (LogFormat, uint64, error) {
+	segments, err := listSegments(logFilename)
+	if err != nil || len(segments) == 0 {
+		return StringLogFormat, 0, err
+	}
+	segment, err := openSegment(segmentFile(logFilename, segments[len(segments)-1]))
+	if err != nil {
+		return StringLogFormat, 0, err
+	}
+	defer segment.Close()
+	format, err := detectLogFormat(segment, StringLogFormat)
+	if err != nil {
+		return format, 0, err
+	}
+	lsn, err := lastLSN(segment, format)
+	return format, lsn, err
+}
+
+// copyLogFiles copies the specified log file and all of its segments to dst,
+// replacing any log file or segments already there.
+func copyLogFiles(src string, dst string) error {
+	stale, err := listSegments(dst)
+	if err != nil {
+		return err
+	}
+	for _, n := range stale {
+		if err = removeSegment(dst, n); err != nil {
+			return err
+		}
+	}
+	segments, err := listSegments(src)
+	if err != nil {
+		return err
+	}
+	for _, n := range segments {
+		name := segmentFile(src, n)
+		if err = copy.Copy(name, dst+name[len(src):]); err != nil {
+			return err
+		}
+	}
+	return copy.Copy(src, dst)
+}
+
+// rotate renames the log file to the next segment and starts a fresh log file
+// headed by a segment log. Everything in the old log file is synced first.
+// If the log file can't be reopened, logging fails from then on.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) rotate() error {
+	// Keep the syncer from syncing the old log file while it is closed.
+	rm.fileMtx.Lock()
+	defer rm.fileMtx.Unlock()
+	if err := rm.syncAll(); err != nil {
+		return err
+	}
+
+	segments, err := listSegments(rm.logFilename)
+	if err != nil {
+		return err
+	}
+	next := uint64(1)
+	if len(segments) > 0 {
+		next = segments[len(segments)-1] + 1
+	}
+	if err = os.Rename(rm.logFilename, segmentName(rm.logFilename, next)); err != nil {
+		return err
+	}
+	rm.logFile.Close()
+	logFile, err := os.OpenFile(rm.logFilename, os.O_APPEND|os.O_RDWR|os.O_CREATE, rm.logFileMode)
+	if err != nil {
+		rm.syncErr = fmt.Errorf("error starting a new log file: %w", err)
+		return rm.syncErr
+	}
+	rm.logFile = logFile
+	rm.writer.Reset(logFile)
+	rm.logSize = 0
+	if err = rm.writeVersion(); err != nil {
+		return err
+	}
+
+	header := logHeader{lsn: rm.lsn + 1, timestamp: rm.nextTimestamp()}
+	record := rm.serialize(nil, header, segmentLog{prev: next})
+	if _, err = rm.writer.Write(record); err != nil {
+		return err
+	}
+	rm.lsn = header.lsn
+	rm.logSize += int64(len(record))
+	if rm.compressSegments {
+		return compressSegment(segmentName(rm.logFilename, next), rm.logFileMode)
+	}
+	return nil
+}
+
+// TruncateBeforeCheckpoint reclaims disk space by removing every log written before the most
+// recent checkpoint, which are redundant since the database can be restored from that
+// checkpoint's backup. Logs still needed to undo a transaction that hasn't finished,
+// starting from its start log, are kept. Segments that are no longer needed are deleted,
+// and the segment or log file holding the oldest needed log is rewritten to start with it.
+// Does nothing if there is no checkpoint.
+func (rm *RecoveryManager) TruncateBeforeCheckpoint() error {
+	if rm.storage != nil {
+		return ErrNotLogFile
+	}
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	// Make sure the checkpoint is durable before dropping the logs preceding it.
+	rm.fileMtx.Lock()
+	err := rm.syncAll()
+	rm.fileMtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	scanner, err := rm.newLogScanner()
+	if err != nil {
+		return err
+	}
+	defer scanner.close()
+	// Scanning backward, a transaction's commit or abort is seen before its start, so any
+	// start without one belongs to a transaction that may still need to be undone.
+	finished := make(map[uuid.UUID]bool)
+	checkpointHit := false
+	var finder checkpointFinder
+	cutFile, cutOffset := -1, int64(0)
+	for {
+		record, offset, err := scanner.prev()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		l, err := scanner.parse(record, offset)
+		if err != nil {
+			return err
+		}
+		switch log := l.(type) {
+		case commitLog:
+			finished[log.id] = true
+		case abortLog:
+			finished[log.id] = true
+		case reclaimLog:
+			// The transaction finished under the id it was reclaimed by.
+			if finished[log.to] {
+				delete(finished, log.to)
+				finished[log.id] = true
+			}
+		case startLog:
+			if finished[log.id] {
+				delete(finished, log.id)
+			} else if checkpointHit {
+				cutFile, cutOffset = scanner.idx, offset
+			}
+		default:
+			if _, ok := finder.checkpoint(l); ok && !checkpointHit {
+				checkpointHit = true
+				cutFile, cutOffset = scanner.idx, offset
+			}
+		}
+	}
+	if !checkpointHit {
+		return nil
+	}
+
+	// Files are ordered newest first, so everything after the cut file is redundant.
+	for _, name := range scanner.files[cutFile+1:] {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if cutOffset == 0 {
+		return nil
+	}
+	if cutFile > 0 {
+		return truncateFront(scanner.files[cutFile], cutOffset, rm.logFileMode)
+	}
+	// Replace the log file itself, keeping the syncer away from it in the meantime.
+	rm.fileMtx.Lock()
+	defer rm.fileMtx.Unlock()
+	if err = truncateFront(rm.logFilename, cutOffset, rm.logFileMode); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(rm.logFilename, os.O_APPEND|os.O_RDWR|os.O_CREATE, rm.logFileMode)
+	if err != nil {
+		rm.syncErr = fmt.Errorf("error reopening the log file: %w", err)
+		return rm.syncErr
+	}
+	rm.logFile.Close()
+	rm.logFile = logFile
+	rm.writer.Reset(logFile)
+	rm.logSize -= cutOffset
+	return nil
+}
+
+// truncateAfter discards every log written after the given time from the end of the log
+// file and its segments. If that leaves the log file empty, the segment holding the last log
+// that is kept becomes the log file.
+func (rm *RecoveryManager) truncateAfter(ts time.Time) error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	rm.fileMtx.Lock()
+	defer rm.fileMtx.Unlock()
+	if err := rm.syncAll(); err != nil {
+		return err
+	}
+
+	scanner, err := rm.newLogScanner()
+	if err != nil {
+		return err
+	}
+	defer scanner.close()
+	cutFile, cutOffset := 0, rm.logSize
+	for {
+		record, offset, err := scanner.prev()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		l, err := scanner.parse(record, offset)
+		if err != nil {
+			return err
+		}
+		if !l.getTime().After(ts) {
+			break
+		}
+		cutFile, cutOffset = scanner.idx, offset
+	}
+	scanner.close()
+	if cutFile == 0 && cutOffset == rm.logSize {
+		return nil
+	}
+
+	// Files are ordered newest first, so everything before the cut file is discarded.
+	cut := scanner.files[cutFile]
+	if isCompressed(cut) {
+		// A compressed segment can't be truncated in place, so its logs are decompressed.
+		if cut, err = decompressPrefix(cut, cutOffset, rm.logFileMode); err != nil {
+			return err
+		}
+	} else if err = os.Truncate(cut, cutOffset); err != nil {
+		return err
+	}
+	if cutFile > 0 {
+		for _, name := range scanner.files[1:cutFile] {
+			if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err = os.Rename(cut, rm.logFilename); err != nil {
+			return err
+		}
+	}
+	logFile, err := os.OpenFile(rm.logFilename, os.O_APPEND|os.O_RDWR|os.O_CREATE, rm.logFileMode)
+	if err != nil {
+		rm.syncErr = fmt.Errorf("error reopening the log file: %w", err)
+		return rm.syncErr
+	}
+	rm.logFile.Close()
+	rm.logFile = logFile
+	rm.writer.Reset(logFile)
+	rm.logSize = cutOffset
+	return nil
+}
+
+// truncateFront atomically rewrites the specified file to drop its first n bytes,
+// by writing the rest of the file to a temporary file and renaming it over the original.
+// A compressed segment stays compressed, with n counting its uncompressed bytes.
+func truncateFront(name string, n int64, mode os.FileMode) error {
+	src, err := openSegment(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if _, err = src.Seek(n, io.SeekStart); err != nil {
+		return err
+	}
+	return writeSegment(name, mode, func(w io.Writer) error {
+		_, err := io.Copy(w, src)
+		return err
+	}, nil)
+}
+
+// logScanner reads the records of the log file and then each of its segments backward,
+// starting from the end of the log file.
+type logScanner struct {
+	logFile   LogStorage    // The open log file, which the scanner leaves open
+	logFormat LogFormat     // The format of the log file, and the default for its segments
+	files     []string      // The names of the files to scan, newest first
+	idx       int           // The index of the file being scanned
+	file      LogStorage    // The file being scanned
+	format    LogFormat     // The format of the file being scanned
+	aead      cipher.AEAD   // Decrypts sealed logs, or nil if there is no encryption key
+	scanner   recordScanner // The scanner over the file being scanned
+	prefetch  *logPrefetch  // Reads the files, if set; see prefetch.go
+}
+
+// newLogScanner returns a scanner over the log file and its segments.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) newLogScanner() (*logScanner, error) {
+	if rm.storage != nil {
+		// A log kept elsewhere has no segments.
+		return &logScanner{logFile: rm.logFile, logFormat: rm.format, aead: rm.aead, files: []string{rm.logFilename}}, nil
+	}
+	return scanLogFiles(rm.logFilename, rm.logFile, rm.format, rm.aead)
+}
+
+// scanLogFiles returns a scanner over the specified log file, which must already be open
+// in the given format, and its segments, decrypting sealed logs with the given cipher.
+func scanLogFiles(logFilename string, logFile LogStorage, format LogFormat, aead cipher.AEAD) (*logScanner, error) {
+	segments, err := listSegments(logFilename)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{logFilename}
+	for i := len(segments) - 1; i >= 0; i-- {
+		files = append(files, segmentFile(logFilename, segments[i]))
+	}
+	return &logScanner{logFile: logFile, logFormat: format, aead: aead, files: files}, nil
+}
+
+// prev returns the previous record and its byte offset in the file being scanned,
+// or io.EOF once the start of the oldest segment is reached.
+func (s *logScanner) prev() ([]byte, int64, error) {
+	for s.idx < len(s.files) {
+		if s.scanner == nil {
+			if err := s.open(); err != nil {
+				return nil, 0, err
+			}
+		}
+		record, offset, err := s.scanner.prev()
+		if err != io.EOF {
+			return record, offset, err
+		}
+		s.closeFile()
+		s.idx++
+	}
+	return nil, 0, io.EOF
+}
+
+// open starts scanning the file at s.idx.
+func (s *logScanner) open() (err error) {
+	if s.idx == 0 {
+		s.file, s.format = s.logFile, s.logFormat
+		if s.prefetch != nil {
+			if s.file, err = s.prefetch.wrap(s.files[0], s.logFile, false); err != nil {
+				return err
+			}
+		}
+	} else {
+		var file LogStorage
+		if s.prefetch != nil {
+			file, err = s.prefetch.open(s.files[s.idx])
+		} else {
+			file, err = openSegment(s.files[s.idx])
+		}
+		if err != nil {
+			return err
+		}
+		s.file = file
+		s.format, err = detectLogFormat(s.file, s.logFormat)
+		if err == nil {
+			err = checkFileVersion(s.file, s.format)
+		}
+		if err != nil {
+			s.closeFile()
+			return err
+		}
+	}
+	s.scanner, err = newRecordScanner(s.file, s.format)
+	if err != nil {
+		s.closeFile()
+	}
+	return err
+}
+
+// parse converts the raw record found at the specified byte offset of the file being scanned
+// to its respective struct, returning a CorruptLogError if the log could not be parsed
+// or failed its checksum.
+func (s *logScanner) parse(record []byte, offset int64) (log, error) {
+	log, err := parseRecord(record, s.format)
+	if err == nil {
+		log, err = unseal(s.aead, log, s.format)
+	}
+	if err != nil {
+		return nil, &CorruptLogError{File: s.files[s.idx], Offset: offset, Line: string(record), Err: err}
+	}
+	return log, nil
+}
+
+// closeFile closes the file being scanned, unless it is the log file itself.
+func (s *logScanner) closeFile() {
+	if s.file != nil && s.idx > 0 {
+		s.file.Close()
+	}
+	s.file = nil
+	s.scanner = nil
+}
+
+// close releases any file still being scanned.
+func (s *logScanner) close() {
+	s.closeFile()
+}
@@ -0,0 +1,163 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"dinodb/pkg/entry"
+
+	"github.com/google/uuid"
+)
+
+/*
+   A Snapshot reads the database as it was when a given log was written, consulting the log
+   rather than taking locks, so that a long-running read, such as a report, never blocks
+   writers, nor is blocked by them. A snapshot as of LSN S sees the edits of every transaction
+   that committed at or before S, and nothing else.
+
+   Reading a key scans the log forward for the edits of that key. The key's value in the
+   snapshot is that of the last edit of the last transaction that committed at or before S;
+   edits are applied when their transaction's commit log is read, as CompactLog does. If no
+   such transaction edited the key, no committed edit changed it before S, so its value is the
+   before-image of the first edit of it in the log, made under a write lock and therefore
+   committed, or, if the log never edits it, its value in the table now, read before the log
+   is scanned. Creating or dropping the table at or before S empties it.
+
+   A table created or dropped after S can't be read through, since its logs describe another
+   table; a read that can't tell the key's value otherwise returns ErrSnapshotUnavailable, as
+   does a read of a table that no longer exists. History discarded by CompactLog can't be read
+   either, so a snapshot from before a compaction may see later values.
+
+   Every read scans the whole log, so a snapshot is suited to reading a few keys of a large
+   database, rather than every key.
+*/
+
+// ErrSnapshotUnavailable is returned when the log no longer tells a key's value in a snapshot.
+var ErrSnapshotUnavailable = errors.New("key's value in the snapshot is unavailable")
+
+// ErrNotInSnapshot is returned when a key was not present in a snapshot.
+var ErrNotInSnapshot = errors.New("key not present in the snapshot")
+
+// Snapshot reads the database as of an LSN; see snapshot.go.
+type Snapshot struct {
+	rm  *RecoveryManager
+	lsn uint64
+}
+
+// Snapshot returns a snapshot of the database as of the most recently flushed log.
+func (rm *RecoveryManager) Snapshot() *Snapshot {
+	return rm.SnapshotAt(rm.GetLSN())
+}
+
+// SnapshotAt returns a snapshot of the database as of the log with the given LSN, seeing the
+// edits of every transaction that committed at or before it.
+func (rm *RecoveryManager) SnapshotAt(lsn uint64) *Snapshot {
+	return &Snapshot{rm: rm, lsn: lsn}
+}
+
+// LSN returns the LSN the snapshot is as of.
+func (s *Snapshot) LSN() uint64 {
+	return s.lsn
+}
+
+// Find returns the entry with the given key in the specified table as of the snapshot, or
+// ErrNotInSnapshot if the key wasn't present. Takes no locks.
+func (s *Snapshot) Find(tableName string, key int64) (entry.Entry, error) {
+	// Read the key's current value before the log, so that any edit made since is in the log.
+	var current keyState
+	table, tableErr := s.rm.db.GetTable(tableName)
+	if tableErr == nil {
+		if e, err := table.Find(key); err == nil {
+			current = keyState{present: true, val: e.Value}
+		}
+	}
+	cursor, err := s.rm.NewLogCursor(0)
+	if err != nil {
+		return entry.Entry{}, err
+	}
+	defer cursor.Close()
+
+	var (
+		value       keyState                    // The key's state as of the snapshot, if known
+		known       bool                        // Whether value is known
+		firstBefore *keyState                   // The before-image of the first edit of the key since the table was created
+		pending     = map[uuid.UUID][]editLog{} // The edits of the key by each transaction, until it commits
+		changed     bool                        // Whether the table was created or dropped after the snapshot
+	)
+	for {
+		l, err := cursor.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return entry.Entry{}, err
+		}
+		after := l.getLSN() > s.lsn
+		name := ""
+		switch log := l.(type) {
+		case tableLog:
+			name = qualify(log.db, log.tblName)
+		case dropTableLog:
+			name = qualify(log.db, log.tblName)
+		}
+		if name == tableName {
+			// Later logs of the table describe another table.
+			if after {
+				changed = true
+				break
+			}
+			value, known, firstBefore = keyState{}, true, nil
+			clear(pending)
+			continue
+		}
+		switch log := l.(type) {
+		case editLog:
+			if qualify(log.db, log.tablename) != tableName || log.key != key {
+				continue
+			}
+			if firstBefore == nil && !known {
+				firstBefore = &keyState{present: log.action != INSERT_ACTION, val: log.oldval}
+			}
+			if !after {
+				pending[log.id] = append(pending[log.id], log)
+			}
+		case clrLog:
+			if qualify(log.edit.db, log.edit.tablename) != tableName || log.edit.key != key || after {
+				continue
+			}
+			pending[log.edit.id] = append(pending[log.edit.id], log.edit.inverse())
+		case commitLog:
+			if after {
+				continue
+			}
+			for _, el := range pending[log.id] {
+				value = keyState{present: el.action != DELETE_ACTION, val: el.newval}
+				known = true
+			}
+			delete(pending, log.id)
+		case abortLog:
+			delete(pending, log.id)
+		case reclaimLog:
+			if !after {
+				pending[log.to] = pending[log.id]
+				delete(pending, log.id)
+			}
+		}
+	}
+
+	switch {
+	case known:
+	case firstBefore != nil:
+		value = *firstBefore
+	case changed:
+		return entry.Entry{}, fmt.Errorf("table %s was created or dropped since the snapshot: %w", tableName, ErrSnapshotUnavailable)
+	case tableErr != nil:
+		return entry.Entry{}, fmt.Errorf("%w: %w", ErrSnapshotUnavailable, tableErr)
+	default:
+		value = current
+	}
+	if !value.present {
+		return entry.Entry{}, ErrNotInSnapshot
+	}
+	return entry.New(key, value.val), nil
+}
@@ -0,0 +1,139 @@
+package recovery
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// The number of shards the undo stacks are spread across.
+const stackShards = 32
+
+// txStacks keeps track of the edits of all uncommitted transactions, mapping each
+// client/transaction id to a stack of its edit logs. The clients are spread across shards
+// with a lock each, so that clients editing at once don't contend on a single lock.
+type txStacks struct {
+	shards [stackShards]stackShard
+}
+
+// stackShard holds the stacks of the clients whose ids hash to it.
+type stackShard struct {
+	mtx    sync.Mutex
+	stacks map[uuid.UUID][]editLog
+	starts map[uuid.UUID]uint64 // The LSN of each client's Start log
+}
+
+// newTxStacks returns an empty set of stacks.
+func newTxStacks() *txStacks {
+	ts := &txStacks{}
+	for i := range ts.shards {
+		ts.shards[i].stacks = make(map[uuid.UUID][]editLog)
+		ts.shards[i].starts = make(map[uuid.UUID]uint64)
+	}
+	return ts
+}
+
+// shard returns the shard holding the stack of the specified client.
+func (ts *txStacks) shard(clientId uuid.UUID) *stackShard {
+	return &ts.shards[binary.BigEndian.Uint32(clientId[12:])%stackShards]
+}
+
+// get returns the client's stack, and whether the client has one.
+func (ts *txStacks) get(clientId uuid.UUID) ([]editLog, bool) {
+	s := ts.shard(clientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	stack, ok := s.stacks[clientId]
+	return stack, ok
+}
+
+// set replaces the client's stack.
+func (ts *txStacks) set(clientId uuid.UUID, stack []editLog) {
+	s := ts.shard(clientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stacks[clientId] = stack
+}
+
+// touch gives the client an empty stack if it doesn't have one yet, along with the LSN
+// of the Start log its first edit is chained to.
+func (ts *txStacks) touch(clientId uuid.UUID, startLSN uint64) {
+	s := ts.shard(clientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if _, ok := s.stacks[clientId]; !ok {
+		s.stacks[clientId] = []editLog{}
+		s.starts[clientId] = startLSN
+	}
+}
+
+// prevLSN returns the LSN the client's next edit is chained to: that of the edit on top of
+// its stack, or of its Start log if the stack is empty. Returns 0 for a client whose Start
+// log isn't known, such as a transaction rebuilt by recovery with no edits left to undo.
+func (ts *txStacks) prevLSN(clientId uuid.UUID) uint64 {
+	s := ts.shard(clientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if stack := s.stacks[clientId]; len(stack) > 0 {
+		return stack[len(stack)-1].lsn
+	}
+	return s.starts[clientId]
+}
+
+// push pushes edits onto the client's stack, all at once.
+func (ts *txStacks) push(clientId uuid.UUID, edits ...editLog) {
+	s := ts.shard(clientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stacks[clientId] = append(s.stacks[clientId], edits...)
+}
+
+// remove discards the client's stack.
+func (ts *txStacks) remove(clientId uuid.UUID) {
+	s := ts.shard(clientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	delete(s.stacks, clientId)
+	delete(s.starts, clientId)
+}
+
+// rename moves the stack of oldClientId, and the LSN of its Start log, over to newClientId.
+// The stack's edits become edits of newClientId, so that they're undone under it.
+func (ts *txStacks) rename(oldClientId uuid.UUID, newClientId uuid.UUID) {
+	s := ts.shard(oldClientId)
+	s.mtx.Lock()
+	stack, ok := s.stacks[oldClientId]
+	start := s.starts[oldClientId]
+	delete(s.stacks, oldClientId)
+	delete(s.starts, oldClientId)
+	s.mtx.Unlock()
+	if !ok {
+		return
+	}
+	renamed := make([]editLog, len(stack))
+	for i, edit := range stack {
+		edit.id = newClientId
+		renamed[i] = edit
+	}
+	s = ts.shard(newClientId)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.stacks[newClientId] = renamed
+	s.starts[newClientId] = start
+}
+
+// each calls fn with every client's stack, one shard at a time, stopping once fn returns false.
+func (ts *txStacks) each(fn func(clientId uuid.UUID, stack []editLog) bool) {
+	for i := range ts.shards {
+		s := &ts.shards[i]
+		s.mtx.Lock()
+		for id, stack := range s.stacks {
+			if !fn(id, stack) {
+				s.mtx.Unlock()
+				return
+			}
+		}
+		s.mtx.Unlock()
+	}
+}
@@ -0,0 +1,127 @@
+package recovery
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/google/uuid"
+)
+
+/*
+   Subscribers receive the log's committed records as they become durable, so that a follower
+   can apply them for near-real-time replication. Each record is streamed exactly as it was
+   written to the log file, in the log file's format and encrypted if the log is.
+
+   While there are subscribers, flushLog holds back the records of each transaction until it
+   commits, at which point they are queued along with the commit log; an abort discards them.
+   Table and drop logs are queued as they are flushed. Queued records are written to every
+   subscriber once they are durable, so a follower never sees a record that a crash could lose.
+   Transactions are streamed whole, in the order they committed, so each transaction's records
+   arrive in LSN order. A transaction that began before a subscriber subscribed is not streamed
+   to it, nor are checkpoint or segment logs.
+*/
+
+// shippedRecord is a record held back or queued for subscribers.
+type shippedRecord struct {
+	lsn    uint64
+	record []byte
+}
+
+// Subscribe streams every committed record flushed from now on to w once it is durable; see
+// subscribe.go. Writes to w happen while logging is held up, so w should be fast, such as a
+// buffer or a pipe that is drained promptly. A subscriber whose write fails is unsubscribed.
+// w must be comparable, so that it can be passed to Unsubscribe.
+func (rm *RecoveryManager) Subscribe(w io.Writer) error {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	if rm.closed {
+		return ErrClosed
+	}
+	if rm.shipPending == nil {
+		rm.shipPending = make(map[uuid.UUID][]shippedRecord)
+	}
+	rm.subscribers = append(rm.subscribers, w)
+	return nil
+}
+
+// Unsubscribe stops streaming records to w. Nothing is written to w once Unsubscribe returns.
+func (rm *RecoveryManager) Unsubscribe(w io.Writer) {
+	rm.mtx.Lock()
+	defer rm.mtx.Unlock()
+	rm.unsubscribe(w)
+}
+
+// unsubscribe removes w from the subscribers, forgetting what was held back for them once
+// none are left. Expects rm.mtx to be locked.
+func (rm *RecoveryManager) unsubscribe(w io.Writer) {
+	for i, sub := range rm.subscribers {
+		if sub == w {
+			rm.subscribers = append(rm.subscribers[:i], rm.subscribers[i+1:]...)
+			break
+		}
+	}
+	if len(rm.subscribers) == 0 {
+		rm.subscribers = nil
+		rm.shipPending = nil
+		rm.shipReady = nil
+	}
+}
+
+// ship holds back or queues the flushed record of the specified log for subscribers, if there
+// are any. Expects rm.mtx to be locked.
+func (rm *RecoveryManager) ship(l log, lsn uint64, record []byte) {
+	if len(rm.subscribers) == 0 {
+		return
+	}
+	// The record's buffer is reused once it is flushed, so hold on to a copy.
+	shipped := shippedRecord{lsn: lsn, record: bytes.Clone(record)}
+	hold := func(id uuid.UUID) {
+		if pending, ok := rm.shipPending[id]; ok {
+			rm.shipPending[id] = append(pending, shipped)
+		}
+	}
+	switch log := l.(type) {
+	case tableLog, dropTableLog:
+		rm.shipReady = append(rm.shipReady, shipped)
+	case startLog:
+		rm.shipPending[log.id] = []shippedRecord{shipped}
+	case editLog:
+		hold(log.id)
+	case clrLog:
+		hold(log.edit.id)
+	case commitLog:
+		if pending, ok := rm.shipPending[log.id]; ok {
+			rm.shipReady = append(append(rm.shipReady, pending...), shipped)
+			delete(rm.shipPending, log.id)
+		}
+	case abortLog:
+		delete(rm.shipPending, log.id)
+	case reclaimLog:
+		if pending, ok := rm.shipPending[log.id]; ok {
+			rm.shipPending[log.to] = append(pending, shipped)
+			delete(rm.shipPending, log.id)
+		}
+	}
+}
+
+// publish writes the queued records that are durable to every subscriber, in order.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) publish() {
+	n := 0
+	for n < len(rm.shipReady) && rm.shipReady[n].lsn <= rm.syncedLSN {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	ready := rm.shipReady[:n]
+	rm.shipReady = rm.shipReady[n:]
+	for _, w := range append([]io.Writer(nil), rm.subscribers...) {
+		for _, shipped := range ready {
+			if _, err := w.Write(shipped.record); err != nil {
+				rm.unsubscribe(w)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package recovery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrLSNOrder is reported for a log whose LSN doesn't exceed the LSN of the log before it.
+	ErrLSNOrder = errors.New("LSN does not increase")
+	// ErrUnknownTable is reported for an edit of a table that was never created or was dropped.
+	ErrUnknownTable = errors.New("table does not exist")
+	// ErrUnknownTransaction is reported for a log of a transaction that isn't running,
+	// either because it never started or because it already ended.
+	ErrUnknownTransaction = errors.New("transaction is not running")
+	// ErrBadUndoNext is reported for a compensation log whose undoNext LSN isn't an edit
+	// of its transaction that is still left to undo.
+	ErrBadUndoNext = errors.New("undoNext is not an edit left to undo")
+)
+
+// LogProblem is a problem found in the log by VerifyLog.
+type LogProblem struct {
+	LSN uint64 // The LSN of the offending log, or 0 if it couldn't be parsed
+	Err error  // What is wrong with the log, possibly a *CorruptLogError
+}
+
+func (p LogProblem) String() string {
+	if p.LSN == 0 {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("log %d: %v", p.LSN, p.Err)
+}
+
+// LogReport is the result of checking the log with VerifyLog.
+type LogReport struct {
+	Logs       int          // The number of logs read, including those that couldn't be parsed
+	Problems   []LogProblem // Every problem found, in log order
+	Unfinished []uuid.UUID  // The transactions recovery would roll back, in order of their start
+}
+
+// OK returns whether no problems were found.
+func (r *LogReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *LogReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d logs, %d problems, %d unfinished transactions\n", r.Logs, len(r.Problems), len(r.Unfinished))
+	for _, p := range r.Problems {
+		fmt.Fprintln(&sb, p)
+	}
+	return sb.String()
+}
+
+// VerifyLog checks every log in the log file and its segments, oldest first, without
+// redoing or undoing anything, so that problems can be found before recovering. Each log
+// must parse and pass its checksum, have a greater LSN than the log before it, and belong
+// to a running transaction if it is an edit, compensation, commit, or abort. Edits must be
+// of a table created earlier in the log or already in the database, and not dropped since,
+// and compensations must point at an edit still left to undo. The problems found are
+// returned in the report; an error is only returned if the log couldn't be read at all.
+func (rm *RecoveryManager) VerifyLog() (*LogReport, error) {
+	cursor, err := rm.NewLogCursor(0)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close()
+
+	report := &LogReport{}
+	problem := func(l log, err error) {
+		report.Problems = append(report.Problems, LogProblem{LSN: l.getLSN(), Err: err})
+	}
+	// Whether each table created or dropped so far exists.
+	tables := make(map[string]bool)
+	stacks := make(map[uuid.UUID][]editLog)
+	var order []uuid.UUID
+	begin := func(id uuid.UUID) {
+		order = append(order, id)
+		stacks[id] = []editLog{}
+	}
+	// A log truncated by TruncateBeforeCheckpoint can start partway through transactions
+	// that finish before the checkpoint, so their logs are only checked after it.
+	var lastLSN uint64
+	truncated, checkpointHit := false, false
+	running := func(l log, id uuid.UUID) bool {
+		if _, ok := stacks[id]; ok {
+			return true
+		}
+		if truncated && !checkpointHit {
+			return false
+		}
+		problem(l, fmt.Errorf("%w: %v", ErrUnknownTransaction, id))
+		return false
+	}
+	edited := func(l log, el editLog) {
+		name := qualify(el.db, el.tablename)
+		if exists, ok := tables[name]; ok {
+			if !exists {
+				problem(l, fmt.Errorf("%w: %s was dropped", ErrUnknownTable, name))
+			}
+			return
+		}
+		db, err := rm.database(el.db)
+		if err == nil {
+			_, err = db.GetTable(el.tablename)
+		}
+		if err == nil {
+			tables[name] = true
+			return
+		}
+		problem(l, fmt.Errorf("%w: %s", ErrUnknownTable, name))
+	}
+	for {
+		l, err := cursor.Next()
+		var corrupt *CorruptLogError
+		if err == io.EOF {
+			break
+		} else if errors.As(err, &corrupt) {
+			report.Logs++
+			report.Problems = append(report.Problems, LogProblem{Err: corrupt})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		report.Logs++
+
+		// Logs written before LSNs were introduced have an LSN of 0.
+		if lsn := l.getLSN(); lsn != 0 {
+			if report.Logs == 1 && lsn > 1 {
+				truncated = true
+			}
+			if lsn <= lastLSN {
+				problem(l, fmt.Errorf("%w: %d follows %d", ErrLSNOrder, lsn, lastLSN))
+			}
+			lastLSN = max(lastLSN, lsn)
+		}
+
+		switch log := l.(type) {
+		case tableLog:
+			tables[qualify(log.db, log.tblName)] = true
+		case dropTableLog:
+			tables[qualify(log.db, log.tblName)] = false
+		case startLog:
+			if _, ok := stacks[log.id]; ok {
+				problem(l, fmt.Errorf("transaction %v started twice", log.id))
+			}
+			begin(log.id)
+		case commitLog:
+			if running(l, log.id) {
+				delete(stacks, log.id)
+			}
+		case abortLog:
+			if running(l, log.id) {
+				if len(stacks[log.id]) > 0 {
+					problem(l, fmt.Errorf("transaction %v aborted with %d edits left to undo", log.id, len(stacks[log.id])))
+				}
+				delete(stacks, log.id)
+			}
+		case reclaimLog:
+			if running(l, log.id) {
+				if _, ok := stacks[log.to]; ok {
+					problem(l, fmt.Errorf("transaction %v reclaimed by running transaction %v", log.id, log.to))
+				}
+				order = append(order, log.to)
+				stacks[log.to] = stacks[log.id]
+				delete(stacks, log.id)
+			}
+		case checkpointLog:
+			checkpointHit = true
+			for _, id := range log.ids {
+				if _, ok := stacks[id]; !ok {
+					begin(id)
+				}
+			}
+		case beginCheckpointLog:
+			checkpointHit = true
+			for _, id := range log.ids {
+				if _, ok := stacks[id]; !ok {
+					begin(id)
+				}
+			}
+		case editLog:
+			edited(l, log)
+			if running(l, log.id) {
+				stacks[log.id] = append(stacks[log.id], log)
+			}
+		case clrLog:
+			edited(l, log.edit)
+			if running(l, log.edit.id) {
+				stack := stacks[log.edit.id]
+				if log.undoNext != 0 && !containsLSN(stack, log.undoNext) {
+					problem(l, fmt.Errorf("%w: %d", ErrBadUndoNext, log.undoNext))
+				}
+				stacks[log.edit.id] = truncateStack(stack, log.undoNext)
+			}
+		}
+	}
+	for _, id := range order {
+		if _, ok := stacks[id]; ok {
+			report.Unfinished = append(report.Unfinished, id)
+			delete(stacks, id)
+		}
+	}
+	return report, nil
+}
+
+// containsLSN returns whether the stack holds the edit with the specified LSN.
+func containsLSN(stack []editLog, lsn uint64) bool {
+	for _, el := range stack {
+		if el.lsn == lsn {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package recovery
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"dinodb/pkg/entry"
+)
+
+/*
+   VerifyBackup checks that the backup is good for recovery before it is needed. It restores a
+   copy of the backup into a temporary folder and redoes the logs since its checkpoint onto the
+   copy, as recovery would, but without undoing the transactions still running: their edits are
+   in the live tables as well. Every table of the copy is then compared against the live table
+   key by key.
+
+   Edits are held up throughout by holding the checkpoint mutex and the apply mutex of every
+   table, so that the log and the live tables stay in step. Tables must not be created or
+   dropped in the meantime.
+*/
+
+// ErrBackupDiverges is returned by VerifyBackup when the backup and the log don't reconstruct
+// the live database.
+var ErrBackupDiverges = errors.New("backup does not reconstruct the database")
+
+// VerifyBackup confirms that restoring the latest backup and redoing the logs written since its
+// checkpoint reconstructs the live database. Otherwise, the returned error wraps
+// ErrBackupDiverges and names the backup file of the first table that differs.
+func (rm *RecoveryManager) VerifyBackup() error {
+	if len(rm.dbs) > 0 {
+		return ErrSharedLog
+	}
+	rm.checkpointMtx.Lock()
+	defer rm.checkpointMtx.Unlock()
+	base := strings.TrimSuffix(rm.db.GetBasePath(), "/")
+	backup := rm.backupFolderFor(base)
+	if !isComplete(backup) {
+		return fmt.Errorf("no complete backup in %s", backup)
+	}
+	tables := rm.db.GetTables()
+	names := slices.Sorted(maps.Keys(tables))
+	for _, name := range names {
+		mtx := rm.tableMutex("", name)
+		mtx.Lock()
+		defer mtx.Unlock()
+	}
+	logs, checkpointIndex, err := rm.readLogs()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.MkdirTemp("", "dinodb-verify-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	restored, err := restoreFolder(backup, filepath.Join(tmp, "db"))
+	if err != nil {
+		return fmt.Errorf("error restoring backup %s: %w", backup, err)
+	}
+	defer restored.Close()
+	verifier := &RecoveryManager{db: restored, redoWorkers: 1}
+	if _, err = verifier.redoFromCheckpoint(context.Background(), logs, checkpointIndex, nil); err != nil {
+		return fmt.Errorf("%w: error redoing the log onto %s: %w", ErrBackupDiverges, backup, err)
+	}
+
+	restoredTables := restored.GetTables()
+	for _, name := range names {
+		file := filepath.Join(backup, name)
+		restoredTable, ok := restoredTables[name]
+		if !ok {
+			return fmt.Errorf("%w: %s: table is missing from the backup", ErrBackupDiverges, file)
+		}
+		expected, err := tables[name].Select()
+		if err != nil {
+			return fmt.Errorf("error reading table %s: %w", name, err)
+		}
+		actual, err := restoredTable.Select()
+		if err != nil {
+			return fmt.Errorf("%w: %s: error reading table: %w", ErrBackupDiverges, file, err)
+		}
+		if diff := diffEntries(expected, actual); diff != "" {
+			return fmt.Errorf("%w: %s: %s", ErrBackupDiverges, file, diff)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(restoredTables)) {
+		if _, ok := tables[name]; !ok {
+			return fmt.Errorf("%w: %s: table is not in the database", ErrBackupDiverges,
+				filepath.Join(backup, name))
+		}
+	}
+	return nil
+}
+
+// diffEntries describes the first key, in key order, on which the entries of a live table and
+// those of its restored copy differ, or returns "" if they hold the same entries.
+func diffEntries(expected []entry.Entry, actual []entry.Entry) string {
+	byKey := func(a, b entry.Entry) int { return cmp.Compare(a.Key, b.Key) }
+	slices.SortFunc(expected, byKey)
+	slices.SortFunc(actual, byKey)
+	i, j := 0, 0
+	for i < len(expected) || j < len(actual) {
+		switch {
+		case j == len(actual) || (i < len(expected) && expected[i].Key < actual[j].Key):
+			return fmt.Sprintf("key %d is missing from the backup", expected[i].Key)
+		case i == len(expected) || actual[j].Key < expected[i].Key:
+			return fmt.Sprintf("key %d is not in the database", actual[j].Key)
+		case expected[i].Value != actual[j].Value:
+			return fmt.Sprintf("key %d is %d in the backup, but %d in the database",
+				expected[i].Key, actual[j].Value, expected[i].Value)
+		}
+		i++
+		j++
+	}
+	return ""
+}
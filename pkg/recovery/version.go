@@ -0,0 +1,105 @@
+package recovery
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+   Every new log file, including one started by rotation or compaction, is headed by a version
+   log recording the version of the log format it was written in, along with flags for the
+   optional features its logs use. A log file written in a version or with a feature this version
+   doesn't know is rejected with ErrUnsupportedLogVersion when the recovery manager is constructed
+   or the file is read, rather than having its logs misparsed. Reading such a log file takes an
+   explicit migration to the current version first.
+
+   The version log has no LSN of its own and is written unsealed, so that it can be checked
+   without the encryption key. Log files written before version logs were introduced, and
+   segments whose version log was truncated away, are read as the current version.
+*/
+
+// ErrUnsupportedLogVersion is returned when a log file was written in a version of the log
+// format, or with a feature, that this version can't read.
+var ErrUnsupportedLogVersion = errors.New("unsupported log version")
+
+// The version of the log format written by this version.
+const logVersion uint32 = 1
+
+// Flags for the optional features a log file may use.
+const (
+	featureEncrypted  uint32 = 1 << iota // Logs may be sealed; see encryption.go
+	featureDatabases                     // Tables may be qualified by a database id; see databases.go
+	supportedFeatures = featureEncrypted | featureDatabases
+)
+
+// check returns ErrUnsupportedLogVersion if the log file headed by the version log can't be read.
+func (vl versionLog) check() error {
+	if vl.version != logVersion {
+		return fmt.Errorf("%w: log version %d, expected %d", ErrUnsupportedLogVersion, vl.version, logVersion)
+	}
+	if unknown := vl.features &^ supportedFeatures; unknown != 0 {
+		return fmt.Errorf("%w: unknown log features %#x", ErrUnsupportedLogVersion, unknown)
+	}
+	return nil
+}
+
+// versionRecord returns the version log heading a new log file, serialized in the log file's
+// format. Expects rm.mtx to be locked.
+func (rm *RecoveryManager) versionRecord() []byte {
+	vl := versionLog{logHeader: logHeader{timestamp: rm.nextTimestamp()}, version: logVersion, features: rm.features()}
+	return appendRecord(nil, vl.logHeader, vl, rm.format, rm.checksums)
+}
+
+// features returns the flags for the optional features the recovery manager's logs use.
+func (rm *RecoveryManager) features() uint32 {
+	var features uint32
+	if rm.aead != nil {
+		features |= featureEncrypted
+	}
+	if len(rm.dbs) > 0 {
+		features |= featureDatabases
+	}
+	return features
+}
+
+// writeVersion writes the version log heading a new log file to the log file, which must be empty.
+// Expects rm.mtx to be locked.
+func (rm *RecoveryManager) writeVersion() error {
+	record := rm.versionRecord()
+	if _, err := rm.writer.Write(record); err != nil {
+		return err
+	}
+	rm.logSize += int64(len(record))
+	return nil
+}
+
+// checkLogVersion returns ErrUnsupportedLogVersion if the file of the given size in the given
+// format is headed by a version log naming a version or feature that can't be read. A file
+// without a version log is read as the current version, and one whose first log can't be parsed
+// is left for reading it to report.
+func checkLogVersion(file io.ReaderAt, size int64, format LogFormat) error {
+	r := bufio.NewReader(io.NewSectionReader(file, 0, size))
+	record, _, err := readRecord(r, format, size)
+	if err != nil {
+		return nil
+	}
+	l, err := parseRecord(record, format)
+	if err != nil {
+		return nil
+	}
+	if vl, ok := l.(versionLog); ok {
+		return vl.check()
+	}
+	return nil
+}
+
+// checkFileVersion is checkLogVersion for a whole file in the given format.
+func checkFileVersion(file LogStorage, format LogFormat) error {
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return checkLogVersion(file, info.Size(), format)
+}
@@ -2,6 +2,7 @@ package concurrency_test
 
 import (
 	"dinodb/pkg/concurrency"
+	"errors"
 	"testing"
 )
 
@@ -10,6 +11,8 @@ func TestDeadlock(t *testing.T) {
 	t.Run("OneEdge", testDeadlockOneEdge)
 	t.Run("Simple", testDeadlockSimple)
 	t.Run("DAGSmall", testDeadlockDAGSmall)
+	t.Run("FindCycle", testDeadlockFindCycle)
+	t.Run("SelfEdge", testDeadlockSelfEdge)
 }
 
 func testDeadlockEmpty(t *testing.T) {
@@ -50,3 +53,33 @@ func testDeadlockDAGSmall(t *testing.T) {
 		t.Error("cycle detected in DAG")
 	}
 }
+
+func testDeadlockFindCycle(t *testing.T) {
+	t1 := concurrency.Transaction{}
+	t2 := concurrency.Transaction{}
+	t3 := concurrency.Transaction{}
+	t4 := concurrency.Transaction{}
+	g := concurrency.NewGraph()
+	g.AddEdge(&t1, &t4)
+	g.AddEdge(&t1, &t2)
+	g.AddEdge(&t2, &t3)
+	g.AddEdge(&t3, &t1)
+	cycle := g.FindCycle(&t1)
+	if len(cycle) != 3 || cycle[0] != &t1 || cycle[1] != &t2 || cycle[2] != &t3 {
+		t.Errorf("expected cycle t1 -> t2 -> t3, found %v", cycle)
+	}
+	if cycle := g.FindCycle(&t4); cycle != nil {
+		t.Error("cycle found through a transaction not on one")
+	}
+}
+
+func testDeadlockSelfEdge(t *testing.T) {
+	t1 := concurrency.Transaction{}
+	g := concurrency.NewGraph()
+	if err := g.AddEdge(&t1, &t1); !errors.Is(err, concurrency.ErrSelfWait) {
+		t.Errorf("expected a self edge to be rejected, got %v", err)
+	}
+	if g.DetectCycle() || g.FindCycle(&t1) != nil {
+		t.Error("cycle detected through a rejected self edge")
+	}
+}
@@ -1,8 +1,19 @@
 package concurrency_test
 
 import (
+	"bytes"
+	"context"
+	"dinodb/pkg/btree"
 	"dinodb/pkg/concurrency"
 	"dinodb/pkg/database"
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,12 +27,12 @@ type LockCommand struct {
 	lt   concurrency.LockType
 }
 
-func setupTransaction(t *testing.T) (*concurrency.TransactionManager, database.Index) {
+func setupTransaction(t *testing.T, opts ...concurrency.Option) (*concurrency.TransactionManager, database.Index) {
 	// TODO: test transaction manager with hash indices too
 	index := setupIndex(t, database.BTreeIndexType)
 
 	lm := concurrency.NewResourceLockManager()
-	tm := concurrency.NewTransactionManager(lm)
+	tm := concurrency.NewTransactionManager(lm, opts...)
 	return tm, index
 }
 
@@ -94,10 +105,46 @@ func TestTransaction(t *testing.T) {
 	t.Run("Deadlock", testTransactionDeadlock)
 	t.Run("DAGNoCycle", testTransactionDAGNoCycle)
 	t.Run("ReadLockNoCycle", testTransactionReadLockNoCycle)
-	t.Run("DontUpgradeLocks", testTransactionDontUpgradeLocks)
+	t.Run("UpgradeLocks", testTransactionUpgradeLocks)
+	t.Run("UpgradeDeadlock", testTransactionUpgradeDeadlock)
 	t.Run("DontDowngradeLocks", testTransactionDontDowngradeLocks)
 	t.Run("LockIdempotency", testTransactionLockIdempotency)
+	t.Run("RelockNoSelfDeadlock", testTransactionRelockNoSelfDeadlock)
 	t.Run("CommitsReleaseLocks", testTransactionCommitsReleaseLocks)
+	t.Run("ErrorsReleaseLocks", testTransactionErrorsReleaseLocks)
+	t.Run("DeadlockVictimLeavesNoEdges", testTransactionDeadlockVictimLeavesNoEdges)
+	t.Run("CommitsClearGraph", testTransactionCommitsClearGraph)
+	t.Run("LockTimeout", testTransactionLockTimeout)
+	t.Run("AbortReleasesLocks", testTransactionAbortReleasesLocks)
+	t.Run("CycleDetectionVictim", testTransactionCycleDetectionVictim)
+	t.Run("WoundWaitVictim", testTransactionWoundWaitVictim)
+	t.Run("WoundWaitNoStarvation", testTransactionWoundWaitNoStarvation)
+	t.Run("CheapestDeadlockVictim", testTransactionCheapestDeadlockVictim)
+	t.Run("DeadlockErrorCycle", testTransactionDeadlockErrorCycle)
+	t.Run("TryLock", testTransactionTryLock)
+	t.Run("GetLockedResources", testTransactionGetLockedResources)
+	t.Run("TableLockBlocksKeys", testTransactionTableLockBlocksKeys)
+	t.Run("KeyLocksBlockTable", testTransactionKeyLocksBlockTable)
+	t.Run("UpgradeBlockedByTable", testTransactionUpgradeBlockedByTable)
+	t.Run("TableLockDeadlock", testTransactionTableLockDeadlock)
+	t.Run("Stats", testTransactionStats)
+	t.Run("IdleReaped", testTransactionIdleReaped)
+	t.Run("MaxActiveTransactions", testTransactionMaxActiveTransactions)
+	t.Run("FIFOWriterNotStarved", testTransactionFIFOWriterNotStarved)
+	t.Run("CompositeKeys", testTransactionCompositeKeys)
+	t.Run("LockAllCanonicalOrder", testTransactionLockAllCanonicalOrder)
+	t.Run("LockAllReleasesOnFailure", testTransactionLockAllReleasesOnFailure)
+	t.Run("ReadOnlyNoEdges", testTransactionReadOnlyNoEdges)
+	t.Run("ReadOnlyConflictsWithWriters", testTransactionReadOnlyConflictsWithWriters)
+	t.Run("ExportWaitsFor", testTransactionExportWaitsFor)
+	t.Run("ForceUnlockAll", testTransactionForceUnlockAll)
+	t.Run("Hotspots", testTransactionHotspots)
+	t.Run("UnlockResource", testTransactionUnlockResource)
+	t.Run("RangeLockBlocksInserts", testTransactionRangeLockBlocksInserts)
+	t.Run("ReclaimTransaction", testTransactionReclaimTransaction)
+	t.Run("CommitUnlocksDespiteErrors", testTransactionCommitUnlocksDespiteErrors)
+	t.Run("ReentrantReadLocks", testTransactionReentrantReadLocks)
+	t.Run("DeadlockTimeout", testTransactionDeadlockTimeout)
 }
 
 func testTransactionBasic(t *testing.T) {
@@ -214,16 +261,40 @@ func testTransactionReadLockNoCycle(t *testing.T) {
 	checkNoErrors(t, errch)
 }
 
-func testTransactionDontUpgradeLocks(t *testing.T) {
+func testTransactionUpgradeLocks(t *testing.T) {
 	tm, index := setupTransaction(t)
 	errch := make(chan error, BUFFER_SIZE)
 	// Set up transactions
 	tid1, ch1 := getTransactionThread()
 	go handleTransactionThread(tm, index, tid1, ch1, errch)
-	// Sending instructions
+	tid2, ch2 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid2, ch2, errch)
+	// Sending instructions; the second transaction's upgrade waits for the first's read lock
+	sendWithDelay(ch1, LockCommand{key: 1, lock: true, lt: concurrency.R_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 1, lock: true, lt: concurrency.R_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch1, LockCommand{done: true})
+	sendWithDelay(ch2, LockCommand{key: 1, lock: false, lt: concurrency.W_LOCK})
+	sendWithDelay(ch2, LockCommand{done: true})
+	// Check for errors
+	checkNoErrors(t, errch)
+}
+
+func testTransactionUpgradeDeadlock(t *testing.T) {
+	tm, index := setupTransaction(t)
+	errch := make(chan error, BUFFER_SIZE)
+	// Set up transactions
+	tid1, ch1 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid1, ch1, errch)
+	tid2, ch2 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid2, ch2, errch)
+	// Sending instructions; each transaction waits for the other's read lock to upgrade
 	sendWithDelay(ch1, LockCommand{key: 1, lock: true, lt: concurrency.R_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 1, lock: true, lt: concurrency.R_LOCK})
 	sendWithDelay(ch1, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
 	sendWithDelay(ch1, LockCommand{done: true})
+	sendWithDelay(ch2, LockCommand{done: true})
 	// Check for errors
 	checkWasErrors(t, errch)
 }
@@ -266,6 +337,43 @@ func testTransactionLockIdempotency(t *testing.T) {
 	checkNoErrors(t, errch)
 }
 
+func testTransactionRelockNoSelfDeadlock(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.Lock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	// Another transaction waits for the entry, so it has conflicting waiters while we relock it
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(tid2, index, 1, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	finishesInTime(t, "relocking a held entry", func() {
+		for _, lType := range []concurrency.LockType{concurrency.W_LOCK, concurrency.R_LOCK, concurrency.W_LOCK} {
+			if err := tm.Lock(tid1, index, 1, lType); err != nil {
+				t.Error("Expected relocking to never deadlock, got", err)
+			}
+		}
+	})
+	if tm.GetWaitsForGraph().DetectCycle() {
+		t.Error("Expected no cycle in the waits-for graph")
+	}
+	tm.Commit(tid1)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	// Relocking our own lock, read and then write, never waits for ourselves
+	finishesInTime(t, "relocking and upgrading a held entry", func() {
+		for _, lType := range []concurrency.LockType{concurrency.R_LOCK, concurrency.W_LOCK} {
+			if err := tm.Lock(tid2, index, 1, lType); err != nil {
+				t.Error("Expected relocking to never deadlock, got", err)
+			}
+		}
+	})
+	tm.Commit(tid2)
+}
+
 func testTransactionCommitsReleaseLocks(t *testing.T) {
 	tm, index := setupTransaction(t)
 	errch := make(chan error, BUFFER_SIZE)
@@ -287,3 +395,1351 @@ func testTransactionCommitsReleaseLocks(t *testing.T) {
 	// Check for errors
 	checkWasErrors(t, errch)
 }
+
+func testTransactionDeadlockVictimLeavesNoEdges(t *testing.T) {
+	tm, index := setupTransaction(t)
+	errch := make(chan error, BUFFER_SIZE)
+	// Set up transactions
+	tid1, ch1 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid1, ch1, errch)
+	tid2, ch2 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid2, ch2, errch)
+	tid3, ch3 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid3, ch3, errch)
+	// The second transaction is the victim of a deadlock, and aborts
+	sendWithDelay(ch1, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 2, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch1, LockCommand{key: 2, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
+	checkWasErrors(t, errch)
+	// Waiting on the survivor must not be mistaken for the old cycle
+	sendWithDelay(ch3, LockCommand{key: 3, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch3, LockCommand{key: 2, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch1, LockCommand{key: 4, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch1, LockCommand{done: true})
+	sendWithDelay(ch3, LockCommand{done: true})
+	checkNoErrors(t, errch)
+}
+
+func testTransactionCommitsClearGraph(t *testing.T) {
+	tm, index := setupTransaction(t)
+	var wg sync.WaitGroup
+	// Thousands of short transactions contending for a handful of keys
+	for i := 0; i < 2000; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tid := uuid.New()
+			tm.Begin(tid)
+			defer tm.Commit(tid)
+			if err := tm.Lock(tid, index, int64(i%4), concurrency.R_LOCK); err != nil {
+				return
+			}
+			tm.Lock(tid, index, int64(i%4+4), concurrency.W_LOCK)
+		}(i)
+	}
+	wg.Wait()
+	if n := len(tm.GetTransactions()); n != 0 {
+		t.Errorf("Expected no running transactions, found %d", n)
+	}
+	if n := len(tm.GetWaitsForGraph().GetEdges()); n != 0 {
+		t.Errorf("Expected the waits-for graph to be empty, found %d edges", n)
+	}
+}
+
+func testTransactionLockTimeout(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.Lock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tm.LockContext(ctx, tid2, index, 1, concurrency.R_LOCK)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the lock to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*DELAY_TIME {
+		t.Errorf("Expected the lock to give up after 50ms, took %v", elapsed)
+	}
+	// The timed out transaction must hold nothing and wait on no one
+	tx, _ := tm.GetTransaction(tid2)
+	if n := len(tx.GetResources()); n != 0 {
+		t.Errorf("Expected the timed out transaction to hold no locks, found %d", n)
+	}
+	if n := len(tm.GetWaitsForGraph().GetEdges()); n != 0 {
+		t.Errorf("Expected the waits-for graph to be empty, found %d edges", n)
+	}
+	finishesInTime(t, "locking after a timeout", func() {
+		if err := tm.Commit(tid1); err != nil {
+			t.Error("Error committing:", err)
+		}
+		if err := tm.Lock(tid2, index, 1, concurrency.W_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+		if err := tm.Commit(tid2); err != nil {
+			t.Error("Error committing:", err)
+		}
+	})
+}
+
+func testTransactionAbortReleasesLocks(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	for key := int64(0); key < 3; key++ {
+		if err := tm.Lock(tid1, index, key, concurrency.W_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	if err := tm.Abort(tid1); err != nil {
+		t.Fatal("Error aborting:", err)
+	}
+	if _, found := tm.GetTransaction(tid1); found {
+		t.Error("Expected the aborted transaction to be removed")
+	}
+	if err := tm.Abort(tid1); err == nil {
+		t.Error("Expected aborting twice to fail")
+	}
+	finishesInTime(t, "locking after an abort", func() {
+		for key := int64(0); key < 3; key++ {
+			if err := tm.Lock(tid2, index, key, concurrency.W_LOCK); err != nil {
+				t.Error("Error locking:", err)
+			}
+		}
+	})
+	tm.Commit(tid2)
+}
+
+// setupConflict has an older and a younger transaction each lock a resource, then has the
+// younger wait for the older's resource before the older asks for the younger's.
+// Returns the two transactions and channels of the results of their second locks.
+func setupConflict(t *testing.T, tm *concurrency.TransactionManager, index database.Index) (older uuid.UUID, younger uuid.UUID, olderch chan error, youngerch chan error) {
+	older, younger = uuid.New(), uuid.New()
+	tm.Begin(older)
+	tm.Begin(younger)
+	if err := tm.Lock(older, index, 0, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.Lock(younger, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	olderch, youngerch = make(chan error, 1), make(chan error, 1)
+	go func() { youngerch <- tm.Lock(younger, index, 0, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	go func() { olderch <- tm.Lock(older, index, 1, concurrency.W_LOCK) }()
+	return older, younger, olderch, youngerch
+}
+
+// lockResult returns the result of a lock sent on the channel, failing if none is sent in time.
+func lockResult(t *testing.T, ch chan error) error {
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(10 * DELAY_TIME):
+		t.Fatal("Expected the lock to finish")
+		return nil
+	}
+}
+
+func testTransactionCycleDetectionVictim(t *testing.T) {
+	tm, index := setupTransaction(t, concurrency.WithDeadlockPolicy(concurrency.CycleDetection))
+	older, younger, olderch, youngerch := setupConflict(t, tm, index)
+	// The requester that closes the cycle loses, even though it's the older transaction
+	if err := lockResult(t, olderch); err == nil {
+		t.Fatal("Expected the older transaction's lock to detect a deadlock")
+	}
+	tm.Abort(older)
+	if err := lockResult(t, youngerch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(younger)
+}
+
+func testTransactionWoundWaitVictim(t *testing.T) {
+	tm, index := setupTransaction(t, concurrency.WithDeadlockPolicy(concurrency.WoundWait))
+	older, younger, olderch, youngerch := setupConflict(t, tm, index)
+	// The older transaction wounds the younger one, waking it from its wait
+	if err := lockResult(t, youngerch); !errors.Is(err, concurrency.ErrWounded) {
+		t.Fatalf("Expected the younger transaction to be wounded, got %v", err)
+	}
+	if len(olderch) != 0 {
+		t.Fatal("Expected the older transaction to wait for the younger one to abort")
+	}
+	tm.Abort(younger)
+	if err := lockResult(t, olderch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(older)
+}
+
+func testTransactionWoundWaitNoStarvation(t *testing.T) {
+	tm, index := setupTransaction(t, concurrency.WithDeadlockPolicy(concurrency.WoundWait))
+	const clients = 8
+	tids := make([]uuid.UUID, clients)
+	for i := range tids {
+		tids[i] = uuid.New()
+		tm.Begin(tids[i])
+	}
+	// Every client locks the same two resources in opposite orders, restarting when wounded
+	retries := make([]int, clients)
+	var wg sync.WaitGroup
+	for i := range tids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			first, second := int64(i%2), int64(1-i%2)
+			for {
+				err := tm.Lock(tids[i], index, first, concurrency.W_LOCK)
+				if err == nil {
+					time.Sleep(time.Millisecond)
+					err = tm.Lock(tids[i], index, second, concurrency.W_LOCK)
+				}
+				if err == nil {
+					tm.Commit(tids[i])
+					return
+				}
+				retries[i]++
+				tm.Abort(tids[i])
+				tm.Begin(tids[i])
+			}
+		}(i)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected every client to finish its transaction")
+	}
+	// The oldest transaction is never wounded
+	if retries[0] != 0 {
+		t.Errorf("Expected the oldest transaction to never restart, restarted %d times", retries[0])
+	}
+}
+
+func testTransactionCheapestDeadlockVictim(t *testing.T) {
+	tm, index := setupTransaction(t)
+	large, medium, small := uuid.New(), uuid.New(), uuid.New()
+	// Each transaction holds a different number of locks
+	sizes := map[uuid.UUID][]int64{large: {1, 10, 11, 12}, medium: {2, 20}, small: {3}}
+	for tid, keys := range sizes {
+		tm.Begin(tid)
+		for _, key := range keys {
+			if err := tm.Lock(tid, index, key, concurrency.W_LOCK); err != nil {
+				t.Fatal("Error locking:", err)
+			}
+		}
+	}
+	// The small transaction waits for the large one, the medium for the small, and the
+	// large closes the cycle by waiting for the medium
+	smallch, mediumch, largech := make(chan error, 1), make(chan error, 1), make(chan error, 1)
+	go func() { smallch <- tm.Lock(small, index, 1, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	go func() { mediumch <- tm.Lock(medium, index, 3, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	go func() { largech <- tm.Lock(large, index, 2, concurrency.W_LOCK) }()
+	var deadlock *concurrency.DeadlockError
+	if err := lockResult(t, smallch); !errors.As(err, &deadlock) {
+		t.Fatalf("Expected the small transaction's lock to fail with a deadlock, got %v", err)
+	}
+	if deadlock.Victim != small {
+		t.Errorf("Expected the small transaction to be the victim, got %v", deadlock.Victim)
+	}
+	if len(mediumch) != 0 || len(largech) != 0 {
+		t.Fatal("Expected the other transactions to keep waiting")
+	}
+	// Aborting the victim lets the others finish in turn
+	tm.Abort(small)
+	if err := lockResult(t, mediumch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(medium)
+	if err := lockResult(t, largech); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(large)
+}
+
+func testTransactionDeadlockErrorCycle(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for i, tid := range tids {
+		tm.Begin(tid)
+		if err := tm.Lock(tid, index, int64(i), concurrency.W_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	// Each transaction waits for the next one's key, and the last closes the cycle
+	chs := []chan error{make(chan error, 1), make(chan error, 1)}
+	for i, ch := range chs {
+		go func() { ch <- tm.Lock(tids[i], index, int64(i+1), concurrency.W_LOCK) }()
+		time.Sleep(DELAY_TIME)
+	}
+	err := tm.Lock(tids[2], index, 0, concurrency.W_LOCK)
+	var deadlock *concurrency.DeadlockError
+	if !errors.As(err, &deadlock) {
+		t.Fatalf("Expected the last lock to fail with a deadlock, got %v", err)
+	}
+	// The cycle starts with the requester that closed it, each waiting for the next
+	expectedCycle := []uuid.UUID{tids[2], tids[0], tids[1]}
+	expectedResources := []string{index.GetName() + "/0", index.GetName() + "/1", index.GetName() + "/2"}
+	if !slices.Equal(deadlock.Cycle, expectedCycle) {
+		t.Errorf("Expected the cycle %v, got %v", expectedCycle, deadlock.Cycle)
+	}
+	resources := make([]string, len(deadlock.Resources))
+	for i, r := range deadlock.Resources {
+		resources[i] = r.String()
+	}
+	if !slices.Equal(resources, expectedResources) {
+		t.Errorf("Expected the resources %v, got %v", expectedResources, resources)
+	}
+	if !strings.Contains(err.Error(), tids[0].String()) || !strings.Contains(err.Error(), expectedResources[0]) {
+		t.Errorf("Expected the error message to describe the cycle, got %q", err)
+	}
+	tm.Abort(tids[2])
+	if err := lockResult(t, chs[1]); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(tids[1])
+	if err := lockResult(t, chs[0]); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(tids[0])
+}
+
+func testTransactionTryLock(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.Lock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.Lock(tid1, index, 2, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	tryLock := func(tid uuid.UUID, key int64, lType concurrency.LockType, expected bool) {
+		t.Helper()
+		finishesInTime(t, "trying to lock", func() {
+			acquired, err := tm.TryLock(tid, index, key, lType)
+			if err != nil {
+				t.Error("Error trying to lock:", err)
+			} else if acquired != expected {
+				t.Errorf("Expected trying to lock key %d to return %v", key, expected)
+			}
+		})
+	}
+	// A held resource isn't acquired, and doesn't make us wait for its holder
+	tryLock(tid2, 1, concurrency.R_LOCK, false)
+	if n := len(tm.GetWaitsForGraph().GetEdges()); n != 0 {
+		t.Errorf("Expected the waits-for graph to be empty, found %d edges", n)
+	}
+	// A free resource is acquired, and held resources follow the rules of Lock
+	tryLock(tid2, 3, concurrency.W_LOCK, true)
+	tryLock(tid2, 3, concurrency.R_LOCK, true)
+	tryLock(tid2, 2, concurrency.R_LOCK, true)
+	tryLock(tid2, 2, concurrency.W_LOCK, false)
+	// The read lock that couldn't be upgraded is kept
+	tx, _ := tm.GetTransaction(tid2)
+	if n := len(tx.GetResources()); n != 2 {
+		t.Errorf("Expected 2 locked resources, found %d", n)
+	}
+	tm.Commit(tid1)
+	tryLock(tid2, 2, concurrency.W_LOCK, true)
+	tryLock(tid2, 1, concurrency.W_LOCK, true)
+	if n := len(tx.GetResources()); n != 3 {
+		t.Errorf("Expected 3 locked resources, found %d", n)
+	}
+	tm.Commit(tid2)
+}
+
+func testTransactionGetLockedResources(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid := uuid.New()
+	tm.Begin(tid)
+	locks := map[int64]concurrency.LockType{1: concurrency.R_LOCK, 2: concurrency.W_LOCK, 3: concurrency.R_LOCK}
+	for key, lType := range locks {
+		if err := tm.Lock(tid, index, key, lType); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	resources, found := tm.GetLockedResources(tid)
+	if !found {
+		t.Fatal("Expected the transaction to be found")
+	}
+	if len(resources) != len(locks) {
+		t.Errorf("Expected %d locked resources, found %d", len(locks), len(resources))
+	}
+	for r, lType := range resources {
+		if r.GetTableName() != index.GetName() || locks[r.GetResourceKey()] != lType {
+			t.Errorf("Unexpected lock on key %d of table %s", r.GetResourceKey(), r.GetTableName())
+		}
+	}
+	// The returned map is a copy
+	for r := range resources {
+		delete(resources, r)
+	}
+	if resources, _ := tm.GetLockedResources(tid); len(resources) != len(locks) {
+		t.Error("Expected changing the returned map to leave the transaction's locks alone")
+	}
+	tm.Commit(tid)
+	if _, found := tm.GetLockedResources(tid); found {
+		t.Error("Expected a committed transaction to not be found")
+	}
+}
+
+func testTransactionTableLockBlocksKeys(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.LockTable(tid1, index, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking table:", err)
+	}
+	// The table's write lock covers its keys for its own transaction
+	finishesInTime(t, "locking a key of a write locked table", func() {
+		if err := tm.Lock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+	})
+	// But makes other transactions wait, even to read a key
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(tid2, index, 1, concurrency.R_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if len(ch) != 0 {
+		t.Fatal("Expected reading a key of a write locked table to wait")
+	}
+	if err := tm.UnlockTable(tid1, index, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error unlocking table:", err)
+	}
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	// A table read lock lets other transactions read keys, but not write them
+	if err := tm.LockTable(tid1, index, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking table:", err)
+	}
+	finishesInTime(t, "reading a key of a read locked table", func() {
+		if err := tm.Lock(tid2, index, 2, concurrency.R_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+	})
+	go func() { ch <- tm.Lock(tid2, index, 3, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if len(ch) != 0 {
+		t.Fatal("Expected writing a key of a read locked table to wait")
+	}
+	tm.Commit(tid1)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(tid2)
+}
+
+func testTransactionKeyLocksBlockTable(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.Lock(tid1, index, 1, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	// Reading a key doesn't stop another transaction from reading the whole table
+	finishesInTime(t, "read locking a table with a read locked key", func() {
+		if err := tm.LockTable(tid2, index, concurrency.R_LOCK); err != nil {
+			t.Error("Error locking table:", err)
+		}
+	})
+	// But does stop it from writing the whole table
+	ch := make(chan error, 1)
+	go func() { ch <- tm.LockTable(tid2, index, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if len(ch) != 0 {
+		t.Fatal("Expected write locking a table with a read locked key to wait")
+	}
+	tm.Commit(tid1)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking table:", err)
+	}
+	resources, _ := tm.GetLockedResources(tid2)
+	for r, lType := range resources {
+		if !r.IsTable() || lType != concurrency.W_LOCK {
+			t.Errorf("Expected only a table write lock, found a lock on key %d", r.GetResourceKey())
+		}
+	}
+	tm.Commit(tid2)
+}
+
+func testTransactionUpgradeBlockedByTable(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2, tid3 := uuid.New(), uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.Lock(tid1, index, 1, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.LockTable(tid2, index, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking table:", err)
+	}
+	// The table's read lock keeps the upgrade from taking the table's write intention in time
+	ctx, cancel := context.WithTimeout(context.Background(), DELAY_TIME)
+	defer cancel()
+	if err := tm.LockContext(ctx, tid1, index, 1, concurrency.W_LOCK); err == nil {
+		t.Fatal("Expected upgrading a key of a read locked table to time out")
+	}
+	// Gives up the key's read lock along with the upgrade, so nothing is left locked once both commit
+	tm.Commit(tid1)
+	tm.Commit(tid2)
+	tm.Begin(tid3)
+	if locked, err := tm.TryLock(tid3, index, 1, concurrency.W_LOCK); err != nil || !locked {
+		t.Errorf("Expected the key to be free to write lock, got %v (error %v)", locked, err)
+	}
+	tm.Commit(tid3)
+}
+
+func testTransactionTableLockDeadlock(t *testing.T) {
+	tm, index := setupTransaction(t)
+	errch := make(chan error, BUFFER_SIZE)
+	tid1, ch1 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid1, ch1, errch)
+	tid2, ch2 := getTransactionThread()
+	go handleTransactionThread(tm, index, tid2, ch2, errch)
+	sendWithDelay(ch1, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
+	sendWithDelay(ch2, LockCommand{key: 2, lock: true, lt: concurrency.W_LOCK})
+	// The first transaction waits to read the whole table, including the second's key
+	time.Sleep(DELAY_TIME)
+	go tm.LockTable(tid1, index, concurrency.R_LOCK)
+	sendWithDelay(ch2, LockCommand{key: 1, lock: true, lt: concurrency.W_LOCK})
+	checkWasErrors(t, errch)
+	sendWithDelay(ch1, LockCommand{done: true})
+}
+
+func testTransactionStats(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	// The second transaction waits for the first's lock
+	if err := tm.Lock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(tid2, index, 1, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if err := tm.Unlock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error unlocking:", err)
+	}
+	if err := lockResult(t, ch); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	// Then they deadlock
+	if err := tm.Lock(tid1, index, 2, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	go func() { ch <- tm.Lock(tid1, index, 1, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if err := tm.Lock(tid2, index, 2, concurrency.W_LOCK); err == nil {
+		t.Fatal("Expected a deadlock")
+	}
+	stats := tm.Stats()
+	if stats.LocksGranted != 3 || stats.LockWaits != 1 || stats.DeadlocksDetected != 1 {
+		t.Errorf("Expected 3 locks granted, 1 wait, and 1 deadlock, got %+v", stats)
+	}
+	if stats.AverageWait < DELAY_TIME {
+		t.Errorf("Expected the average wait to be at least %v, got %v", DELAY_TIME, stats.AverageWait)
+	}
+	if stats.ActiveTransactions != 2 {
+		t.Errorf("Expected 2 active transactions, got %d", stats.ActiveTransactions)
+	}
+	tm.Abort(tid2)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(tid1)
+	tm.ResetStats()
+	if stats := tm.Stats(); stats != (concurrency.LockStats{}) {
+		t.Errorf("Expected reset stats to be zero, got %+v", stats)
+	}
+}
+
+func testTransactionIdleReaped(t *testing.T) {
+	tm, index := setupTransaction(t, concurrency.WithTransactionTimeout(5*DELAY_TIME))
+	defer tm.Close()
+	idle, active := uuid.New(), uuid.New()
+	tm.Begin(idle)
+	tm.Begin(active)
+	for key := int64(0); key < 3; key++ {
+		if err := tm.Lock(idle, index, key, concurrency.W_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	// The active transaction keeps touching, while the idle one goes quiet.
+	for i := 0; i < 15; i++ {
+		time.Sleep(DELAY_TIME)
+		tm.Touch(active)
+	}
+	if _, found := tm.GetTransaction(idle); found {
+		t.Error("Expected the idle transaction to be reaped")
+	}
+	if _, found := tm.GetTransaction(active); !found {
+		t.Error("Expected the active transaction to keep running")
+	}
+	if reaped := tm.Stats().TransactionsReaped; reaped != 1 {
+		t.Errorf("Expected 1 transaction reaped, got %d", reaped)
+	}
+	finishesInTime(t, "locking after a reap", func() {
+		for key := int64(0); key < 3; key++ {
+			if err := tm.Lock(active, index, key, concurrency.W_LOCK); err != nil {
+				t.Error("Error locking:", err)
+			}
+		}
+	})
+	tm.Commit(active)
+}
+
+func testTransactionMaxActiveTransactions(t *testing.T) {
+	tm, _ := setupTransaction(t, concurrency.WithMaxActiveTransactions(3))
+	tids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, tid := range tids {
+		if err := tm.Begin(tid); err != nil {
+			t.Fatal("Error beginning:", err)
+		}
+	}
+	extra := uuid.New()
+	if err := tm.Begin(extra); !errors.Is(err, concurrency.ErrTooManyTransactions) {
+		t.Fatalf("Expected ErrTooManyTransactions at the cap, got %v", err)
+	}
+	if err := tm.Commit(tids[0]); err != nil {
+		t.Fatal("Error committing:", err)
+	}
+	if err := tm.Begin(extra); err != nil {
+		t.Fatal("Expected beginning after a commit to succeed, got", err)
+	}
+	// Lowering the limit keeps the running transactions, but stops more from beginning.
+	tm.SetMaxActiveTransactions(1)
+	if err := tm.Begin(tids[0]); !errors.Is(err, concurrency.ErrTooManyTransactions) {
+		t.Errorf("Expected ErrTooManyTransactions after lowering the cap, got %v", err)
+	}
+	if stats := tm.Stats(); stats.ActiveTransactions != 3 {
+		t.Errorf("Expected 3 active transactions, got %d", stats.ActiveTransactions)
+	}
+	tm.SetMaxActiveTransactions(0)
+	if err := tm.Begin(tids[0]); err != nil {
+		t.Error("Expected no limit to accept any transaction, got", err)
+	}
+}
+
+func testTransactionFIFOWriterNotStarved(t *testing.T) {
+	tm, index := setupTransaction(t)
+	const readers = 4
+	var writerGranted atomic.Bool
+	var handoffs atomic.Int64 // Read locks granted after the writer began waiting
+	var writerWaiting atomic.Bool
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	// A steady stream of overlapping readers, so the key is always read locked by someone.
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				tid := uuid.New()
+				tm.Begin(tid)
+				if err := tm.Lock(tid, index, 0, concurrency.R_LOCK); err != nil {
+					t.Error("Error read locking:", err)
+					tm.Abort(tid)
+					return
+				}
+				if writerWaiting.Load() && !writerGranted.Load() {
+					handoffs.Add(1)
+				}
+				time.Sleep(DELAY_TIME / 10)
+				tm.Commit(tid)
+			}
+		}()
+	}
+	time.Sleep(DELAY_TIME)
+	writer := uuid.New()
+	tm.Begin(writer)
+	finishesInTime(t, "a writer waiting behind a stream of readers", func() {
+		writerWaiting.Store(true)
+		if err := tm.Lock(writer, index, 0, concurrency.W_LOCK); err != nil {
+			t.Error("Error write locking:", err)
+		}
+		writerGranted.Store(true)
+	})
+	close(stop)
+	tm.Commit(writer)
+	wg.Wait()
+	// Only readers that asked before the writer can be granted ahead of it.
+	if n := handoffs.Load(); n > readers {
+		t.Errorf("Expected the writer to be granted within %d read locks, took %d", readers, n)
+	}
+}
+
+func testTransactionCompositeKeys(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	// Two keys sharing their first column
+	shared := []byte("customer-1")
+	key1 := concurrency.NewCompositeKey(shared, []byte("order-1"))
+	key2 := concurrency.NewCompositeKey(shared, []byte("order-2"))
+	if columns := key1.Columns(); len(columns) != 2 || string(columns[0]) != "customer-1" || string(columns[1]) != "order-1" {
+		t.Errorf("Expected the key's columns to round trip, got %q", columns)
+	}
+	// Columns must not run together when serialized
+	if concurrency.NewCompositeKey([]byte("ab"), []byte("c")) == concurrency.NewCompositeKey([]byte("a"), []byte("bc")) {
+		t.Error("Expected keys with different columns to differ")
+	}
+	if err := tm.LockComposite(tid1, index, key1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	finishesInTime(t, "locking a composite key sharing a column", func() {
+		if err := tm.LockComposite(tid2, index, key2, concurrency.W_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+		// Nor does a composite key conflict with an int64 key
+		if err := tm.Lock(tid2, index, 0, concurrency.W_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+	})
+	// The same composite key still conflicts
+	ch := make(chan error, 1)
+	go func() { ch <- tm.LockComposite(tid2, index, key1, concurrency.R_LOCK) }()
+	select {
+	case err := <-ch:
+		t.Fatal("Expected locking the same composite key to wait, got", err)
+	case <-time.After(DELAY_TIME):
+	}
+	if err := tm.UnlockComposite(tid1, index, key1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error unlocking:", err)
+	}
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	resources, _ := tm.GetLockedResources(tid2)
+	if len(resources) != 3 {
+		t.Errorf("Expected 3 locked resources, got %d", len(resources))
+	}
+	tm.Commit(tid1)
+	tm.Commit(tid2)
+}
+
+func testTransactionLockAllCanonicalOrder(t *testing.T) {
+	tm, index := setupTransaction(t, concurrency.WithDeadlockPolicy(concurrency.CycleDetection))
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	// Overlapping sets, requested in opposite orders
+	requests1 := []concurrency.LockRequest{
+		{Table: index, Key: 1, Type: concurrency.W_LOCK},
+		{Table: index, Key: 2, Type: concurrency.W_LOCK},
+		{Table: index, Key: 3, Type: concurrency.W_LOCK},
+	}
+	requests2 := []concurrency.LockRequest{
+		{Table: index, Key: 4, Type: concurrency.W_LOCK},
+		{Table: index, Key: 3, Type: concurrency.W_LOCK},
+		{Table: index, Key: 2, Type: concurrency.R_LOCK},
+		{Table: index, Key: 2, Type: concurrency.W_LOCK},
+	}
+	ch1, ch2 := make(chan error, 1), make(chan error, 1)
+	for _, tx := range []struct {
+		tid      uuid.UUID
+		requests []concurrency.LockRequest
+		ch       chan error
+	}{{tid1, requests1, ch1}, {tid2, requests2, ch2}} {
+		go func() {
+			err := tm.LockAll(tx.tid, tx.requests)
+			if err == nil {
+				time.Sleep(DELAY_TIME)
+			}
+			tm.Commit(tx.tid)
+			tx.ch <- err
+		}()
+	}
+	if err := lockResult(t, ch1); err != nil {
+		t.Error("Expected no deadlock, got", err)
+	}
+	if err := lockResult(t, ch2); err != nil {
+		t.Error("Expected no deadlock, got", err)
+	}
+}
+
+func testTransactionLockAllReleasesOnFailure(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.Lock(tid1, index, 3, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	// A lock held before the call is kept
+	if err := tm.Lock(tid2, index, 1, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DELAY_TIME)
+	defer cancel()
+	err := tm.LockAllContext(ctx, tid2, []concurrency.LockRequest{
+		{Table: index, Key: 3, Type: concurrency.R_LOCK},
+		{Table: index, Key: 2, Type: concurrency.W_LOCK},
+		{Table: index, Key: 1, Type: concurrency.R_LOCK},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("Expected the locks to time out, got", err)
+	}
+	resources, _ := tm.GetLockedResources(tid2)
+	if len(resources) != 1 {
+		t.Errorf("Expected only the lock held before the call, got %v", resources)
+	}
+	// The released entry can be locked by another transaction
+	if ok, err := tm.TryLock(tid1, index, 2, concurrency.W_LOCK); !ok || err != nil {
+		t.Error("Expected the entry to be released, got", ok, err)
+	}
+	tm.Commit(tid1)
+	tm.Commit(tid2)
+}
+
+func testTransactionReadOnlyNoEdges(t *testing.T) {
+	tm, index := setupTransaction(t)
+	// A writer holding another entry, so there is a conflicting transaction to find
+	writer := uuid.New()
+	tm.Begin(writer)
+	if err := tm.Lock(writer, index, 100, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	var wg sync.WaitGroup
+	var edges atomic.Int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tid := uuid.New()
+			if err := tm.BeginReadOnly(tid); err != nil {
+				t.Error("Error beginning:", err)
+				return
+			}
+			for key := int64(0); key < 10; key++ {
+				if err := tm.Lock(tid, index, key, concurrency.R_LOCK); err != nil {
+					t.Error("Error locking:", err)
+				}
+				edges.Add(int32(len(tm.GetWaitsForGraph().GetEdges())))
+			}
+			if err := tm.Lock(tid, index, 0, concurrency.W_LOCK); !errors.Is(err, concurrency.ErrReadOnly) {
+				t.Error("Expected a read-only transaction's write lock to fail, got", err)
+			}
+			tm.Commit(tid)
+		}()
+	}
+	wg.Wait()
+	if n := edges.Load(); n != 0 {
+		t.Errorf("Expected read-only transactions to add no edges, saw %d", n)
+	}
+	tm.Commit(writer)
+}
+
+func testTransactionReadOnlyConflictsWithWriters(t *testing.T) {
+	tm, index := setupTransaction(t)
+	writer, reader := uuid.New(), uuid.New()
+	tm.Begin(writer)
+	tm.BeginReadOnly(reader)
+	if err := tm.Lock(writer, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(reader, index, 1, concurrency.R_LOCK) }()
+	select {
+	case err := <-ch:
+		t.Fatal("Expected the read-only transaction to wait for the writer, got", err)
+	case <-time.After(DELAY_TIME):
+	}
+	if edges := tm.GetWaitsForGraph().GetEdges(); len(edges) != 1 {
+		t.Errorf("Expected the waiting reader to add an edge, got %d", len(edges))
+	}
+	tm.Commit(writer)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(reader)
+}
+
+func testTransactionExportWaitsFor(t *testing.T) {
+	tm, index := setupTransaction(t)
+	holder, waiter := uuid.New(), uuid.New()
+	tm.Begin(holder)
+	tm.Begin(waiter)
+	if err := tm.Lock(holder, index, 7, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(waiter, index, 7, concurrency.R_LOCK) }()
+	time.Sleep(DELAY_TIME)
+
+	var buf bytes.Buffer
+	if err := tm.ExportWaitsFor(&buf); err != nil {
+		t.Fatal("Error exporting the waits-for graph:", err)
+	}
+	dot := buf.String()
+	edge := fmt.Sprintf("%q -> %q [label=%q];", waiter.String(), holder.String(), index.GetName()+"/7")
+	if !strings.HasPrefix(dot, "digraph") || !strings.Contains(dot, edge) {
+		t.Errorf("Expected the DOT output to contain the edge %s, got:\n%s", edge, dot)
+	}
+	for _, tid := range []uuid.UUID{holder, waiter} {
+		if !strings.Contains(dot, fmt.Sprintf("%q;", tid.String())) {
+			t.Errorf("Expected the DOT output to list transaction %s", tid)
+		}
+	}
+	tm.Commit(holder)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(waiter)
+}
+
+// finishesInTime fails the test if f doesn't return in time, such as when a mutex was left locked
+func finishesInTime(t *testing.T, what string, f func()) {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * DELAY_TIME):
+		t.Fatalf("Expected %s to not block", what)
+	}
+}
+
+func testTransactionErrorsReleaseLocks(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid := uuid.New()
+	if err := tm.Begin(tid); err != nil {
+		t.Fatal("Error beginning transaction:", err)
+	}
+	if err := tm.Lock(tid, index, 1, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	// Every error path must leave the managers' and the transaction's mutexes usable
+	if err := tm.Unlock(tid, index, 1, concurrency.W_LOCK); err == nil {
+		t.Error("Expected unlocking with the wrong lock type to fail")
+	}
+	if err := tm.Lock(uuid.New(), index, 1, concurrency.W_LOCK); err == nil {
+		t.Error("Expected locking without a transaction to fail")
+	}
+	if err := tm.GetResourceLockManager().Unlock(concurrency.Resource{}, concurrency.W_LOCK); err == nil {
+		t.Error("Expected unlocking a nonexistent resource to fail")
+	}
+	finishesInTime(t, "locking after errors", func() {
+		if err := tm.Lock(tid, index, 2, concurrency.W_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+		if err := tm.Unlock(tid, index, 1, concurrency.R_LOCK); err != nil {
+			t.Error("Error unlocking:", err)
+		}
+	})
+	finishesInTime(t, "committing after errors", func() {
+		if err := tm.Commit(tid); err != nil {
+			t.Error("Error committing:", err)
+		}
+	})
+}
+
+func testTransactionForceUnlockAll(t *testing.T) {
+	tm, index := setupTransaction(t)
+	wedged, holder, other := uuid.New(), uuid.New(), uuid.New()
+	tm.Begin(wedged)
+	tm.Begin(holder)
+	tm.Begin(other)
+	if err := tm.Lock(wedged, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.Lock(wedged, index, 2, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.Lock(holder, index, 3, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	// The wedged transaction is still waiting for a lock when its locks are released
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(wedged, index, 3, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if err := tm.ForceUnlockAll(wedged); err != nil {
+		t.Fatal("Error force-unlocking:", err)
+	}
+	if err := lockResult(t, ch); !errors.Is(err, concurrency.ErrForceUnlocked) {
+		t.Errorf("Expected the waiting lock request to fail with ErrForceUnlocked, got %v", err)
+	}
+	if _, found := tm.GetTransaction(wedged); found {
+		t.Error("Expected the force-unlocked transaction to no longer be running")
+	}
+	if edges := tm.GetWaitsForGraph().GetEdges(); len(edges) != 0 {
+		t.Errorf("Expected the force-unlocked transaction to leave no edges, got %d", len(edges))
+	}
+	// Every resource the wedged transaction held can be locked by another client
+	for _, key := range []int64{1, 2} {
+		if locked, err := tm.TryLock(other, index, key, concurrency.W_LOCK); err != nil || !locked {
+			t.Errorf("Expected key %d to be free, but locking it returned %t (error %v)", key, locked, err)
+		}
+	}
+	if err := tm.ForceUnlockAll(wedged); err == nil {
+		t.Error("Expected force-unlocking a transaction that isn't running to fail")
+	}
+	tm.Commit(holder)
+	tm.Commit(other)
+}
+
+func testTransactionHotspots(t *testing.T) {
+	tm, index := setupTransaction(t)
+	lm := tm.GetResourceLockManager()
+	holder := uuid.New()
+	tm.Begin(holder)
+	for _, key := range []int64{1, 2} {
+		if err := tm.Lock(holder, index, key, concurrency.W_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	// Many clients pile up on key 1, and a single one on key 2
+	chs := make([]chan error, 6)
+	for i := range chs {
+		key := int64(1)
+		if i == len(chs)-1 {
+			key = 2
+		}
+		chs[i] = make(chan error, 1)
+		go func() {
+			client := uuid.New()
+			tm.Begin(client)
+			err := tm.Lock(client, index, key, concurrency.W_LOCK)
+			if err == nil {
+				err = tm.Commit(client)
+			}
+			chs[i] <- err
+		}()
+	}
+	time.Sleep(DELAY_TIME)
+	hotspots := lm.Hotspots(1)
+	if len(hotspots) != 1 || hotspots[0].Resource.GetResourceKey() != 1 || hotspots[0].Queued != 5 {
+		t.Errorf("Expected key 1 to be the hottest resource with 5 requests queued, got %+v", hotspots)
+	}
+	tm.Commit(holder)
+	for _, ch := range chs {
+		if err := lockResult(t, ch); err != nil {
+			t.Error("Error locking:", err)
+		}
+	}
+
+	hotspots = lm.Hotspots(0)
+	if len(hotspots) != 2 {
+		t.Fatalf("Expected 2 contended resources, got %+v", hotspots)
+	}
+	if r := hotspots[0]; r.Resource.GetResourceKey() != 1 || r.Waits != 5 || r.Queued != 0 {
+		t.Errorf("Expected key 1 to rank first with 5 waits, got %+v", r)
+	}
+	if r := hotspots[1]; r.Resource.GetResourceKey() != 2 || r.Waits != 1 {
+		t.Errorf("Expected key 2 to rank second with 1 wait, got %+v", r)
+	}
+	tm.ResetStats()
+	if hotspots := lm.Hotspots(0); len(hotspots) != 0 {
+		t.Errorf("Expected no contended resources after resetting stats, got %+v", hotspots)
+	}
+}
+
+func testTransactionUnlockResource(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	// The read lock is upgraded, so it has to be unlocked as a write lock
+	if err := tm.Lock(tid1, index, 1, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.Lock(tid1, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error upgrading:", err)
+	}
+	if err := tm.Unlock(tid1, index, 1, concurrency.R_LOCK); err == nil {
+		t.Error("Expected unlocking an upgraded lock as a read lock to fail")
+	}
+	if err := tm.UnlockResource(tid1, index, 1); err != nil {
+		t.Fatal("Error unlocking without a lock type:", err)
+	}
+	if resources, _ := tm.GetLockedResources(tid1); len(resources) != 0 {
+		t.Errorf("Expected no locked resources, got %v", resources)
+	}
+	if locked, err := tm.TryLock(tid2, index, 1, concurrency.W_LOCK); err != nil || !locked {
+		t.Errorf("Expected the resource to be free once unlocked, got %v, %v", locked, err)
+	}
+	if err := tm.UnlockResource(tid1, index, 1); err == nil {
+		t.Error("Expected unlocking a resource that isn't locked to fail")
+	}
+	if err := tm.UnlockResource(uuid.New(), index, 1); err == nil {
+		t.Error("Expected unlocking for a client without a transaction to fail")
+	}
+	tm.Commit(tid1)
+	tm.Commit(tid2)
+}
+
+func testTransactionRangeLockBlocksInserts(t *testing.T) {
+	tm, index := setupTransaction(t)
+	tid1, tid2 := uuid.New(), uuid.New()
+	tm.Begin(tid1)
+	tm.Begin(tid2)
+	if err := tm.LockRange(tid1, index, 10, 20, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking range:", err)
+	}
+	// Keys outside the range can be written, and keys inside it read
+	finishesInTime(t, "inserting a key outside a read locked range", func() {
+		if err := tm.Lock(tid2, index, 21, concurrency.W_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+	})
+	finishesInTime(t, "reading a key inside a read locked range", func() {
+		if err := tm.Lock(tid2, index, 10, concurrency.R_LOCK); err != nil {
+			t.Error("Error locking:", err)
+		}
+	})
+	// But inserting a key inside the range waits for the range's transaction to commit
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(tid2, index, 15, concurrency.W_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if len(ch) != 0 {
+		t.Fatal("Expected inserting a key inside a read locked range to wait")
+	}
+	tm.Commit(tid1)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+
+	// A range waits for the keys already written inside it, but not for those outside it
+	tm.Begin(tid1)
+	finishesInTime(t, "locking a range with no written keys inside", func() {
+		if err := tm.LockRange(tid1, index, 0, 9, concurrency.R_LOCK); err != nil {
+			t.Error("Error locking range:", err)
+		}
+	})
+	go func() { ch <- tm.LockRange(tid1, index, 12, 18, concurrency.R_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if len(ch) != 0 {
+		t.Fatal("Expected locking a range holding a written key to wait")
+	}
+	tm.Commit(tid2)
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking range:", err)
+	}
+	if err := tm.LockRange(tid1, index, 5, 1, concurrency.R_LOCK); err == nil {
+		t.Error("Expected locking a range that starts after it ends to fail")
+	}
+	tm.Commit(tid1)
+}
+
+func testTransactionReclaimTransaction(t *testing.T) {
+	tm, index := setupTransaction(t)
+	oldId, newId, otherId := uuid.New(), uuid.New(), uuid.New()
+	tm.Begin(oldId)
+	tm.Begin(otherId)
+	if err := tm.Lock(oldId, index, 0, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error locking:", err)
+	}
+	if err := tm.ReclaimTransaction(oldId, otherId); err == nil {
+		t.Error("Expected reclaiming under the id of a running transaction to fail")
+	}
+	if err := tm.ReclaimTransaction(oldId, newId); err != nil {
+		t.Fatal("Error reclaiming transaction:", err)
+	}
+	if _, found := tm.GetTransaction(oldId); found {
+		t.Error("Expected the old client to have no transaction once reclaimed")
+	}
+	if resources, _ := tm.GetLockedResources(newId); len(resources) != 1 {
+		t.Errorf("Expected the reclaimed transaction to hold 1 lock, but it holds %d", len(resources))
+	}
+	// The lock is still held, now by the new client
+	ch := make(chan error, 1)
+	go func() { ch <- tm.Lock(otherId, index, 0, concurrency.R_LOCK) }()
+	time.Sleep(DELAY_TIME)
+	if len(ch) != 0 {
+		t.Fatal("Expected locking a key held by the reclaimed transaction to wait")
+	}
+	if err := tm.Commit(newId); err != nil {
+		t.Fatal("Error committing under the new id:", err)
+	}
+	if err := lockResult(t, ch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	if err := tm.ReclaimTransaction(oldId, newId); err == nil {
+		t.Error("Expected reclaiming a client with no transaction to fail")
+	}
+}
+
+func testTransactionCommitUnlocksDespiteErrors(t *testing.T) {
+	tm, index := setupTransaction(t)
+	clientId, otherId := uuid.New(), uuid.New()
+	tm.Begin(clientId)
+	tm.Begin(otherId)
+	for key := int64(0); key < 4; key++ {
+		if err := tm.Lock(clientId, index, key, concurrency.W_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	// Release one lock behind the transaction manager's back, so that unlocking it fails
+	resources, _ := tm.GetLockedResources(clientId)
+	for r, lType := range resources {
+		if !r.IsTable() && r.GetResourceKey() == 2 {
+			if err := tm.GetResourceLockManager().Unlock(r, lType); err != nil {
+				t.Fatal("Error unlocking:", err)
+			}
+		}
+	}
+	if err := tm.Commit(clientId); err == nil {
+		t.Error("Expected committing to return the error of the failed unlock")
+	}
+	if _, found := tm.GetTransaction(clientId); found {
+		t.Error("Expected the transaction to be removed despite the failed unlock")
+	}
+	for key := int64(0); key < 4; key++ {
+		if ok, err := tm.TryLock(otherId, index, key, concurrency.W_LOCK); err != nil || !ok {
+			t.Errorf("Expected key %d to be released, but locking it returned %v, %v", key, ok, err)
+		}
+	}
+	if err := tm.Begin(clientId); err != nil {
+		t.Error("Expected the client to begin again after committing:", err)
+	}
+}
+
+func testTransactionReentrantReadLocks(t *testing.T) {
+	tm, index := setupTransaction(t)
+	clientId, otherId := uuid.New(), uuid.New()
+	tm.Begin(clientId)
+	tm.Begin(otherId)
+	for i := 0; i < 2; i++ {
+		if err := tm.Lock(clientId, index, 0, concurrency.R_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	if err := tm.Unlock(clientId, index, 0, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error unlocking:", err)
+	}
+	// The second hold keeps the entry locked
+	if ok, err := tm.TryLock(otherId, index, 0, concurrency.W_LOCK); err != nil || ok {
+		t.Fatalf("Expected the entry to stay read locked after the first unlock, but locking it returned %v, %v", ok, err)
+	}
+	if err := tm.Unlock(clientId, index, 0, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error unlocking:", err)
+	}
+	if ok, err := tm.TryLock(otherId, index, 0, concurrency.W_LOCK); err != nil || !ok {
+		t.Errorf("Expected the entry to be free after the second unlock, but locking it returned %v, %v", ok, err)
+	}
+	if err := tm.Unlock(clientId, index, 0, concurrency.R_LOCK); err == nil {
+		t.Error("Expected a third unlock to fail")
+	}
+	tm.Commit(otherId)
+
+	// Upgrading leaves a single hold of the write lock
+	for i := 0; i < 2; i++ {
+		if err := tm.Lock(clientId, index, 1, concurrency.R_LOCK); err != nil {
+			t.Fatal("Error locking:", err)
+		}
+	}
+	if err := tm.Lock(clientId, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error upgrading:", err)
+	}
+	if err := tm.Unlock(clientId, index, 1, concurrency.W_LOCK); err != nil {
+		t.Fatal("Error unlocking:", err)
+	}
+	if resources, _ := tm.GetLockedResources(clientId); len(resources) != 0 {
+		t.Errorf("Expected no locks held after unlocking the upgraded entry, but %d are", len(resources))
+	}
+	tm.Commit(clientId)
+}
+
+func testTransactionDeadlockTimeout(t *testing.T) {
+	tm, index := setupTransaction(t, concurrency.WithDeadlockTimeout(5*DELAY_TIME))
+	older, younger, olderch, youngerch := setupConflict(t, tm, index)
+	// Closing the cycle doesn't fail at once, but only once a wait times out
+	time.Sleep(DELAY_TIME)
+	if len(olderch) != 0 || len(youngerch) != 0 {
+		t.Fatal("Expected both transactions to wait until the deadlock timeout")
+	}
+	// The younger transaction waited first and holds as many locks, so it's the victim
+	var deadlock *concurrency.DeadlockError
+	if err := lockResult(t, youngerch); !errors.As(err, &deadlock) {
+		t.Fatalf("Expected the younger transaction's lock to fail with a deadlock, got %v", err)
+	}
+	if deadlock.Victim != younger {
+		t.Errorf("Expected the younger transaction to be the victim, got %v", deadlock.Victim)
+	}
+	tm.Abort(younger)
+	if err := lockResult(t, olderch); err != nil {
+		t.Error("Error locking:", err)
+	}
+	tm.Commit(older)
+	if stats := tm.Stats(); stats.DeadlocksDetected != 1 {
+		t.Errorf("Expected 1 deadlock detected, got %d", stats.DeadlocksDetected)
+	}
+}
+
+// BenchmarkDeadlockDetection measures transactions contending for a few keys, each locking some
+// of them in ascending order so that none deadlock, with deadlocks looked for before every wait
+// and only once a wait times out.
+func BenchmarkDeadlockDetection(b *testing.B) {
+	modes := []struct {
+		name string
+		opts []concurrency.Option
+	}{
+		{"Eager", nil},
+		{"Lazy", []concurrency.Option{concurrency.WithDeadlockTimeout(time.Second)}},
+	}
+	const workers, keys, keysPerTransaction = 256, 32, 8
+	for _, mode := range modes {
+		b.Run(mode.name, func(b *testing.B) {
+			index, err := btree.OpenIndex(filepath.Join(b.TempDir(), "bench.db"))
+			if err != nil {
+				b.Fatal("Failed to create index:", err)
+			}
+			defer index.Close()
+			tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager(), mode.opts...)
+			defer tm.Close()
+			var next atomic.Int64
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for w := 0; w < workers; w++ {
+				wg.Add(1)
+				go func(seed int64) {
+					defer wg.Done()
+					clientId, rng := uuid.New(), rand.New(rand.NewSource(seed))
+					for next.Add(1) <= int64(b.N) {
+						if err := tm.Begin(clientId); err != nil {
+							b.Error("Error beginning:", err)
+							return
+						}
+						locked := rng.Perm(keys)[:keysPerTransaction]
+						slices.Sort(locked)
+						for _, key := range locked {
+							if err := tm.Lock(clientId, index, int64(key), concurrency.W_LOCK); err != nil {
+								b.Error("Error locking:", err)
+								return
+							}
+						}
+						if err := tm.Commit(clientId); err != nil {
+							b.Error("Error committing:", err)
+							return
+						}
+					}
+				}(int64(w))
+			}
+			wg.Wait()
+		})
+	}
+}
@@ -87,6 +87,8 @@ func TestPager(t *testing.T) {
 	t.Run("PincountsOnClose", testPincountsOnClose)
 	t.Run("GetExistingChangedPage", testGetExistingChangedPage)
 	t.Run("GetNewPagesStress", testGetNewPagesStress)
+	t.Run("PageLSN", testPageLSN)
+	t.Run("FlushOnlyDirtyPages", testFlushOnlyDirtyPages)
 }
 
 /*
@@ -263,4 +265,79 @@ func testGetNewPagesStress(t *testing.T) {
 		}
 		_ = p.PutPage(page)
 	}
-}
\ No newline at end of file
+}
+
+/*
+Stamps a page with write LSNs, checking that its LSN only ever increases,
+even when the whole page is overwritten, and that it survives a flush.
+*/
+func testPageLSN(t *testing.T) {
+	p := setupPager(t)
+	page := getNewPage(t, p, false)
+	if lsn := page.GetLSN(); lsn != 0 {
+		t.Fatalf("Expected a new page to have LSN 0, but it has LSN %d", lsn)
+	}
+	data := []byte("hello")
+	page.Update(data, 0, int64(len(data)))
+	if lsn := page.GetLSN(); lsn != 0 {
+		t.Fatalf("Expected an update without a write LSN to leave LSN 0, but got %d", lsn)
+	}
+	p.SetWriteLSN(5)
+	page.Update(data, 0, int64(len(data)))
+	if lsn := page.GetLSN(); lsn != 5 {
+		t.Fatalf("Expected the page to be stamped with LSN 5, but it has LSN %d", lsn)
+	}
+	p.SetWriteLSN(3)
+	page.Update(data, 0, int64(len(data)))
+	p.SetWriteLSN(0)
+	page.Update(make([]byte, pager.Pagesize), 0, pager.Pagesize)
+	if lsn := page.GetLSN(); lsn != 5 {
+		t.Fatalf("Expected the page's LSN to stay 5, but it's %d", lsn)
+	}
+	_ = p.PutPage(page)
+
+	p.FlushPage(page)
+	closeAndReopen(t, p)
+	page = getPage(t, p, 0, true)
+	if lsn := page.GetLSN(); lsn != 5 {
+		t.Fatalf("Expected the page's LSN to be flushed as 5, but it's %d", lsn)
+	}
+}
+
+/*
+Dirties some of a pager's pages, checking that flushing all pages writes only
+those, and that a page updated after a flush is written again by the next one.
+*/
+func testFlushOnlyDirtyPages(t *testing.T) {
+	p := setupPager(t)
+	data := []byte("hello")
+	pages := make([]*pager.Page, 10)
+	for i := range pages {
+		pages[i] = getNewPage(t, p, true)
+	}
+	p.FlushAllPages()
+	written := p.GetNumPagesWritten()
+	if written != int64(len(pages)) {
+		t.Fatalf("Expected %d new pages to be written, but %d were", len(pages), written)
+	}
+	if dirty := p.GetNumDirtyPages(); dirty != 0 {
+		t.Fatalf("Expected no dirty pages after flushing, but found %d", dirty)
+	}
+	p.FlushAllPages()
+	if p.GetNumPagesWritten() != written {
+		t.Fatal("Expected flushing only clean pages to write nothing")
+	}
+
+	pages[3].Update(data, 0, int64(len(data)))
+	pages[7].Update(data, 0, int64(len(data)))
+	if dirty := p.GetNumDirtyPages(); dirty != 2 {
+		t.Fatalf("Expected 2 dirty pages, but found %d", dirty)
+	}
+	p.FlushAllPages()
+	if n := p.GetNumPagesWritten() - written; n != 2 {
+		t.Fatalf("Expected only the 2 dirty pages to be written, but %d were", n)
+	}
+	if pages[3].IsDirty() || pages[7].IsDirty() {
+		t.Error("Expected flushed pages to be clean")
+	}
+}
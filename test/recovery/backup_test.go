@@ -0,0 +1,704 @@
+package recovery_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dinodb/pkg/btree"
+	"dinodb/pkg/concurrency"
+	"dinodb/pkg/config"
+	"dinodb/pkg/database"
+	"dinodb/pkg/pager"
+	"dinodb/pkg/recovery"
+)
+
+func TestBackup(t *testing.T) {
+	t.Run("IncrementalIdentical", testIncrementalIdentical)
+	t.Run("RemovesDeletedFiles", testRemovesDeletedFiles)
+	t.Run("CrashMidSwap", testCrashMidSwap)
+	t.Run("RestoreGeneration", testRestoreGeneration)
+	t.Run("RestoreFromSnapshot", testRestoreFromSnapshot)
+	t.Run("ArchiveRoundTrip", testArchiveRoundTrip)
+	t.Run("BackupFolder", testBackupFolder)
+	t.Run("IgnoresPartialBackup", testIgnoresPartialBackup)
+	t.Run("CheckpointBackupFails", testCheckpointBackupFails)
+	t.Run("VerifyBackup", testVerifyBackup)
+	t.Run("CustomBackuper", testCustomBackuper)
+}
+
+// recoveryFolder returns the backup folder of the database
+func recoveryFolder(db *database.Database) string {
+	return strings.TrimSuffix(db.GetBasePath(), "/") + "-recovery"
+}
+
+// The name of the file marking a backup folder as complete
+const completeMarker = ".committed"
+
+// checkFoldersIdentical asserts that both folders hold exactly the same files with the same contents,
+// apart from the backup's marker of a complete backup
+func checkFoldersIdentical(t *testing.T, expected string, actual string) {
+	collect := func(folder string) map[string][]byte {
+		files := make(map[string][]byte)
+		err := filepath.WalkDir(folder, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() || entry.Name() == completeMarker {
+				return err
+			}
+			rel, _ := filepath.Rel(folder, path)
+			files[rel], err = os.ReadFile(path)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("Failed to read folder %q: %s", folder, err)
+		}
+		return files
+	}
+	files := collect(expected)
+	actualFiles := collect(actual)
+	for name, data := range files {
+		actualData, ok := actualFiles[name]
+		if !ok {
+			t.Errorf("Expected file %q to be in the backup", name)
+		} else if !bytes.Equal(data, actualData) {
+			t.Errorf("Expected file %q to be identical in the backup", name)
+		}
+	}
+	for name := range actualFiles {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Expected file %q to not be in the backup", name)
+		}
+	}
+}
+
+func testIncrementalIdentical(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 500; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	checkFoldersIdentical(t, db.GetBasePath(), recoveryFolder(db))
+
+	// Change a few entries so that only some pages differ from the backup
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 500; i += 100 {
+		updateTableEntry(t, db, tm, rm, clientId, tableName, i, i+1)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	checkFoldersIdentical(t, db.GetBasePath(), recoveryFolder(db))
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 500; i++ {
+		expected := i
+		if i%100 == 0 {
+			expected++
+		}
+		checkFind(t, db, tm, clientId, tableName, i, expected)
+	}
+}
+
+func testRemovesDeletedFiles(t *testing.T) {
+	db, _, rm, _ := setupRecovery(t, "")
+	extra := filepath.Join(db.GetBasePath(), "extra")
+	if err := os.WriteFile(extra, []byte("stale"), 0666); err != nil {
+		t.Fatal("Failed to write file:", err)
+	}
+	checkpoint(t, rm)
+	if _, err := os.Stat(filepath.Join(recoveryFolder(db), "extra")); err != nil {
+		t.Fatal("Expected the new file to be backed up:", err)
+	}
+	if err := os.Remove(extra); err != nil {
+		t.Fatal("Failed to remove file:", err)
+	}
+	checkpoint(t, rm)
+	checkFoldersIdentical(t, db.GetBasePath(), recoveryFolder(db))
+}
+
+func testCrashMidSwap(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 10, 10)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Simulate crashing while swapping in the next backup: the complete backup has been
+	// moved aside (where the old flow would have removed it) and the new one is half-written
+	backup := recoveryFolder(db)
+	if err := os.Rename(backup, backup+".old"); err != nil {
+		t.Fatal("Failed to move backup aside:", err)
+	}
+	if err := os.RemoveAll(backup + ".tmp"); err != nil {
+		t.Fatal("Failed to remove temporary backup:", err)
+	}
+	if err := os.MkdirAll(backup+".tmp", 0775); err != nil {
+		t.Fatal("Failed to create temporary backup:", err)
+	}
+	if err := os.WriteFile(filepath.Join(backup+".tmp", tableName), []byte("partial"), 0666); err != nil {
+		t.Fatal("Failed to write partial backup:", err)
+	}
+
+	// The database folder can't be trusted after a crash, so recovery must rely on the backup
+	if err := os.Remove(filepath.Join(db.GetBasePath(), tableName)); err != nil {
+		t.Fatal("Failed to remove table file:", err)
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 11; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// The next checkpoint must replace the partial backup with a complete one
+	checkpoint(t, rm)
+	checkFoldersIdentical(t, db.GetBasePath(), backup)
+}
+
+func testRestoreGeneration(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithBackupRetention(2))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	for i := int64(1); i <= 3; i++ {
+		startTransaction(t, db, tm, rm, clientId)
+		updateTableEntry(t, db, tm, rm, clientId, tableName, 0, i)
+		commitTransaction(t, db, tm, rm, clientId)
+		checkpoint(t, rm)
+	}
+
+	backups, err := rm.ListBackups()
+	if err != nil {
+		t.Fatal("Error listing backups:", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backup generations to be kept, found %d", len(backups))
+	}
+	if !backups[0].Before(backups[1]) {
+		t.Error("Expected backup generations to be listed from oldest to newest")
+	}
+	if err = rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+
+	// Step back a generation, to the checkpoint after the second update
+	folder := strings.TrimSuffix(db.GetBasePath(), "/")
+	db, err = recovery.RestoreBackup(folder, backups[0])
+	if err != nil {
+		t.Fatal("Error restoring backup:", err)
+	}
+	tm = concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	rm, err = recovery.NewRecoveryManager(db, tm, filepath.Join(folder, config.LogFileName))
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	if _, err = rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 2)
+	commitTransaction(t, db, tm, rm, clientId)
+	if err = rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal("Error closing database:", err)
+	}
+}
+
+func testRestoreFromSnapshot(t *testing.T) {
+	// Hand-build a snapshot from the backup of another database
+	src, srcTm, srcRm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, src, srcRm, database.BTreeIndexType)
+	startTransaction(t, src, srcTm, srcRm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, src, srcTm, srcRm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, src, srcTm, srcRm, clientId)
+	checkpoint(t, srcRm)
+	snapshot := t.TempDir()
+	if err := os.CopyFS(snapshot, os.DirFS(recoveryFolder(src))); err != nil {
+		t.Fatal("Failed to copy snapshot:", err)
+	}
+
+	folder := filepath.Join(t.TempDir(), "db")
+	if err := os.MkdirAll(folder, 0775); err != nil {
+		t.Fatal("Failed to create database folder:", err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, config.LogFileName), nil, 0666); err != nil {
+		t.Fatal("Failed to create log file:", err)
+	}
+
+	// A folder without a log file isn't a snapshot and must leave the database untouched
+	if _, err := recovery.RestoreFrom(folder, t.TempDir()); err == nil {
+		t.Fatal("Expected restoring from a folder without a log file to fail")
+	}
+	if _, err := os.Stat(filepath.Join(folder, config.LogFileName)); err != nil {
+		t.Fatal("Expected the database folder to be left alone:", err)
+	}
+
+	db, err := recovery.RestoreFrom(folder, snapshot)
+	if err != nil {
+		t.Fatal("Error restoring from snapshot:", err)
+	}
+	defer db.Close()
+	tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	rm, err := recovery.NewRecoveryManager(db, tm, filepath.Join(folder, config.LogFileName))
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	defer rm.Close()
+	if _, err = rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+}
+
+func testArchiveRoundTrip(t *testing.T) {
+	src, srcTm, srcRm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, src, srcRm, database.BTreeIndexType)
+	startTransaction(t, src, srcTm, srcRm, clientId)
+	for i := int64(0); i < 100; i++ {
+		insertIntoTable(t, src, srcTm, srcRm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, src, srcTm, srcRm, clientId)
+	var archive bytes.Buffer
+	if err := srcRm.BackupTo(&archive); err != nil {
+		t.Fatal("Error backing up to archive:", err)
+	}
+
+	folder := filepath.Join(t.TempDir(), "db")
+	db, err := recovery.RestoreFromArchive(&archive, folder)
+	if err != nil {
+		t.Fatal("Error restoring from archive:", err)
+	}
+	defer db.Close()
+	tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	rm, err := recovery.NewRecoveryManager(db, tm, filepath.Join(folder, config.LogFileName))
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	defer rm.Close()
+	if _, err = rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 100; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+}
+
+func testBackupFolder(t *testing.T) {
+	for _, fuzzy := range []bool{false, true} {
+		t.Run(map[bool]string{false: "Checkpoint", true: "FuzzyCheckpoint"}[fuzzy], func(t *testing.T) {
+			backup := filepath.Join(t.TempDir(), "elsewhere", "backup")
+			dbName := filepath.Join(t.TempDir(), "db")
+			opt := recovery.WithBackupFolder(backup)
+			db, tm, rm, clientId := setupRecovery(t, dbName, opt)
+			tableName := createTable(t, db, rm, database.BTreeIndexType)
+			startTransaction(t, db, tm, rm, clientId)
+			for i := int64(0); i < 10; i++ {
+				insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+			}
+			commitTransaction(t, db, tm, rm, clientId)
+			if fuzzy {
+				if err := rm.FuzzyCheckpoint(); err != nil {
+					t.Fatal("Error taking a fuzzy checkpoint:", err)
+				}
+			} else {
+				checkpoint(t, rm)
+			}
+			startTransaction(t, db, tm, rm, clientId)
+			updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 100)
+			commitTransaction(t, db, tm, rm, clientId)
+
+			if _, err := os.Stat(filepath.Join(backup, tableName)); err != nil {
+				t.Error("Expected the table to be backed up to the configured folder:", err)
+			}
+			if _, err := os.Stat(dbName + "-recovery"); !os.IsNotExist(err) {
+				t.Errorf("Expected no backup next to the database folder, got %v", err)
+			}
+
+			func() {
+				defer revive(t)
+				panic("simulating database crash")
+			}()
+			db, tm, rm, _ = setupRecovery(t, dbName, opt)
+			if _, err := rm.Recover(); err != nil {
+				t.Fatal("Error recovering:", err)
+			}
+			startTransaction(t, db, tm, rm, clientId)
+			checkFind(t, db, tm, clientId, tableName, 0, 100)
+			for i := int64(1); i < 10; i++ {
+				checkFind(t, db, tm, clientId, tableName, i, i)
+			}
+		})
+	}
+}
+
+func testIgnoresPartialBackup(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 10, 10)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Simulate a backup folder that was left half-written, without the marker of a complete backup
+	backup := recoveryFolder(db)
+	if err := os.RemoveAll(backup); err != nil {
+		t.Fatal("Failed to remove backup:", err)
+	}
+	if err := os.MkdirAll(backup, 0775); err != nil {
+		t.Fatal("Failed to create partial backup:", err)
+	}
+	if err := os.WriteFile(filepath.Join(backup, tableName), []byte("partial"), 0666); err != nil {
+		t.Fatal("Failed to write partial backup:", err)
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	data, err := os.ReadFile(filepath.Join(db.GetBasePath(), tableName))
+	if err != nil {
+		t.Fatal("Failed to read table file:", err)
+	}
+	if string(data) == "partial" {
+		t.Fatal("Expected Prime not to restore the database from a partial backup")
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 11; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+}
+
+func testCheckpointBackupFails(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+
+	// Put a file where the next backup is taken, so that taking it fails
+	tmp := recoveryFolder(db) + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		t.Fatal("Failed to remove temporary backup:", err)
+	}
+	if err := os.WriteFile(tmp, []byte("in the way"), 0666); err != nil {
+		t.Fatal("Failed to create file:", err)
+	}
+	err := rm.Checkpoint()
+	if !errors.Is(err, recovery.ErrStaleBackup) {
+		t.Fatal("Expected checkpointing to report a stale backup, got", err)
+	}
+	if !strings.Contains(err.Error(), tmp) {
+		t.Errorf("Expected the error to name %s, got %q", tmp, err)
+	}
+	// The checkpoint log was still written
+	cursor, err := rm.NewCheckpointCursor()
+	if err != nil {
+		t.Fatal("Error opening a checkpoint cursor:", err)
+	}
+	if n := countCursor(t, cursor); n != 1 {
+		t.Errorf("Expected the checkpoint log to be the last log, but %d logs follow the last checkpoint", n-1)
+	}
+
+	// Once the backup can be taken again, checkpointing succeeds
+	if err = os.Remove(tmp); err != nil {
+		t.Fatal("Failed to remove file:", err)
+	}
+	checkpoint(t, rm)
+}
+
+func testVerifyBackup(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	hashTable := createTable(t, db, rm, database.HashIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for key := int64(0); key < 5; key++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, key, key)
+		insertIntoTable(t, db, tm, rm, clientId, hashTable, key, key)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+
+	// Edits since the checkpoint, committed or not, are reconstructed from the log
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 4, 40)
+	deleteFromTable(t, db, tm, rm, clientId, hashTable, 3)
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 5, 5)
+	if err := rm.VerifyBackup(); err != nil {
+		t.Fatal("Expected the backup to verify, got", err)
+	}
+
+	// Corrupt the value of key 0 in the backup, which no edit since the checkpoint touches
+	file := filepath.Join(recoveryFolder(db), tableName)
+	f, err := os.OpenFile(file, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal("Failed to open backup file:", err)
+	}
+	value := make([]byte, binary.MaxVarintLen64)
+	binary.PutVarint(value, 99)
+	_, err = f.WriteAt(value, btree.LEAF_NODE_HEADER_SIZE+binary.MaxVarintLen64)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal("Failed to corrupt backup file:", err)
+	}
+	err = rm.VerifyBackup()
+	if !errors.Is(err, recovery.ErrBackupDiverges) {
+		t.Fatal("Expected verifying the corrupted backup to fail, got", err)
+	}
+	if !strings.Contains(err.Error(), file) || !strings.Contains(err.Error(), "key 0") {
+		t.Errorf("Expected the error to name %s and key 0, got %q", file, err)
+	}
+}
+
+// memBackuper is a Backuper keeping its snapshots in memory, recording the folders it was given
+type memBackuper struct {
+	mtx         sync.Mutex
+	snapshots   []map[string][]byte
+	times       []time.Time
+	snapshotted []string
+	restored    []string
+}
+
+func (b *memBackuper) Snapshot(srcDir string) error {
+	files := make(map[string][]byte)
+	err := filepath.WalkDir(srcDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(srcDir, path)
+		files[rel], err = os.ReadFile(path)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.snapshots = append(b.snapshots, files)
+	b.times = append(b.times, time.Now())
+	b.snapshotted = append(b.snapshotted, filepath.Clean(srcDir))
+	return nil
+}
+
+func (b *memBackuper) Restore(dstDir string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.restored = append(b.restored, filepath.Clean(dstDir))
+	if len(b.snapshots) == 0 {
+		return recovery.ErrNoBackup
+	}
+	// Keep the log, which is newer than the snapshot's
+	isLog := func(name string) bool { return strings.HasPrefix(name, config.LogFileName) }
+	entries, err := os.ReadDir(dstDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !isLog(entry.Name()) {
+			if err = os.RemoveAll(filepath.Join(dstDir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	for rel, data := range b.snapshots[len(b.snapshots)-1] {
+		if isLog(rel) {
+			continue
+		}
+		if err = os.MkdirAll(filepath.Dir(filepath.Join(dstDir, rel)), 0775); err != nil {
+			return err
+		}
+		if err = os.WriteFile(filepath.Join(dstDir, rel), data, 0666); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *memBackuper) List() ([]time.Time, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return append([]time.Time(nil), b.times...), nil
+}
+
+func testCustomBackuper(t *testing.T) {
+	backuper := &memBackuper{}
+	opt := recovery.WithBackuper(backuper)
+	dbName := filepath.Join(t.TempDir(), "db")
+	db, tm, rm, clientId := setupRecovery(t, dbName, opt)
+	// Prime asked for a backup, but there was none yet
+	if len(backuper.restored) != 1 || backuper.restored[0] != dbName {
+		t.Fatalf("Expected Prime to restore %s once, got %v", dbName, backuper.restored)
+	}
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	if len(backuper.snapshotted) != 0 {
+		t.Fatalf("Expected no snapshot before checkpointing, got %v", backuper.snapshotted)
+	}
+
+	// A checkpoint snapshots the database folder, with every table flushed
+	checkpoint(t, rm)
+	if len(backuper.snapshotted) != 1 || backuper.snapshotted[0] != dbName {
+		t.Fatalf("Expected a checkpoint to snapshot %s, got %v", dbName, backuper.snapshotted)
+	}
+	if _, ok := backuper.snapshots[0][tableName]; !ok {
+		t.Errorf("Expected the snapshot to hold table %s", tableName)
+	}
+	// A fuzzy checkpoint snapshots the folder it copied the tables into
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 100)
+	commitTransaction(t, db, tm, rm, clientId)
+	if err := rm.FuzzyCheckpoint(); err != nil {
+		t.Fatal("Error taking a fuzzy checkpoint:", err)
+	}
+	if len(backuper.snapshotted) != 2 {
+		t.Fatalf("Expected a fuzzy checkpoint to take a snapshot, got %v", backuper.snapshotted)
+	}
+	if backups, err := rm.ListBackups(); err != nil || len(backups) != 2 {
+		t.Errorf("Expected the backuper to list 2 backups, got %v (error %v)", backups, err)
+	}
+	if _, err := os.Stat(recoveryFolder(db)); !os.IsNotExist(err) {
+		t.Errorf("Expected no local backup folder, got %v", err)
+	}
+
+	// Prime restores the latest snapshot, on top of which the log is recovered
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 1, 200)
+	commitTransaction(t, db, tm, rm, clientId)
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	db, tm, rm, _ = setupRecovery(t, dbName, opt)
+	if len(backuper.restored) != 2 {
+		t.Fatalf("Expected Prime to restore a snapshot, got %v", backuper.restored)
+	}
+	if _, err := rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 100)
+	checkFind(t, db, tm, clientId, tableName, 1, 200)
+	checkFind(t, db, tm, clientId, tableName, 9, 9)
+}
+
+// BenchmarkDelta measures checkpointing a large database after dirtying a single page,
+// both when the backup has to be taken from scratch and when it can be taken incrementally.
+// The database size in MB can be set with DINODB_BENCH_DB_MB, e.g. 1024 for a 1GB database.
+func BenchmarkDelta(b *testing.B) {
+	sizeMB := 64
+	if env, err := strconv.Atoi(os.Getenv("DINODB_BENCH_DB_MB")); err == nil {
+		sizeMB = env
+	}
+	for _, name := range []string{"Full", "Incremental"} {
+		b.Run(name, func(b *testing.B) {
+			db, rm, _ := setupBenchmark(b)
+			basePath := strings.TrimSuffix(db.GetBasePath(), "/")
+			bulk, err := os.Create(filepath.Join(basePath, "bulk"))
+			if err != nil {
+				b.Fatal("Failed to create database file:", err)
+			}
+			defer bulk.Close()
+			page := make([]byte, pager.Pagesize)
+			numPages := int64(sizeMB) << 20 / pager.Pagesize
+			for i := int64(0); i < numPages; i++ {
+				rand.Read(page)
+				if _, err = bulk.Write(page); err != nil {
+					b.Fatal("Failed to write database file:", err)
+				}
+			}
+			if err = rm.Checkpoint(); err != nil {
+				b.Fatal("Error checkpointing:", err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rand.Read(page)
+				if _, err = bulk.WriteAt(page, rand.Int63n(numPages)*pager.Pagesize); err != nil {
+					b.Fatal("Failed to write database file:", err)
+				}
+				if name == "Full" {
+					os.RemoveAll(basePath + "-recovery")
+					os.RemoveAll(basePath + "-recovery.tmp")
+				}
+				if err = rm.Checkpoint(); err != nil {
+					b.Fatal("Error checkpointing:", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkCheckpointMostlyReads measures checkpointing a table after a mostly-read workload
+// that updates a single key between checkpoints, reporting the pages written per checkpoint
+// against the table's size in pages: only the pages modified since the last one are written.
+func BenchmarkCheckpointMostlyReads(b *testing.B) {
+	_, rm, table := setupBenchmark(b)
+	const numKeys = 20000
+	for key := int64(0); key < numKeys; key++ {
+		if err := table.Insert(key, key); err != nil {
+			b.Fatal("Error inserting:", err)
+		}
+	}
+	if err := rm.Checkpoint(); err != nil {
+		b.Fatal("Error checkpointing:", err)
+	}
+	var written int64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			if _, err := table.Find(rand.Int63n(numKeys)); err != nil {
+				b.Fatal("Error finding:", err)
+			}
+		}
+		if err := table.Update(rand.Int63n(numKeys), int64(i)); err != nil {
+			b.Fatal("Error updating:", err)
+		}
+		before := table.GetPager().GetNumPagesWritten()
+		if err := rm.Checkpoint(); err != nil {
+			b.Fatal("Error checkpointing:", err)
+		}
+		written += table.GetPager().GetNumPagesWritten() - before
+	}
+	b.ReportMetric(float64(written)/float64(b.N), "pages-written/op")
+	b.ReportMetric(float64(table.GetPager().GetNumPages()), "table-pages")
+}
@@ -0,0 +1,306 @@
+package recovery_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"dinodb/pkg/concurrency"
+	"dinodb/pkg/config"
+	"dinodb/pkg/database"
+	"dinodb/pkg/recovery"
+)
+
+func TestDurability(t *testing.T) {
+	t.Run("BufferedUncommittedLost", testBufferedUncommittedLost)
+	t.Run("GroupCommitConcurrent", testGroupCommitConcurrent)
+	t.Run("AsyncCommitDurable", testAsyncCommitDurable)
+	t.Run("AsyncFlushAndClose", testAsyncFlushAndClose)
+	t.Run("NoSync", testNoSync)
+}
+
+// logFileSize returns the size of the database's log file
+func logFileSize(t *testing.T, db *database.Database) int64 {
+	info, err := os.Stat(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to stat log file:", err)
+	}
+	return info.Size()
+}
+
+func testBufferedUncommittedLost(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+	size := logFileSize(t, db)
+
+	// Edits of an uncommitted transaction stay in the buffer
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 1)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	if logFileSize(t, db) != size {
+		t.Error("Expected uncommitted logs to be buffered")
+	}
+
+	// Kill the recovery manager without committing
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+}
+
+func testGroupCommitConcurrent(t *testing.T) {
+	db, tm, rm, _ := setupRecovery(t, "", recovery.WithGroupCommit(config.GroupCommitInterval))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+
+	// Commit many small transactions at once so that their syncs are coalesced
+	const numClients = 16
+	var wg sync.WaitGroup
+	errs := make(chan error, numClients)
+	for i := 0; i < numClients; i++ {
+		wg.Add(1)
+		go func(key int64) {
+			defer wg.Done()
+			clientId := uuid.New()
+			payloads := []string{
+				"transaction begin",
+				fmt.Sprintf("insert %d %d into %s", key, key, tableName),
+				"transaction commit",
+			}
+			for j, payload := range payloads {
+				var err error
+				if j == 1 {
+					err = recovery.HandleInsert(db, tm, rm, payload, clientId)
+				} else {
+					err = recovery.HandleTransaction(db, tm, rm, payload, clientId)
+				}
+				if err != nil {
+					errs <- fmt.Errorf("error running %q: %w", payload, err)
+					return
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Every committed transaction must have been durable once Commit returned
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	clientId := uuid.New()
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < numClients; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+func testAsyncCommitDurable(t *testing.T) {
+	// Use an interval long enough that buffered logs are never written in the background
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithAsyncWrites(time.Hour))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	if size := logFileSize(t, db); size != 0 {
+		t.Errorf("Expected logs to be buffered before committing, but the log file has %d bytes", size)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	if logFileSize(t, db) == 0 {
+		t.Error("Expected logs to be written once committed")
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+
+	// The committed transaction must survive the crash
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+}
+
+func testAsyncFlushAndClose(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithAsyncWrites(time.Hour))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+	size := logFileSize(t, db)
+	if size == 0 {
+		t.Error("Expected Flush to write out buffered logs")
+	}
+
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	if logFileSize(t, db) != size {
+		t.Error("Expected logs to be buffered until closing")
+	}
+	if err := rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+	if logFileSize(t, db) <= size {
+		t.Error("Expected Close to write out buffered logs")
+	}
+	if err := rm.Flush(); !errors.Is(err, recovery.ErrClosed) {
+		t.Error("Expected Flush after Close to return ErrClosed, but got:", err)
+	}
+}
+
+// syncCountingLog is a memory log that counts how many times it is synced.
+type syncCountingLog struct {
+	*recovery.MemoryLog
+	syncs atomic.Int64
+}
+
+func (l *syncCountingLog) Sync() error {
+	l.syncs.Add(1)
+	return l.MemoryLog.Sync()
+}
+
+func testNoSync(t *testing.T) {
+	for name, durability := range map[string]recovery.Durability{
+		"Full":   recovery.FullDurability,
+		"NoSync": recovery.NoSyncDurability,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dbName := filepath.Join(t.TempDir(), "db")
+			log := &syncCountingLog{MemoryLog: recovery.NewMemoryLog()}
+			setup := func() (*database.Database, *concurrency.TransactionManager, *recovery.RecoveryManager) {
+				db, err := recovery.Prime(dbName)
+				if err != nil {
+					t.Fatal("Error priming database:", err)
+				}
+				t.Cleanup(func() { db.Close() })
+				tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+				rm, err := recovery.NewRecoveryManager(db, tm, filepath.Join(dbName, config.LogFileName),
+					recovery.WithLogStorage(log), recovery.WithDurability(durability))
+				if err != nil {
+					t.Fatal("Error constructing recovery manager:", err)
+				}
+				return db, tm, rm
+			}
+			db, tm, rm := setup()
+			clientId := uuid.New()
+			tableName := createTable(t, db, rm, database.BTreeIndexType)
+			startTransaction(t, db, tm, rm, clientId)
+			insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+			commitTransaction(t, db, tm, rm, clientId)
+			checkpoint(t, rm)
+			startTransaction(t, db, tm, rm, clientId)
+			insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+			commitTransaction(t, db, tm, rm, clientId)
+			if syncs := log.syncs.Load(); durability == recovery.NoSyncDurability && syncs != 0 {
+				t.Errorf("Expected the log never to be synced, but it was synced %d times", syncs)
+			} else if durability == recovery.FullDurability && syncs == 0 {
+				t.Error("Expected the log to be synced")
+			}
+
+			// The committed records were still written, in order
+			func() {
+				defer revive(t)
+				panic("simulating database crash")
+			}()
+			db, tm, rm = setup()
+			defer rm.Close()
+			if _, err := rm.Recover(); err != nil {
+				t.Fatal("Error recovering:", err)
+			}
+			startTransaction(t, db, tm, rm, clientId)
+			checkFind(t, db, tm, clientId, tableName, 0, 0)
+			checkFind(t, db, tm, clientId, tableName, 1, 1)
+		})
+	}
+}
+
+// BenchmarkNoSync measures the cost of committing single-edit transactions with and without
+// syncing the log file.
+func BenchmarkNoSync(b *testing.B) {
+	durabilities := map[string]recovery.Durability{
+		"Full":   recovery.FullDurability,
+		"NoSync": recovery.NoSyncDurability,
+	}
+	for name, durability := range durabilities {
+		b.Run(name, func(b *testing.B) {
+			_, rm, table := setupBenchmark(b, recovery.WithDurability(durability))
+			clientId := uuid.New()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				rm.Start(clientId)
+				rm.Edit(clientId, table, recovery.INSERT_ACTION, int64(i), 0, int64(i))
+				if err := rm.Commit(clientId); err != nil {
+					b.Fatal("Error committing:", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkAsyncWrites measures the cost of logging edits within transactions
+// with and without asynchronous writes.
+func BenchmarkAsyncWrites(b *testing.B) {
+	intervals := map[string]time.Duration{
+		"Default": 0,
+		"Async":   config.GroupCommitInterval,
+	}
+	const editsPerTransaction = 100
+	for name, interval := range intervals {
+		b.Run(name, func(b *testing.B) {
+			_, rm, table := setupBenchmark(b, recovery.WithAsyncWrites(interval))
+			clientId := uuid.New()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%editsPerTransaction == 0 {
+					rm.Start(clientId)
+				}
+				rm.Edit(clientId, table, recovery.INSERT_ACTION, int64(i), 0, int64(i))
+				if i%editsPerTransaction == editsPerTransaction-1 || i == b.N-1 {
+					if err := rm.Commit(clientId); err != nil {
+						b.Fatal("Error committing:", err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkGroupCommit measures commit throughput of many concurrent committers
+// with and without group commit.
+func BenchmarkGroupCommit(b *testing.B) {
+	intervals := map[string]time.Duration{
+		"Default":     0,
+		"GroupCommit": config.GroupCommitInterval,
+	}
+	const numCommitters = 32
+	for name, interval := range intervals {
+		b.Run(name, func(b *testing.B) {
+			_, rm, table := setupBenchmark(b, recovery.WithGroupCommit(interval))
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for c := 0; c < numCommitters; c++ {
+				wg.Add(1)
+				go func(c int) {
+					defer wg.Done()
+					clientId := uuid.New()
+					for i := c; i < b.N; i += numCommitters {
+						rm.Start(clientId)
+						rm.Edit(clientId, table, recovery.INSERT_ACTION, int64(i), 0, int64(i))
+						if err := rm.Commit(clientId); err != nil {
+							b.Error("Error committing:", err)
+							return
+						}
+					}
+				}(c)
+			}
+			wg.Wait()
+		})
+	}
+}
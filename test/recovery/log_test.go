@@ -0,0 +1,1753 @@
+package recovery_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"dinodb/pkg/concurrency"
+	"dinodb/pkg/config"
+	"dinodb/pkg/database"
+	"dinodb/pkg/recovery"
+)
+
+func TestLog(t *testing.T) {
+	t.Run("LSNIncreasing", testLSNIncreasing)
+	t.Run("ChecksumDetectsCorruption", testChecksumDetectsCorruption)
+	t.Run("TornWriteTruncated", testTornWriteTruncated)
+	t.Run("BinaryFormat", testBinaryFormat)
+	t.Run("Close", testClose)
+	t.Run("CreatesMissingLogFile", testCreatesMissingLogFile)
+	t.Run("RotationAcrossSegments", testRotationAcrossSegments)
+	t.Run("TruncateBeforeCheckpoint", testTruncateBeforeCheckpoint)
+	t.Run("TruncateRemovesSegments", testTruncateRemovesSegments)
+	t.Run("CompressedSegments", testCompressedSegments)
+	t.Run("LogPrefetch", testLogPrefetch)
+	t.Run("Timestamps", testTimestamps)
+	t.Run("ManualClock", testManualClock)
+	t.Run("StringFormat", testStringFormat)
+	t.Run("EditBatch", testEditBatch)
+	t.Run("EditBatchFails", testEditBatchFails)
+	t.Run("EditBatchRotationFails", testEditBatchRotationFails)
+	t.Run("EditWithoutStart", testEditWithoutStart)
+	t.Run("Dump", testDump)
+	t.Run("FailedWrites", testFailedWrites)
+	t.Run("Verify", testVerify)
+	t.Run("CompactLog", testCompactLog)
+	t.Run("Encryption", testEncryption)
+	t.Run("Subscribe", testSubscribe)
+	t.Run("Version", testVersion)
+}
+
+// checkLSNIncreased asserts that the recovery manager's LSN is strictly greater than prevLSN,
+// returning the current LSN
+func checkLSNIncreased(t *testing.T, rm *recovery.RecoveryManager, prevLSN uint64, op string) uint64 {
+	lsn := rm.GetLSN()
+	if lsn <= prevLSN {
+		t.Errorf("Expected LSN to increase after %s, but went from %d to %d", op, prevLSN, lsn)
+	}
+	return lsn
+}
+
+func testLSNIncreasing(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	lsn := rm.GetLSN()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	lsn = checkLSNIncreased(t, rm, lsn, "Table")
+	startTransaction(t, db, tm, rm, clientId)
+	lsn = checkLSNIncreased(t, rm, lsn, "Start")
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	lsn = checkLSNIncreased(t, rm, lsn, "Edit")
+	commitTransaction(t, db, tm, rm, clientId)
+	lsn = checkLSNIncreased(t, rm, lsn, "Commit")
+	checkpoint(t, rm)
+	lsn = checkLSNIncreased(t, rm, lsn, "Checkpoint")
+
+	// LSNs must keep increasing after a restart
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	if rm.GetLSN() < lsn {
+		t.Errorf("Expected LSN to resume from %d after recovery, but got %d", lsn, rm.GetLSN())
+	}
+	lsn = rm.GetLSN()
+	startTransaction(t, db, tm, rm, clientId)
+	checkLSNIncreased(t, rm, lsn, "Start after recovery")
+}
+
+func testChecksumDetectsCorruption(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	rm.SetChecksums(true)
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Flip a byte of the table name in the edit log
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	data, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	pos := bytes.Index(data, []byte(", "+tableName))
+	if pos < 0 {
+		t.Fatal("Failed to find the edit log in the log file")
+	}
+	data[pos+2] = 'z'
+	if err = os.WriteFile(logFileName, data, 0666); err != nil {
+		t.Fatal("Failed to write log file:", err)
+	}
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	_, _, rm, _ = setupRecovery(t, db.GetBasePath())
+	_, err = rm.Recover()
+	var corruptErr *recovery.CorruptLogError
+	if !errors.As(err, &corruptErr) {
+		t.Fatal("Expected a CorruptLogError when recovering, but got:", err)
+	}
+	if !errors.Is(err, recovery.ErrCorruptLog) {
+		t.Error("Expected the CorruptLogError to match ErrCorruptLog")
+	}
+	lineStart := int64(bytes.LastIndexByte(data[:pos], '\n') + 1)
+	if corruptErr.Offset != lineStart {
+		t.Errorf("Expected corrupt log to be reported at byte offset %d, but got %d", lineStart, corruptErr.Offset)
+	}
+}
+
+func testTornWriteTruncated(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Append a half-written edit log, as if we crashed in the middle of writing it
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	torn := fmt.Sprintf("%d < %s, %s, INSERT, 12345, 0", rm.GetLSN()+1, clientId, tableName)
+	logFile, err := os.OpenFile(logFileName, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal("Failed to open log file:", err)
+	}
+	_, err = logFile.WriteString(torn)
+	logFile.Close()
+	if err != nil {
+		t.Fatal("Failed to write torn log:", err)
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+	checkFindFails(t, db, tm, clientId, tableName, 12345)
+
+	data, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	if bytes.Contains(data, []byte(torn)) {
+		t.Error("Expected the torn log to be truncated from the log file")
+	}
+}
+
+func testBinaryFormat(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	if err := rm.SetLogFormat(recovery.BinaryLogFormat); err != nil {
+		t.Fatal("Failed to set binary log format:", err)
+	}
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 1)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+
+	// The string format can't be mixed into a binary log file
+	if err := rm.SetLogFormat(recovery.StringLogFormat); err == nil {
+		t.Error("Expected an error changing the format of a non-empty log file")
+	}
+
+	// Append the first half of a frame, as if we crashed in the middle of writing it
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	logFile, err := os.OpenFile(logFileName, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal("Failed to open log file:", err)
+	}
+	_, err = logFile.Write([]byte{0xDB, 0, 0, 0, 40, 0, 0})
+	logFile.Close()
+	if err != nil {
+		t.Fatal("Failed to write torn log:", err)
+	}
+	lsn := rm.GetLSN()
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	if rm.GetLSN() <= lsn {
+		t.Errorf("Expected LSN to resume after %d, but got %d", lsn, rm.GetLSN())
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// The recovered log must still be readable after a second crash
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+}
+
+// openFileCount returns how many of this process's file descriptors refer to the specified file.
+func openFileCount(t *testing.T, fileName string) int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("Cannot list open file descriptors:", err)
+	}
+	count := 0
+	for _, fd := range fds {
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && target == fileName {
+			count++
+		}
+	}
+	return count
+}
+
+func testClose(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+
+	logFileName, err := filepath.Abs(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to resolve log file path:", err)
+	}
+	logFileName, err = filepath.EvalSymlinks(logFileName)
+	if err != nil {
+		t.Fatal("Failed to resolve log file path:", err)
+	}
+	if openFileCount(t, logFileName) == 0 {
+		t.Fatal("Expected the log file to be open before Close")
+	}
+	if err = rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+	if n := openFileCount(t, logFileName); n != 0 {
+		t.Errorf("Expected the log file to be released after Close, but %d descriptors remain", n)
+	}
+
+	// Every logging call must now fail with ErrClosed
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatal("Failed to get table:", err)
+	}
+	_, recoverErr := rm.Recover()
+	calls := map[string]error{
+		"Table":      rm.Table(string(database.BTreeIndexType), tableName),
+		"Edit":       rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1),
+		"Start":      rm.Start(clientId),
+		"Commit":     rm.Commit(clientId),
+		"Checkpoint": rm.Checkpoint(),
+		"Recover":    recoverErr,
+		"Close":      rm.Close(),
+	}
+	for name, err := range calls {
+		if !errors.Is(err, recovery.ErrClosed) {
+			t.Errorf("Expected %s after Close to return ErrClosed, but got: %v", name, err)
+		}
+	}
+}
+
+func testCreatesMissingLogFile(t *testing.T) {
+	t.Parallel()
+	dbName := t.TempDir()
+	db, err := database.Open(dbName + "/")
+	if err != nil {
+		t.Fatal("Error opening database:", err)
+	}
+	defer db.Close()
+
+	// Point the recovery manager at a log file that doesn't exist yet
+	logFileName := filepath.Join(dbName, config.LogFileName)
+	tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	rm, err := recovery.NewRecoveryManager(db, tm, logFileName, recovery.WithLogFileMode(0600))
+	if err != nil {
+		t.Fatal("Expected NewRecoveryManager to create a missing log file, but got:", err)
+	}
+	defer rm.Close()
+	info, err := os.Stat(logFileName)
+	if err != nil {
+		t.Fatal("Expected the log file to be created:", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Expected the new log file to be empty, but it has %d bytes", info.Size())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the new log file to have mode 0600, but got %v", info.Mode().Perm())
+	}
+	if _, err = rm.Recover(); err != nil {
+		t.Error("Error recovering from an empty log:", err)
+	}
+}
+
+func testRotationAcrossSegments(t *testing.T) {
+	const maxLogSize = 1024
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithMaxLogSize(maxLogSize))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+
+	// Write past the threshold with a committed transaction, then again with an uncommitted one
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 15; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 15; i++ {
+		updateTableEntry(t, db, tm, rm, clientId, tableName, i, i+100)
+	}
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	segments, err := filepath.Glob(logFileName + ".0*")
+	if err != nil {
+		t.Fatal("Failed to list log segments:", err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("Expected the log to be rotated at least twice, but found %d segments", len(segments))
+	}
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			t.Fatal("Failed to stat log segment:", err)
+		}
+		if info.Size() > maxLogSize {
+			t.Errorf("Expected segment %s to be at most %d bytes, but it has %d", segment, maxLogSize, info.Size())
+		}
+	}
+
+	// Recovery must read the table, edits, and commit across every segment
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 15; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+// logSize returns the total size of the database's log file and all of its segments
+func logSize(t *testing.T, db *database.Database) int64 {
+	files, err := filepath.Glob(filepath.Join(db.GetBasePath(), config.LogFileName) + "*")
+	if err != nil {
+		t.Fatal("Failed to list log files:", err)
+	}
+	var size int64
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal("Failed to stat log file:", err)
+		}
+		size += info.Size()
+	}
+	return size
+}
+
+func testTruncateBeforeCheckpoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 50; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// A transaction in flight across the checkpoint must still be undoable afterwards
+	inFlightId := uuid.New()
+	startTransaction(t, db, tm, rm, inFlightId)
+	insertIntoTable(t, db, tm, rm, inFlightId, tableName, 100, 100)
+	checkpoint(t, rm)
+	insertIntoTable(t, db, tm, rm, inFlightId, tableName, 101, 101)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 50, 50)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	before := logSize(t, db)
+	if err := rm.TruncateBeforeCheckpoint(); err != nil {
+		t.Fatal("Error truncating log:", err)
+	}
+	after := logSize(t, db)
+	if after*4 > before {
+		t.Errorf("Expected truncating to shrink the log to a fraction of %d bytes, but it has %d", before, after)
+	}
+
+	// Logging must continue normally after truncating
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 51, 51)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 52; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	checkFindFails(t, db, tm, clientId, tableName, 100)
+	checkFindFails(t, db, tm, clientId, tableName, 101)
+}
+
+func testTruncateRemovesSegments(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithMaxLogSize(1024))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 30; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	if err := rm.TruncateBeforeCheckpoint(); err != nil {
+		t.Fatal("Error truncating log:", err)
+	}
+	segments, err := filepath.Glob(filepath.Join(db.GetBasePath(), config.LogFileName) + ".0*")
+	if err != nil {
+		t.Fatal("Failed to list log segments:", err)
+	}
+	if len(segments) > 1 {
+		t.Errorf("Expected segments before the checkpoint to be removed, but found %d", len(segments))
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 30; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+func testCompressedSegments(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithMaxLogSize(1024), recovery.WithCompressSealedSegments(true))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	// A transaction in flight across several segments must be undone from the compressed ones
+	inFlightId := uuid.New()
+	startTransaction(t, db, tm, rm, inFlightId)
+	insertIntoTable(t, db, tm, rm, inFlightId, tableName, 100, 100)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 30; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	insertIntoTable(t, db, tm, rm, inFlightId, tableName, 101, 101)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(30); i < 60; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	if err := rm.TruncateBeforeCheckpoint(); err != nil {
+		t.Fatal("Error truncating log:", err)
+	}
+
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	segments, err := filepath.Glob(logFileName + ".0*")
+	if err != nil {
+		t.Fatal("Failed to list log segments:", err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("Expected the log to be rotated into several segments, but found %v", segments)
+	}
+	for _, segment := range segments {
+		data, err := os.ReadFile(segment)
+		if err != nil {
+			t.Fatal("Failed to read segment:", err)
+		}
+		if !strings.HasSuffix(segment, ".gz") || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			t.Errorf("Expected segment %s to be gzip-compressed", segment)
+		}
+	}
+	if data, _ := os.ReadFile(logFileName); bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Error("Expected the log file itself not to be compressed")
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 60; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	checkFindFails(t, db, tm, clientId, tableName, 100)
+	checkFindFails(t, db, tm, clientId, tableName, 101)
+}
+
+func testLogPrefetch(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithMaxLogSize(1024), recovery.WithCompressSealedSegments(true))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	// A transaction in flight since several segments ago sends recovery back through them
+	inFlightId := uuid.New()
+	startTransaction(t, db, tm, rm, inFlightId)
+	insertIntoTable(t, db, tm, rm, inFlightId, tableName, 100, 100)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 30; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(30); i < 60; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Recovering a copy without the prefetch must give the same result as with it
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	copyName := filepath.Join(t.TempDir(), "db")
+	if err := os.CopyFS(copyName, os.DirFS(db.GetBasePath())); err != nil {
+		t.Fatal("Failed to copy database:", err)
+	}
+	_, _, rm, _ = setupRecovery(t, copyName, recovery.WithLogPrefetch(false))
+	want, err := rm.Recover()
+	if err != nil {
+		t.Fatal("Error recovering without the prefetch:", err)
+	}
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath())
+	got, err := rm.Recover()
+	if err != nil {
+		t.Fatal("Error recovering with the prefetch:", err)
+	}
+	if got.RecordsScanned != want.RecordsScanned || got.RecordsRedone != want.RecordsRedone ||
+		got.RecordsUndone != want.RecordsUndone || got.CheckpointLSN != want.CheckpointLSN {
+		t.Errorf("Expected recovery with the prefetch to match recovery without it, but got %+v and %+v", got, want)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 60; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	checkFindFails(t, db, tm, clientId, tableName, 100)
+}
+
+// setupBenchmark creates a RecoveryManager with the specified options over a fresh database,
+// returning both along with a table to log edits to
+func setupBenchmark(b *testing.B, opts ...recovery.Option) (*database.Database, *recovery.RecoveryManager, database.Index) {
+	dbName := b.TempDir()
+	db, err := database.Open(dbName + "/")
+	if err != nil {
+		b.Fatal("Error opening database:", err)
+	}
+	b.Cleanup(func() { db.Close() })
+	tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	rm, err := recovery.NewRecoveryManager(db, tm, filepath.Join(dbName, config.LogFileName), opts...)
+	if err != nil {
+		b.Fatal("Error constructing recovery manager:", err)
+	}
+	b.Cleanup(func() { rm.Close() })
+	table, err := db.CreateTable("bench", database.BTreeIndexType)
+	if err != nil {
+		b.Fatal("Error creating table:", err)
+	}
+	return db, rm, table
+}
+
+// BenchmarkLogFormats measures the cost, and the allocations, of writing edit logs in each log format.
+// Run with -benchtime=1000000x to benchmark a million edits.
+func BenchmarkLogFormats(b *testing.B) {
+	formats := map[string]recovery.LogFormat{
+		"String": recovery.StringLogFormat,
+		"Binary": recovery.BinaryLogFormat,
+	}
+	for name, format := range formats {
+		b.Run(name, func(b *testing.B) {
+			_, rm, table := setupBenchmark(b)
+			if err := rm.SetLogFormat(format); err != nil {
+				b.Fatal("Error setting log format:", err)
+			}
+			clientId := uuid.New()
+			if err := rm.Start(clientId); err != nil {
+				b.Fatal("Error starting transaction:", err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := rm.Edit(clientId, table, recovery.UPDATE_ACTION, int64(i), int64(i), int64(i+1)); err != nil {
+					b.Fatal("Error writing edit log:", err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkBulkLoad measures logging a transaction loading 100k rows, with one Edit per row
+// or one EditBatch per 1000 rows.
+func BenchmarkBulkLoad(b *testing.B) {
+	const rows, batchSize = 100_000, 1000
+	b.Run("Edit", func(b *testing.B) {
+		_, rm, table := setupBenchmark(b)
+		clientId := uuid.New()
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := rm.Start(clientId); err != nil {
+				b.Fatal("Error starting transaction:", err)
+			}
+			for key := int64(0); key < rows; key++ {
+				if err := rm.Edit(clientId, table, recovery.INSERT_ACTION, key, 0, key); err != nil {
+					b.Fatal("Error writing edit log:", err)
+				}
+			}
+			if err := rm.Commit(clientId); err != nil {
+				b.Fatal("Error committing:", err)
+			}
+		}
+	})
+	b.Run("EditBatch", func(b *testing.B) {
+		_, rm, table := setupBenchmark(b)
+		clientId := uuid.New()
+		batch := make([]recovery.EditRecord, batchSize)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := rm.Start(clientId); err != nil {
+				b.Fatal("Error starting transaction:", err)
+			}
+			for start := int64(0); start < rows; start += batchSize {
+				for j := range batch {
+					key := start + int64(j)
+					batch[j] = recovery.EditRecord{Table: table, Action: recovery.INSERT_ACTION, Key: key, NewVal: key}
+				}
+				if err := rm.EditBatch(clientId, batch); err != nil {
+					b.Fatal("Error writing edit batch:", err)
+				}
+			}
+			if err := rm.Commit(clientId); err != nil {
+				b.Fatal("Error committing:", err)
+			}
+		}
+	})
+}
+
+// BenchmarkConcurrentEdits measures the throughput of edit logs written by 64 clients at once.
+func BenchmarkConcurrentEdits(b *testing.B) {
+	const clients = 64
+	_, rm, table := setupBenchmark(b)
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for c := 0; c < clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			clientId := uuid.New()
+			if err := rm.Start(clientId); err != nil {
+				b.Error("Error starting transaction:", err)
+				return
+			}
+			for i := c; i < b.N; i += clients {
+				if err := rm.Edit(clientId, table, recovery.UPDATE_ACTION, int64(i), int64(i), int64(i+1)); err != nil {
+					b.Error("Error writing edit log:", err)
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+}
+
+// BenchmarkReadLogs measures recovering from a log of 100k lines that are all relevant,
+// since a transaction that started before them is still running at the checkpoint after them.
+func BenchmarkReadLogs(b *testing.B) {
+	_, rm, table := setupBenchmark(b)
+	longId, clientId := uuid.New(), uuid.New()
+	if err := table.Insert(0, 0); err != nil {
+		b.Fatal("Error inserting entry:", err)
+	}
+	if err := rm.Start(longId); err != nil {
+		b.Fatal("Error starting transaction:", err)
+	}
+	if err := rm.Edit(longId, table, recovery.INSERT_ACTION, 0, 0, 0); err != nil {
+		b.Fatal("Error writing edit log:", err)
+	}
+	if err := rm.Start(clientId); err != nil {
+		b.Fatal("Error starting transaction:", err)
+	}
+	for i := int64(1); i <= 100000; i++ {
+		if err := rm.Edit(clientId, table, recovery.UPDATE_ACTION, i, i, i+1); err != nil {
+			b.Fatal("Error writing edit log:", err)
+		}
+	}
+	if err := rm.Commit(clientId); err != nil {
+		b.Fatal("Error committing transaction:", err)
+	}
+	if err := rm.Checkpoint(); err != nil {
+		b.Fatal("Error checkpointing:", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := rm.Recover(); err != nil {
+			b.Fatal("Error recovering:", err)
+		}
+	}
+}
+
+// BenchmarkRedo measures recovering 8 tables of 20k committed inserts each from scratch,
+// redoing the tables' logs serially and in parallel.
+func BenchmarkRedo(b *testing.B) {
+	db, rm, _ := setupBenchmark(b)
+	clientId := uuid.New()
+	if err := rm.Start(clientId); err != nil {
+		b.Fatal("Error starting transaction:", err)
+	}
+	for i := 0; i < 8; i++ {
+		table, err := db.CreateTable(fmt.Sprintf("table%d", i), database.BTreeIndexType)
+		if err != nil {
+			b.Fatal("Error creating table:", err)
+		}
+		if err = rm.Table(string(database.BTreeIndexType), table.GetName()); err != nil {
+			b.Fatal("Error writing table log:", err)
+		}
+		for key := int64(0); key < 20000; key++ {
+			if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, key, 0, key); err != nil {
+				b.Fatal("Error writing edit log:", err)
+			}
+		}
+	}
+	if err := rm.Commit(clientId); err != nil {
+		b.Fatal("Error committing transaction:", err)
+	}
+	logs, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		b.Fatal("Failed to read log file:", err)
+	}
+
+	for name, workers := range map[string]int{"Serial": 1, "Parallel": 8} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				// Recover each time into an empty database with only the log file
+				b.StopTimer()
+				dbName := filepath.Join(b.TempDir(), "db")
+				db, err := database.Open(dbName)
+				if err != nil {
+					b.Fatal("Error opening database:", err)
+				}
+				logFileName := filepath.Join(dbName, config.LogFileName)
+				if err = os.WriteFile(logFileName, logs, 0666); err != nil {
+					b.Fatal("Failed to write log file:", err)
+				}
+				tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+				rm, err := recovery.NewRecoveryManager(db, tm, logFileName, recovery.WithRedoWorkers(workers))
+				if err != nil {
+					b.Fatal("Error constructing recovery manager:", err)
+				}
+				b.StartTimer()
+				if _, err = rm.Recover(); err != nil {
+					b.Fatal("Error recovering:", err)
+				}
+				b.StopTimer()
+				rm.Close()
+				db.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// BenchmarkRecoveryPrefetch measures recovering 100k updates by a transaction running at the
+// checkpoint, which recovery reads both backward and forward, with and without the log prefetch.
+// Warm recovers repeatedly with the same recovery manager, and Cold with a new one over a new copy
+// of the log file each time, though the OS may still cache the copy.
+func BenchmarkRecoveryPrefetch(b *testing.B) {
+	db, rm, table := setupBenchmark(b)
+	longId, clientId := uuid.New(), uuid.New()
+	if err := rm.Start(longId); err != nil {
+		b.Fatal("Error starting transaction:", err)
+	}
+	if err := rm.Table(string(database.BTreeIndexType), table.GetName()); err != nil {
+		b.Fatal("Error writing table log:", err)
+	}
+	if err := rm.Edit(longId, table, recovery.INSERT_ACTION, 0, 0, 0); err != nil {
+		b.Fatal("Error writing edit log:", err)
+	}
+	if err := rm.Start(clientId); err != nil {
+		b.Fatal("Error starting transaction:", err)
+	}
+	for i := int64(1); i <= 100000; i++ {
+		if err := rm.Edit(clientId, table, recovery.UPDATE_ACTION, i, i, i+1); err != nil {
+			b.Fatal("Error writing edit log:", err)
+		}
+	}
+	if err := rm.Commit(clientId); err != nil {
+		b.Fatal("Error committing transaction:", err)
+	}
+	if err := rm.Checkpoint(); err != nil {
+		b.Fatal("Error checkpointing:", err)
+	}
+	logs, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		b.Fatal("Failed to read log file:", err)
+	}
+
+	for name, prefetch := range map[string]bool{"Prefetch": true, "NoPrefetch": false} {
+		b.Run(name+"/Warm", func(b *testing.B) {
+			logFileName := filepath.Join(b.TempDir(), config.LogFileName)
+			if err := os.WriteFile(logFileName, logs, 0666); err != nil {
+				b.Fatal("Failed to write log file:", err)
+			}
+			db, err := database.Open(filepath.Dir(logFileName))
+			if err != nil {
+				b.Fatal("Error opening database:", err)
+			}
+			b.Cleanup(func() { db.Close() })
+			tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+			rm, err := recovery.NewRecoveryManager(db, tm, logFileName, recovery.WithLogPrefetch(prefetch))
+			if err != nil {
+				b.Fatal("Error constructing recovery manager:", err)
+			}
+			b.Cleanup(func() { rm.Close() })
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := rm.Recover(); err != nil {
+					b.Fatal("Error recovering:", err)
+				}
+			}
+		})
+		b.Run(name+"/Cold", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				dbName := filepath.Join(b.TempDir(), "db")
+				db, err := database.Open(dbName)
+				if err != nil {
+					b.Fatal("Error opening database:", err)
+				}
+				logFileName := filepath.Join(dbName, config.LogFileName)
+				if err = os.WriteFile(logFileName, logs, 0666); err != nil {
+					b.Fatal("Failed to write log file:", err)
+				}
+				tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+				rm, err := recovery.NewRecoveryManager(db, tm, logFileName, recovery.WithLogPrefetch(prefetch))
+				if err != nil {
+					b.Fatal("Error constructing recovery manager:", err)
+				}
+				b.StartTimer()
+				if _, err = rm.Recover(); err != nil {
+					b.Fatal("Error recovering:", err)
+				}
+				b.StopTimer()
+				rm.Close()
+				db.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func testTimestamps(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	before := time.Now().UnixNano()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 50; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	after := time.Now().UnixNano()
+
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	data, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	timestampExp := regexp.MustCompile(`^\d+ @(\d+) <`)
+	prev := before
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		match := timestampExp.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("Expected log %q to have a timestamp", line)
+		}
+		timestamp, _ := strconv.ParseInt(match[1], 10, 64)
+		if timestamp < prev || timestamp > after {
+			t.Fatalf("Expected timestamp of log %q to be between %d and %d", line, prev, after)
+		}
+		prev = timestamp
+	}
+
+	// Logs written before timestamps were introduced must still be read
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 50, 50)
+	if err = rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+	logFile, err := os.OpenFile(logFileName, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal("Failed to open log file:", err)
+	}
+	fmt.Fprintf(logFile, "%d < %s commit >\n", rm.GetLSN()+1, clientId)
+	logFile.Close()
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 50, 50)
+}
+
+func testManualClock(t *testing.T) {
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := recovery.NewManualClock(start)
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithClock(clock), recovery.WithBackupRetention(1))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	clock.Advance(time.Second)
+	startTransaction(t, db, tm, rm, clientId)
+	clock.Advance(time.Second)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	clock.Advance(time.Second)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpointAt := clock.Advance(time.Minute)
+	checkpoint(t, rm)
+
+	// Each log is timestamped with exactly the time the clock read
+	data, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	timestampExp := regexp.MustCompile(`^\d+ @(\d+) <`)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	// The version log heading the log file is timestamped along with the first log
+	expected := []time.Time{start, start, start.Add(time.Second), start.Add(2 * time.Second), start.Add(3 * time.Second), checkpointAt}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d logs, got %d", len(expected), len(lines))
+	}
+	for i, line := range lines {
+		match := timestampExp.FindStringSubmatch(line)
+		if match == nil {
+			t.Fatalf("Expected log %q to have a timestamp", line)
+		}
+		timestamp, _ := strconv.ParseInt(match[1], 10, 64)
+		if timestamp != expected[i].UnixNano() {
+			t.Errorf("Expected log %q to be timestamped %d", line, expected[i].UnixNano())
+		}
+	}
+
+	// The backup generation is named after the checkpoint's time
+	backups, err := rm.ListBackups()
+	if err != nil {
+		t.Fatal("Error listing backups:", err)
+	}
+	if len(backups) != 1 || !backups[0].Equal(checkpointAt) {
+		t.Errorf("Expected a single backup generation taken at %v, got %v", checkpointAt, backups)
+	}
+
+	// Timestamps never go backward, even if the clock does
+	clock.Set(start)
+	startTransaction(t, db, tm, rm, clientId)
+	if err = rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+	data, err = os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	match := timestampExp.FindStringSubmatch(lines[len(lines)-1])
+	if match == nil || match[1] != strconv.FormatInt(checkpointAt.UnixNano(), 10) {
+		t.Errorf("Expected log %q to be timestamped %d", lines[len(lines)-1], checkpointAt.UnixNano())
+	}
+}
+
+func testStringFormat(t *testing.T) {
+	clock := recovery.NewManualClock(time.Unix(0, 1700000000123456789))
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithClock(clock))
+	otherId := uuid.New()
+	rm.SetChecksums(true)
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 10)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 1, 20)
+	deleteFromTable(t, db, tm, rm, clientId, tableName, 1)
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, otherId)
+	insertIntoTable(t, db, tm, rm, otherId, tableName, 2, 5)
+	checkpoint(t, rm)
+	reclaimedId := uuid.New()
+	if err := rm.ReclaimTransaction(otherId, reclaimedId); err != nil {
+		t.Fatal("Error reclaiming transaction:", err)
+	}
+	abortTransaction(t, tm, rm, reclaimedId)
+
+	// Every log is written exactly as formatted below
+	logs := []string{
+		fmt.Sprintf("< create btree table %s >", tableName),
+		fmt.Sprintf("< %s start >", clientId),
+		fmt.Sprintf("< %s, %s, INSERT, 1, 0, 10, prev 2 >", clientId, tableName),
+		fmt.Sprintf("< %s, %s, UPDATE, 1, 10, 20, prev 3 >", clientId, tableName),
+		fmt.Sprintf("< %s, %s, DELETE, 1, 20, 0, prev 4 >", clientId, tableName),
+		fmt.Sprintf("< %s commit >", clientId),
+		fmt.Sprintf("< %s start >", otherId),
+		fmt.Sprintf("< %s, %s, INSERT, 2, 0, 5, prev 7 >", otherId, tableName),
+		fmt.Sprintf("< %s checkpoint >", otherId),
+		fmt.Sprintf("< %s reclaimed by %s >", otherId, reclaimedId),
+		fmt.Sprintf("< clr %s, %s, INSERT, 2, 0, 5, undoNext 0 >", reclaimedId, tableName),
+		fmt.Sprintf("< %s abort >", reclaimedId),
+	}
+	var expected strings.Builder
+	version := fmt.Sprintf("0 @%d < version 1 features 0 >", clock.Now().UnixNano())
+	fmt.Fprintf(&expected, "%s #%08x\n", version, crc32.ChecksumIEEE([]byte(version)))
+	for i, l := range logs {
+		line := fmt.Sprintf("%d @%d %s", i+1, clock.Now().UnixNano(), l)
+		fmt.Fprintf(&expected, "%s #%08x\n", line, crc32.ChecksumIEEE([]byte(line)))
+	}
+	data, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	if string(data) != expected.String() {
+		t.Errorf("Expected the log file to read:\n%s\ngot:\n%s", expected.String(), data)
+	}
+}
+
+func testEditBatch(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	otherId := uuid.New()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	// Load a batch of rows in one transaction, and update them in another that never commits
+	startTransaction(t, db, tm, rm, clientId)
+	inserts := make([]recovery.EditRecord, 0, 100)
+	for i := int64(0); i < 100; i++ {
+		inserts = append(inserts, recovery.EditRecord{Table: table, Action: recovery.INSERT_ACTION, Key: i, NewVal: i})
+	}
+	lsn := rm.GetLSN()
+	if err = rm.EditBatch(clientId, inserts); err != nil {
+		t.Fatal("Error writing edit batch:", err)
+	}
+	if rm.GetLSN() != lsn+100 {
+		t.Errorf("Expected the batch to take LSNs %d through %d, but the LSN is %d", lsn+1, lsn+100, rm.GetLSN())
+	}
+	for _, edit := range inserts {
+		if err = table.Insert(edit.Key, edit.NewVal); err != nil {
+			t.Fatal("Error inserting entry:", err)
+		}
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, otherId)
+	updates := make([]recovery.EditRecord, 0, 50)
+	for i := int64(0); i < 50; i++ {
+		updates = append(updates, recovery.EditRecord{Table: table, Action: recovery.UPDATE_ACTION, Key: i, OldVal: i, NewVal: i + 1000})
+	}
+	if err = rm.EditBatch(otherId, updates); err != nil {
+		t.Fatal("Error writing edit batch:", err)
+	}
+	for _, edit := range updates {
+		if err = table.Update(edit.Key, edit.NewVal); err != nil {
+			t.Fatal("Error updating entry:", err)
+		}
+	}
+	// Every edit of the batch can be undone
+	if size, err := rm.Savepoint(otherId); err != nil || size != 50 {
+		t.Errorf("Expected 50 edits to be left to undo, found %d (error %v)", size, err)
+	}
+	if err = rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 100; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+func testEditBatchFails(t *testing.T) {
+	// Writing to /dev/full always fails as if the disk were full
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("Cannot simulate a full disk without /dev/full:", err)
+	}
+	db, tm, _, clientId := setupRecovery(t, "")
+	rm, err := recovery.NewRecoveryManager(db, tm, "/dev/full")
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	defer rm.Close()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	// The batch is too large to be buffered, so writing it out fails
+	edits := make([]recovery.EditRecord, 0, 1000)
+	for i := int64(0); i < 1000; i++ {
+		edits = append(edits, recovery.EditRecord{Table: table, Action: recovery.INSERT_ACTION, Key: i, NewVal: i})
+	}
+	if err = rm.EditBatch(clientId, edits); err == nil {
+		t.Fatal("Expected writing the batch to a full disk to fail")
+	}
+	if size, err := rm.Savepoint(clientId); err != nil || size != 0 {
+		t.Errorf("Expected no edit of the failed batch to be left to undo, found %d (error %v)", size, err)
+	}
+	if err = rm.Commit(clientId); err == nil {
+		t.Error("Expected Commit to fail after a failed batch")
+	}
+}
+
+func testEditBatchRotationFails(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithMaxLogSize(1024))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	// A folder in the way of the first segment keeps the log file from being rotated
+	segment := filepath.Join(db.GetBasePath(), config.LogFileName) + ".000001"
+	if err = os.MkdirAll(filepath.Join(segment, "blocker"), 0775); err != nil {
+		t.Fatal("Failed to create folder:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	// The batch only fits in the log file past its first few edits
+	edits := make([]recovery.EditRecord, 0, 50)
+	for i := int64(0); i < 50; i++ {
+		edits = append(edits, recovery.EditRecord{Table: table, Action: recovery.INSERT_ACTION, Key: i, NewVal: i})
+	}
+	lsn := rm.GetLSN()
+	if err = rm.EditBatch(clientId, edits); err == nil {
+		t.Fatal("Expected writing the batch to fail when the log file can't be rotated")
+	}
+	if rm.GetLSN() != lsn {
+		t.Errorf("Expected no edit of the failed batch to be logged, but the LSN went from %d to %d", lsn, rm.GetLSN())
+	}
+	// Once the log file can be rotated again, the transaction commits without the batch
+	if err = os.RemoveAll(segment); err != nil {
+		t.Fatal("Failed to remove folder:", err)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 50; i++ {
+		checkFindFails(t, db, tm, clientId, tableName, i)
+	}
+}
+
+func testEditWithoutStart(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	// Neither Edit nor EditBatch logs anything for a client that never started a transaction
+	lsn := rm.GetLSN()
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1); !errors.Is(err, recovery.ErrNoTransaction) {
+		t.Errorf("Expected Edit without Start to return ErrNoTransaction, but got: %v", err)
+	}
+	batch := []recovery.EditRecord{{Table: table, Action: recovery.INSERT_ACTION, Key: 1, NewVal: 1}}
+	if err = rm.EditBatch(clientId, batch); !errors.Is(err, recovery.ErrNoTransaction) {
+		t.Errorf("Expected EditBatch without Start to return ErrNoTransaction, but got: %v", err)
+	}
+	if rm.GetLSN() != lsn {
+		t.Errorf("Expected nothing to be logged, but the LSN went from %d to %d", lsn, rm.GetLSN())
+	}
+
+	// Nor for one whose transaction already committed
+	startTransaction(t, db, tm, rm, clientId)
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1); err != nil {
+		t.Fatal("Error logging an insert:", err)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 2, 0, 2); !errors.Is(err, recovery.ErrNoTransaction) {
+		t.Errorf("Expected Edit after Commit to return ErrNoTransaction, but got: %v", err)
+	}
+}
+
+func testDump(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 7, 1)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 7, 2)
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	deleteFromTable(t, db, tm, rm, clientId, tableName, 7)
+	abortTransaction(t, tm, rm, clientId)
+	dropTable(t, db, rm, tableName)
+	if err := rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+
+	var out bytes.Buffer
+	if err := recovery.DumpLogFile(&out, filepath.Join(db.GetBasePath(), config.LogFileName)); err != nil {
+		t.Fatal("Error dumping log:", err)
+	}
+	id := clientId.String()
+	// Each row without its LSN and time
+	expected := [][]string{
+		{"LSN", "TIME", "TYPE", "CLIENT", "TABLE", "ACTION", "KEY", "CHANGE"},
+		{"TABLE", "-", tableName, "CREATE", "BTREE", "-", "-"},
+		{"START", id, "-", "-", "-", "-"},
+		{"EDIT", id, tableName, "INSERT", "7", "0→1"},
+		{"EDIT", id, tableName, "UPDATE", "7", "1→2"},
+		{"COMMIT", id, "-", "-", "-", "-"},
+		{"START", id, "-", "-", "-", "-"},
+		{"EDIT", id, tableName, "DELETE", "7", "2→0"},
+		{"CLR", id, tableName, "DELETE", "7", "2→0", "(undone,", "undoNext", "0)"},
+		{"ABORT", id, "-", "-", "-", "-"},
+		{"TABLE", "-", tableName, "DROP", "-", "-"},
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d rows in the dump, found %d:\n%s", len(expected), len(lines), out.String())
+	}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if i > 0 {
+			fields = fields[2:]
+		}
+		if strings.Join(fields, " ") != strings.Join(expected[i], " ") {
+			t.Errorf("Expected row %d of the dump to be %q, found %q", i, expected[i], fields)
+		}
+	}
+}
+
+// countCursor iterates the cursor to the end, returning the number of logs read
+func countCursor(t *testing.T, cursor *recovery.LogCursor) int {
+	defer cursor.Close()
+	count := 0
+	for {
+		_, err := cursor.Next()
+		if err == io.EOF {
+			return count
+		} else if err != nil {
+			t.Fatal("Error reading log with cursor:", err)
+		}
+		count++
+	}
+}
+
+// TestLogCursor isn't run in parallel with other tests so that it can measure memory use
+func TestLogCursor(t *testing.T) {
+	db, _, rm, _ := setupRecovery(t, filepath.Join(t.TempDir(), "db"), recovery.WithMaxLogSize(64<<10))
+	const numLogs = 100000
+	for i := 0; i < numLogs; i++ {
+		if err := rm.Table("btree", fmt.Sprintf("table%d", i)); err != nil {
+			t.Fatal("Error writing table log:", err)
+		}
+	}
+
+	// Iterate the whole log across its segments, which must not be held in memory
+	cursor, err := rm.NewLogCursor(0)
+	if err != nil {
+		t.Fatal("Error creating cursor:", err)
+	}
+	defer cursor.Close()
+	heapAlloc := func() uint64 {
+		var stats runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+	base := heapAlloc()
+	var peak uint64
+	count := 0
+	for {
+		_, err := cursor.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal("Error reading log with cursor:", err)
+		}
+		if count++; count%10000 == 0 {
+			peak = max(peak, heapAlloc())
+		}
+	}
+	// Every segment starts with a segment log
+	if count < numLogs {
+		t.Fatalf("Expected to read at least %d logs, read %d", numLogs, count)
+	}
+	if size := logSize(t, db); peak > base && peak-base > uint64(size)/10 {
+		t.Errorf("Expected memory use to stay constant, but grew by %d bytes reading a %d byte log", peak-base, size)
+	}
+
+	// Start from a given LSN, or the last checkpoint
+	cursor, err = rm.NewLogCursor(rm.GetLSN() - 9)
+	if err != nil {
+		t.Fatal("Error creating cursor:", err)
+	}
+	if n := countCursor(t, cursor); n != 10 {
+		t.Errorf("Expected to read the last 10 logs, read %d", n)
+	}
+	checkpoint(t, rm)
+	for i := 0; i < 5; i++ {
+		if err := rm.Table("btree", fmt.Sprintf("late%d", i)); err != nil {
+			t.Fatal("Error writing table log:", err)
+		}
+	}
+	cursor, err = rm.NewCheckpointCursor()
+	if err != nil {
+		t.Fatal("Error creating cursor:", err)
+	}
+	if n := countCursor(t, cursor); n != 6 {
+		t.Errorf("Expected to read the checkpoint and the 5 logs after it, read %d", n)
+	}
+}
+
+func testFailedWrites(t *testing.T) {
+	// Writing to /dev/full always fails as if the disk were full
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("Cannot simulate a full disk without /dev/full:", err)
+	}
+	db, tm, _, clientId := setupRecovery(t, "")
+	rm, err := recovery.NewRecoveryManager(db, tm, "/dev/full")
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	defer rm.Close()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	if err = rm.Flush(); err == nil {
+		t.Fatal("Expected writing to a full disk to fail")
+	}
+
+	checkStackSize := func(expected int) {
+		size, err := rm.Savepoint(clientId)
+		if err != nil {
+			t.Fatal("Error taking savepoint:", err)
+		}
+		if size != expected {
+			t.Errorf("Expected %d edits to be left to undo, found %d", expected, size)
+		}
+	}
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1); err == nil {
+		t.Error("Expected Edit to fail after a failed write")
+	}
+	checkStackSize(1)
+	if err = rm.Start(uuid.New()); err == nil {
+		t.Error("Expected Start to fail after a failed write")
+	}
+	if err = rm.Commit(clientId); err == nil {
+		t.Error("Expected Commit to fail after a failed write")
+	}
+	// The transaction didn't commit, so its edit must still be undoable
+	checkStackSize(1)
+}
+
+// writeVerifiableLog writes a log with a committed transaction and an unfinished one to a new
+// database, returning the database's folder, the name of its table, the id of the unfinished
+// transaction, and the LSN of the last log
+func writeVerifiableLog(t *testing.T) (string, string, uuid.UUID, uint64) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	rm.SetChecksums(true)
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 1, 2)
+	lsn := rm.GetLSN()
+	if err := rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+	return db.GetBasePath(), tableName, clientId, lsn
+}
+
+func testVerify(t *testing.T) {
+	// Each case appends broken logs to a good log and expects the error reported
+	cases := []struct {
+		name     string
+		logs     func(table string, id uuid.UUID, lsn uint64) string
+		expected error
+	}{
+		{"LSNOrder", func(table string, id uuid.UUID, lsn uint64) string {
+			return fmt.Sprintf("%d < %s commit >\n", lsn, id)
+		}, recovery.ErrLSNOrder},
+		{"UnknownTable", func(table string, id uuid.UUID, lsn uint64) string {
+			return fmt.Sprintf("%d < %s, missing, INSERT, 2, 0, 2 >\n", lsn+1, id)
+		}, recovery.ErrUnknownTable},
+		{"UnknownTransaction", func(table string, id uuid.UUID, lsn uint64) string {
+			return fmt.Sprintf("%d < %s, %s, INSERT, 2, 0, 2 >\n", lsn+1, uuid.New(), table)
+		}, recovery.ErrUnknownTransaction},
+		{"EndedTransaction", func(table string, id uuid.UUID, lsn uint64) string {
+			return fmt.Sprintf("%d < %s commit >\n%d < %s commit >\n", lsn+1, id, lsn+2, id)
+		}, recovery.ErrUnknownTransaction},
+		{"BadUndoNext", func(table string, id uuid.UUID, lsn uint64) string {
+			return fmt.Sprintf("%d < clr %s, %s, UPDATE, 1, 2, 1, undoNext %d >\n", lsn+1, id, table, lsn+5)
+		}, recovery.ErrBadUndoNext},
+		{"Corrupt", func(table string, id uuid.UUID, lsn uint64) string {
+			return fmt.Sprintf("%d < %s commit > #00000000\n", lsn+1, id)
+		}, &recovery.CorruptLogError{}},
+	}
+
+	t.Run("Good", func(t *testing.T) {
+		folder, _, clientId, lsn := writeVerifiableLog(t)
+		_, _, rm, _ := setupRecovery(t, folder)
+		report, err := rm.VerifyLog()
+		if err != nil {
+			t.Fatal("Error verifying log:", err)
+		}
+		if !report.OK() {
+			t.Errorf("Expected no problems in a good log, found:\n%s", report)
+		}
+		if report.Logs != int(lsn) {
+			t.Errorf("Expected %d logs to be verified, found %d", lsn, report.Logs)
+		}
+		if len(report.Unfinished) != 1 || report.Unfinished[0] != clientId {
+			t.Errorf("Expected only %v to be unfinished, found %v", clientId, report.Unfinished)
+		}
+	})
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			folder, tableName, clientId, lsn := writeVerifiableLog(t)
+			logFile, err := os.OpenFile(filepath.Join(folder, config.LogFileName), os.O_APPEND|os.O_WRONLY, 0666)
+			if err != nil {
+				t.Fatal("Failed to open log file:", err)
+			}
+			fmt.Fprint(logFile, c.logs(tableName, clientId, lsn))
+			logFile.Close()
+
+			_, _, rm, _ := setupRecovery(t, folder)
+			before, err := os.ReadFile(filepath.Join(folder, config.LogFileName))
+			if err != nil {
+				t.Fatal("Failed to read log file:", err)
+			}
+			report, err := rm.VerifyLog()
+			if err != nil {
+				t.Fatal("Error verifying log:", err)
+			}
+			if len(report.Problems) != 1 {
+				t.Fatalf("Expected exactly one problem, found:\n%s", report)
+			}
+			var corrupt *recovery.CorruptLogError
+			if errors.As(c.expected, &corrupt) {
+				if !errors.As(report.Problems[0].Err, &corrupt) {
+					t.Errorf("Expected a CorruptLogError, found %v", report.Problems[0])
+				}
+			} else if !errors.Is(report.Problems[0].Err, c.expected) {
+				t.Errorf("Expected %v, found %v", c.expected, report.Problems[0])
+			}
+			// Verifying mustn't change the log
+			after, err := os.ReadFile(filepath.Join(folder, config.LogFileName))
+			if err != nil {
+				t.Fatal("Failed to read log file:", err)
+			}
+			if !bytes.Equal(before, after) {
+				t.Error("Expected verifying to leave the log file unchanged")
+			}
+		})
+	}
+}
+
+func testCompactLog(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithMaxLogSize(1024))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, clientId)
+	for i := int64(1); i <= 50; i++ {
+		startTransaction(t, db, tm, rm, clientId)
+		updateTableEntry(t, db, tm, rm, clientId, tableName, 0, i)
+		commitTransaction(t, db, tm, rm, clientId)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	deleteFromTable(t, db, tm, rm, clientId, tableName, 1)
+	commitTransaction(t, db, tm, rm, clientId)
+	// The records of a transaction that hasn't finished must survive compaction
+	inFlightId := uuid.New()
+	startTransaction(t, db, tm, rm, inFlightId)
+	updateTableEntry(t, db, tm, rm, inFlightId, tableName, 2, 100)
+
+	before := logSize(t, db)
+	if err := rm.CompactLog(); err != nil {
+		t.Fatal("Error compacting log:", err)
+	}
+	if after := logSize(t, db); after*4 > before {
+		t.Errorf("Expected compacting to shrink the log to a fraction of %d bytes, but it has %d", before, after)
+	}
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	if segments, _ := filepath.Glob(logFileName + ".0*"); len(segments) > 0 {
+		t.Errorf("Expected compacting to merge the segments into the log file, but found %v", segments)
+	}
+	contents, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	editsOf := func(key int64) int {
+		return len(regexp.MustCompile(fmt.Sprintf(`, %s, (INSERT|UPDATE|DELETE), %d, `, tableName, key)).FindAll(contents, -1))
+	}
+	if n := editsOf(0); n != 1 {
+		t.Errorf("Expected the compacted log to have a single edit of key 0, but found %d", n)
+	}
+	if n := editsOf(2); n != 2 {
+		t.Errorf("Expected the compacted log to have the committed edit and the uncommitted edit of key 2, but found %d", n)
+	}
+	if report, err := rm.VerifyLog(); err != nil || !report.OK() {
+		t.Errorf("Expected the compacted log to verify, but got %v: %v", err, report)
+	}
+
+	// Logging must continue normally after compacting
+	insertIntoTable(t, db, tm, rm, inFlightId, tableName, 3, 3)
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 51)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 51)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+	checkFindFails(t, db, tm, clientId, tableName, 3)
+}
+
+func testEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	for name, format := range map[string]recovery.LogFormat{"String": recovery.StringLogFormat, "Binary": recovery.BinaryLogFormat} {
+		t.Run(name, func(t *testing.T) {
+			db, tm, rm, clientId := setupRecovery(t, filepath.Join(t.TempDir(), "db"), recovery.WithEncryptionKey(key))
+			if err := rm.SetLogFormat(format); err != nil {
+				t.Fatal("Failed to set log format:", err)
+			}
+			tableName := createTable(t, db, rm, database.BTreeIndexType)
+			startTransaction(t, db, tm, rm, clientId)
+			insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+			commitTransaction(t, db, tm, rm, clientId)
+			checkpoint(t, rm)
+			startTransaction(t, db, tm, rm, clientId)
+			updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 1)
+			insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+			commitTransaction(t, db, tm, rm, clientId)
+
+			logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+			data, err := os.ReadFile(logFileName)
+			if err != nil {
+				t.Fatal("Failed to read log file:", err)
+			}
+			if bytes.Contains(data, []byte(tableName)) || bytes.Contains(data, []byte(clientId.String())) {
+				t.Error("Expected the log file not to hold any log in the clear")
+			}
+			if report, err := rm.VerifyLog(); err != nil || !report.OK() {
+				t.Errorf("Expected the encrypted log to verify, but got %v: %v", err, report)
+			}
+
+			// Recovering with the wrong key fails authentication, and with the right key succeeds
+			func() {
+				defer revive(t)
+				panic("simulating database crash")
+			}()
+			_, _, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithEncryptionKey(bytes.Repeat([]byte{8}, 32)))
+			if _, err = rm.Recover(); !errors.Is(err, recovery.ErrLogAuthentication) {
+				t.Fatal("Expected recovering with the wrong key to fail authentication, but got:", err)
+			}
+			db, tm, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithEncryptionKey(key))
+			if _, err = rm.Recover(); err != nil {
+				t.Fatal("Error recovering with the encryption key:", err)
+			}
+			startTransaction(t, db, tm, rm, clientId)
+			checkFind(t, db, tm, clientId, tableName, 0, 1)
+			checkFind(t, db, tm, clientId, tableName, 1, 1)
+		})
+	}
+
+	t.Run("Tampered", func(t *testing.T) {
+		db, tm, rm, clientId := setupRecovery(t, "", recovery.WithEncryptionKey(key))
+		tableName := createTable(t, db, rm, database.BTreeIndexType)
+		startTransaction(t, db, tm, rm, clientId)
+		insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+		commitTransaction(t, db, tm, rm, clientId)
+
+		// Alter the ciphertext of the last log, or just its LSN, which is authenticated too
+		logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+		data, err := os.ReadFile(logFileName)
+		if err != nil {
+			t.Fatal("Failed to read log file:", err)
+		}
+		lines := strings.SplitAfter(string(data), "\n")
+		last := lines[len(lines)-2]
+		brace := strings.Index(last, "{ ") + 2
+		flipped := "A"
+		if last[brace] == 'A' {
+			flipped = "B"
+		}
+		lines[len(lines)-2] = last[:brace] + flipped + last[brace+1:]
+		// The first line is the version log, which isn't sealed
+		lines[1] = "9" + lines[1]
+		if err = os.WriteFile(logFileName, []byte(strings.Join(lines, "")), 0666); err != nil {
+			t.Fatal("Failed to write log file:", err)
+		}
+		report, err := rm.VerifyLog()
+		if err != nil {
+			t.Fatal("Error verifying log:", err)
+		}
+		tampered := 0
+		for _, p := range report.Problems {
+			if errors.Is(p.Err, recovery.ErrLogAuthentication) {
+				tampered++
+			}
+		}
+		if tampered != 2 {
+			t.Errorf("Expected both tampered logs to fail authentication, but got: %v", report)
+		}
+	})
+
+	t.Run("NoKey", func(t *testing.T) {
+		db, tm, rm, clientId := setupRecovery(t, "", recovery.WithEncryptionKey(key))
+		tableName := createTable(t, db, rm, database.BTreeIndexType)
+		startTransaction(t, db, tm, rm, clientId)
+		insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+		commitTransaction(t, db, tm, rm, clientId)
+		var out bytes.Buffer
+		if err := recovery.DumpLogFile(&out, filepath.Join(db.GetBasePath(), config.LogFileName)); err != nil {
+			t.Fatal("Error dumping log file:", err)
+		}
+		if !strings.Contains(out.String(), recovery.ErrLogEncrypted.Error()) {
+			t.Errorf("Expected dumping an encrypted log without its key to report it as encrypted, but got:\n%s", out.String())
+		}
+
+		logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+		if _, err := recovery.NewRecoveryManager(db, tm, logFileName, recovery.WithEncryptionKey([]byte("short"))); err == nil {
+			t.Error("Expected an error for an encryption key of the wrong length")
+		}
+	})
+}
+
+func testSubscribe(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	// A transaction begun before subscribing is never streamed
+	earlyId := uuid.New()
+	startTransaction(t, db, tm, rm, earlyId)
+	insertIntoTable(t, db, tm, rm, earlyId, tableName, 100, 100)
+
+	var buf bytes.Buffer
+	if err := rm.Subscribe(&buf); err != nil {
+		t.Fatal("Error subscribing:", err)
+	}
+	uncommittedId, abortedId := uuid.New(), uuid.New()
+	startTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, uncommittedId)
+	startTransaction(t, db, tm, rm, abortedId)
+	for key := int64(0); key < 5; key++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, key, key)
+		insertIntoTable(t, db, tm, rm, uncommittedId, tableName, key+10, key)
+		insertIntoTable(t, db, tm, rm, abortedId, tableName, key+20, key)
+	}
+	abortTransaction(t, tm, rm, abortedId)
+	if buf.Len() != 0 {
+		t.Fatalf("Expected nothing to be streamed before a commit, got %q", buf.String())
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	commitTransaction(t, db, tm, rm, earlyId)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	// The start, five inserts, and commit of the committed transaction
+	if len(lines) != 7 {
+		t.Fatalf("Expected 7 records to be streamed, got %d: %q", len(lines), lines)
+	}
+	var prevLSN uint64
+	for _, line := range lines {
+		if !strings.Contains(line, clientId.String()) {
+			t.Errorf("Expected only records of the committed transaction to be streamed, got %q", line)
+		}
+		lsn, err := strconv.ParseUint(strings.Fields(line)[0], 10, 64)
+		if err != nil || lsn <= prevLSN {
+			t.Errorf("Expected records to be streamed in LSN order, got %q after LSN %d", line, prevLSN)
+		}
+		prevLSN = lsn
+	}
+	if !strings.Contains(lines[len(lines)-1], "commit") {
+		t.Errorf("Expected the commit to be streamed last, got %q", lines[len(lines)-1])
+	}
+
+	// Nothing is streamed once unsubscribed
+	rm.Unsubscribe(&buf)
+	streamed := buf.Len()
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 5, 5)
+	commitTransaction(t, db, tm, rm, clientId)
+	if buf.Len() != streamed {
+		t.Errorf("Expected nothing to be streamed after unsubscribing, got %q", buf.String()[streamed:])
+	}
+
+	// Closing streams what's left and stops the stream
+	var tail bytes.Buffer
+	if err := rm.Subscribe(&tail); err != nil {
+		t.Fatal("Error subscribing:", err)
+	}
+	otherTable := createTable(t, db, rm, database.HashIndexType)
+	if err := rm.Close(); err != nil {
+		t.Fatal("Error closing:", err)
+	}
+	if !strings.Contains(tail.String(), otherTable) {
+		t.Errorf("Expected closing to stream the table log, got %q", tail.String())
+	}
+	if err := rm.Subscribe(&tail); !errors.Is(err, recovery.ErrClosed) {
+		t.Errorf("Expected subscribing after closing to fail with ErrClosed, got %v", err)
+	}
+}
+
+func testVersion(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// The log file is headed by a version log, which doesn't take an LSN
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	data, err := os.ReadFile(logFileName)
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	if !regexp.MustCompile(`^0 @\d+ < version 1 features 0 >\n$`).MatchString(lines[0]) {
+		t.Fatalf("Expected the log file to start with a version log, got %q", lines[0])
+	}
+
+	// A log of the current version is read back
+	reopened, err := recovery.NewRecoveryManager(db, concurrency.NewTransactionManager(concurrency.NewResourceLockManager()), logFileName)
+	if err != nil {
+		t.Fatal("Error reopening a log of the current version:", err)
+	}
+	if reopened.GetLSN() != rm.GetLSN() {
+		t.Errorf("Expected the reopened log to continue from LSN %d, got %d", rm.GetLSN(), reopened.GetLSN())
+	}
+	if _, err := reopened.Recover(); err != nil {
+		t.Error("Error recovering a log of the current version:", err)
+	}
+	reopened.Close()
+
+	bumped := map[string]string{
+		"FutureVersion":  "< version 2 features 0 >",
+		"UnknownFeature": "< version 1 features 128 >",
+	}
+	for name, header := range bumped {
+		t.Run(name, func(t *testing.T) {
+			altered := strings.Replace(string(data), "< version 1 features 0 >", header, 1)
+			if err := os.WriteFile(logFileName, []byte(altered), 0666); err != nil {
+				t.Fatal("Failed to write log file:", err)
+			}
+			tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+			if _, err := recovery.NewRecoveryManager(db, tm, logFileName); !errors.Is(err, recovery.ErrUnsupportedLogVersion) {
+				t.Errorf("Expected opening the log to return ErrUnsupportedLogVersion, got %v", err)
+			}
+			// A recovery manager that opened the log before it was replaced rejects it when reading it
+			if _, err := rm.Recover(); !errors.Is(err, recovery.ErrUnsupportedLogVersion) {
+				t.Errorf("Expected recovering from the log to return ErrUnsupportedLogVersion, got %v", err)
+			}
+		})
+	}
+}
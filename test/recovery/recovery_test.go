@@ -1,18 +1,29 @@
 package recovery_test
 
 import (
+	"cmp"
+	"context"
 	"dinodb/test/utils"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 
 	"dinodb/pkg/concurrency"
 	"dinodb/pkg/config"
 	"dinodb/pkg/database"
+	"dinodb/pkg/entry"
 	"dinodb/pkg/recovery"
 )
 
@@ -23,8 +34,9 @@ import (
 
 // setupRecovery creates and returns a Database, TransactionManager, RecoveryManager, and a user id to use.
 // Uses the specified dbName for the Database's base directory if dbName is not
-// the empty string, otherwise uses a unique random base directory
-func setupRecovery(t *testing.T, dbName string) (
+// the empty string, otherwise uses a unique random base directory.
+// The RecoveryManager is constructed with the specified options
+func setupRecovery(t *testing.T, dbName string, opts ...recovery.Option) (
 	*database.Database, *concurrency.TransactionManager, *recovery.RecoveryManager, uuid.UUID) {
 	// Create random directory to use for db if none was provided
 	var err error
@@ -39,7 +51,7 @@ func setupRecovery(t *testing.T, dbName string) (
 	// Ensures dbName doesn't have trailing path separator
 	dbName = filepath.Clean(dbName)
 
-	d, err := recovery.Prime(dbName)
+	d, err := recovery.Prime(dbName, opts...)
 	if err != nil {
 		t.Fatal("Error priming database:", err)
 	}
@@ -52,7 +64,7 @@ func setupRecovery(t *testing.T, dbName string) (
 
 	lm := concurrency.NewResourceLockManager()
 	tm := concurrency.NewTransactionManager(lm)
-	rm, err := recovery.NewRecoveryManager(d, tm, logFileName)
+	rm, err := recovery.NewRecoveryManager(d, tm, logFileName, opts...)
 	if err != nil {
 		t.Fatal("Error constructing recovery manager:", err)
 	}
@@ -66,8 +78,11 @@ func setupRecovery(t *testing.T, dbName string) (
 		if err != nil {
 			t.Log("Error cleaning up database folder:", err)
 		}
-		recoveryFolderName := dbName + "-recovery"
-		_ = os.RemoveAll(recoveryFolderName)
+		// Removes the backup folder along with its temporary folders and generations
+		backupFolders, _ := filepath.Glob(dbName + "-recovery*")
+		for _, folder := range backupFolders {
+			_ = os.RemoveAll(folder)
+		}
 	})
 	return d, tm, rm, uuid.New()
 }
@@ -81,7 +96,7 @@ func crashAndRecover(t *testing.T, dbFolderName string) (
 		panic("simulating database crash")
 	}()
 	d, tm, rm, _ := setupRecovery(t, dbFolderName)
-	err := rm.Recover()
+	_, err := rm.Recover()
 	if err != nil {
 		t.Fatal("Error recovering using RecoveryManager:", err)
 	}
@@ -109,6 +124,20 @@ func createTable(t *testing.T, db *database.Database, rm *recovery.RecoveryManag
 	return tableName
 }
 
+func dropTable(t *testing.T, db *database.Database, rm *recovery.RecoveryManager, tableName string) {
+	_, err := recovery.HandleDropTable(db, rm, "drop table "+tableName)
+	if err != nil {
+		t.Fatal("Error dropping table:", err)
+	}
+}
+
+// Asserts that the specified table doesn't exist
+func checkTableAbsent(t *testing.T, db *database.Database, tableName string) {
+	if _, err := db.GetTable(tableName); err == nil {
+		t.Errorf("Expected table %q to have been dropped", tableName)
+	}
+}
+
 func startTransaction(t *testing.T, db *database.Database, tm *concurrency.TransactionManager, rm *recovery.RecoveryManager, clientId uuid.UUID) {
 	err := recovery.HandleTransaction(db, tm, rm, "transaction begin", clientId)
 	if err != nil {
@@ -213,6 +242,8 @@ func TestRecovery(t *testing.T) {
 	t.Run("InsertAbort", testInsertAbort)
 	t.Run("AbortInsertDeleteAndUpdate", testAbortInsertDeleteAndUpdate)
 	t.Run("AbortIsolated", testAbortIsolated)
+	t.Run("AbortManyInserts", testAbortManyInserts)
+	t.Run("RollbackUnknownClient", testRollbackUnknownClient)
 	t.Run("InsertCommit", testInsertCommit)
 	t.Run("InsertDeleteCommit", testInsertDeleteCommit)
 	t.Run("InsertCommitUpdate", testInsertCommitUpdate)
@@ -220,6 +251,44 @@ func TestRecovery(t *testing.T) {
 	t.Run("MultipleTablesOneClient", testMultipleTablesOneClient)
 	t.Run("MultiInsertCheckpointing", testMultiInsertCheckpointing)
 	t.Run("MultiInsertCommitDeleteCheckpointing", testMultiInsertCommitDeleteCheckpointing)
+	t.Run("RecoverEmptyLog", testRecoverEmptyLog)
+	t.Run("CrashMidTransaction", testCrashMidTransaction)
+	t.Run("InterruptedRollback", testInterruptedRollback)
+	t.Run("AbortLogged", testAbortLogged)
+	t.Run("RollbackToSavepoint", testRollbackToSavepoint)
+	t.Run("UndoChain", testUndoChain)
+	t.Run("UndoNewestFirst", testUndoNewestFirst)
+	t.Run("RecoverToTimestamp", testRecoverToTimestamp)
+	t.Run("RecoveryProgress", testRecoveryProgress)
+	t.Run("ParallelRedo", testParallelRedo)
+	t.Run("DropTable", testDropTable)
+	t.Run("DropAndRecreateTable", testDropAndRecreateTable)
+	t.Run("DropTableWithUncommittedEdits", testDropTableWithUncommittedEdits)
+	t.Run("TableTypes", testTableTypes)
+	t.Run("TableTypeMismatch", testTableTypeMismatch)
+	t.Run("FuzzyCheckpoint", testFuzzyCheckpoint)
+	t.Run("IncompleteFuzzyCheckpoint", testIncompleteFuzzyCheckpoint)
+	t.Run("EditInFlightAtFuzzyCheckpoint", testEditInFlightAtFuzzyCheckpoint)
+	t.Run("EditInFlightAtCheckpoint", testEditInFlightAtCheckpoint)
+	t.Run("CheckpointTables", testCheckpointTables)
+	t.Run("EditInFlightAtTableCheckpoint", testEditInFlightAtTableCheckpoint)
+	t.Run("AutoCheckpoint", testAutoCheckpoint)
+	t.Run("ApplyLog", testApplyLog)
+	t.Run("RecoveryResult", testRecoveryResult)
+	t.Run("SnapshotRead", testSnapshotRead)
+	t.Run("RedoIdempotent", testRedoIdempotent)
+	t.Run("RecoverCancelled", testRecoverCancelled)
+	t.Run("CheckpointCancelled", testCheckpointCancelled)
+	t.Run("TimestampsAfterRecovery", testTimestampsAfterRecovery)
+	t.Run("RecoverReleasesLocks", testRecoverReleasesLocks)
+	t.Run("SharedLog", testSharedLog)
+	t.Run("MemoryLog", testMemoryLog)
+	t.Run("MigrateLog", testMigrateLog)
+	t.Run("Quiesce", testQuiesce)
+	t.Run("MaxReplayRecords", testMaxReplayRecords)
+	t.Run("RedoHook", testRedoHook)
+	t.Run("ReclaimTransaction", testReclaimTransaction)
+	t.Run("CheckpointActiveSet", testCheckpointActiveSet)
 }
 
 func testBasic(t *testing.T) {
@@ -280,6 +349,29 @@ func testAbortIsolated(t *testing.T) {
 	checkFind(t, db, tm, clientId2, tableName, 1, 1)
 }
 
+func testAbortManyInserts(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 3; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+
+	abortTransaction(t, tm, rm, clientId)
+
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 3; i++ {
+		checkFindFails(t, db, tm, clientId, tableName, i)
+	}
+}
+
+func testRollbackUnknownClient(t *testing.T) {
+	_, _, rm, clientId := setupRecovery(t, "")
+	if err := rm.Rollback(clientId); err != nil {
+		t.Error("Expected rolling back a client with no transaction to be a no-op, but got:", err)
+	}
+}
+
 func testInsertCommit(t *testing.T) {
 	// Define the test cases. Maps subtest name to the number of entries
 	tests := map[string]int64{
@@ -427,3 +519,1879 @@ func testMultiInsertCommitDeleteCheckpointing(t *testing.T) {
 		checkFind(t, db, tm, clientId, tableName, i, i%utils.Salt)
 	}
 }
+
+func testRecoverEmptyLog(t *testing.T) {
+	db, _, _, _ := setupRecovery(t, "")
+
+	crashAndRecover(t, db.GetBasePath())
+}
+
+func testCrashMidTransaction(t *testing.T) {
+	db, tm, rm, clientId1 := setupRecovery(t, "")
+	clientId2 := uuid.New()
+	// Before crash
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId1)
+	insertIntoTable(t, db, tm, rm, clientId1, tableName, 0, 0)
+	insertIntoTable(t, db, tm, rm, clientId1, tableName, 1, 1)
+	commitTransaction(t, db, tm, rm, clientId1)
+	startTransaction(t, db, tm, rm, clientId2)
+	insertIntoTable(t, db, tm, rm, clientId2, tableName, 2, 2)
+	updateTableEntry(t, db, tm, rm, clientId2, tableName, 0, 10)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	// After crash, only the committed transaction's entries should remain
+	startTransaction(t, db, tm, rm, clientId1)
+	checkFind(t, db, tm, clientId1, tableName, 0, 0)
+	checkFind(t, db, tm, clientId1, tableName, 1, 1)
+	checkFindFails(t, db, tm, clientId1, tableName, 2)
+}
+
+func testInterruptedRollback(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	// Before crash
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 3; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	deleteFromTable(t, db, tm, rm, clientId, tableName, 0)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 1, 10)
+	deleteFromTable(t, db, tm, rm, clientId, tableName, 2)
+	// Remove key 1 behind the transaction's back so the rollback fails partway through
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	if err = table.Delete(1); err != nil {
+		t.Fatal("Failed to delete key 1:", err)
+	}
+	if err = rm.Rollback(clientId); err == nil {
+		t.Fatal("Expected rollback to fail when undoing the update of key 1")
+	}
+	// Make sure the compensation logs written so far reach the log file
+	if err = rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	// Re-inserting key 2 a second time would fail, so recovery must skip compensated edits
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	// After crash
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 3; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+func testAbortLogged(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 1)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	abortTransaction(t, tm, rm, clientId)
+
+	countAborts := func() int {
+		data, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+		if err != nil {
+			t.Fatal("Failed to read log file:", err)
+		}
+		return strings.Count(string(data), clientId.String()+" abort >")
+	}
+	if n := countAborts(); n != 1 {
+		t.Fatalf("Expected the rollback to write 1 abort log, found %d", n)
+	}
+
+	// The aborted transaction is finished, so recovery must not roll it back again
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	if n := countAborts(); n != 1 {
+		t.Fatalf("Expected recovery to not abort the transaction again, found %d abort logs", n)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+}
+
+func testRollbackToSavepoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 3; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	savepoint, err := rm.Savepoint(clientId)
+	if err != nil {
+		t.Fatal("Error taking savepoint:", err)
+	}
+	for i := int64(3); i < 6; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 0, 10)
+	if err = rm.RollbackToSavepoint(clientId, savepoint); err != nil {
+		t.Fatal("Error rolling back to savepoint:", err)
+	}
+	for i := int64(0); i < 3; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	for i := int64(3); i < 6; i++ {
+		checkFindFails(t, db, tm, clientId, tableName, i)
+	}
+
+	// The transaction keeps running past the savepoint, and shrank past the later savepoint
+	stale, err := rm.Savepoint(clientId)
+	if err != nil {
+		t.Fatal("Error taking savepoint:", err)
+	}
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 6, 6)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 7, 7)
+	stale += 2
+	if err = rm.RollbackToSavepoint(clientId, savepoint); err != nil {
+		t.Fatal("Error rolling back to savepoint:", err)
+	}
+	if err = rm.RollbackToSavepoint(clientId, stale); err == nil {
+		t.Error("Expected rolling back to a stale savepoint to fail")
+	}
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 8, 8)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Recovery must skip the compensated edits and keep the rest of the transaction
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 3; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	for i := int64(3); i < 8; i++ {
+		checkFindFails(t, db, tm, clientId, tableName, i)
+	}
+	checkFind(t, db, tm, clientId, tableName, 8, 8)
+}
+
+func testUndoChain(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	first := rm.GetLSN()
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 2, 2)
+	last := rm.GetLSN()
+	if err := rm.Close(); err != nil {
+		t.Fatal("Error closing recovery manager:", err)
+	}
+	// Chain an edit straight to the first one, skipping the insert of key 2
+	logFile, err := os.OpenFile(filepath.Join(db.GetBasePath(), config.LogFileName), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal("Failed to open log file:", err)
+	}
+	fmt.Fprintf(logFile, "%d < %s, %s, INSERT, 3, 0, 3, prev %d >\n", last+1, clientId, tableName, first)
+	logFile.Close()
+
+	// Nothing is left of the transaction but its logs, so only the chain can tell what to undo
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath())
+	result, err := rm.Recover()
+	if err != nil {
+		t.Fatal("Error recovering using RecoveryManager:", err)
+	}
+	if result.RecordsUndone != 2 {
+		t.Errorf("Expected the 2 chained edits to be undone, but %d were", result.RecordsUndone)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+	checkFindFails(t, db, tm, clientId, tableName, 3)
+}
+
+func testUndoNewestFirst(t *testing.T) {
+	db, tm, rm, clientId1 := setupRecovery(t, "")
+	clientId2 := uuid.New()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId1)
+	startTransaction(t, db, tm, rm, clientId2)
+	// The transactions' edits are interleaved in the log
+	for key := int64(1); key <= 6; key++ {
+		clientId := clientId1
+		if key%2 == 0 {
+			clientId = clientId2
+		}
+		insertIntoTable(t, db, tm, rm, clientId, tableName, key, key)
+	}
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId1)
+	for key := int64(1); key <= 6; key++ {
+		checkFindFails(t, db, tm, clientId1, tableName, key)
+	}
+	// Both transactions are undone together, newest edit first
+	data, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	var undone []string
+	for _, match := range regexp.MustCompile(`< clr \S+, \S+, INSERT, (\d+),`).FindAllStringSubmatch(string(data), -1) {
+		undone = append(undone, match[1])
+	}
+	if expected := []string{"6", "5", "4", "3", "2", "1"}; !slices.Equal(undone, expected) {
+		t.Errorf("Expected the edits to be undone in the order %v, but got %v", expected, undone)
+	}
+}
+
+func testRecoverToTimestamp(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	otherId := uuid.New()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	beforeCheckpoint := time.Now()
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 5; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	// This transaction is still running at the midpoint, so it must be rolled back
+	startTransaction(t, db, tm, rm, otherId)
+	insertIntoTable(t, db, tm, rm, otherId, tableName, 10, 10)
+	midpoint := time.Now()
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(5); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	commitTransaction(t, db, tm, rm, otherId)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath())
+	if err := rm.RecoverToTimestamp(beforeCheckpoint); !errors.Is(err, recovery.ErrNoCheckpoint) {
+		t.Fatal("Expected recovering to a time before the checkpoint to return ErrNoCheckpoint, but got:", err)
+	}
+	if err := rm.RecoverToTimestamp(midpoint); err != nil {
+		t.Fatal("Error recovering to timestamp:", err)
+	}
+	checkMidpoint := func() {
+		startTransaction(t, db, tm, rm, clientId)
+		for i := int64(0); i < 5; i++ {
+			checkFind(t, db, tm, clientId, tableName, i, i)
+		}
+		for i := int64(5); i <= 10; i++ {
+			checkFindFails(t, db, tm, clientId, tableName, i)
+		}
+		commitTransaction(t, db, tm, rm, clientId)
+	}
+	checkMidpoint()
+
+	// The discarded logs must stay discarded after another crash
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	checkMidpoint()
+}
+
+func testRecoveryProgress(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	otherId := uuid.New()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 1500; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, otherId)
+	for i := int64(1500); i < 2700; i++ {
+		insertIntoTable(t, db, tm, rm, otherId, tableName, i, i)
+	}
+	// Make sure every edit of the running transaction reaches the log file
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	type report struct {
+		processed, total int
+	}
+	reports := make(map[string][]report)
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithRecoveryProgress(func(pass string, processed int, total int) {
+		reports[pass] = append(reports[pass], report{processed, total})
+	}))
+	if _, err := rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	// Each pass reports at least once midway, with increasing counts, and once at the end
+	for pass, total := range map[string]int{"redo": 2704, "undo": 1200} {
+		passReports := reports[pass]
+		if len(passReports) < 2 {
+			t.Fatalf("Expected the %s pass to report progress more than once, got %v", pass, passReports)
+		}
+		for i, r := range passReports {
+			if r.total != total {
+				t.Errorf("Expected the %s pass to report a total of %d, got %d", pass, total, r.total)
+			}
+			if i > 0 && r.processed <= passReports[i-1].processed {
+				t.Errorf("Expected the %s pass's counts to increase, got %v", pass, passReports)
+			}
+		}
+		if last := passReports[len(passReports)-1]; last.processed != total {
+			t.Errorf("Expected the %s pass to finish by reporting %d, got %d", pass, total, last.processed)
+		}
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 1499, 1499)
+	checkFindFails(t, db, tm, clientId, tableName, 1500)
+}
+
+// redoWorkload edits several tables in interleaved transactions, then crashes and recovers
+// with the specified number of redo workers. Returns the recovered entries of each table.
+func redoWorkload(t *testing.T, dbName string, workers int) [][]entry.Entry {
+	db, tm, rm, clientId := setupRecovery(t, dbName)
+	otherId := uuid.New()
+	tableNames := make([]string, 4)
+	for i := range tableNames {
+		tableNames[i] = createTable(t, db, rm, database.BTreeIndexType)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for key := int64(0); key < 100; key++ {
+		for _, tableName := range tableNames {
+			insertIntoTable(t, db, tm, rm, clientId, tableName, key, key)
+		}
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, otherId)
+	for key := int64(0); key < 100; key += 2 {
+		for i, tableName := range tableNames {
+			updateTableEntry(t, db, tm, rm, clientId, tableName, key, key*int64(i+2))
+			if key%10 == 0 {
+				deleteFromTable(t, db, tm, rm, otherId, tableName, key+1)
+			}
+		}
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	commitTransaction(t, db, tm, rm, otherId)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	db, _, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithRedoWorkers(workers))
+	if _, err := rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	results := make([][]entry.Entry, len(tableNames))
+	for i, tableName := range tableNames {
+		table, err := db.GetTable(tableName)
+		if err != nil {
+			t.Fatalf("Failed to get table %q: %s", tableName, err)
+		}
+		if results[i], err = table.Select(); err != nil {
+			t.Fatalf("Failed to select from table %q: %s", tableName, err)
+		}
+	}
+	return results
+}
+
+func testParallelRedo(t *testing.T) {
+	serial := redoWorkload(t, "", 1)
+	parallel := redoWorkload(t, filepath.Join(t.TempDir(), "db"), 4)
+	for i := range serial {
+		if len(serial[i]) != 90 {
+			t.Errorf("Expected table %d to have 90 entries after serial redo, found %d", i, len(serial[i]))
+		}
+	}
+	if !reflect.DeepEqual(serial, parallel) {
+		t.Error("Expected parallel redo to recover the same entries as serial redo")
+	}
+}
+
+func testDropTable(t *testing.T) {
+	// Maps subtest name to when a checkpoint is taken: never, before the drop, or after it
+	tests := map[string]string{
+		"NoCheckpoint":         "",
+		"CheckpointBeforeDrop": "before",
+		"CheckpointAfterDrop":  "after",
+	}
+	for name, when := range tests {
+		t.Run(name, func(t *testing.T) {
+			db, tm, rm, clientId := setupRecovery(t, "")
+			tableName := createTable(t, db, rm, database.BTreeIndexType)
+			keptName := createTable(t, db, rm, database.BTreeIndexType)
+			startTransaction(t, db, tm, rm, clientId)
+			insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+			insertIntoTable(t, db, tm, rm, clientId, keptName, 1, 1)
+			commitTransaction(t, db, tm, rm, clientId)
+			if when == "before" {
+				checkpoint(t, rm)
+			}
+			dropTable(t, db, rm, tableName)
+			checkTableAbsent(t, db, tableName)
+			if when == "after" {
+				checkpoint(t, rm)
+			}
+
+			db, tm, rm = crashAndRecover(t, db.GetBasePath())
+			checkTableAbsent(t, db, tableName)
+			startTransaction(t, db, tm, rm, clientId)
+			checkFind(t, db, tm, clientId, keptName, 1, 1)
+		})
+	}
+}
+
+func testDropAndRecreateTable(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	dropTable(t, db, rm, tableName)
+	if _, err := recovery.HandleCreateTable(db, rm, "create btree table "+tableName); err != nil {
+		t.Fatal("Error recreating table:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Only the edits made after the table was recreated survive
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+}
+
+func testDropTableWithUncommittedEdits(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	if _, err := recovery.HandleDropTable(db, rm, "drop table "+tableName); err == nil {
+		t.Fatal("Expected dropping a table with uncommitted edits to fail")
+	}
+	// The transaction can still be rolled back
+	abortTransaction(t, tm, rm, clientId)
+	startTransaction(t, db, tm, rm, clientId)
+	checkFindFails(t, db, tm, clientId, tableName, 1)
+}
+
+func testTableTypes(t *testing.T) {
+	for _, withCheckpoint := range []bool{false, true} {
+		t.Run(fmt.Sprint("Checkpoint=", withCheckpoint), func(t *testing.T) {
+			db, tm, rm, clientId := setupRecovery(t, "")
+			tableTypes := map[string]database.IndexType{}
+			for _, tableType := range []database.IndexType{database.BTreeIndexType, database.HashIndexType} {
+				tableTypes[createTable(t, db, rm, tableType)] = tableType
+			}
+			// Enough entries to split the hash table's buckets both before and after the checkpoint
+			startTransaction(t, db, tm, rm, clientId)
+			for tableName := range tableTypes {
+				for i := int64(0); i < 500; i++ {
+					insertIntoTable(t, db, tm, rm, clientId, tableName, i, i%utils.Salt)
+				}
+			}
+			commitTransaction(t, db, tm, rm, clientId)
+			if withCheckpoint {
+				checkpoint(t, rm)
+			}
+			startTransaction(t, db, tm, rm, clientId)
+			for tableName := range tableTypes {
+				for i := int64(500); i < 1000; i++ {
+					insertIntoTable(t, db, tm, rm, clientId, tableName, i, i%utils.Salt)
+				}
+			}
+			commitTransaction(t, db, tm, rm, clientId)
+
+			db, tm, rm = crashAndRecover(t, db.GetBasePath())
+			startTransaction(t, db, tm, rm, clientId)
+			for tableName, tableType := range tableTypes {
+				table, err := db.GetTable(tableName)
+				if err != nil {
+					t.Fatalf("Failed to get table %q: %s", tableName, err)
+				}
+				if recovered, err := database.TypeOf(table); err != nil || recovered != tableType {
+					t.Errorf("Expected table %q to be recovered as a %s table, but got %s (%v)", tableName, tableType, recovered, err)
+				}
+				for i := int64(0); i < 1000; i++ {
+					checkFind(t, db, tm, clientId, tableName, i, i%utils.Salt)
+				}
+			}
+		})
+	}
+}
+
+func testTableTypeMismatch(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	// Recovery reads back to the start of a transaction left running by the checkpoint,
+	// so it sees the table log of a table that is also in the checkpoint's backup
+	otherTable := createTable(t, db, rm, database.BTreeIndexType)
+	otherId := uuid.New()
+	startTransaction(t, db, tm, rm, otherId)
+	insertIntoTable(t, db, tm, rm, otherId, otherTable, 1, 1)
+	// Log a hash table while creating a B+Tree
+	tableName := strings.ReplaceAll(uuid.NewString(), "-", "")
+	if _, err := db.CreateTable(tableName, database.BTreeIndexType); err != nil {
+		t.Fatal("Error creating table:", err)
+	}
+	if err := rm.Table(string(database.HashIndexType), tableName); err != nil {
+		t.Fatal("Error logging table:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	_, _, rm, _ = setupRecovery(t, db.GetBasePath())
+	_, err := rm.Recover()
+	if err == nil || !strings.Contains(err.Error(), "logged as a hash table") {
+		t.Fatal("Expected recovering a table of the wrong type to fail, but got:", err)
+	}
+	var recoveryErr *recovery.RecoveryError
+	if !errors.As(err, &recoveryErr) || recoveryErr.Pass != "redo" {
+		t.Errorf("Expected a RecoveryError from the redo pass, but got: %v", err)
+	}
+}
+
+func testFuzzyCheckpoint(t *testing.T) {
+	db, tm, rm, _ := setupRecovery(t, "")
+	tableNames := []string{
+		createTable(t, db, rm, database.BTreeIndexType),
+		createTable(t, db, rm, database.HashIndexType),
+	}
+	// Each worker inserts and then updates its own keys in both tables, one transaction each,
+	// while checkpoints are taken, and leaves its last transaction running
+	const workers, keysPerWorker = 4, 100
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			clientId := uuid.New()
+			transaction := func(edit func(key int64) string, keys int64) error {
+				if err := recovery.HandleTransaction(db, tm, rm, "transaction begin", clientId); err != nil {
+					return err
+				}
+				for i := int64(0); i < keys; i++ {
+					key := int64(w*keysPerWorker) + i
+					for _, tableName := range tableNames {
+						payload := fmt.Sprintf(edit(key), tableName)
+						if strings.HasPrefix(payload, "insert") {
+							if err := recovery.HandleInsert(db, tm, rm, payload, clientId); err != nil {
+								return err
+							}
+						} else if err := recovery.HandleUpdate(db, tm, rm, payload, clientId); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			}
+			commit := func() error {
+				return recovery.HandleTransaction(db, tm, rm, "transaction commit", clientId)
+			}
+			err := transaction(func(key int64) string { return fmt.Sprintf("insert %d %d into %%s", key, key) }, keysPerWorker)
+			if err == nil {
+				err = commit()
+			}
+			if err == nil {
+				err = transaction(func(key int64) string { return fmt.Sprintf("update %%s %d %d", key, key+1) }, keysPerWorker)
+			}
+			if err == nil {
+				err = commit()
+			}
+			if err == nil {
+				err = transaction(func(key int64) string { return fmt.Sprintf("update %%s %d %d", key, 0) }, keysPerWorker/2)
+			}
+			errs <- err
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		if err := rm.FuzzyCheckpoint(); err != nil {
+			t.Fatal("Error creating a fuzzy checkpoint:", err)
+		}
+	}
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal("Error editing concurrently with a fuzzy checkpoint:", err)
+		}
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	clientId := uuid.New()
+	startTransaction(t, db, tm, rm, clientId)
+	for _, tableName := range tableNames {
+		for key := int64(0); key < workers*keysPerWorker; key++ {
+			checkFind(t, db, tm, clientId, tableName, key, key+1)
+		}
+	}
+}
+
+func testIncompleteFuzzyCheckpoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(10); i < 20; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// Crash after beginning a fuzzy checkpoint, before its backup was swapped in. Recovery
+	// must redo the logs since the previous checkpoint rather than those since the begin log.
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(db.GetBasePath(), config.LogFileName), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal("Failed to open log file:", err)
+	}
+	fmt.Fprintf(logFile, "%d < begin checkpoint >\n", rm.GetLSN()+1)
+	logFile.Close()
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 20; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+func testEditInFlightAtFuzzyCheckpoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	// Log an insert, but only make it once the fuzzy checkpoint has backed up the table
+	startTransaction(t, db, tm, rm, clientId)
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1); err != nil {
+		t.Fatal("Error logging an insert:", err)
+	}
+	if err = rm.FuzzyCheckpoint(); err != nil {
+		t.Fatal("Error creating a fuzzy checkpoint:", err)
+	}
+	if err = concurrency.HandleInsert(db, tm, "insert 1 1 into "+tableName, clientId); err != nil {
+		t.Fatal("Error inserting:", err)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 1, 1)
+}
+
+func testEditInFlightAtCheckpoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	// Log an insert, but only make it once the checkpoint has flushed the table
+	startTransaction(t, db, tm, rm, clientId)
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1); err != nil {
+		t.Fatal("Error logging an insert:", err)
+	}
+	// A later insert into the same leaf stamps it with a higher LSN before the checkpoint
+	otherId := uuid.New()
+	startTransaction(t, db, tm, rm, otherId)
+	insertIntoTable(t, db, tm, rm, otherId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, otherId)
+	if err = rm.Checkpoint(); err != nil {
+		t.Fatal("Error creating a checkpoint:", err)
+	}
+	if err = concurrency.HandleInsert(db, tm, "insert 1 1 into "+tableName, clientId); err != nil {
+		t.Fatal("Error inserting:", err)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 1, 1)
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+}
+
+func testCheckpointTables(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	checkpointed := createTable(t, db, rm, database.BTreeIndexType)
+	other := createTable(t, db, rm, database.HashIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, checkpointed, i, i)
+		insertIntoTable(t, db, tm, rm, clientId, other, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	// A transaction left running across the checkpoint, whose update is in the table's backup
+	runningId := uuid.New()
+	startTransaction(t, db, tm, rm, runningId)
+	updateTableEntry(t, db, tm, rm, runningId, checkpointed, 0, 100)
+
+	if err := rm.CheckpointTables(checkpointed, "missing"); err == nil {
+		t.Error("Expected checkpointing a table that doesn't exist to fail")
+	}
+	if err := rm.CheckpointTables(checkpointed); err != nil {
+		t.Fatal("Error checkpointing a table:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(10); i < 20; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, checkpointed, i, i)
+		insertIntoTable(t, db, tm, rm, clientId, other, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath())
+	result, err := rm.Recover()
+	if err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	// The edits of the checkpointed table before its checkpoint are in its backup, but for the
+	// running transaction's update, which is redone before it's undone
+	if result.RecordsRedone != 31 {
+		t.Errorf("Expected 31 records redone, got %d", result.RecordsRedone)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 20; i++ {
+		checkFind(t, db, tm, clientId, checkpointed, i, i)
+		checkFind(t, db, tm, clientId, other, i, i)
+	}
+}
+
+func testEditInFlightAtTableCheckpoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	// Log an insert, but only make it once the table checkpoint has backed up the table
+	startTransaction(t, db, tm, rm, clientId)
+	if err = rm.Edit(clientId, table, recovery.INSERT_ACTION, 1, 0, 1); err != nil {
+		t.Fatal("Error logging an insert:", err)
+	}
+	// A later insert into the same leaf stamps it with a higher LSN before the checkpoint
+	otherId := uuid.New()
+	startTransaction(t, db, tm, rm, otherId)
+	insertIntoTable(t, db, tm, rm, otherId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, otherId)
+	if err = rm.CheckpointTables(tableName); err != nil {
+		t.Fatal("Error checkpointing the table:", err)
+	}
+	if err = concurrency.HandleInsert(db, tm, "insert 1 1 into "+tableName, clientId); err != nil {
+		t.Fatal("Error inserting:", err)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 1, 1)
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+}
+
+func testAutoCheckpoint(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "", recovery.WithAutoCheckpoint(time.Millisecond, 0, 50))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 100; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// The log grew past the threshold, so a checkpoint is taken without asking for one
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		contents, err := os.ReadFile(logFileName)
+		if err != nil {
+			t.Fatal("Failed to read log file:", err)
+		}
+		if strings.Contains(string(contents), "end checkpoint") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected an automatic checkpoint to be logged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := rm.Close(); err != nil {
+		t.Fatal("Error closing the recovery manager:", err)
+	}
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 100; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+}
+
+// selectAll returns the entries of every table in the database, by table name, sorted by key
+func selectAll(t *testing.T, db *database.Database) map[string][]entry.Entry {
+	results := make(map[string][]entry.Entry)
+	for name, table := range db.GetTables() {
+		entries, err := table.Select()
+		if err != nil {
+			t.Fatalf("Failed to select from table %q: %s", name, err)
+		}
+		slices.SortFunc(entries, func(a, b entry.Entry) int { return cmp.Compare(a.Key, b.Key) })
+		results[name] = entries
+	}
+	return results
+}
+
+func testApplyLog(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	btreeTable := createTable(t, db, rm, database.BTreeIndexType)
+	hashTable := createTable(t, db, rm, database.HashIndexType)
+	droppedTable := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for key := int64(0); key < 20; key++ {
+		insertIntoTable(t, db, tm, rm, clientId, btreeTable, key, key)
+		insertIntoTable(t, db, tm, rm, clientId, hashTable, key, key*2)
+		insertIntoTable(t, db, tm, rm, clientId, droppedTable, key, key)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	dropTable(t, db, rm, droppedTable)
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, btreeTable, 3, 300)
+	deleteFromTable(t, db, tm, rm, clientId, hashTable, 4)
+	commitTransaction(t, db, tm, rm, clientId)
+	// An aborted transaction and one that never finishes must leave no trace on the replica
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, btreeTable, 5, 500)
+	abortTransaction(t, tm, rm, clientId)
+	unfinishedId := uuid.New()
+	startTransaction(t, db, tm, rm, unfinishedId)
+	insertIntoTable(t, db, tm, rm, unfinishedId, hashTable, 100, 100)
+	updateTableEntry(t, db, tm, rm, unfinishedId, btreeTable, 6, 600)
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing the log:", err)
+	}
+
+	replica, err := database.Open(t.TempDir() + "/")
+	if err != nil {
+		t.Fatal("Error opening the replica database:", err)
+	}
+	defer replica.Close()
+	if err := recovery.ApplyLog(replica, filepath.Join(db.GetBasePath(), config.LogFileName)); err != nil {
+		t.Fatal("Error applying the log:", err)
+	}
+	abortTransaction(t, tm, rm, unfinishedId)
+	expected, actual := selectAll(t, db), selectAll(t, replica)
+	if _, ok := actual[droppedTable]; ok {
+		t.Errorf("Expected the dropped table %q to be absent from the replica", droppedTable)
+	}
+	if len(expected[btreeTable]) != 20 || len(expected[hashTable]) != 19 {
+		t.Fatalf("Expected the source tables to have 20 and 19 entries, found %d and %d",
+			len(expected[btreeTable]), len(expected[hashTable]))
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected the replica to equal the source database, got %v, expected %v", actual, expected)
+	}
+}
+
+func testRecoveryResult(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 0, 0)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	checkpointLSN := rm.GetLSN()
+	// After the checkpoint: one transaction that never finishes, with two edits,
+	// and one that commits, with one edit
+	unfinishedId, committedId := uuid.New(), uuid.New()
+	startTransaction(t, db, tm, rm, unfinishedId)
+	insertIntoTable(t, db, tm, rm, unfinishedId, tableName, 3, 3)
+	updateTableEntry(t, db, tm, rm, unfinishedId, tableName, 0, 100)
+	startTransaction(t, db, tm, rm, committedId)
+	insertIntoTable(t, db, tm, rm, committedId, tableName, 4, 4)
+	commitTransaction(t, db, tm, rm, committedId)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath())
+	result, err := rm.Recover()
+	if err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	// The checkpoint, the two starts, the three edits, and the commit
+	if result.RecordsScanned != 7 {
+		t.Errorf("Expected 7 records scanned, got %d", result.RecordsScanned)
+	}
+	if result.RecordsRedone != 3 {
+		t.Errorf("Expected 3 records redone, got %d", result.RecordsRedone)
+	}
+	if result.RecordsUndone != 2 {
+		t.Errorf("Expected 2 records undone, got %d", result.RecordsUndone)
+	}
+	if result.CheckpointLSN != checkpointLSN {
+		t.Errorf("Expected recovery to start from the checkpoint at LSN %d, got %d", checkpointLSN, result.CheckpointLSN)
+	}
+	if !reflect.DeepEqual(result.RolledBack, []uuid.UUID{unfinishedId}) {
+		t.Errorf("Expected only %v to be rolled back, got %v", unfinishedId, result.RolledBack)
+	}
+	if result.Duration <= 0 {
+		t.Errorf("Expected a positive duration, got %v", result.Duration)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 0, 0)
+	checkFindFails(t, db, tm, clientId, tableName, 3)
+	checkFind(t, db, tm, clientId, tableName, 4, 4)
+}
+
+// Asserts that the snapshot sees the expected value under the key, or that the key is absent if present is false
+func checkSnapshot(t *testing.T, snapshot *recovery.Snapshot, tableName string, key int64, expectedVal int64, present bool) {
+	t.Helper()
+	e, err := snapshot.Find(tableName, key)
+	if !present {
+		if !errors.Is(err, recovery.ErrNotInSnapshot) {
+			t.Errorf("Expected key %d to not be present in the snapshot, got %v, %v", key, e, err)
+		}
+		return
+	}
+	if err != nil {
+		t.Errorf("Error reading key %d from the snapshot: %s", key, err)
+	} else if e.Value != expectedVal {
+		t.Errorf("Expected the snapshot to see value %d under key %d, got %d", expectedVal, key, e.Value)
+	}
+}
+
+func testSnapshotRead(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for key := int64(0); key < 3; key++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, key, key)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	snapshot := rm.Snapshot()
+
+	// A concurrent writer changes every key, holding its write locks while the snapshot reads
+	writer := uuid.New()
+	startTransaction(t, db, tm, rm, writer)
+	updateTableEntry(t, db, tm, rm, writer, tableName, 0, 100)
+	deleteFromTable(t, db, tm, rm, writer, tableName, 1)
+	insertIntoTable(t, db, tm, rm, writer, tableName, 3, 3)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		checkSnapshot(t, snapshot, tableName, 0, 0, true)
+		checkSnapshot(t, snapshot, tableName, 1, 1, true)
+		checkSnapshot(t, snapshot, tableName, 3, 0, false)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected snapshot reads to not wait for the writer")
+	}
+	commitTransaction(t, db, tm, rm, writer)
+
+	// The snapshot still sees the old values once the writer commits, while a new one sees the new values
+	checkSnapshot(t, snapshot, tableName, 0, 0, true)
+	checkSnapshot(t, snapshot, tableName, 1, 1, true)
+	checkSnapshot(t, snapshot, tableName, 2, 2, true)
+	checkSnapshot(t, snapshot, tableName, 3, 0, false)
+	latest := rm.Snapshot()
+	checkSnapshot(t, latest, tableName, 0, 100, true)
+	checkSnapshot(t, latest, tableName, 1, 0, false)
+	checkSnapshot(t, latest, tableName, 3, 3, true)
+
+	// An aborted transaction is never seen
+	aborted := uuid.New()
+	startTransaction(t, db, tm, rm, aborted)
+	updateTableEntry(t, db, tm, rm, aborted, tableName, 2, 200)
+	abortTransaction(t, tm, rm, aborted)
+	checkSnapshot(t, rm.Snapshot(), tableName, 2, 2, true)
+	if _, err := snapshot.Find("missing", 0); !errors.Is(err, recovery.ErrSnapshotUnavailable) {
+		t.Error("Expected reading a missing table to be unavailable, got", err)
+	}
+}
+
+// Returns every entry of the named table, mapped from key to value.
+func tableContents(t *testing.T, db *database.Database, tableName string) map[int64]int64 {
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	entries, err := table.Select()
+	if err != nil {
+		t.Fatalf("Failed to select from table %q: %s", tableName, err)
+	}
+	contents := make(map[int64]int64, len(entries))
+	for _, e := range entries {
+		contents[e.Key] = e.Value
+	}
+	return contents
+}
+
+func testRedoIdempotent(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableNames := []string{
+		createTable(t, db, rm, database.BTreeIndexType),
+		createTable(t, db, rm, database.HashIndexType),
+	}
+	unfinishedId := uuid.New()
+	for _, tableName := range tableNames {
+		startTransaction(t, db, tm, rm, clientId)
+		for key := int64(0); key < 10; key++ {
+			insertIntoTable(t, db, tm, rm, clientId, tableName, key, key)
+		}
+		commitTransaction(t, db, tm, rm, clientId)
+	}
+	checkpoint(t, rm)
+	// After the checkpoint, edit every key in ways that fail if applied twice
+	for _, tableName := range tableNames {
+		startTransaction(t, db, tm, rm, clientId)
+		for key := int64(0); key < 5; key++ {
+			updateTableEntry(t, db, tm, rm, clientId, tableName, key, key+100)
+		}
+		deleteFromTable(t, db, tm, rm, clientId, tableName, 5)
+		deleteFromTable(t, db, tm, rm, clientId, tableName, 6)
+		insertIntoTable(t, db, tm, rm, clientId, tableName, 10, 10)
+		insertIntoTable(t, db, tm, rm, clientId, tableName, 11, 11)
+		deleteFromTable(t, db, tm, rm, clientId, tableName, 10)
+		insertIntoTable(t, db, tm, rm, clientId, tableName, 10, 20)
+		commitTransaction(t, db, tm, rm, clientId)
+	}
+	startTransaction(t, db, tm, rm, unfinishedId)
+	for _, tableName := range tableNames {
+		updateTableEntry(t, db, tm, rm, unfinishedId, tableName, 7, 700)
+		insertIntoTable(t, db, tm, rm, unfinishedId, tableName, 12, 12)
+	}
+	expected := map[int64]int64{0: 100, 1: 101, 2: 102, 3: 103, 4: 104, 7: 7, 8: 8, 9: 9, 10: 20, 11: 11}
+
+	db, _, rm = crashAndRecover(t, db.GetBasePath())
+	recovered := make([]map[int64]int64, len(tableNames))
+	for i, tableName := range tableNames {
+		recovered[i] = tableContents(t, db, tableName)
+		if !maps.Equal(recovered[i], expected) {
+			t.Fatalf("Expected table %q to hold %v after recovering, but it holds %v", tableName, expected, recovered[i])
+		}
+	}
+	// Recovering again redoes the same log over the recovered database, including the
+	// compensation logs of the first recovery, and must skip every edit it already reflects
+	if _, err := rm.Recover(); err != nil {
+		t.Fatal("Error recovering a second time:", err)
+	}
+	for i, tableName := range tableNames {
+		if contents := tableContents(t, db, tableName); !maps.Equal(contents, recovered[i]) {
+			t.Errorf("Expected table %q to hold %v after recovering twice, but it holds %v", tableName, recovered[i], contents)
+		}
+	}
+}
+
+func testRecoverCancelled(t *testing.T) {
+	for _, pass := range []string{"redo", "undo"} {
+		t.Run(pass, func(t *testing.T) {
+			db, tm, rm, clientId := setupRecovery(t, "")
+			otherId := uuid.New()
+			tableName := createTable(t, db, rm, database.BTreeIndexType)
+			startTransaction(t, db, tm, rm, clientId)
+			for i := int64(0); i < 1500; i++ {
+				insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+			}
+			commitTransaction(t, db, tm, rm, clientId)
+			startTransaction(t, db, tm, rm, otherId)
+			for i := int64(1500); i < 2700; i++ {
+				insertIntoTable(t, db, tm, rm, otherId, tableName, i, i)
+			}
+			if err := rm.Flush(); err != nil {
+				t.Fatal("Error flushing:", err)
+			}
+
+			func() {
+				defer revive(t)
+				panic("simulating database crash")
+			}()
+			// Cancel recovery as soon as the pass first reports progress
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			db, tm, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithRecoveryProgress(func(p string, processed int, total int) {
+				if p == pass {
+					cancel()
+				}
+			}))
+			result, err := rm.RecoverContext(ctx)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatal("Expected recovery to be cancelled, got", err)
+			}
+			if pass == "redo" && result.RecordsRedone >= 2700 {
+				t.Errorf("Expected redo to stop early, but it redid %d logs", result.RecordsRedone)
+			}
+			if pass == "undo" && (result.RecordsUndone == 0 || result.RecordsUndone >= 1200) {
+				t.Errorf("Expected undo to stop midway, but it undid %d edits", result.RecordsUndone)
+			}
+
+			// Recovering again finishes the job
+			db, tm, rm = crashAndRecover(t, db.GetBasePath())
+			startTransaction(t, db, tm, rm, clientId)
+			checkFind(t, db, tm, clientId, tableName, 0, 0)
+			checkFind(t, db, tm, clientId, tableName, 1499, 1499)
+			checkFindFails(t, db, tm, clientId, tableName, 1500)
+			checkFindFails(t, db, tm, clientId, tableName, 2699)
+		})
+	}
+}
+
+// cancelAfter is a context that is cancelled once its Err method has been called n times
+type cancelAfter struct {
+	context.Context
+	n atomic.Int64
+}
+
+func (ctx *cancelAfter) Err() error {
+	if ctx.n.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func testCheckpointCancelled(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 2000; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatal("Error getting table:", err)
+	}
+	dirty := table.GetPager().GetNumDirtyPages()
+	if dirty < 3 {
+		t.Fatalf("Expected several dirty pages, got %d", dirty)
+	}
+
+	// Cancel the checkpoint after flushing a couple of pages
+	ctx := &cancelAfter{Context: context.Background()}
+	ctx.n.Store(3)
+	lsn := rm.GetLSN()
+	if err = rm.CheckpointContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatal("Expected the checkpoint to be cancelled, got", err)
+	}
+	if rm.GetLSN() != lsn {
+		t.Error("Expected a cancelled checkpoint not to write a checkpoint log")
+	}
+	if left := table.GetPager().GetNumDirtyPages(); left == 0 || left >= dirty {
+		t.Errorf("Expected the checkpoint to stop midway through %d dirty pages, but %d are left", dirty, left)
+	}
+
+	// Checkpointing again succeeds, and the database still recovers
+	checkpoint(t, rm)
+	if left := table.GetPager().GetNumDirtyPages(); left != 0 {
+		t.Errorf("Expected every page to be flushed, but %d are dirty", left)
+	}
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 1999, 1999)
+}
+
+func testTimestampsAfterRecovery(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	timestamp := func(tm *concurrency.TransactionManager, clientId uuid.UUID) uint64 {
+		tx, found := tm.GetTransaction(clientId)
+		if !found {
+			t.Fatal("No transaction found for client")
+		}
+		return tx.GetTimestamp()
+	}
+	// Each transaction is younger than the last, across a checkpoint, and the last never finishes
+	var highest uint64
+	for i := int64(0); i < 10; i++ {
+		startTransaction(t, db, tm, rm, clientId)
+		if ts := timestamp(tm, clientId); ts <= highest {
+			t.Fatalf("Expected transaction %d to get a timestamp above %d, got %d", i, highest, ts)
+		} else {
+			highest = ts
+		}
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+		if i == 5 {
+			checkpoint(t, rm)
+		}
+		if i < 9 {
+			commitTransaction(t, db, tm, rm, clientId)
+		}
+	}
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	// A new transaction manager starts its clock over, but recovery moves it past the log, so
+	// even a transaction begun without a Start log is younger than those before the crash
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	otherId := uuid.New()
+	if err := tm.Begin(otherId); err != nil {
+		t.Fatal("Error beginning a transaction:", err)
+	}
+	if ts := timestamp(tm, otherId); ts <= highest {
+		t.Errorf("Expected a transaction begun after recovery to get a timestamp above %d, got %d", highest, ts)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	if ts := timestamp(tm, clientId); ts <= highest {
+		t.Errorf("Expected a transaction begun after recovery to get a timestamp above %d, got %d", highest, ts)
+	}
+	checkFind(t, db, tm, clientId, tableName, 8, 8)
+	checkFindFails(t, db, tm, clientId, tableName, 9)
+}
+
+func testRecoverReleasesLocks(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	readerId := uuid.New()
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	// A read-only transaction never logs anything, so only its lock is left of it
+	if err := tm.BeginReadOnly(readerId); err != nil {
+		t.Fatal("Error beginning read-only transaction:", err)
+	}
+	table, err := db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	if err = tm.Lock(readerId, table, 100, concurrency.R_LOCK); err != nil {
+		t.Fatal("Error locking key:", err)
+	}
+	if err = rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	// Crash with both transactions holding locks, and recover with the same transaction manager
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	dbName := db.GetBasePath()
+	db, err = recovery.Prime(dbName)
+	if err != nil {
+		t.Fatal("Error priming database:", err)
+	}
+	defer db.Close()
+	rm, err = recovery.NewRecoveryManager(db, tm, filepath.Join(dbName, config.LogFileName))
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	defer rm.Close()
+	if _, err = rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+
+	if txs := tm.GetTransactions(); len(txs) != 0 {
+		t.Errorf("Expected no transaction to be running after recovery, but %d are", len(txs))
+	}
+	for _, id := range []uuid.UUID{clientId, readerId} {
+		if resources, found := tm.GetLockedResources(id); found {
+			t.Errorf("Expected client %s to hold no locks after recovery, but it holds %d", id, len(resources))
+		}
+	}
+	// Every key the crashed transactions locked can be locked again
+	table, err = db.GetTable(tableName)
+	if err != nil {
+		t.Fatalf("Failed to get table %q: %s", tableName, err)
+	}
+	writerId := uuid.New()
+	if err = tm.Begin(writerId); err != nil {
+		t.Fatal("Error beginning transaction:", err)
+	}
+	for _, key := range []int64{0, 9, 100} {
+		if locked, err := tm.TryLock(writerId, table, key, concurrency.W_LOCK); err != nil || !locked {
+			t.Errorf("Expected key %d to be free after recovery, but locking it returned %t (error %v)", key, locked, err)
+		}
+	}
+}
+
+// setupSharedLog opens the databases in the specified folders, logging both to the primary
+// database's log file, with the second registered under the id "tenant".
+func setupSharedLog(t *testing.T, primaryName string, tenantName string) (
+	*database.Database, *database.Database, *concurrency.TransactionManager, *recovery.RecoveryManager) {
+	primary, err := recovery.Prime(primaryName)
+	if err != nil {
+		t.Fatal("Error priming database:", err)
+	}
+	tenant, err := recovery.Prime(tenantName)
+	if err != nil {
+		t.Fatal("Error priming database:", err)
+	}
+	utils.EnsureCleanup(t, func() {
+		_ = primary.Close()
+		_ = tenant.Close()
+	})
+	logFileName := filepath.Join(primaryName, config.LogFileName)
+	if err = primary.CreateLogFile(logFileName); err != nil {
+		t.Fatal("Error creating log file:", err)
+	}
+	tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	rm, err := recovery.NewRecoveryManager(primary, tm, logFileName, recovery.WithDatabase("tenant", tenant))
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	return primary, tenant, tm, rm
+}
+
+func testSharedLog(t *testing.T) {
+	formats := map[string]recovery.LogFormat{
+		"String": recovery.StringLogFormat,
+		"Binary": recovery.BinaryLogFormat,
+	}
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var folders []string
+			for range 2 {
+				folder, err := os.MkdirTemp("", "")
+				if err != nil {
+					t.Fatal("Failed to create random database folder:", err)
+				}
+				folders = append(folders, folder)
+			}
+			utils.EnsureCleanup(t, func() {
+				for _, folder := range folders {
+					_ = os.RemoveAll(folder)
+					_ = os.RemoveAll(folder + "-recovery")
+				}
+			})
+			primary, tenant, tm, rm := setupSharedLog(t, folders[0], folders[1])
+			if err := rm.SetLogFormat(format); err != nil {
+				t.Fatal("Error setting log format:", err)
+			}
+			if err := rm.TableIn("unknown", string(database.BTreeIndexType), "table"); !errors.Is(err, recovery.ErrUnknownDatabase) {
+				t.Errorf("Expected creating a table in an unregistered database to return ErrUnknownDatabase, got %v", err)
+			}
+
+			// Both databases have a table of the same name
+			tableName := createTable(t, primary, rm, database.BTreeIndexType)
+			if _, err := tenant.CreateTable(tableName, database.BTreeIndexType); err != nil {
+				t.Fatal("Error creating table:", err)
+			}
+			if err := rm.TableIn("tenant", string(database.BTreeIndexType), tableName); err != nil {
+				t.Fatal("Error creating table:", err)
+			}
+			clientId1, clientId2, clientId3 := uuid.New(), uuid.New(), uuid.New()
+			startTransaction(t, primary, tm, rm, clientId1)
+			for i := int64(1); i <= 3; i++ {
+				insertIntoTable(t, primary, tm, rm, clientId1, tableName, i, i)
+				insertIntoTable(t, tenant, tm, rm, clientId1, tableName, i, i*10)
+			}
+			commitTransaction(t, primary, tm, rm, clientId1)
+			checkpoint(t, rm)
+			startTransaction(t, primary, tm, rm, clientId2)
+			insertIntoTable(t, tenant, tm, rm, clientId2, tableName, 4, 40)
+			updateTableEntry(t, primary, tm, rm, clientId2, tableName, 1, 100)
+			commitTransaction(t, primary, tm, rm, clientId2)
+			startTransaction(t, primary, tm, rm, clientId3)
+			insertIntoTable(t, tenant, tm, rm, clientId3, tableName, 5, 50)
+			updateTableEntry(t, tenant, tm, rm, clientId3, tableName, 2, 99)
+			if err := rm.Flush(); err != nil {
+				t.Fatal("Error flushing:", err)
+			}
+
+			func() {
+				defer revive(t)
+				panic("simulating database crash")
+			}()
+			primary, tenant, tm, rm = setupSharedLog(t, folders[0], folders[1])
+			defer rm.Close()
+			if _, err := rm.Recover(); err != nil {
+				t.Fatal("Error recovering:", err)
+			}
+			// Each database only has its own committed edits
+			startTransaction(t, primary, tm, rm, clientId1)
+			checkFind(t, primary, tm, clientId1, tableName, 1, 100)
+			checkFind(t, primary, tm, clientId1, tableName, 2, 2)
+			checkFind(t, primary, tm, clientId1, tableName, 3, 3)
+			checkFindFails(t, primary, tm, clientId1, tableName, 4)
+			for i := int64(1); i <= 4; i++ {
+				checkFind(t, tenant, tm, clientId1, tableName, i, i*10)
+			}
+			checkFindFails(t, tenant, tm, clientId1, tableName, 5)
+		})
+	}
+}
+
+// setupMemoryLog primes the database in the specified folder and returns it along with a
+// TransactionManager and a RecoveryManager keeping its log in the specified MemoryLog.
+func setupMemoryLog(t *testing.T, dbName string, log *recovery.MemoryLog) (
+	*database.Database, *concurrency.TransactionManager, *recovery.RecoveryManager) {
+	d, err := recovery.Prime(dbName)
+	if err != nil {
+		t.Fatal("Error priming database:", err)
+	}
+	utils.EnsureCleanup(t, func() {
+		_ = d.Close()
+	})
+	tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+	logFileName := filepath.Join(dbName, config.LogFileName)
+	rm, err := recovery.NewRecoveryManager(d, tm, logFileName, recovery.WithLogStorage(log))
+	if err != nil {
+		t.Fatal("Error constructing recovery manager:", err)
+	}
+	return d, tm, rm
+}
+
+func testMemoryLog(t *testing.T) {
+	formats := map[string]recovery.LogFormat{
+		"String": recovery.StringLogFormat,
+		"Binary": recovery.BinaryLogFormat,
+	}
+	for name, format := range formats {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dbName, err := os.MkdirTemp("", "")
+			if err != nil {
+				t.Fatal("Failed to create random database folder:", err)
+			}
+			utils.EnsureCleanup(t, func() {
+				_ = os.RemoveAll(dbName)
+				_ = os.RemoveAll(dbName + "-recovery")
+			})
+			log := recovery.NewMemoryLog()
+			db, tm, rm := setupMemoryLog(t, dbName, log)
+			if err := rm.SetLogFormat(format); err != nil {
+				t.Fatal("Error setting log format:", err)
+			}
+			tableName := createTable(t, db, rm, database.BTreeIndexType)
+			clientId1, clientId2, clientId3 := uuid.New(), uuid.New(), uuid.New()
+			startTransaction(t, db, tm, rm, clientId1)
+			for i := int64(1); i <= 3; i++ {
+				insertIntoTable(t, db, tm, rm, clientId1, tableName, i, i)
+			}
+			commitTransaction(t, db, tm, rm, clientId1)
+			checkpoint(t, rm)
+			startTransaction(t, db, tm, rm, clientId2)
+			updateTableEntry(t, db, tm, rm, clientId2, tableName, 1, 100)
+			insertIntoTable(t, db, tm, rm, clientId2, tableName, 4, 4)
+			commitTransaction(t, db, tm, rm, clientId2)
+			startTransaction(t, db, tm, rm, clientId3)
+			insertIntoTable(t, db, tm, rm, clientId3, tableName, 5, 5)
+			deleteFromTable(t, db, tm, rm, clientId3, tableName, 2)
+			if err := rm.CompactLog(); !errors.Is(err, recovery.ErrNotLogFile) {
+				t.Errorf("Expected compacting a memory log to return ErrNotLogFile, got %v", err)
+			}
+			if err := rm.Flush(); err != nil {
+				t.Fatal("Error flushing:", err)
+			}
+			if len(log.Bytes()) == 0 {
+				t.Error("Expected the logs to be kept in the memory log")
+			}
+
+			func() {
+				defer revive(t)
+				panic("simulating database crash")
+			}()
+			db, tm, rm = setupMemoryLog(t, dbName, log)
+			defer rm.Close()
+			if _, err := rm.Recover(); err != nil {
+				t.Fatal("Error recovering:", err)
+			}
+			// Only the committed edits survive
+			startTransaction(t, db, tm, rm, clientId1)
+			checkFind(t, db, tm, clientId1, tableName, 1, 100)
+			checkFind(t, db, tm, clientId1, tableName, 2, 2)
+			checkFind(t, db, tm, clientId1, tableName, 3, 3)
+			checkFind(t, db, tm, clientId1, tableName, 4, 4)
+			checkFindFails(t, db, tm, clientId1, tableName, 5)
+			commitTransaction(t, db, tm, rm, clientId1)
+			if _, err := os.Stat(filepath.Join(dbName, config.LogFileName)); !os.IsNotExist(err) {
+				t.Errorf("Expected no log file to be created, got %v", err)
+			}
+		})
+	}
+
+	t.Run("Rotation", func(t *testing.T) {
+		t.Parallel()
+		dbName, err := os.MkdirTemp("", "")
+		if err != nil {
+			t.Fatal("Failed to create random database folder:", err)
+		}
+		defer os.RemoveAll(dbName)
+		d, err := recovery.Prime(dbName)
+		if err != nil {
+			t.Fatal("Error priming database:", err)
+		}
+		defer d.Close()
+		defer os.RemoveAll(dbName + "-recovery")
+		tm := concurrency.NewTransactionManager(concurrency.NewResourceLockManager())
+		_, err = recovery.NewRecoveryManager(d, tm, "memory",
+			recovery.WithLogStorage(recovery.NewMemoryLog()), recovery.WithMaxLogSize(1024))
+		if err == nil {
+			t.Error("Expected rotating a memory log to fail")
+		}
+	})
+}
+
+func testMigrateLog(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+	startTransaction(t, db, tm, rm, clientId)
+	updateTableEntry(t, db, tm, rm, clientId, tableName, 3, 300)
+	deleteFromTable(t, db, tm, rm, clientId, tableName, 4)
+	commitTransaction(t, db, tm, rm, clientId)
+	unfinishedId := uuid.New()
+	startTransaction(t, db, tm, rm, unfinishedId)
+	updateTableEntry(t, db, tm, rm, unfinishedId, tableName, 5, 500)
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing the log:", err)
+	}
+
+	// The migrated log is in the binary format but holds the same logs
+	logFileName := filepath.Join(db.GetBasePath(), config.LogFileName)
+	migratedName := filepath.Join(t.TempDir(), config.LogFileName)
+	if err := recovery.MigrateLog(logFileName, migratedName, recovery.BinaryLogFormat); err != nil {
+		t.Fatal("Error migrating the log:", err)
+	}
+	if err := recovery.MigrateLog(logFileName, logFileName, recovery.BinaryLogFormat); err == nil {
+		t.Error("Expected migrating a log file in place to fail")
+	}
+	data, err := os.ReadFile(migratedName)
+	if err != nil {
+		t.Fatal("Failed to read the migrated log:", err)
+	}
+	if len(data) == 0 || data[0] != 0xDB {
+		t.Fatal("Expected the migrated log to be in the binary format")
+	}
+	var before, after strings.Builder
+	if err := recovery.DumpLogFile(&before, logFileName); err != nil {
+		t.Fatal("Error dumping the log:", err)
+	}
+	if err := recovery.DumpLogFile(&after, migratedName); err != nil {
+		t.Fatal("Error dumping the migrated log:", err)
+	}
+	if before.String() != after.String() {
+		t.Errorf("Expected the migrated log to hold:\n%s\ngot:\n%s", before.String(), after.String())
+	}
+
+	// Recovering from the migrated log only keeps the committed edits
+	if err := os.Rename(migratedName, logFileName); err != nil {
+		t.Fatal("Failed to replace the log with the migrated log:", err)
+	}
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 3, 300)
+	checkFindFails(t, db, tm, clientId, tableName, 4)
+	checkFind(t, db, tm, clientId, tableName, 5, 5)
+	checkFind(t, db, tm, clientId, tableName, 9, 9)
+	commitTransaction(t, db, tm, rm, clientId)
+	// Logging carries on in the binary format
+	if err := rm.SetLogFormat(recovery.StringLogFormat); err == nil {
+		t.Error("Expected the recovered log to stay in the binary format")
+	}
+}
+
+func testQuiesce(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	release, err := rm.Quiesce()
+	if err != nil {
+		t.Fatal("Error quiescing:", err)
+	}
+	// Edits wait until the database is released
+	done := make(chan error, 1)
+	go func() {
+		done <- recovery.HandleInsert(db, tm, rm, fmt.Sprintf("insert 2 2 into %s", tableName), clientId)
+	}()
+	select {
+	case err := <-done:
+		t.Fatal("Expected the edit to wait while quiesced, but it finished with:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	lsn := rm.GetLSN()
+	release()
+	release()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal("Error inserting after release:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the edit to resume once released")
+	}
+	if rm.GetLSN() <= lsn {
+		t.Error("Expected the edit to be logged once released")
+	}
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, clientId)
+	checkpoint(t, rm)
+}
+
+func testMaxReplayRecords(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	_, _, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithMaxReplayRecords(5))
+	result, err := rm.Recover()
+	if !errors.Is(err, recovery.ErrReplayLimit) {
+		t.Fatal("Expected recovery to stop at the replay limit, but got:", err)
+	}
+	if result.RecordsRedone != 5 {
+		t.Errorf("Expected 5 logs to be redone before stopping, but got %d", result.RecordsRedone)
+	}
+	if !regexp.MustCompile(`LSN \d+`).MatchString(err.Error()) {
+		t.Errorf("Expected the error to name the last LSN replayed, but got: %v", err)
+	}
+
+	// Without the limit, recovery finishes the job
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath())
+	if _, err = rm.Recover(); err != nil {
+		t.Fatal("Error recovering without a limit:", err)
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	for i := int64(0); i < 10; i++ {
+		checkFind(t, db, tm, clientId, tableName, i, i)
+	}
+	commitTransaction(t, db, tm, rm, clientId)
+}
+
+func testRedoHook(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName1 := createTable(t, db, rm, database.BTreeIndexType)
+	tableName2 := createTable(t, db, rm, database.HashIndexType)
+	startTransaction(t, db, tm, rm, clientId)
+	want := int64(0)
+	for i := int64(0); i < 20; i++ {
+		insertIntoTable(t, db, tm, rm, clientId, tableName1, i, i)
+		insertIntoTable(t, db, tm, rm, clientId, tableName2, i, 2*i)
+		want += 3 * i
+	}
+	updateTableEntry(t, db, tm, rm, clientId, tableName1, 5, 100)
+	want += 95
+	commitTransaction(t, db, tm, rm, clientId)
+	// A transaction recovery rolls back doesn't count
+	loserId := uuid.New()
+	startTransaction(t, db, tm, rm, loserId)
+	insertIntoTable(t, db, tm, rm, loserId, tableName2, 50, 1000)
+	updateTableEntry(t, db, tm, rm, loserId, tableName1, 6, 1000)
+
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	sum, lastLSN, inOrder := int64(0), uint64(0), true
+	hook := func(edit recovery.RedoneEdit) error {
+		switch edit.Action {
+		case recovery.INSERT_ACTION:
+			sum += edit.NewVal
+		case recovery.UPDATE_ACTION:
+			sum += edit.NewVal - edit.OldVal
+		case recovery.DELETE_ACTION:
+			sum -= edit.OldVal
+		}
+		inOrder = inOrder && edit.LSN > lastLSN
+		lastLSN = edit.LSN
+		return nil
+	}
+	db, tm, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithRedoHook(hook))
+	if _, err := rm.Recover(); err != nil {
+		t.Fatal("Error recovering:", err)
+	}
+	if sum != want {
+		t.Errorf("Expected the redo hook to sum the values to %d, but got %d", want, sum)
+	}
+	if !inOrder {
+		t.Error("Expected the redo hook to be called in LSN order")
+	}
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName1, 5, 100)
+	checkFind(t, db, tm, clientId, tableName1, 6, 6)
+	checkFindFails(t, db, tm, clientId, tableName2, 50)
+	commitTransaction(t, db, tm, rm, clientId)
+
+	// An error from the hook stops recovery
+	func() {
+		defer revive(t)
+		panic("simulating database crash")
+	}()
+	failing := func(edit recovery.RedoneEdit) error { return errors.New("hook failed") }
+	_, _, rm, _ = setupRecovery(t, db.GetBasePath(), recovery.WithRedoHook(failing))
+	var recoveryErr *recovery.RecoveryError
+	if _, err := rm.Recover(); !errors.As(err, &recoveryErr) || recoveryErr.Pass != "redo" {
+		t.Error("Expected an error from the redo hook to stop the redo pass, but got:", err)
+	}
+}
+
+func testReclaimTransaction(t *testing.T) {
+	db, tm, rm, clientId := setupRecovery(t, "")
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	// Reclaim a transaction and commit it under the new id
+	startTransaction(t, db, tm, rm, clientId)
+	insertIntoTable(t, db, tm, rm, clientId, tableName, 1, 1)
+	newId := uuid.New()
+	if err := rm.ReclaimTransaction(clientId, newId); err != nil {
+		t.Fatal("Error reclaiming transaction:", err)
+	}
+	if err := recovery.HandleInsert(db, tm, rm, fmt.Sprintf("insert 9 9 into %s", tableName), clientId); err == nil {
+		t.Error("Expected editing under the old id to fail once reclaimed")
+	}
+	insertIntoTable(t, db, tm, rm, newId, tableName, 2, 2)
+	commitTransaction(t, db, tm, rm, newId)
+
+	// Rolling back a reclaimed transaction undoes the edits made under the old id too
+	abortedId := uuid.New()
+	startTransaction(t, db, tm, rm, abortedId)
+	insertIntoTable(t, db, tm, rm, abortedId, tableName, 3, 3)
+	abortedNewId := uuid.New()
+	if err := rm.ReclaimTransaction(abortedId, abortedNewId); err != nil {
+		t.Fatal("Error reclaiming transaction:", err)
+	}
+	abortTransaction(t, tm, rm, abortedNewId)
+
+	// Recovery undoes a reclaimed transaction left running as one transaction
+	runningId := uuid.New()
+	startTransaction(t, db, tm, rm, runningId)
+	insertIntoTable(t, db, tm, rm, runningId, tableName, 4, 4)
+	runningNewId := uuid.New()
+	if err := rm.ReclaimTransaction(runningId, runningNewId); err != nil {
+		t.Fatal("Error reclaiming transaction:", err)
+	}
+	insertIntoTable(t, db, tm, rm, runningNewId, tableName, 5, 5)
+
+	db, tm, rm = crashAndRecover(t, db.GetBasePath())
+	startTransaction(t, db, tm, rm, clientId)
+	checkFind(t, db, tm, clientId, tableName, 1, 1)
+	checkFind(t, db, tm, clientId, tableName, 2, 2)
+	checkFindFails(t, db, tm, clientId, tableName, 3)
+	checkFindFails(t, db, tm, clientId, tableName, 4)
+	checkFindFails(t, db, tm, clientId, tableName, 5)
+}
+
+func testCheckpointActiveSet(t *testing.T) {
+	db, tm, rm, _ := setupRecovery(t, "", recovery.WithGroupCommit(config.GroupCommitInterval))
+	tableName := createTable(t, db, rm, database.BTreeIndexType)
+	// Workers run short transactions, committing or rolling back each, while checkpoints are taken
+	const workers, txnsPerWorker = 8, 25
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < txnsPerWorker; i++ {
+				clientId := uuid.New()
+				key := int64(w*txnsPerWorker + i)
+				err := recovery.HandleTransaction(db, tm, rm, "transaction begin", clientId)
+				if err == nil {
+					err = recovery.HandleInsert(db, tm, rm, fmt.Sprintf("insert %d %d into %s", key, key, tableName), clientId)
+				}
+				if err == nil && i%2 == 0 {
+					err = recovery.HandleTransaction(db, tm, rm, "transaction commit", clientId)
+				} else if err == nil {
+					err = rm.Rollback(clientId)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			checkpoint(t, rm)
+		}
+	}
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil {
+			t.Fatal("Error running transaction:", err)
+		}
+	}
+	if err := rm.Flush(); err != nil {
+		t.Fatal("Error flushing:", err)
+	}
+
+	// Every checkpoint lists exactly the transactions started and not yet finished before it
+	data, err := os.ReadFile(filepath.Join(db.GetBasePath(), config.LogFileName))
+	if err != nil {
+		t.Fatal("Failed to read log file:", err)
+	}
+	endExp := regexp.MustCompile(`^(\d+) .*< (\S+) (start|commit|abort) >`)
+	checkpointExp := regexp.MustCompile(`^(\d+) .*< (.*)checkpoint >`)
+	active := make(map[string]bool)
+	checkpoints := 0
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if match := endExp.FindStringSubmatch(line); match != nil {
+			if match[3] == "start" {
+				active[match[2]] = true
+			} else {
+				delete(active, match[2])
+			}
+		} else if match := checkpointExp.FindStringSubmatch(line); match != nil {
+			checkpoints++
+			listed := make(map[string]bool)
+			for _, id := range strings.Fields(strings.ReplaceAll(match[2], ",", " ")) {
+				listed[id] = true
+			}
+			if !maps.Equal(listed, active) {
+				t.Errorf("Expected the checkpoint with LSN %s to list the %d running transactions, but it lists %d",
+					match[1], len(active), len(listed))
+			}
+		}
+	}
+	if checkpoints == 0 {
+		t.Error("Expected a checkpoint to be taken while the transactions ran")
+	}
+}